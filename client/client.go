@@ -0,0 +1,213 @@
+// Package client is a Go SDK for the RTSP stream server's HTTP and WebSocket API.
+//
+//	c := client.New("http://localhost:8091")
+//	c.StartStream(ctx, client.StartRequest{StreamID: "camera1", RTSPURL: "rtsp://..."})
+//	frames, err := c.SubscribeFrames(ctx, "camera1")
+//	for frame := range frames {
+//		// frame.Image is an image.Image in the stream's pixel format
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"rtsp-stream-server/framedecode"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client talks to one RTSP stream server
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	dialer     *websocket.Dialer
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for API calls
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.httpClient = h }
+}
+
+// WithAPIKey authenticates every request with an API key from the server's AUTH_KEYS_FILE
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithDialer sets the WebSocket dialer, e.g. to configure TLS
+func WithDialer(d *websocket.Dialer) Option {
+	return func(c *Client) { c.dialer = d }
+}
+
+// New creates a client for the server at baseURL, e.g. "http://localhost:8091"
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		dialer:     websocket.DefaultDialer,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is an error response from the server
+type Error struct {
+	StatusCode int
+	Code       string                 `json:"code"`
+	Message    string                 `json:"message"`
+	Details    map[string]interface{} `json:"details"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// StartRequest is the body of a stream start; zero fields take the server's defaults
+type StartRequest struct {
+	StreamID    string                 `json:"stream_id"`
+	RTSPURL     string                 `json:"rtsp_url,omitempty"`
+	RTSPURLs    []string               `json:"rtsp_urls,omitempty"`
+	Width       int                    `json:"width,omitempty"`
+	Height      int                    `json:"height,omitempty"`
+	PixelFormat string                 `json:"pixel_format,omitempty"`
+	MaxDuration string                 `json:"max_duration,omitempty"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// StartResult describes a started (or already running) stream
+type StartResult struct {
+	Message     string `json:"message"`
+	StreamID    string `json:"stream_id"`
+	RTSPURL     string `json:"rtsp_url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	PixelFormat string `json:"pixel_format"`
+}
+
+// StreamInfo is one entry of the stream list
+type StreamInfo struct {
+	StreamID    string                 `json:"stream_id"`
+	RTSPURL     string                 `json:"rtsp_url"`
+	PixelFormat string                 `json:"pixel_format"`
+	Status      string                 `json:"status"`
+	IsRunning   bool                   `json:"is_running"`
+	Paused      bool                   `json:"paused"`
+	ClientCount int                    `json:"client_count"`
+	FrameCount  int64                  `json:"frame_count"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// Format is the frame geometry of a stream together with its WebSocket subprotocol
+type Format struct {
+	framedecode.Format
+	FrameSizeBytes int    `json:"frame_size_bytes"`
+	Subprotocol    string `json:"subprotocol"`
+}
+
+// StartStream starts a stream; starting an identical running stream succeeds
+func (c *Client) StartStream(ctx context.Context, req StartRequest) (*StartResult, error) {
+	var result StartResult
+	if err := c.do(ctx, http.MethodPost, "/api/streams", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StopStream stops a stream. Without force the server refuses while clients are connected.
+func (c *Client) StopStream(ctx context.Context, streamID string, force bool) error {
+	path := "/api/streams/" + url.PathEscape(streamID)
+	if force {
+		path += "/force"
+	}
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// ListStreams returns every stream the caller may see
+func (c *Client) ListStreams(ctx context.Context) ([]StreamInfo, error) {
+	var result struct {
+		Streams []StreamInfo `json:"streams"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/streams", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Streams, nil
+}
+
+// GetStats returns a stream's statistics as reported by the server
+func (c *Client) GetStats(ctx context.Context, streamID string) (map[string]interface{}, error) {
+	var stats map[string]interface{}
+	if err := c.do(ctx, http.MethodGet, "/api/streams/"+url.PathEscape(streamID)+"/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetFormat returns a stream's frame geometry and pixel format
+func (c *Client) GetFormat(ctx context.Context, streamID string) (*Format, error) {
+	var format Format
+	if err := c.do(ctx, http.MethodGet, "/api/streams/"+url.PathEscape(streamID)+"/format", nil, &format); err != nil {
+		return nil, err
+	}
+	return &format, nil
+}
+
+// do sends a JSON request and decodes a JSON response into out, converting error envelopes to *Error
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authenticate(req.Header)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var envelope struct {
+			Error *Error `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil || envelope.Error == nil {
+			return &Error{StatusCode: resp.StatusCode, Code: "HTTP_ERROR", Message: resp.Status}
+		}
+		envelope.Error.StatusCode = resp.StatusCode
+		return envelope.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// authenticate adds the API key, if any, to request headers
+func (c *Client) authenticate(header http.Header) {
+	if c.apiKey != "" {
+		header.Set("X-API-Key", c.apiKey)
+	}
+}
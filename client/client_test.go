@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeServer imitates the parts of the stream server's API the client uses, for one 2x2 gray stream
+type fakeServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	format      string // subprotocol of the stream's current frame format
+	lastStart   map[string]interface{}
+	apiKeys     []string
+	offered     []string // Sec-WebSocket-Protocol of each WebSocket request
+	connections int
+	// framesPerConn frames are sent on each connection before the server drops it without a close
+	// frame; 0 keeps the connection open
+	framesPerConn int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	f := &fakeServer{format: "rtsp-gray-2x2"}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.apiKeys = append(f.apiKeys, r.Header.Get("X-API-Key"))
+	format := f.format
+	f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/streams":
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		f.lastStart = req
+		f.mu.Unlock()
+		if req["stream_id"] == "taken" {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"error": map[string]interface{}{
+				"code":    "STREAM_EXISTS",
+				"message": "stream taken already exists with different parameters: width",
+				"details": map[string]interface{}{"mismatched_fields": []string{"width"}},
+			}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"message":      "Stream started successfully",
+			"stream_id":    req["stream_id"],
+			"rtsp_url":     req["rtsp_url"],
+			"width":        640,
+			"height":       480,
+			"pixel_format": "bgr24",
+		})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/api/streams/cam/format":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stream_id":        "cam",
+			"width":            2,
+			"height":           2,
+			"pixel_format":     "gray",
+			"frame_size_bytes": 4,
+			"subprotocol":      "rtsp-gray-2x2",
+		})
+
+	case r.URL.Path == "/ws/cam":
+		f.mu.Lock()
+		f.offered = append(f.offered, r.Header.Get("Sec-WebSocket-Protocol"))
+		f.connections++
+		n, limit := f.connections, f.framesPerConn
+		f.mu.Unlock()
+
+		upgrader := websocket.Upgrader{Subprotocols: []string{format}}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Each frame's first pixel is the connection number, the rest the frame number
+		for i := 0; limit == 0 || i < limit; i++ {
+			if err := conn.WriteMessage(websocket.BinaryMessage, []byte{byte(n), byte(i), byte(i), byte(i)}); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+	case r.URL.Path == "/api/streams/broken/stats":
+		// A proxy's error page rather than the server's envelope
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>502 Bad Gateway</html>"))
+
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": map[string]interface{}{
+			"code":    "STREAM_NOT_FOUND",
+			"message": "Stream not found",
+		}})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestStartStream(t *testing.T) {
+	f := newFakeServer(t)
+	c := New(f.URL+"/", WithAPIKey("secret"))
+
+	result, err := c.StartStream(context.Background(), StartRequest{
+		StreamID:    "lobby",
+		RTSPURL:     "rtsp://camera.example/lobby",
+		PixelFormat: "bgr24",
+		Metadata:    map[string]interface{}{"floor": "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StreamID != "lobby" || result.Width != 640 || result.Height != 480 || result.PixelFormat != "bgr24" {
+		t.Errorf("result = %+v", result)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lastStart["rtsp_url"] != "rtsp://camera.example/lobby" || f.lastStart["pixel_format"] != "bgr24" {
+		t.Errorf("request body = %v", f.lastStart)
+	}
+	// Zero fields are left to the server's defaults
+	if _, sent := f.lastStart["width"]; sent {
+		t.Errorf("request body %v sets width", f.lastStart)
+	}
+	if f.apiKeys[0] != "secret" {
+		t.Errorf("X-API-Key = %q, want secret", f.apiKeys[0])
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	f := newFakeServer(t)
+	c := New(f.URL)
+
+	_, err := c.StartStream(context.Background(), StartRequest{StreamID: "taken", RTSPURL: "rtsp://camera.example/taken"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error %v (%T) is not an *Error", err, err)
+	}
+	if apiErr.StatusCode != http.StatusConflict || apiErr.Code != "STREAM_EXISTS" || !strings.Contains(apiErr.Message, "different parameters") {
+		t.Errorf("error = %+v", apiErr)
+	}
+	if fields, _ := apiErr.Details["mismatched_fields"].([]interface{}); len(fields) != 1 || fields[0] != "width" {
+		t.Errorf("details = %v", apiErr.Details)
+	}
+
+	if err := c.StopStream(context.Background(), "missing", true); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "STREAM_NOT_FOUND" {
+		t.Errorf("StopStream of a missing stream: %v", err)
+	}
+
+	// A response without the envelope still becomes an *Error carrying the status
+	_, err = c.GetStats(context.Background(), "broken")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway || apiErr.Code != "HTTP_ERROR" {
+		t.Errorf("GetStats through a failing proxy: %v", err)
+	}
+}
+
+func TestSubscribeFramesNegotiatesSubprotocol(t *testing.T) {
+	f := newFakeServer(t)
+	c := New(f.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	frames, err := c.SubscribeFrames(ctx, "cam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := <-frames
+	if frame.StreamID != "cam" || frame.Format.Width != 2 || frame.Format.Height != 2 || frame.Format.PixelFormat != "gray" {
+		t.Errorf("frame = %+v", frame)
+	}
+	if b := frame.Image.Bounds(); b.Dx() != 2 || b.Dy() != 2 {
+		t.Errorf("decoded image is %v, want 2x2", b)
+	}
+
+	f.mu.Lock()
+	offered := f.offered[0]
+	f.mu.Unlock()
+	if offered != "rtsp-gray-2x2" {
+		t.Errorf("offered subprotocol %q, want the format's rtsp-gray-2x2", offered)
+	}
+
+	// When the server no longer speaks the format it reported, the connection is refused
+	f.mu.Lock()
+	f.format = "rtsp-gray-4x4"
+	f.mu.Unlock()
+	if _, err := c.SubscribeFrames(ctx, "cam"); err == nil || !strings.Contains(err.Error(), "format changed") {
+		t.Errorf("subscribe with a mismatched subprotocol: %v", err)
+	}
+}
+
+func TestSubscribeFramesReconnects(t *testing.T) {
+	f := newFakeServer(t)
+	f.framesPerConn = 3
+	c := New(f.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	frames, err := c.SubscribeFrames(ctx, "cam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server drops the socket after three frames; the fourth must come over a new connection
+	var connections []byte
+	for i := 0; i < 4; i++ {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				t.Fatalf("frames closed after %d frames", i)
+			}
+			connections = append(connections, frame.Data[0])
+		case <-ctx.Done():
+			t.Fatalf("no frame %d: %v", i, ctx.Err())
+		}
+	}
+	if want := []byte{1, 1, 1, 2}; string(connections) != string(want) {
+		t.Errorf("frames came over connections %v, want %v", connections, want)
+	}
+
+	cancel()
+	for range frames {
+	}
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"rtsp-stream-server/framedecode"
+
+	"github.com/gorilla/websocket"
+)
+
+// Reconnect backoff and liveness settings for SubscribeFrames
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+
+	// readTimeout must exceed the server's ping interval (54s by default); any message or ping resets it
+	readTimeout = 90 * time.Second
+)
+
+// Frame is one decoded video frame
+type Frame struct {
+	StreamID   string
+	Format     framedecode.Format
+	Data       []byte      // raw frame bytes in Format.PixelFormat
+	Image      image.Image // Data decoded with framedecode
+	ReceivedAt time.Time
+}
+
+// SubscribeFrames connects to a stream's WebSocket and delivers decoded frames until ctx is cancelled,
+// reconnecting with exponential backoff when the connection drops. The frame format is fetched and
+// negotiated as a subprotocol on every connect, so a changed format is picked up on reconnect. The
+// initial connection must succeed; the channel is closed when ctx is done.
+func (c *Client) SubscribeFrames(ctx context.Context, streamID string) (<-chan Frame, error) {
+	conn, format, err := c.dialFrames(ctx, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make(chan Frame, 1)
+	go func() {
+		defer close(frames)
+
+		delay := minReconnectDelay
+		for {
+			err := readFrames(ctx, conn, streamID, format, frames)
+			conn.Close()
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Reconnect until it works or ctx ends
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				if conn, format, err = c.dialFrames(ctx, streamID); err == nil {
+					delay = minReconnectDelay
+					break
+				}
+				if delay *= 2; delay > maxReconnectDelay {
+					delay = maxReconnectDelay
+				}
+			}
+		}
+	}()
+	return frames, nil
+}
+
+// dialFrames fetches the stream's format and opens its WebSocket, offering the format as a subprotocol
+func (c *Client) dialFrames(ctx context.Context, streamID string) (*websocket.Conn, framedecode.Format, error) {
+	format, err := c.GetFormat(ctx, streamID)
+	if err != nil {
+		return nil, framedecode.Format{}, err
+	}
+
+	wsURL, err := c.webSocketURL(streamID)
+	if err != nil {
+		return nil, framedecode.Format{}, err
+	}
+	header := http.Header{}
+	c.authenticate(header)
+	header.Set("Sec-WebSocket-Protocol", format.Subprotocol)
+
+	conn, resp, err := c.dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if resp != nil {
+			return nil, framedecode.Format{}, fmt.Errorf("websocket connect: %v (HTTP %d)", err, resp.StatusCode)
+		}
+		return nil, framedecode.Format{}, fmt.Errorf("websocket connect: %v", err)
+	}
+	if conn.Subprotocol() != format.Subprotocol {
+		// The stream's format changed between the format request and the upgrade
+		conn.Close()
+		return nil, framedecode.Format{}, errors.New("frame format changed while connecting")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+	return conn, format.Format, nil
+}
+
+// readFrames decodes binary messages into frames until the connection fails or ctx is cancelled
+func readFrames(ctx context.Context, conn *websocket.Conn, streamID string, format framedecode.Format, frames chan<- Frame) error {
+	// Unblock ReadMessage when ctx ends
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		img, err := format.Decode(data)
+		if err != nil {
+			// Frames no longer match the negotiated format; reconnect to renegotiate
+			return err
+		}
+		select {
+		case frames <- Frame{StreamID: streamID, Format: format, Data: data, Image: img, ReceivedAt: time.Now()}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// webSocketURL maps the server's base URL to the stream's ws:// or wss:// URL
+func (c *Client) webSocketURL(streamID string) (string, error) {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/ws/" + url.PathEscape(streamID)
+	return u.String(), nil
+}
@@ -0,0 +1,89 @@
+// Package framedecode turns raw frames from the RTSP stream server into standard images, so Go
+// consumers of the WebSocket or HTTP feed don't have to reimplement pixel format conversion.
+//
+// The geometry and pixel format of a stream come from GET /api/streams/:streamId/format, which
+// decodes directly into Format:
+//
+//	var f framedecode.Format
+//	json.NewDecoder(resp.Body).Decode(&f)
+//	img, err := f.Decode(frame)
+package framedecode
+
+import (
+	"fmt"
+	"image"
+)
+
+// Format describes the raw frames of a stream, as returned by the server's format endpoint
+type Format struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	PixelFormat string `json:"pixel_format"`
+}
+
+// Decode converts a frame in this format to an image
+func (f Format) Decode(frame []byte) (image.Image, error) {
+	return Decode(frame, f.Width, f.Height, f.PixelFormat)
+}
+
+// FrameSize returns the size in bytes of one w x h frame in the given pixel format
+func FrameSize(w, h int, format string) (int, error) {
+	if w <= 0 || h <= 0 {
+		return 0, fmt.Errorf("framedecode: invalid frame size %dx%d", w, h)
+	}
+	switch format {
+	case "rgb24", "bgr24":
+		return w * h * 3, nil
+	case "gray":
+		return w * h, nil
+	case "yuv420p":
+		// Chroma planes are subsampled 2x2, rounding up for odd dimensions
+		cw, ch := (w+1)/2, (h+1)/2
+		return w*h + 2*cw*ch, nil
+	}
+	return 0, fmt.Errorf("framedecode: unsupported pixel format %q", format)
+}
+
+// Decode converts a raw w x h frame to an image. rgb24 and bgr24 produce an *image.RGBA, gray an
+// *image.Gray and yuv420p an *image.YCbCr; the returned image never aliases frame.
+func Decode(frame []byte, w, h int, format string) (image.Image, error) {
+	size, err := FrameSize(w, h, format)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) != size {
+		return nil, fmt.Errorf("framedecode: %s frame of %dx%d needs %d bytes, got %d", format, w, h, size, len(frame))
+	}
+
+	rect := image.Rect(0, 0, w, h)
+	switch format {
+	case "rgb24", "bgr24":
+		// Red and blue swap places between the two layouts
+		r, b := 0, 2
+		if format == "bgr24" {
+			r, b = 2, 0
+		}
+		img := image.NewRGBA(rect)
+		for i, j := 0, 0; i < len(frame); i, j = i+3, j+4 {
+			img.Pix[j] = frame[i+r]
+			img.Pix[j+1] = frame[i+1]
+			img.Pix[j+2] = frame[i+b]
+			img.Pix[j+3] = 0xff
+		}
+		return img, nil
+
+	case "gray":
+		img := image.NewGray(rect)
+		copy(img.Pix, frame)
+		return img, nil
+
+	case "yuv420p":
+		img := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+		ySize, cSize := w*h, len(img.Cb)
+		copy(img.Y, frame[:ySize])
+		copy(img.Cb, frame[ySize:ySize+cSize])
+		copy(img.Cr, frame[ySize+cSize:])
+		return img, nil
+	}
+	return nil, fmt.Errorf("framedecode: unsupported pixel format %q", format)
+}
@@ -0,0 +1,189 @@
+package framedecode
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestFrameSize(t *testing.T) {
+	tests := []struct {
+		w, h   int
+		format string
+		want   int
+	}{
+		{640, 480, "bgr24", 640 * 480 * 3},
+		{640, 480, "rgb24", 640 * 480 * 3},
+		{640, 480, "gray", 640 * 480},
+		{640, 480, "yuv420p", 640*480 + 2*320*240},
+		// Odd dimensions round the chroma planes up
+		{3, 3, "yuv420p", 9 + 2*2*2},
+	}
+	for _, tt := range tests {
+		got, err := FrameSize(tt.w, tt.h, tt.format)
+		if err != nil || got != tt.want {
+			t.Errorf("FrameSize(%d, %d, %q) = %d, %v; want %d", tt.w, tt.h, tt.format, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []struct {
+		w, h   int
+		format string
+	}{{0, 480, "bgr24"}, {640, -1, "gray"}, {640, 480, "nv12"}} {
+		if _, err := FrameSize(bad.w, bad.h, bad.format); err == nil {
+			t.Errorf("FrameSize(%d, %d, %q) succeeded", bad.w, bad.h, bad.format)
+		}
+	}
+}
+
+func TestDecodeRGB(t *testing.T) {
+	// A 2x2 frame: red, green / blue, white in rgb24 byte order
+	rgb := []byte{
+		255, 0, 0, 0, 255, 0,
+		0, 0, 255, 255, 255, 255,
+	}
+	// The same image in bgr24 byte order
+	bgr := []byte{
+		0, 0, 255, 0, 255, 0,
+		255, 0, 0, 255, 255, 255,
+	}
+	want := map[image.Point]color.RGBA{
+		{0, 0}: {R: 255, A: 255},
+		{1, 0}: {G: 255, A: 255},
+		{0, 1}: {B: 255, A: 255},
+		{1, 1}: {R: 255, G: 255, B: 255, A: 255},
+	}
+
+	for format, frame := range map[string][]byte{"rgb24": rgb, "bgr24": bgr} {
+		img, err := Decode(frame, 2, 2, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if _, ok := img.(*image.RGBA); !ok {
+			t.Errorf("%s: decoded to %T, want *image.RGBA", format, img)
+		}
+		for p, c := range want {
+			if got := img.At(p.X, p.Y); got != c {
+				t.Errorf("%s: At(%d, %d) = %v, want %v", format, p.X, p.Y, got, c)
+			}
+		}
+	}
+}
+
+func TestDecodeGray(t *testing.T) {
+	frame := []byte{
+		0, 64, 128,
+		192, 255, 7,
+	}
+	img, err := Decode(frame, 3, 2, "gray")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("decoded to %T, want *image.Gray", img)
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			if got, want := img.At(x, y), (color.Gray{Y: frame[y*3+x]}); got != want {
+				t.Errorf("At(%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeYUV420p(t *testing.T) {
+	// A 4x2 frame: a full-resolution luma plane, then one Cb and one Cr sample per 2x2 block
+	frame := []byte{
+		10, 20, 30, 40,
+		50, 60, 70, 80,
+		100, 200, // Cb of the left and right blocks
+		110, 210, // Cr
+	}
+	img, err := Decode(frame, 4, 2, "yuv420p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.YCbCr); !ok {
+		t.Errorf("decoded to %T, want *image.YCbCr", img)
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			want := color.YCbCr{Y: frame[y*4+x], Cb: frame[8+x/2], Cr: frame[10+x/2]}
+			if got := img.At(x, y); got != want {
+				t.Errorf("At(%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	// Odd sizes have a chroma sample for the partial block at the edge
+	odd := []byte{
+		1, 2, 3,
+		4, 5, 6,
+		7, 8, 9,
+		11, 12, 13, 14, // Cb, 2x2
+		21, 22, 23, 24, // Cr
+	}
+	img, err = Decode(odd, 3, 3, "yuv420p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.At(2, 2), (color.YCbCr{Y: 9, Cb: 14, Cr: 24}); got != want {
+		t.Errorf("odd frame: At(2, 2) = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeDoesNotAlias(t *testing.T) {
+	for _, format := range []string{"rgb24", "bgr24", "gray", "yuv420p"} {
+		size, _ := FrameSize(2, 2, format)
+		frame := make([]byte, size)
+		img, err := Decode(frame, 2, 2, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		before := img.At(0, 0)
+		for i := range frame {
+			frame[i] = 0xaa
+		}
+		if after := img.At(0, 0); after != before {
+			t.Errorf("%s: image changed from %v to %v with the frame buffer", format, before, after)
+		}
+	}
+}
+
+func TestDecodeWrongSize(t *testing.T) {
+	for _, format := range []string{"rgb24", "bgr24", "gray", "yuv420p"} {
+		size, _ := FrameSize(4, 2, format)
+		for _, n := range []int{0, size - 1, size + 1} {
+			_, err := Decode(make([]byte, n), 4, 2, format)
+			if err == nil {
+				t.Errorf("%s: Decode of %d bytes, want %d, succeeded", format, n, size)
+				continue
+			}
+			if !strings.Contains(err.Error(), "needs") {
+				t.Errorf("%s: Decode of %d bytes: error %q does not state the expected size", format, n, err)
+			}
+		}
+	}
+
+	if _, err := Decode(make([]byte, 8), 2, 2, "nv12"); err == nil {
+		t.Error("Decode of an unsupported pixel format succeeded")
+	}
+}
+
+func TestFormatDecode(t *testing.T) {
+	// The body of GET /api/streams/:streamId/format carries more fields than Format needs
+	body := `{"stream_id":"cam","width":2,"height":1,"pixel_format":"bgr24","bytes_per_pixel":3,"frame_size_bytes":6}`
+	var f Format
+	if err := json.Unmarshal([]byte(body), &f); err != nil {
+		t.Fatal(err)
+	}
+	img, err := f.Decode([]byte{1, 2, 3, 4, 5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.At(1, 0), (color.RGBA{R: 6, G: 5, B: 4, A: 255}); got != want {
+		t.Errorf("At(1, 0) = %v, want %v", got, want)
+	}
+}
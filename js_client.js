@@ -196,8 +196,9 @@ class RTSPStreamClient {
                 console.warn('Cannot stop stream - other clients connected:', result);
                 return {
                     success: false,
-                    error: result.error,
-                    clientCount: result.client_count,
+                    error: result.error.message,
+                    code: result.error.code,
+                    clientCount: result.error.details.client_count,
                     conflict: true
                 };
             } else if (response.status === 404) {
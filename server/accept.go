@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Representations of a frame that GET /frame can return, in the server's order of preference. Raw
+// comes first so clients sending Accept: */* (or nothing) keep getting raw frames.
+const (
+	FrameTypeRaw  = "application/octet-stream"
+	FrameTypeJPEG = "image/jpeg"
+	FrameTypePNG  = "image/png"
+)
+
+// frameTypes lists the supported representations, most preferred first
+var frameTypes = []string{FrameTypeRaw, FrameTypeJPEG, FrameTypePNG}
+
+// negotiateFrameType picks the frame representation for an Accept header: the supported type with the
+// highest quality value, ties going to the earlier entry of frameTypes. It returns "" when the header
+// rules out every supported type.
+func negotiateFrameType(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return FrameTypeRaw
+	}
+
+	best, bestQ := "", 0.0
+	for _, candidate := range frameTypes {
+		if q := acceptQuality(accept, candidate); q > bestQ {
+			best, bestQ = candidate, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality an Accept header gives a media type, taken from its most specific
+// matching range (type/subtype over type/* over */*); 0 means not acceptable
+func acceptQuality(accept, mediaType string) float64 {
+	major, _, _ := strings.Cut(mediaType, "/")
+
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var s int
+		switch mediaRange {
+		case mediaType:
+			s = 2
+		case major + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && parsed >= 0 && parsed <= 1 {
+					q = parsed
+				}
+			}
+		}
+		quality, specificity = q, s
+	}
+	return quality
+}
+
+// encodeFrameImage encodes a full-size frame as JPEG or PNG, reusing the stream's encode cache when the
+// same frame was already encoded. Frames from the rolling cache are keyed by their seq, so a cached
+// encoding is only reused for exactly the frame it was made from.
+func (s *Stream) encodeFrameImage(frame *Frame, frameType string) ([]byte, error) {
+	key := encodeKey{width: s.width, height: s.height, format: frameType, quality: FrameJPEGQuality}
+	if frame.seq > 0 {
+		if encoded, ok := s.encoded.getExact(key, frame.seq); ok {
+			return encoded.data, nil
+		}
+	}
+
+	img := downscale(frame.data, s.width, s.height, s.pixelFormat, s.width, s.height)
+	var buf bytes.Buffer
+	var err error
+	if frameType == FrameTypePNG {
+		err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: FrameJPEGQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode frame as %s: %v", frameType, err)
+	}
+
+	if frame.seq > 0 {
+		s.encoded.put(&encodedFrame{key: key, data: buf.Bytes(), generation: frame.seq, frameTime: frame.timestamp, createdAt: time.Now()})
+	}
+	return buf.Bytes(), nil
+}
+
+// frameTypeFor negotiates the representation of a /frame response, answering 406 when none fits
+func frameTypeFor(c *gin.Context) (string, bool) {
+	frameType := negotiateFrameType(c.GetHeader("Accept"))
+	if frameType == "" {
+		respondError(c, http.StatusNotAcceptable, CodeNotAcceptable, "Frames are available as application/octet-stream, image/jpeg or image/png",
+			gin.H{"accept": c.GetHeader("Accept")})
+		return "", false
+	}
+	return frameType, true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ackQuery parses the ?ack= query parameter of a WebSocket connection request
+func ackQuery(query url.Values) (bool, error) {
+	raw := query.Get("ack")
+	if raw == "" {
+		return false, nil
+	}
+	ack, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid ack: %v", err)
+	}
+	return ack, nil
+}
+
+// writeFrameHeader announces the next binary frame to an ack client, which must echo its seq back
+// as {"ack":seq}. Raw frames carry no header of their own, so this is how the client learns the seq.
+// The frame is marked in flight before anything is written so a fast ack can't arrive unexpected.
+func (c *Client) writeFrameHeader(frame *Frame) error {
+	msg, _ := json.Marshal(map[string]interface{}{
+		"type":             "frame",
+		"seq":              frame.seq,
+		"timestamp":        frame.timestamp.UnixMilli(),
+		"timestamp_source": frame.timestampSource(),
+	})
+
+	c.mu.Lock()
+	c.ackPending, c.ackSeq = true, frame.seq
+	c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteDeadline))
+	return c.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// handleAck releases writePump to send the next frame once the client acknowledges the one in flight.
+// Acks for any other seq are stale or bogus and are ignored.
+func (c *Client) handleAck(seq int64) {
+	if !c.opts.Ack {
+		log.Printf("Ignoring ack from client %s, which did not connect with ?ack=true", c.id)
+		return
+	}
+
+	c.mu.Lock()
+	if !c.ackPending || seq != c.ackSeq {
+		c.mu.Unlock()
+		log.Printf("Ignoring ack %d from client %s, which is not the frame in flight", seq, c.id)
+		return
+	}
+	c.ackPending = false
+	c.mu.Unlock()
+
+	c.framesAcked.Add(1)
+	select {
+	case c.acked <- struct{}{}:
+	default:
+	}
+}
+
+// skipAckClientLocked handles a full queue for an ack client: rather than dropping the newest frame,
+// which would leave it delivering ever staler frames, the queue is discarded and delivery resumes
+// with the live frame. Callers must hold c.mu.
+func (c *Client) skipAckClientLocked(frame *Frame) {
+	skipped := c.skipToLiveLocked()
+	c.queueLocked(frame)
+	c.lastQueued = frame.timestamp
+	if skips := c.ackSkips.Add(1); skips == 1 || skips%100 == 0 {
+		log.Printf("Ack client %s fell %d frames behind, skipped to live (%d times so far)", c.id, skipped, skips)
+	}
+}
+
+// ackSeqInFlight returns the seq of the frame awaiting an ack
+func (c *Client) ackSeqInFlight() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ackSeq
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audio ingest status values
+const (
+	AudioStarting    = "starting"
+	AudioRunning     = "running"
+	AudioError       = "error"
+	AudioUnavailable = "unavailable"
+)
+
+// errNoAudioStream means the source has no audio track, so retrying is pointless
+var errNoAudioStream = errors.New("source has no audio stream")
+
+// audioIngest extracts a stream's audio track with its own FFmpeg process and fans the encoded
+// ADTS/AAC bytes out to listeners, keeping audio entirely separate from video delivery
+type audioIngest struct {
+	mu        sync.Mutex
+	status    string
+	detail    string
+	listeners map[chan []byte]struct{}
+	closed    bool
+}
+
+// newAudioIngest creates an audio ingest in the starting state
+func newAudioIngest() *audioIngest {
+	return &audioIngest{
+		status:    AudioStarting,
+		listeners: make(map[chan []byte]struct{}),
+	}
+}
+
+// setStatus records the audio ingest status
+func (a *audioIngest) setStatus(status, detail string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status = status
+	a.detail = detail
+}
+
+// state returns the audio ingest status and detail
+func (a *audioIngest) state() (string, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status, a.detail
+}
+
+// listen registers a listener; the returned channel is closed when audio stops
+func (a *audioIngest) listen() chan []byte {
+	ch := make(chan []byte, AudioListenerBufferSize)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		close(ch)
+		return ch
+	}
+	a.listeners[ch] = struct{}{}
+	return ch
+}
+
+// unlisten removes a listener and closes its channel
+func (a *audioIngest) unlisten(ch chan []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.listeners[ch]; ok {
+		delete(a.listeners, ch)
+		close(ch)
+	}
+}
+
+// listenerCount returns the number of connected audio listeners
+func (a *audioIngest) listenerCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.listeners)
+}
+
+// broadcast delivers a chunk to every listener, dropping it for listeners that are behind
+func (a *audioIngest) broadcast(chunk []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for ch := range a.listeners {
+		select {
+		case ch <- chunk:
+		default:
+		}
+	}
+}
+
+// close disconnects all listeners
+func (a *audioIngest) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return
+	}
+	a.closed = true
+	for ch := range a.listeners {
+		close(ch)
+	}
+	a.listeners = nil
+}
+
+// run keeps an audio FFmpeg process running until the stream stops, giving up if the source has no audio
+func (a *audioIngest) run(stream *Stream) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer a.close()
+
+	go func() {
+		<-stream.healthStopChan
+		cancel()
+	}()
+
+	failures := 0
+	for {
+		err := a.runFFmpeg(ctx, stream)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errNoAudioStream) {
+			log.Printf("Audio unavailable for stream %s: %v", stream.streamID, err)
+			a.setStatus(AudioUnavailable, err.Error())
+			return
+		}
+		if err == nil {
+			err = errors.New("FFmpeg exited")
+		}
+
+		failures++
+		log.Printf("Audio FFmpeg error for stream %s: %v", stream.streamID, err)
+		a.setStatus(AudioError, err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(restartDelay(failures)):
+		}
+	}
+}
+
+// runFFmpeg runs one audio-only FFmpeg process, re-encoding the first audio track to AAC in ADTS framing
+// so listeners can join mid-stream
+func (a *audioIngest) runFFmpeg(ctx context.Context, stream *Stream) error {
+	args := ffmpegInputArgs(stream.currentURL(), stream.inputOpts, stream.opts.Loop)
+	args = append(args,
+		"-vn",
+		"-map", "0:a:0",
+		"-c:a", "aac",
+		"-b:a", AudioBitrate,
+		"-f", "adts",
+		"-",
+	)
+
+	// Background context: cancellation goes through stopFFmpeg so FFmpeg can flush before exiting
+	cmd := stream.runner.CommandContext(context.Background(), args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdout pipe: %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stderr pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start FFmpeg: %v", err)
+	}
+	stream.sched.apply(stream.streamID, cmd)
+	untrack := stream.trackFFmpeg("audio", cmd)
+
+	exited := make(chan struct{})
+	defer func() {
+		cmd.Wait()
+		close(exited)
+		untrack()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopFFmpeg(cmd, exited)
+		case <-exited:
+		}
+	}()
+
+	noAudio := make(chan struct{}, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.Contains(line, "matches no streams") {
+				select {
+				case noAudio <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	buf := make([]byte, AudioChunkSize)
+	first := true
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			if first {
+				first = false
+				a.setStatus(AudioRunning, "")
+			}
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			a.broadcast(chunk)
+		}
+		if err != nil {
+			if err == io.EOF && first {
+				// Give the stderr scanner a moment to report why FFmpeg produced nothing
+				select {
+				case <-noAudio:
+					return errNoAudioStream
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// handleStreamAudio streams a stream's audio track as chunked ADTS/AAC (playable by an <audio> element)
+func (sm *StreamManager) handleStreamAudio(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	if stream.audio == nil {
+		respondError(c, http.StatusConflict, CodeAudioUnavailable, "Audio is not enabled for this stream (start it with \"audio\": true)", nil)
+		return
+	}
+	if status, detail := stream.audio.state(); status == AudioUnavailable {
+		respondError(c, http.StatusServiceUnavailable, CodeAudioUnavailable, "Audio unavailable: "+detail, nil)
+		return
+	}
+
+	chunks := stream.audio.listen()
+	defer stream.audio.unlisten(chunks)
+
+	c.Header("Content-Type", "audio/aac")
+	c.Header("Cache-Control", "no-cache")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case chunk, ok := <-chunks:
+			if !ok {
+				return false
+			}
+			_, err := w.Write(chunk)
+			return err == nil
+		}
+	})
+}
+
+// audioStatus reports the stream's audio ingest state for stats
+func (s *Stream) audioStatus() string {
+	if s.audio == nil {
+		return "disabled"
+	}
+	status, _ := s.audio.state()
+	return status
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditEntry is one record of the audit log
+type AuditEntry struct {
+	Seq        uint64                 `json:"seq"`
+	Time       time.Time              `json:"time"`
+	Type       string                 `json:"type"`
+	StreamID   string                 `json:"stream_id,omitempty"`
+	Actor      string                 `json:"actor,omitempty"` // API key name or admin user behind a request
+	RemoteAddr string                 `json:"remote_addr,omitempty"`
+	RequestID  string                 `json:"request_id,omitempty"` // X-Request-ID of the request or WebSocket handshake
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// auditedStreamEvents are the stream event types copied into the audit log; the rest (motion, client
+// counts, fps) are too chatty for a lifecycle record
+var auditedStreamEvents = map[string]bool{
+	"status":         true,
+	"failover":       true,
+	"source_changed": true,
+	"breaker":        true,
+	"recording":      true,
+	"expired":        true,
+	"idle":           true,
+	"drain":          true,
+}
+
+// auditLog keeps the most recent lifecycle events in a ring and, when AUDIT_LOG_FILE is set, appends
+// every event to a JSON-lines file rotated at AUDIT_LOG_MAX_BYTES
+type auditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry // ring; next is the slot the next entry goes in
+	next    int
+	full    bool
+	seq     uint64
+
+	path     string
+	file     *os.File
+	size     int64
+	maxBytes int64
+}
+
+// newAuditLog creates an in-memory audit log holding up to size entries
+func newAuditLog(size int) *auditLog {
+	return &auditLog{entries: make([]AuditEntry, size)}
+}
+
+// openFile makes the log also append to path, continuing an existing file
+func (a *auditLog) openFile(path string, maxBytes int64) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.path, a.file, a.size, a.maxBytes = path, file, info.Size(), maxBytes
+	return nil
+}
+
+// record stamps an entry with a sequence number and time and appends it
+func (a *auditLog) record(entry AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.seq++
+	entry.Seq = a.seq
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	a.entries[a.next] = entry
+	a.next = (a.next + 1) % len(a.entries)
+	if a.next == 0 {
+		a.full = true
+	}
+
+	if a.file != nil {
+		a.writeLocked(entry)
+	}
+}
+
+// writeLocked appends an entry to the file, rotating first if it would grow past maxBytes. Write
+// errors are logged and otherwise ignored; the in-memory ring still has the entry.
+func (a *auditLog) writeLocked(entry AuditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Audit log: failed to encode entry %d: %v", entry.Seq, err)
+		return
+	}
+	line = append(line, '\n')
+
+	if a.size > 0 && a.size+int64(len(line)) > a.maxBytes {
+		if err := a.rotateLocked(); err != nil {
+			log.Printf("Audit log: rotation failed: %v", err)
+			if a.file == nil {
+				return
+			}
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		log.Printf("Audit log: write failed: %v", err)
+	}
+}
+
+// rotateLocked shifts path.1..path.N-1 up by one, dropping the oldest, moves the current file to
+// path.1 and starts a new one
+func (a *auditLog) rotateLocked() error {
+	a.file.Close()
+	a.file = nil
+
+	for i := AuditLogBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+	}
+	if err := os.Rename(a.path, a.path+".1"); err != nil {
+		// Keep appending to the oversized file rather than losing the file log
+		if file, openErr := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640); openErr == nil {
+			a.file = file
+		}
+		return err
+	}
+
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	a.file, a.size = file, 0
+	return nil
+}
+
+// query returns the buffered entries after since, optionally for one stream, oldest first
+func (a *auditLog) query(since time.Time, streamID string) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	start, count := 0, a.next
+	if a.full {
+		start, count = a.next, len(a.entries)
+	}
+	entries := make([]AuditEntry, 0)
+	for i := 0; i < count; i++ {
+		entry := a.entries[(start+i)%len(a.entries)]
+		if !entry.Time.After(since) || (streamID != "" && entry.StreamID != streamID) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// close closes the audit file, if any
+func (a *auditLog) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+// recordStreamEvent copies a lifecycle stream event into the audit log
+func (a *auditLog) recordStreamEvent(event StreamEvent) {
+	kind, _ := event["type"].(string)
+	if !auditedStreamEvents[kind] {
+		return
+	}
+	streamID, _ := event["stream_id"].(string)
+
+	details := make(map[string]interface{}, len(event))
+	for k, v := range event {
+		switch k {
+		case "type", "stream_id", "timestamp":
+		case "from", "to":
+			// Failover URLs may carry camera credentials
+			raw, _ := v.(string)
+			details[k] = redactURL(raw)
+		default:
+			details[k] = v
+		}
+	}
+	a.record(AuditEntry{Type: kind, StreamID: streamID, Details: details})
+}
+
+// auditRequest records an entry attributed to the caller of an API request
+func (sm *StreamManager) auditRequest(c *gin.Context, kind, streamID string, details map[string]interface{}) {
+	sm.audit.record(AuditEntry{
+		Type:       kind,
+		StreamID:   streamID,
+		Actor:      requestActor(c),
+		RemoteAddr: c.ClientIP(),
+		RequestID:  requestID(c),
+		Details:    details,
+	})
+}
+
+// requestActor names who made a request: the API key, else the admin Basic user, else nobody
+func requestActor(c *gin.Context) string {
+	if value, ok := c.Get("api_key"); ok {
+		return value.(*apiKey).Name
+	}
+	if user, ok := c.Get("admin_user"); ok {
+		return user.(string)
+	}
+	return ""
+}
+
+// auditStreamStarted records a start request that created a stream
+func (sm *StreamManager) auditStreamStarted(c *gin.Context, streamID, rtspURL string, opts StreamOptions) {
+	sm.auditRequest(c, "stream_started", streamID, map[string]interface{}{
+		"rtsp_url":     redactURL(rtspURL),
+		"width":        opts.Width,
+		"height":       opts.Height,
+		"pixel_format": opts.PixelFormat,
+	})
+}
+
+// auditClientDisconnected records a WebSocket client leaving, whether it hung up or its stream stopped
+func (sm *StreamManager) auditClientDisconnected(client *Client, reason string) {
+	sm.audit.record(AuditEntry{
+		Type:      "client_disconnected",
+		StreamID:  client.streamID,
+		RequestID: client.opts.RequestID,
+		Details: map[string]interface{}{
+			"client_id":   client.id,
+			"reason":      reason,
+			"connected":   time.Since(client.connectedAt).Round(time.Millisecond).String(),
+			"frames_sent": client.framesSent.Load(),
+		},
+	})
+}
+
+// redactURL hides the password of a URL so camera credentials don't end up in the audit log
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[unparseable URL]"
+	}
+	return u.Redacted()
+}
+
+// parseSince parses the since query parameter as unix milliseconds, like event timestamps, or RFC 3339
+func parseSince(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q: use unix milliseconds or RFC 3339", raw)
+	}
+	return t, nil
+}
+
+// handleAuditEvents returns the buffered audit entries after ?since=, optionally for one ?stream_id=
+func (sm *StreamManager) handleAuditEvents(c *gin.Context) {
+	since, err := parseSince(c.Query("since"))
+	if err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	entries := sm.audit.query(since, c.Query("stream_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"events": entries,
+		"count":  len(entries),
+	})
+}
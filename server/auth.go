@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API key scopes. admin may do anything; viewer:<streamId> may watch one stream and viewer:* any stream.
+const (
+	ScopeAdmin        = "admin"
+	ScopeViewerPrefix = "viewer:"
+	ScopeViewerAll    = ScopeViewerPrefix + "*"
+)
+
+// apiKey is one entry of the AUTH_KEYS_FILE
+type apiKey struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+}
+
+// authStore holds the configured API keys, indexed by the SHA-256 of the key so lookups don't
+// compare secrets byte by byte
+type authStore struct {
+	keys map[[sha256.Size]byte]*apiKey
+}
+
+// loadAuthStore reads API keys from a JSON file of the form {"keys": [{"name", "key", "scopes"}]}
+func loadAuthStore(path string) (*authStore, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth keys: %v", err)
+	}
+
+	var file struct {
+		Keys []*apiKey `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse auth keys %s: %v", path, err)
+	}
+	if len(file.Keys) == 0 {
+		return nil, fmt.Errorf("auth keys %s defines no keys", path)
+	}
+
+	store := &authStore{keys: make(map[[sha256.Size]byte]*apiKey, len(file.Keys))}
+	for i, key := range file.Keys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("auth key %d (%s) has an empty key", i, key.Name)
+		}
+		for _, scope := range key.Scopes {
+			if scope != ScopeAdmin && (!strings.HasPrefix(scope, ScopeViewerPrefix) || scope == ScopeViewerPrefix) {
+				return nil, fmt.Errorf("auth key %d (%s) has invalid scope %q", i, key.Name, scope)
+			}
+		}
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, dup := store.keys[hash]; dup {
+			return nil, fmt.Errorf("auth key %d (%s) duplicates an earlier key", i, key.Name)
+		}
+		store.keys[hash] = key
+	}
+	return store, nil
+}
+
+// lookup returns the key matching the presented secret, or nil
+func (a *authStore) lookup(secret string) *apiKey {
+	return a.keys[sha256.Sum256([]byte(secret))]
+}
+
+// allows reports whether the key grants scope; admin grants everything and viewer:* every viewer scope
+func (k *apiKey) allows(scope string) bool {
+	for _, granted := range k.Scopes {
+		switch {
+		case granted == ScopeAdmin, granted == scope:
+			return true
+		case granted == ScopeViewerAll && strings.HasPrefix(scope, ScopeViewerPrefix):
+			return true
+		}
+	}
+	return false
+}
+
+// viewerScope is the scope needed to watch a stream
+func viewerScope(streamID string) string {
+	return ScopeViewerPrefix + streamID
+}
+
+// presentedKey extracts the API key from the Authorization bearer token, the X-API-Key header or, for
+// browser WebSockets that can't set headers, the api_key query parameter
+func presentedKey(c *gin.Context) string {
+	if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	return c.Query("api_key")
+}
+
+// authenticate resolves the caller's key, responding 401 and returning false when it's missing or unknown.
+// It returns a nil key and true when authentication is disabled.
+func (sm *StreamManager) authenticate(c *gin.Context) (*apiKey, bool) {
+	if sm.auth == nil {
+		return nil, true
+	}
+
+	secret := presentedKey(c)
+	if secret == "" {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "API key required", nil)
+		c.Abort()
+		return nil, false
+	}
+	key := sm.auth.lookup(secret)
+	if key == nil {
+		respondError(c, http.StatusUnauthorized, CodeUnauthorized, "Invalid API key", nil)
+		c.Abort()
+		return nil, false
+	}
+	c.Set("api_key", key)
+	return key, true
+}
+
+// requireScope returns middleware that admits only callers whose key grants the scope built by scopeFor
+func (sm *StreamManager) requireScope(scopeFor func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := sm.authenticate(c)
+		if !ok {
+			return
+		}
+		if key != nil {
+			if scope := scopeFor(c); !key.allows(scope) {
+				respondError(c, http.StatusForbidden, CodeForbidden,
+					fmt.Sprintf("API key %q lacks scope %s", key.Name, scope), gin.H{"required_scope": scope})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// requireAdmin admits only admin keys, and when ADMIN_USER is set only callers with the admin Basic credentials
+func (sm *StreamManager) requireAdmin() gin.HandlerFunc {
+	check := sm.requireScope(func(*gin.Context) string { return ScopeAdmin })
+	if sm.config.AdminUser == "" {
+		return check
+	}
+	return func(c *gin.Context) {
+		if sm.checkAdminBasicAuth(c) {
+			check(c)
+		}
+	}
+}
+
+// requireViewer admits keys that may watch the stream named in the route
+func (sm *StreamManager) requireViewer() gin.HandlerFunc {
+	return sm.requireScope(func(c *gin.Context) string { return viewerScope(c.Param("streamId")) })
+}
+
+// requireWebSocketViewer is requireViewer for the WebSocket route, where a signed URL may stand in for
+// a key when URL signing is enabled; handleWebSocket verifies the signature itself
+func (sm *StreamManager) requireWebSocketViewer() gin.HandlerFunc {
+	check := sm.requireViewer()
+	return func(c *gin.Context) {
+		if len(sm.config.SigningSecret) > 0 && c.Query("sig") != "" && presentedKey(c) == "" {
+			c.Next()
+			return
+		}
+		check(c)
+	}
+}
+
+// requireKey admits any valid key; handlers filter what the caller may see with canView
+func (sm *StreamManager) requireKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := sm.authenticate(c); ok {
+			c.Next()
+		}
+	}
+}
+
+// canView reports whether the authenticated caller may see a stream; always true when auth is disabled
+func canView(c *gin.Context, streamID string) bool {
+	value, exists := c.Get("api_key")
+	if !exists {
+		return true
+	}
+	return value.(*apiKey).allows(viewerScope(streamID))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BasicAuthRealm is the realm announced to clients that must log in to the control API
+const BasicAuthRealm = "RTSP Stream Admin"
+
+// checkAdminBasicAuth verifies HTTP Basic credentials against ADMIN_USER/ADMIN_PASS, responding 401 with a
+// WWW-Authenticate challenge and returning false when they're missing or wrong
+func (sm *StreamManager) checkAdminBasicAuth(c *gin.Context) bool {
+	user, pass, ok := c.Request.BasicAuth()
+	if ok && credentialsEqual(user, sm.config.AdminUser) && credentialsEqual(pass, sm.config.AdminPass) {
+		c.Set("admin_user", user)
+		return true
+	}
+
+	c.Header("WWW-Authenticate", `Basic realm="`+BasicAuthRealm+`", charset="UTF-8"`)
+	message := "Admin credentials required"
+	if ok {
+		message = "Invalid admin credentials"
+	}
+	respondError(c, http.StatusUnauthorized, CodeUnauthorized, message, nil)
+	c.Abort()
+	return false
+}
+
+// credentialsEqual compares two secrets in constant time; hashing first keeps the length from leaking too
+func credentialsEqual(presented, expected string) bool {
+	a := sha256.Sum256([]byte(presented))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
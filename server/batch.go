@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Per-item outcomes of a batch start
+const (
+	BatchStarted        = "started"
+	BatchAlreadyRunning = "already-running"
+	BatchError          = "error"
+)
+
+// batchStartResult reports what happened to one item of a batch start
+type batchStartResult struct {
+	StreamID string    `json:"stream_id"`
+	Result   string    `json:"result"`
+	Error    *APIError `json:"error,omitempty"`
+}
+
+// handleBatchStartStreams starts many streams in one call with a bounded worker pool, returning a
+// result per item in request order. The body is a {"streams": [...]} object or just the array of items.
+// The request succeeds even when individual items fail.
+func (sm *StreamManager) handleBatchStartStreams(c *gin.Context) {
+	var req struct {
+		Streams []startStreamRequest `json:"streams" binding:"required"`
+	}
+	raw, err := c.GetRawData()
+	if err == nil {
+		if body := bytes.TrimLeft(raw, " \t\r\n"); len(body) > 0 && body[0] == '[' {
+			err = json.Unmarshal(raw, &req.Streams)
+		} else {
+			err = binding.JSON.BindBody(raw, &req)
+		}
+	}
+	if err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+	if len(req.Streams) == 0 || len(req.Streams) > MaxBatchStartSize {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("streams must contain between 1 and %d items", MaxBatchStartSize), nil)
+		return
+	}
+
+	results := make([]batchStartResult, len(req.Streams))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := BatchStartWorkers
+	if len(req.Streams) < workers {
+		workers = len(req.Streams)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// Each worker writes only its own slot, so results needs no lock
+				results[i] = sm.batchStartOne(&req.Streams[i])
+			}
+		}()
+	}
+	for i := range req.Streams {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	summary := map[string]int{BatchStarted: 0, BatchAlreadyRunning: 0, BatchError: 0}
+	for i, result := range results {
+		summary[result.Result]++
+		if result.Result == BatchStarted {
+			sm.auditStreamStarted(c, req.Streams[i].StreamID, req.Streams[i].RTSPURL, req.Streams[i].StreamOptions)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"summary": summary,
+	})
+}
+
+// batchStartOne starts one item of a batch, converting failures into a per-item error
+func (sm *StreamManager) batchStartOne(req *startStreamRequest) batchStartResult {
+	result := batchStartResult{StreamID: req.StreamID}
+	if req.StreamID == "" {
+		result.Result = BatchError
+		result.Error = &APIError{Code: CodeInvalidRequest, Message: "stream_id is required"}
+		return result
+	}
+
+	alreadyRunning, err := sm.startRequested(req)
+	switch {
+	case err != nil:
+		result.Result = BatchError
+		result.Error = startErrorDetail(err)
+	case alreadyRunning:
+		result.Result = BatchAlreadyRunning
+	default:
+		result.Result = BatchStarted
+	}
+	return result
+}
+
+// startErrorDetail converts a startRequested failure to the same error body a single start would return
+func startErrorDetail(err error) *APIError {
+	var conflict *streamConflictError
+	switch {
+	case errors.As(err, &conflict):
+		return &APIError{Code: CodeStreamExists, Message: err.Error(), Details: gin.H{"mismatched_fields": conflict.mismatches}}
+	case errors.Is(err, ErrStreamExists):
+		return &APIError{Code: CodeStreamExists, Message: err.Error()}
+	case errors.Is(err, ErrStreamLimit):
+		return &APIError{Code: CodeStreamLimit, Message: err.Error()}
+	case errors.Is(err, ErrStartQueueFull):
+		return &APIError{Code: CodeStartQueueFull, Message: err.Error()}
+	case errors.Is(err, ErrInvalidResolution):
+		return &APIError{Code: CodeInvalidResolution, Message: err.Error()}
+	case errors.Is(err, ErrUnsupportedPixelFormat):
+		return &APIError{Code: CodeInvalidPixelFormat, Message: err.Error()}
+	case errors.Is(err, ErrInputNotAllowed):
+		return &APIError{Code: CodeInputNotAllowed, Message: err.Error()}
+	case errors.Is(err, ErrLocalSocket):
+		return &APIError{Code: CodeLocalSocket, Message: err.Error()}
+	case errors.Is(err, ErrHWAccelUnavailable):
+		return &APIError{Code: CodeHWAccelUnavailable, Message: err.Error()}
+	}
+	return &APIError{Code: CodeInvalidRequest, Message: err.Error()}
+}
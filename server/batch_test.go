@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBatchStartBodies(t *testing.T) {
+	ts := newTestServer(t, 25, nil)
+
+	post := func(body string) (int, map[string]interface{}) {
+		t.Helper()
+		resp, err := http.Post(ts.srv.URL+"/api/streams/batch", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		results []string
+	}{
+		{
+			name:    "envelope",
+			body:    `{"streams":[{"stream_id":"env-1","rtsp_url":"rtsp://camera.example/1"},{"stream_id":"env-2","rtsp_url":"rtsp://camera.example/2","width":32,"height":24}]}`,
+			results: []string{BatchStarted, BatchStarted},
+		},
+		{
+			name:    "bare array",
+			body:    `[{"stream_id":"bare-1","rtsp_url":"rtsp://camera.example/1"},{"stream_id":"bare-2","rtsp_url":"rtsp://camera.example/2","width":32,"height":24}]`,
+			results: []string{BatchStarted, BatchStarted},
+		},
+		{
+			name:    "bare array after whitespace",
+			body:    "\n  [{\"stream_id\":\"bare-1\",\"rtsp_url\":\"rtsp://camera.example/1\"}]",
+			results: []string{BatchAlreadyRunning},
+		},
+		{
+			// Per-item problems fail the item, not the request, in either form
+			name:    "bare array with an invalid item",
+			body:    `[{"rtsp_url":"rtsp://camera.example/3"},{"stream_id":"bare-3","rtsp_url":"rtsp://camera.example/3"}]`,
+			results: []string{BatchError, BatchStarted},
+		},
+	}
+	for _, tt := range tests {
+		status, out := post(tt.body)
+		if status != http.StatusOK {
+			t.Errorf("%s: status %d, body %v", tt.name, status, out)
+			continue
+		}
+		results, _ := out["results"].([]interface{})
+		if len(results) != len(tt.results) {
+			t.Errorf("%s: results %v, want %d", tt.name, results, len(tt.results))
+			continue
+		}
+		for i, want := range tt.results {
+			if got := results[i].(map[string]interface{})["result"]; got != want {
+				t.Errorf("%s: item %d result %v, want %s", tt.name, i, got, want)
+			}
+		}
+	}
+	if stats := ts.status(t, "bare-2"); stats["stream_id"] != "bare-2" {
+		t.Errorf("stream started from a bare array: status %v", stats)
+	}
+
+	for _, body := range []string{``, `[]`, `{"streams":[]}`, `{}`, `null`, `[{"stream_id":"x"`, `"streams"`, `{"streams":{"stream_id":"x"}}`} {
+		status, out := post(body)
+		apiErr, _ := out["error"].(map[string]interface{})
+		if status != http.StatusBadRequest || apiErr["code"] != CodeInvalidRequest {
+			t.Errorf("body %q: status %d, body %v; want 400 %s", body, status, out, CodeInvalidRequest)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // FFmpeg is retried with the normal backoff
+	BreakerOpen     = "open"      // retries are suspended until the cooldown expires
+	BreakerHalfOpen = "half-open" // one trial run decides whether to close or reopen
+)
+
+// circuitBreaker stops a stream from respawning FFmpeg against a camera that keeps failing. After
+// threshold consecutive failed runs it opens for cooldown, then allows a single trial run.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int // 0 disables the breaker
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	trips     int64
+}
+
+// newCircuitBreaker creates a closed breaker
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// recordFailure counts a run that ended without delivering frames, returning the new state when the
+// breaker changed state and "" otherwise. A failed half-open trial reopens the breaker immediately.
+func (b *circuitBreaker) recordFailure(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold == 0 || b.state == BreakerOpen {
+		return ""
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = now
+		b.trips++
+		return BreakerOpen
+	}
+	return ""
+}
+
+// recordSuccess closes the breaker once a run delivers frames, returning BreakerClosed when it changed state
+func (b *circuitBreaker) recordSuccess() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state == BreakerClosed {
+		return ""
+	}
+	b.state = BreakerClosed
+	return BreakerClosed
+}
+
+// admit returns how long the caller must wait before starting FFmpeg. Once an open breaker's cooldown
+// has expired it moves to half-open and admits the trial run; halfOpened reports that transition.
+func (b *circuitBreaker) admit(now time.Time) (wait time.Duration, halfOpened bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerOpen {
+		return 0, false
+	}
+	if remaining := b.openedAt.Add(b.cooldown).Sub(now); remaining > 0 {
+		return remaining, false
+	}
+	b.state = BreakerHalfOpen
+	return 0, true
+}
+
+// isOpen reports whether retries are currently suspended
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == BreakerOpen
+}
+
+// info returns the breaker state for stats and status
+func (b *circuitBreaker) info() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	info := map[string]interface{}{
+		"state":     b.state,
+		"failures":  b.failures,
+		"threshold": b.threshold,
+		"trips":     b.trips,
+	}
+	if b.state == BreakerOpen {
+		info["retry_at"] = b.openedAt.Add(b.cooldown)
+	}
+	return info
+}
+
+// publishBreakerState announces a breaker state change on the stream's event hub
+func (s *Stream) publishBreakerState(state string) {
+	s.events.publish(StreamEvent{
+		"type":      "breaker",
+		"stream_id": s.streamID,
+		"state":     state,
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// highWaterMark returns the frame buffer length at which a stream counts as under pressure
+func (s *Stream) highWaterMark(fraction float64) int {
+	return int(math.Ceil(fraction * float64(cap(s.frameBuffer))))
+}
+
+// checkBufferPressure samples the frame buffer length against the high-water mark. While the buffer
+// stays at or above it a warning is logged at most once per BufferPressureLogInterval. Only the health
+// monitor calls this, so lastPressureLog needs no lock.
+func (sm *StreamManager) checkBufferPressure(stream *Stream, now time.Time) {
+	depth, mark := len(stream.frameBuffer), stream.highWaterMark(sm.config.BufferHighWater)
+	pressured := depth >= mark
+	was := stream.bufferPressure.Swap(pressured)
+
+	switch {
+	case pressured && now.Sub(stream.lastPressureLog) >= BufferPressureLogInterval:
+		stream.lastPressureLog = now
+		log.Printf("WARN buffer_pressure stream=%s depth=%d capacity=%d high_water=%d dropped_frames=%d",
+			stream.streamID, depth, cap(stream.frameBuffer), mark, stream.droppedFrames.Load())
+	case !pressured && was:
+		log.Printf("INFO buffer_pressure_cleared stream=%s depth=%d capacity=%d high_water=%d",
+			stream.streamID, depth, cap(stream.frameBuffer), mark)
+	}
+}
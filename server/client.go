@@ -1,14 +1,123 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// validate checks that the deadlines are positive and bounded and that pings are sent
+// often enough to keep the read deadline from expiring on an idle but healthy connection
+func (o ClientOptions) validate() error {
+	for name, d := range map[string]time.Duration{
+		"read_deadline":            o.ReadDeadline,
+		"write_deadline":           o.WriteDeadline,
+		"ping_interval":            o.PingInterval,
+		"min_frame_write_deadline": o.MinFrameWriteDeadline,
+		"max_frame_write_deadline": o.MaxFrameWriteDeadline,
+	} {
+		if d <= 0 || d > MaxWebSocketDeadline {
+			return fmt.Errorf("%s must be between 0 and %s, got %s", name, MaxWebSocketDeadline, d)
+		}
+	}
+	if o.PingInterval >= o.ReadDeadline {
+		return fmt.Errorf("ping_interval (%s) must be shorter than read_deadline (%s)", o.PingInterval, o.ReadDeadline)
+	}
+	if o.MinFrameWriteDeadline > o.MaxFrameWriteDeadline {
+		return fmt.Errorf("min_frame_write_deadline (%s) must not exceed max_frame_write_deadline (%s)", o.MinFrameWriteDeadline, o.MaxFrameWriteDeadline)
+	}
+	if o.MinBitrateMbps <= 0 {
+		return fmt.Errorf("min_bitrate_mbps must be positive, got %g", o.MinBitrateMbps)
+	}
+	if o.ReadLimit < MinWebSocketReadLimit || o.ReadLimit > MaxWebSocketReadLimit {
+		return fmt.Errorf("read_limit must be between %d and %d bytes, got %d", MinWebSocketReadLimit, MaxWebSocketReadLimit, o.ReadLimit)
+	}
+	if o.MaxStaleness < 0 || o.MaxStaleness > MaxWebSocketDeadline {
+		return fmt.Errorf("max_staleness must be between 0 (off) and %s, got %s", MaxWebSocketDeadline, o.MaxStaleness)
+	}
+	if o.MaxWriteStall < 0 || o.MaxWriteStall > MaxWebSocketDeadline {
+		return fmt.Errorf("max_write_stall must be between 0 (off) and %s, got %s", MaxWebSocketDeadline, o.MaxWriteStall)
+	}
+	return nil
+}
+
+// frameWriteDeadline is how long writing an n-byte frame may take: the time to send it at
+// MinBitrateMbps, clamped so tiny frames still fail fast and huge ones can't stall forever
+func (o ClientOptions) frameWriteDeadline(n int) time.Duration {
+	d := time.Duration(float64(n) * 8 / (o.MinBitrateMbps * 1e6) * float64(time.Second))
+	if d < o.MinFrameWriteDeadline {
+		return o.MinFrameWriteDeadline
+	}
+	if d > o.MaxFrameWriteDeadline {
+		return o.MaxFrameWriteDeadline
+	}
+	return d
+}
+
+// withQuery returns a copy of the options overridden by the read_deadline, write_deadline,
+// ping_interval and max_staleness query parameters of a WebSocket connection request
+func (o ClientOptions) withQuery(query url.Values) (ClientOptions, error) {
+	for name, target := range map[string]*time.Duration{
+		"read_deadline":  &o.ReadDeadline,
+		"write_deadline": &o.WriteDeadline,
+		"ping_interval":  &o.PingInterval,
+		"max_staleness":  &o.MaxStaleness,
+	} {
+		raw := query.Get(name)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return o, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		*target = d
+	}
+	return o, o.validate()
+}
+
+// primeQuery parses the ?prime= query parameter of a WebSocket connection request
+func primeQuery(query url.Values) (bool, error) {
+	raw := query.Get("prime")
+	if raw == "" {
+		return false, nil
+	}
+	prime, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid prime: %v", err)
+	}
+	return prime, nil
+}
+
+// subsampleQuery parses the ?subsample= query parameter of a WebSocket connection request; without it
+// every frame is delivered
+func subsampleQuery(query url.Values) (int, error) {
+	raw := query.Get("subsample")
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid subsample: %v", err)
+	}
+	if n < 1 || n > MaxClientSubsample {
+		return 0, fmt.Errorf("subsample must be between 1 and %d", MaxClientSubsample)
+	}
+	return n, nil
+}
+
 // readPump handles incoming WebSocket messages from the client
 func (c *Client) readPump() {
+	reason := "disconnected"
 	defer func() {
 		// Check if client is already closed to avoid double removal
 		c.mu.Lock()
@@ -16,47 +125,450 @@ func (c *Client) readPump() {
 		c.mu.Unlock()
 
 		if !alreadyClosed {
-			c.manager.RemoveClient(c)
+			c.manager.RemoveClient(c, reason)
 		}
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(512)
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	// Inbound messages are small JSON commands; anything larger closes the connection with 1009
+	c.conn.SetReadLimit(c.opts.ReadLimit)
+	c.conn.SetReadDeadline(time.Now().Add(c.opts.ReadDeadline))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.conn.SetReadDeadline(time.Now().Add(c.opts.ReadDeadline))
 		return nil
 	})
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		messageType, data, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			switch {
+			case errors.Is(err, websocket.ErrReadLimit):
+				// The websocket library has already sent the 1009 close frame
+				reason = "message too large"
+				log.Printf("Disconnecting client %s: sent a message larger than %d bytes", c.id, c.opts.ReadLimit)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
 				log.Printf("WebSocket error for client %s: %v", c.id, err)
 			}
-			break
+			return
+		}
+		// Any message from the client proves the connection is alive
+		c.conn.SetReadDeadline(time.Now().Add(c.opts.ReadDeadline))
+
+		// Empty binary messages are heartbeats (js_client.js sends them); other binary data has no meaning
+		switch {
+		case messageType == websocket.TextMessage:
+			err = c.handleCommand(data)
+		case len(data) > 0:
+			err = errors.New("binary messages other than empty heartbeats are not accepted")
+		}
+		if err == nil {
+			continue
+		}
+		rejected := c.messagesRejected.Add(1)
+		if rejected < MaxRejectedClientMessages {
+			log.Printf("Rejected message from client %s: %v", c.id, err)
+			continue
+		}
+		reason = "too many invalid messages"
+		log.Printf("Disconnecting client %s after %d invalid messages, the last: %v", c.id, rejected, err)
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many invalid messages"),
+			time.Now().Add(c.opts.WriteDeadline))
+		return
+	}
+}
+
+// clientCommand is a JSON command sent by a client over its WebSocket connection
+type clientCommand struct {
+	Cmd     string   `json:"cmd"`
+	FPS     *float64 `json:"fps"`
+	RTTMs   *float64 `json:"rtt_ms"`
+	LastSeq *int64   `json:"last_seq"`
+	Ack     *int64   `json:"ack"`
+}
+
+// validate checks that a command is known and carries only the fields it uses
+func (cmd clientCommand) validate() error {
+	switch cmd.Cmd {
+	case "":
+		// {"ack":seq} carries no cmd, keeping the per-frame reply as small as possible
+		if cmd.Ack == nil || cmd.FPS != nil || cmd.RTTMs != nil || cmd.LastSeq != nil {
+			return errors.New(`a message without cmd must be {"ack":seq}`)
+		}
+	case "report":
+		if cmd.FPS == nil || !validMetric(*cmd.FPS) || (cmd.RTTMs != nil && !validMetric(*cmd.RTTMs)) {
+			return errors.New("report needs a non-negative fps and optionally rtt_ms")
+		}
+		if cmd.LastSeq != nil || cmd.Ack != nil {
+			return errors.New("report takes only fps and rtt_ms")
+		}
+	case "resume":
+		if cmd.FPS != nil || cmd.RTTMs != nil || cmd.Ack != nil {
+			return errors.New("resume takes only last_seq")
+		}
+	default:
+		return fmt.Errorf("unknown command %q", cmd.Cmd)
+	}
+	return nil
+}
+
+// handleCommand parses, validates and applies a text command from the client. A command must be a
+// single JSON object with only the fields of its kind; anything else is returned as an error and not
+// applied, so a misbehaving client can't disrupt its own stream.
+func (c *Client) handleCommand(data []byte) error {
+	var cmd clientCommand
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cmd); err != nil {
+		return fmt.Errorf("malformed command: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("malformed command: data after the JSON object")
+	}
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+
+	switch cmd.Cmd {
+	case "":
+		c.handleAck(*cmd.Ack)
+	case "report":
+		c.mu.Lock()
+		c.reportedFPS = *cmd.FPS
+		if cmd.RTTMs != nil {
+			c.reportedRTTMs = *cmd.RTTMs
+		}
+		c.lastReportAt = time.Now()
+		c.mu.Unlock()
+	case "resume":
+		// Raw frames are self-contained and not replayed, so any gap since last_seq is bridged by
+		// going straight to live: queued frames are discarded and the next frame sent is the newest
+		skipped := c.skipToLive()
+		if cmd.LastSeq != nil {
+			log.Printf("Client %s resumed after seq %d, skipped %d queued frame(s) to go live", c.id, *cmd.LastSeq, skipped)
+		}
+	}
+	return nil
+}
+
+// validMetric reports whether a client-reported measurement is a finite, non-negative number
+func validMetric(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0) && v >= 0
+}
+
+// info returns the client's delivery counters and latest self-reported metrics
+func (c *Client) info() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info := map[string]interface{}{
+		"client_id":         c.id,
+		"connected_at":      c.connectedAt,
+		"frames_sent":       c.framesSent.Load(),
+		"delivered_fps":     c.deliveredFPS.rate(time.Now()),
+		"frames_skipped":    c.framesSkipped.Load(),
+		"queue_length":      len(c.send),
+		"queue_peak":        c.queuePeak,
+		"buffer_size":       cap(c.send),
+		"auto_buffer":       c.auto.enabled,
+		"target_fps":        c.targetFPS,
+		"paused":            c.paused,
+		"frames_throttled":  c.framesThrottled.Load(),
+		"messages_rejected": c.messagesRejected.Load(),
+		"last_write_at":     time.Unix(0, c.lastSuccessfulWrite.Load()),
+	}
+	if c.opts.RequestID != "" {
+		info["request_id"] = c.opts.RequestID
+	}
+	if c.opts.MaxStaleness > 0 {
+		info["max_staleness_ms"] = c.opts.MaxStaleness.Milliseconds()
+		info["stale_flushes"] = c.staleFlushes.Load()
+		info["frames_flushed"] = c.framesFlushed.Load()
+		if !c.lastFlushAt.IsZero() {
+			info["last_flush_at"] = c.lastFlushAt
+		}
+	}
+	if c.opts.Width > 0 {
+		info["width"], info["height"] = c.opts.Width, c.opts.Height
+	}
+	if c.opts.Encoding != "" {
+		info["encoding"] = c.opts.Encoding
+	}
+	if c.opts.Subsample > 1 {
+		info["subsample"] = c.opts.Subsample
+		info["frames_subsampled"] = c.framesSubsampled.Load()
+	}
+	if c.bufferGrows.Load() > 0 || c.bufferShrinks.Load() > 0 {
+		info["buffer_grows"] = c.bufferGrows.Load()
+		info["buffer_shrinks"] = c.bufferShrinks.Load()
+	}
+	if c.opts.Ack {
+		info["ack"] = true
+		info["frames_acked"] = c.framesAcked.Load()
+		info["ack_skips"] = c.ackSkips.Load()
+		info["awaiting_ack"] = c.ackPending
+	}
+	if c.opts.OnMotion {
+		info["on_motion"] = true
+		info["motion_active"] = c.motionActive
+	}
+	if !c.lastReportAt.IsZero() {
+		info["reported_fps"] = c.reportedFPS
+		info["reported_rtt_ms"] = c.reportedRTTMs
+		info["last_report_at"] = c.lastReportAt
+	}
+	return info
+}
+
+// clientTuning is a partial update of a client's delivery settings; nil fields are left unchanged
+type clientTuning struct {
+	BufferSize *int     `json:"buffer_size"`
+	TargetFPS  *float64 `json:"target_fps"`
+	Paused     *bool    `json:"paused"`
+	AutoBuffer *bool    `json:"auto_buffer"`
+}
+
+// validate checks that every provided setting is in range
+func (t clientTuning) validate() error {
+	if t.BufferSize != nil && (*t.BufferSize < 1 || *t.BufferSize > MaxClientBufferSize) {
+		return fmt.Errorf("buffer_size must be between 1 and %d", MaxClientBufferSize)
+	}
+	if t.TargetFPS != nil && (!validMetric(*t.TargetFPS) || *t.TargetFPS > MaxClientTargetFPS) {
+		return fmt.Errorf("target_fps must be between 0 (unlimited) and %v", MaxClientTargetFPS)
+	}
+	return nil
+}
+
+// tune applies a validated settings update to a live client
+func (c *Client) tune(t clientTuning) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return fmt.Errorf("client %s is disconnected", c.id)
+	}
+	if t.TargetFPS != nil {
+		c.targetFPS = *t.TargetFPS
+	}
+	if t.Paused != nil {
+		c.paused = *t.Paused
+	}
+	if t.AutoBuffer != nil {
+		c.auto = autoBuffer{enabled: *t.AutoBuffer}
+	}
+	if t.BufferSize != nil && *t.BufferSize != cap(c.send) {
+		c.resizeLocked(*t.BufferSize)
+	}
+	return nil
+}
+
+// resizeLocked swaps in a send channel of a new capacity, migrating queued frames; frames that
+// don't fit, or that writePump is reading concurrently, may be dropped. Callers must hold c.mu.
+func (c *Client) resizeLocked(size int) {
+	send := make(chan *Frame, size)
+	for migrating := true; migrating; {
+		select {
+		case frame := <-c.send:
+			select {
+			case send <- frame:
+			default:
+				c.framesSkipped.Add(1)
+			}
+		default:
+			migrating = false
+		}
+	}
+	c.send = send
+
+	select {
+	case c.resized <- struct{}{}:
+	default:
+	}
+}
+
+// closeWith is markClosed for a disconnect the client should be told about: writePump closes the
+// connection with code and text instead of an empty close frame
+func (c *Client) closeWith(code int, text string) bool {
+	c.mu.Lock()
+	if !c.closed {
+		c.closeCode, c.closeText = code, text
+	}
+	c.mu.Unlock()
+	return c.markClosed()
+}
+
+// markClosed marks the client closed and signals writePump to exit. Only the first call returns true,
+// so exactly one caller tears the client down.
+func (c *Client) markClosed() bool {
+	first := false
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+		close(c.done)
+		first = true
+	})
+	return first
+}
+
+// skipToLive discards the frames queued for the client, returning how many were dropped
+func (c *Client) skipToLive() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skipToLiveLocked()
+}
+
+// skipToLiveLocked is skipToLive for callers that hold c.mu
+func (c *Client) skipToLiveLocked() int {
+	if c.closed {
+		return 0
+	}
+	skipped := 0
+	for {
+		select {
+		case <-c.send:
+			skipped++
+		default:
+			c.framesSkipped.Add(int64(skipped))
+			return skipped
 		}
 	}
 }
 
+// catchUp jumps a client that fell behind back to the live edge. When frame, the next one queued, is
+// older than max_staleness, the queue behind it is drained and the newest queued frame is returned to
+// be sent instead; frame is returned unchanged when it is fresh or already the newest. on_motion
+// clients are left alone: their pre-roll frames are old by design.
+func (c *Client) catchUp(frame *Frame, now time.Time) *Frame {
+	if c.opts.MaxStaleness <= 0 || c.opts.OnMotion || now.Sub(frame.timestamp) <= c.opts.MaxStaleness {
+		return frame
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	latest, flushed := frame, 0
+	for drained := false; !drained; {
+		select {
+		case next := <-c.send:
+			latest = next
+			flushed++
+		default:
+			drained = true
+		}
+	}
+	if flushed == 0 {
+		return frame
+	}
+	c.staleFlushes.Add(1)
+	c.framesFlushed.Add(int64(flushed))
+	c.lastFlushAt = now
+	log.Printf("Client %s fell %s behind, flushed %d stale frames to catch up", c.id, now.Sub(frame.timestamp).Round(time.Millisecond), flushed)
+	return latest
+}
+
+// writeStalledLocked reports whether a client whose buffer is full has had no frame written for longer
+// than max_write_stall, i.e. it isn't reading, and claims the disconnect; callers must hold c.mu. Only
+// the first call for a stalled client returns true.
+func (c *Client) writeStalledLocked(now time.Time) (time.Duration, bool) {
+	stalled := now.Sub(time.Unix(0, c.lastSuccessfulWrite.Load()))
+	if c.opts.MaxWriteStall <= 0 || c.stallDropped || stalled <= c.opts.MaxWriteStall {
+		return stalled, false
+	}
+	c.stallDropped = true
+	return stalled, true
+}
+
+// dropStalled disconnects a client that stopped reading. Closing the connection also unblocks a
+// writePump stuck writing to it, which the write deadline would otherwise only do much later.
+func (c *Client) dropStalled(stalled time.Duration) {
+	log.Printf("Disconnecting client %s: buffer full and no frame written for %s", c.id, stalled.Round(time.Millisecond))
+	c.mu.Lock()
+	c.closeCode, c.closeText = websocket.ClosePolicyViolation, "not reading frames"
+	c.mu.Unlock()
+	c.manager.RemoveClient(c, "write stalled")
+	c.conn.Close()
+}
+
+// sendChan returns the client's current send channel
+func (c *Client) sendChan() chan *Frame {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.send
+}
+
+// wantsFrameLocked applies the client's pause, subsample and target_fps settings to a frame about to
+// be queued; callers must hold c.mu. Subsampling counts the frames that reach it, so with target_fps as
+// well the rate cap applies to every Nth frame.
+func (c *Client) wantsFrameLocked(frame *Frame) bool {
+	if c.paused {
+		return false
+	}
+	if frame.seq != 0 && frame.seq <= c.primedSeq {
+		return false
+	}
+	if c.opts.Subsample > 1 {
+		n := c.subsampleCount
+		c.subsampleCount++
+		if n%int64(c.opts.Subsample) != 0 {
+			c.framesSubsampled.Add(1)
+			return false
+		}
+	}
+	if c.targetFPS > 0 && frame.timestamp.Sub(c.lastQueued) < time.Duration(float64(time.Second)/c.targetFPS) {
+		c.framesThrottled.Add(1)
+		return false
+	}
+	return true
+}
+
 // writePump handles outgoing frame data to the client via WebSocket
 func (c *Client) writePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(c.opts.PingInterval)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
 	}()
 
+	// In ack mode send is nil while a frame awaits its ack, so no further frame is taken until then
+	var ackTimeout *time.Timer
+	var ackDeadline <-chan time.Time
+	defer func() {
+		if ackTimeout != nil {
+			ackTimeout.Stop()
+		}
+	}()
+
+	send := c.sendChan()
 	for {
 		select {
-		case frame, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				// Channel closed, send close message and exit
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+		case <-c.resized:
+			if send != nil {
+				send = c.sendChan()
+			}
+
+		case <-c.acked:
+			ackTimeout.Stop()
+			ackDeadline = nil
+			send = c.sendChan()
+
+		case <-ackDeadline:
+			log.Printf("Client %s did not acknowledge frame %d within %s, disconnecting", c.id, c.ackSeqInFlight(), c.opts.ReadDeadline)
+			return
+
+		case <-c.done:
+			// Client removed or stream stopped; say goodbye if the connection is still open
+			c.mu.Lock()
+			goodbye := []byte{}
+			if c.closeCode != 0 {
+				goodbye = websocket.FormatCloseMessage(c.closeCode, c.closeText)
 			}
+			c.mu.Unlock()
+			c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteDeadline))
+			c.conn.WriteMessage(websocket.CloseMessage, goodbye)
+			return
 
+		case frame := <-send:
 			// Check if client is marked as closed before writing
 			c.mu.Lock()
 			closed := c.closed
@@ -66,11 +578,43 @@ func (c *Client) writePump() {
 				return
 			}
 
-			// Send frame as binary data
-			if err := c.conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			if frame.control != nil {
+				c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteDeadline))
+				if err := c.conn.WriteMessage(websocket.TextMessage, frame.control); err != nil {
+					log.Printf("Write error for client %s: %v", c.id, err)
+					return
+				}
+				continue
+			}
+
+			frame = c.catchUp(frame, time.Now())
+			data := frame.data
+			if c.opts.Width > 0 {
+				data = frame.scaledFrame(c.srcWidth, c.srcHeight, c.pixelFormat, c.opts.Width, c.opts.Height)
+			}
+
+			if c.opts.Ack {
+				if err := c.writeFrameHeader(frame); err != nil {
+					log.Printf("Write error for client %s: %v", c.id, err)
+					return
+				}
+			}
+
+			if err := c.writeFrame(frame, data); err != nil {
 				log.Printf("Write error for client %s: %v", c.id, err)
 				return
 			}
+			now := time.Now()
+			c.lastSuccessfulWrite.Store(now.UnixNano())
+			c.framesSent.Add(1)
+			c.deliveredFPS.mark(now)
+
+			// A client that never acks is treated like one whose reads time out
+			if c.opts.Ack {
+				send = nil
+				ackTimeout = time.NewTimer(c.opts.ReadDeadline)
+				ackDeadline = ackTimeout.C
+			}
 
 		case <-ticker.C:
 			// Check if client is marked as closed before sending ping
@@ -82,7 +626,7 @@ func (c *Client) writePump() {
 				return
 			}
 
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteDeadline))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
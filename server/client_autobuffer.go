@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// autoBuffer sizes an opted-in client's send buffer to its behaviour: a queue that keeps filling up is
+// doubled, up to AutoBufferMaxSize, and one that stays nearly empty is halved, down to AutoBufferMinSize.
+// Guarded by the client's mu.
+type autoBuffer struct {
+	enabled     bool
+	windowStart time.Time // start of the window fullHits is counted in
+	fullHits    int       // frames that found the queue full since windowStart
+	lowStreak   int       // consecutive frames queued with the queue at most a quarter full
+}
+
+// autoBufferQuery parses the ?auto_buffer= query parameter of a WebSocket connection request
+func autoBufferQuery(query url.Values) (bool, error) {
+	raw := query.Get("auto_buffer")
+	if raw == "" {
+		return false, nil
+	}
+	auto, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid auto_buffer: %v", err)
+	}
+	return auto, nil
+}
+
+// noteQueuedLocked updates the queue depth peak after a frame was queued and shrinks an auto-buffered
+// client's buffer once it has stayed nearly empty for AutoBufferShrinkAfter frames. Callers must hold c.mu.
+func (c *Client) noteQueuedLocked() {
+	depth, size := len(c.send), cap(c.send)
+	if depth > c.queuePeak {
+		c.queuePeak = depth
+	}
+	if !c.auto.enabled {
+		return
+	}
+
+	if depth > size/4 {
+		c.auto.lowStreak = 0
+		return
+	}
+	c.auto.lowStreak++
+	if c.auto.lowStreak < AutoBufferShrinkAfter || size <= AutoBufferMinSize {
+		return
+	}
+	c.auto.lowStreak = 0
+	c.resizeLocked(max(size/2, AutoBufferMinSize))
+	c.bufferShrinks.Add(1)
+	log.Printf("Client %s buffer mostly empty, shrunk from %d to %d frames", c.id, size, cap(c.send))
+}
+
+// growOnFullLocked records a frame that found an auto-buffered client's queue full, doubling the buffer
+// once that has happened AutoBufferGrowHits times within AutoBufferWindow. It reports whether the buffer
+// grew, in which case the frame fits. Callers must hold c.mu.
+func (c *Client) growOnFullLocked(now time.Time) bool {
+	if !c.auto.enabled {
+		return false
+	}
+	c.auto.lowStreak = 0
+	if now.Sub(c.auto.windowStart) > AutoBufferWindow {
+		c.auto.windowStart, c.auto.fullHits = now, 0
+	}
+	c.auto.fullHits++
+
+	size := cap(c.send)
+	if c.auto.fullHits < AutoBufferGrowHits || size >= AutoBufferMaxSize {
+		return false
+	}
+	c.auto.fullHits = 0
+	c.resizeLocked(min(size*2, AutoBufferMaxSize))
+	c.bufferGrows.Add(1)
+	log.Printf("Client %s buffer keeps filling up, grown from %d to %d frames", c.id, size, cap(c.send))
+	return true
+}
+
+// clientQueueDepths sums the frames queued for the stream's clients and finds the deepest queue
+func (s *Stream) clientQueueDepths() (total, deepest int) {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	for _, client := range s.clients {
+		client.mu.Lock()
+		depth := len(client.send)
+		client.mu.Unlock()
+		total += depth
+		deepest = max(deepest, depth)
+	}
+	return total, deepest
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// clientSize parses the optional w and h query parameters of a WebSocket connection. Giving only one
+// keeps the stream's aspect ratio. Frames are only ever downscaled, so the size may not exceed the
+// stream's; 0x0 means the client receives frames at the stream's own size.
+func (s *Stream) clientSize(query url.Values) (int, int, error) {
+	s.mu.RLock()
+	srcWidth, srcHeight, pixelFormat := s.width, s.height, s.pixelFormat
+	s.mu.RUnlock()
+
+	width, err := queryDimension(query, "w")
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := queryDimension(query, "h")
+	if err != nil {
+		return 0, 0, err
+	}
+	if width == 0 && height == 0 {
+		return 0, 0, nil
+	}
+
+	width, height, err = fitSize(srcWidth, srcHeight, width, height, pixelFormat, "client size")
+	if err != nil {
+		return 0, 0, err
+	}
+	if width == srcWidth && height == srcHeight {
+		return 0, 0, nil
+	}
+	return width, height, nil
+}
+
+// fitSize completes a requested downscaled size of a srcWidth x srcHeight source: a 0 side follows the
+// aspect ratio of the other, and the result may not exceed the source. pixelFormat is the format the
+// frames are delivered in; what names the size in errors.
+func fitSize(srcWidth, srcHeight, width, height int, pixelFormat, what string) (int, int, error) {
+	// yuv420p subsamples chroma 2x2, so explicit sizes must be even and derived ones round down to even
+	even := pixelFormat == "yuv420p"
+	if even && (width%2 != 0 || height%2 != 0) {
+		return 0, 0, fmt.Errorf("%w: pixel format yuv420p requires an even %s", ErrInvalidResolution, what)
+	}
+	switch {
+	case width == 0 && height == 0:
+		width, height = srcWidth, srcHeight
+	case height == 0:
+		height = (width*srcHeight + srcWidth/2) / srcWidth
+	case width == 0:
+		width = (height*srcWidth + srcHeight/2) / srcHeight
+	}
+	if even {
+		width, height = width&^1, height&^1
+	}
+	if width < 1 || height < 1 || width > srcWidth || height > srcHeight {
+		return 0, 0, fmt.Errorf("%w: %s %dx%d must be within the stream's %dx%d (frames are never upscaled)",
+			ErrInvalidResolution, what, width, height, srcWidth, srcHeight)
+	}
+	return width, height, nil
+}
+
+// queryDimension parses a positive integer query parameter, returning 0 when it's absent
+func queryDimension(query url.Values, name string) (int, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("%w: %s must be a positive integer", ErrInvalidResolution, name)
+	}
+	return v, nil
+}
+
+// scaledFrame returns the frame's data scaled to width x height, caching the result on the frame so
+// clients sharing a size scale each frame only once
+func (f *Frame) scaledFrame(srcWidth, srcHeight int, pixelFormat string, width, height int) []byte {
+	key := [2]int{width, height}
+
+	f.scaledMu.Lock()
+	defer f.scaledMu.Unlock()
+
+	if data, ok := f.scaled[key]; ok {
+		return data
+	}
+	data := scaleRaw(f.data, srcWidth, srcHeight, pixelFormat, width, height)
+	if f.scaled == nil {
+		f.scaled = make(map[[2]int][]byte)
+	}
+	f.scaled[key] = data
+	return data
+}
+
+// scaleRaw resizes a raw frame with nearest-neighbor sampling, keeping its pixel format
+func scaleRaw(frame []byte, width, height int, pixelFormat string, targetWidth, targetHeight int) []byte {
+	switch pixelFormat {
+	case "rgb24", "bgr24", "gray":
+		bpp := int(pixelFormats[pixelFormat])
+		out := make([]byte, targetWidth*targetHeight*bpp)
+		for ty := 0; ty < targetHeight; ty++ {
+			row := (ty * height / targetHeight) * width
+			for tx := 0; tx < targetWidth; tx++ {
+				src := (row + tx*width/targetWidth) * bpp
+				copy(out[(ty*targetWidth+tx)*bpp:], frame[src:src+bpp])
+			}
+		}
+		return out
+	case "yuv420p":
+		out := make([]byte, targetWidth*targetHeight*3/2)
+		scalePlane(out[:targetWidth*targetHeight], frame[:width*height], width, height, targetWidth, targetHeight)
+		srcChroma, dstChroma := width*height/4, targetWidth*targetHeight/4
+		for plane := 0; plane < 2; plane++ {
+			src := frame[width*height+plane*srcChroma : width*height+(plane+1)*srcChroma]
+			dst := out[targetWidth*targetHeight+plane*dstChroma : targetWidth*targetHeight+(plane+1)*dstChroma]
+			scalePlane(dst, src, width/2, height/2, targetWidth/2, targetHeight/2)
+		}
+		return out
+	}
+	return frame
+}
+
+// scalePlane resizes one 8-bit plane with nearest-neighbor sampling
+func scalePlane(dst, src []byte, width, height, targetWidth, targetHeight int) {
+	for ty := 0; ty < targetHeight; ty++ {
+		row := (ty * height / targetHeight) * width
+		for tx := 0; tx < targetWidth; tx++ {
+			dst[ty*targetWidth+tx] = src[row+tx*width/targetWidth]
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandleCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		ok   bool
+	}{
+		{name: "report", msg: `{"cmd":"report","fps":24.5,"rtt_ms":12}`, ok: true},
+		{name: "report without rtt", msg: `{"cmd":"report","fps":0}`, ok: true},
+		{name: "surrounding whitespace", msg: " {\"cmd\":\"report\",\"fps\":24.5}\n", ok: true},
+		{name: "resume", msg: `{"cmd":"resume","last_seq":42}`, ok: true},
+		{name: "resume without last_seq", msg: `{"cmd":"resume"}`, ok: true},
+		{name: "ack", msg: `{"ack":7}`, ok: true},
+
+		{name: "unknown cmd", msg: `{"cmd":"reboot"}`},
+		{name: "cmd in another case", msg: `{"cmd":"REPORT","fps":1}`},
+		{name: "cmd not a string", msg: `{"cmd":1}`},
+		{name: "unknown field", msg: `{"cmd":"report","fps":1,"debug":true}`},
+		{name: "field of another command", msg: `{"cmd":"report","fps":1,"last_seq":3}`},
+		{name: "resume with fps", msg: `{"cmd":"resume","fps":30}`},
+		{name: "ack with cmd fields", msg: `{"ack":7,"fps":30}`},
+		{name: "report without fps", msg: `{"cmd":"report"}`},
+		{name: "negative fps", msg: `{"cmd":"report","fps":-1}`},
+		{name: "fps out of range", msg: `{"cmd":"report","fps":1e400}`},
+		{name: "fps as a string", msg: `{"cmd":"report","fps":"fast"}`},
+		{name: "empty object", msg: `{}`},
+		{name: "null", msg: `null`},
+		{name: "array", msg: `[{"ack":1}]`},
+		{name: "two objects", msg: `{"ack":1}{"ack":2}`},
+		{name: "trailing data", msg: `{"ack":1} ok`},
+		{name: "truncated", msg: `{"cmd":"report","fps":`},
+		{name: "plain text", msg: `hello`},
+		{name: "empty", msg: ``},
+	}
+	for _, tt := range tests {
+		c := &Client{id: "test", reportedFPS: -1}
+		err := c.handleCommand([]byte(tt.msg))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: handleCommand(%q) = %v, want ok %v", tt.name, tt.msg, err, tt.ok)
+		}
+		// A rejected command is not applied, not even in part
+		if err != nil && c.reportedFPS != -1 {
+			t.Errorf("%s: rejected command %q set reported fps to %v", tt.name, tt.msg, c.reportedFPS)
+		}
+	}
+
+	c := &Client{id: "test"}
+	if err := c.handleCommand([]byte(`{"cmd":"report","fps":24.5,"rtt_ms":12}`)); err != nil {
+		t.Fatal(err)
+	}
+	if c.reportedFPS != 24.5 || c.reportedRTTMs != 12 || c.lastReportAt.IsZero() {
+		t.Errorf("report applied as fps %v, rtt %v at %v", c.reportedFPS, c.reportedRTTMs, c.lastReportAt)
+	}
+}
+
+// readClose reads past any frames until the server closes the connection and returns the close code
+func readClose(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("read: %v, want a close frame", err)
+		}
+		return closeErr.Code
+	}
+}
+
+// TestClientMessages sends a live client bad commands and oversized messages: bad commands are counted
+// and tolerated up to MaxRejectedClientMessages, oversized ones close the connection, and in every case
+// the close is clean and the stream keeps serving its other clients
+func TestClientMessages(t *testing.T) {
+	ts := newTestServer(t, 25, nil)
+	ts.startStream(t, map[string]interface{}{
+		"stream_id": "chatty",
+		"rtsp_url":  "rtsp://camera.example/chatty",
+		"width":     16,
+		"height":    16,
+	})
+	bystander, _, err := ts.dial(t, "chatty", "")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	t.Run("invalid commands", func(t *testing.T) {
+		conn, _, err := ts.dial(t, "chatty", "")
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		bad := []string{
+			`{"cmd":"reboot"}`,
+			`{"cmd":"report","fps":30,"verbose":true}`,
+			`not json`,
+			`{"ack":1}{"ack":2}`,
+		}
+		for _, msg := range bad {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				t.Fatalf("send %q: %v", msg, err)
+			}
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte{1, 2, 3}); err != nil {
+			t.Fatalf("send binary: %v", err)
+		}
+		// A valid command between the bad ones still applies
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"report","fps":12}`)); err != nil {
+			t.Fatalf("send report: %v", err)
+		}
+
+		var info map[string]interface{}
+		waitFor(t, 5*time.Second, "the report", func() bool {
+			var resp struct {
+				Clients []map[string]interface{} `json:"clients"`
+			}
+			ts.do(t, http.MethodGet, "/api/streams/chatty/clients", nil, &resp)
+			for _, client := range resp.Clients {
+				if client["reported_fps"] == 12.0 {
+					info = client
+					return true
+				}
+			}
+			return false
+		})
+		if rejected := info["messages_rejected"]; rejected != float64(len(bad)+1) {
+			t.Errorf("messages_rejected = %v, want %d", rejected, len(bad)+1)
+		}
+		// Tolerated so far: frames keep coming
+		readFrame(t, conn, 5*time.Second)
+
+		for i := len(bad) + 1; i < MaxRejectedClientMessages; i++ {
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"reboot"}`))
+		}
+		if code := readClose(t, conn); code != websocket.ClosePolicyViolation {
+			t.Errorf("close code after %d invalid messages = %d, want %d", MaxRejectedClientMessages, code, websocket.ClosePolicyViolation)
+		}
+	})
+
+	for _, oversized := range []struct {
+		name        string
+		messageType int
+	}{{"oversized text", websocket.TextMessage}, {"oversized binary", websocket.BinaryMessage}} {
+		oversized := oversized
+		t.Run(oversized.name, func(t *testing.T) {
+			conn, _, err := ts.dial(t, "chatty", "")
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			msg := `{"cmd":"report","fps":1,"pad":"` + strings.Repeat("x", DefaultWebSocketReadLimit) + `"}`
+			if err := conn.WriteMessage(oversized.messageType, []byte(msg)); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+			if code := readClose(t, conn); code != websocket.CloseMessageTooBig {
+				t.Errorf("close code = %d, want %d", code, websocket.CloseMessageTooBig)
+			}
+		})
+	}
+
+	// The misbehaving clients are gone and the stream carries on for the others
+	waitFor(t, 5*time.Second, "the closed clients to leave", func() bool { return ts.stream(t, "chatty").clientCount() == 1 })
+	readFrame(t, bystander, 5*time.Second)
+}
+
+func TestWriteStalledLocked(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		maxStall time.Duration
+		since    time.Duration // since the last frame was written
+		drop     bool
+	}{
+		{name: "off", maxStall: 0, since: time.Hour},
+		{name: "within the limit", maxStall: 30 * time.Second, since: 10 * time.Second},
+		{name: "at the limit", maxStall: 30 * time.Second, since: 30 * time.Second},
+		{name: "past the limit", maxStall: 30 * time.Second, since: 31 * time.Second, drop: true},
+	}
+	for _, tt := range tests {
+		c := &Client{opts: ClientOptions{MaxWriteStall: tt.maxStall}}
+		c.lastSuccessfulWrite.Store(now.Add(-tt.since).UnixNano())
+		stalled, drop := c.writeStalledLocked(now)
+		if drop != tt.drop || stalled != tt.since {
+			t.Errorf("%s: writeStalledLocked = %s, %v; want %s, %v", tt.name, stalled, drop, tt.since, tt.drop)
+		}
+		// The drop is claimed once; later full buffers don't start another
+		if _, again := c.writeStalledLocked(now.Add(time.Second)); tt.drop && again {
+			t.Errorf("%s: second writeStalledLocked also dropped", tt.name)
+		}
+	}
+}
+
+// TestStalledClientDropped connects a client that never reads next to one that does: once the socket
+// and the send buffer of the first are full it must be disconnected after max_write_stall, while the
+// reader keeps getting frames
+func TestStalledClientDropped(t *testing.T) {
+	const maxStall = 500 * time.Millisecond
+	ts := newTestServer(t, 25, func(sm *StreamManager) { sm.config.Client.MaxWriteStall = maxStall })
+	// Frames of this size fill the kernel's socket buffers within a second or so
+	ts.startStream(t, map[string]interface{}{
+		"stream_id": "stuck",
+		"rtsp_url":  "rtsp://camera.example/stuck",
+		"width":     320,
+		"height":    240,
+	})
+	reader, _, err := ts.dial(t, "stuck", "")
+	if err != nil {
+		t.Fatalf("dial reader: %v", err)
+	}
+	var read atomic.Int64
+	go func() {
+		for {
+			if _, _, err := reader.ReadMessage(); err != nil {
+				return
+			}
+			read.Add(1)
+		}
+	}()
+	_, resp, err := ts.dial(t, "stuck", "")
+	if err != nil {
+		t.Fatalf("dial stalled client: %v", err)
+	}
+	stream := ts.stream(t, "stuck")
+	waitFor(t, 5*time.Second, "both clients", func() bool { return stream.clientCount() == 2 })
+
+	var dropped *AuditEntry
+	waitFor(t, 10*time.Second, "the stalled client to be dropped", func() bool {
+		for _, entry := range ts.sm.audit.query(time.Time{}, "stuck") {
+			if entry.Type == "client_disconnected" {
+				entry := entry
+				dropped = &entry
+				return true
+			}
+		}
+		return false
+	})
+	if dropped.RequestID != resp.Header.Get(RequestIDHeader) || dropped.Details["reason"] != "write stalled" {
+		t.Errorf("client %v of request %s disconnected for %v, want the stalled client of request %s for write stalled",
+			dropped.Details["client_id"], dropped.RequestID, dropped.Details["reason"], resp.Header.Get(RequestIDHeader))
+	}
+	if connected, _ := time.ParseDuration(fmt.Sprint(dropped.Details["connected"])); connected < maxStall {
+		t.Errorf("stalled client dropped after %s connected, before max_write_stall %s", connected, maxStall)
+	}
+	if n := stream.clientCount(); n != 1 {
+		t.Errorf("%d clients left, want the reader", n)
+	}
+
+	before := read.Load()
+	waitFor(t, 5*time.Second, "frames for the reader after the drop", func() bool { return read.Load() > before+5 })
+}
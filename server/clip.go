@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clipRequest is the body of POST /api/streams/:streamId/clip
+type clipRequest struct {
+	// Duration, e.g. "10s", is how far back from the latest frame the clip reaches
+	Duration string `json:"duration" binding:"required"`
+}
+
+// since returns the cached frames no older than d before the newest one, oldest first, along with
+// how much time the whole cache covers. The newest frame counts for one frame interval.
+func (fc *frameCache) since(d time.Duration) ([]*Frame, time.Duration) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	if fc.count == 0 {
+		return nil, 0
+	}
+	oldest, newest := fc.at(0).timestamp, fc.at(fc.count-1).timestamp
+	buffered := newest.Sub(oldest)
+	if fc.count > 1 {
+		buffered += buffered / time.Duration(fc.count-1)
+	}
+
+	cutoff := newest.Add(-d)
+	var frames []*Frame
+	for i := 0; i < fc.count; i++ {
+		if frame := fc.at(i); !frame.timestamp.Before(cutoff) {
+			frames = append(frames, frame)
+		}
+	}
+	return frames, buffered
+}
+
+// clipFPS is the average frame rate of a run of frames, falling back to DefaultClipFPS
+func clipFPS(frames []*Frame) float64 {
+	if len(frames) < 2 {
+		return DefaultClipFPS
+	}
+	span := frames[len(frames)-1].timestamp.Sub(frames[0].timestamp)
+	if span <= 0 {
+		return DefaultClipFPS
+	}
+	return float64(len(frames)-1) / span.Seconds()
+}
+
+// clipEncodeArgs returns the FFmpeg arguments encoding the stream's raw frames, piped in at fps, into a
+// fragmented MP4, which FFmpeg can write to a pipe without seeking back to patch the header. The
+// stream's gop sets the keyframe interval and with it the fragment length.
+func clipEncodeArgs(stream *Stream, fps float64) []string {
+	args := []string{
+		"-f", "rawvideo",
+		"-pix_fmt", stream.pixelFormat,
+		"-s", fmt.Sprintf("%dx%d", stream.width, stream.height),
+		"-framerate", fmt.Sprintf("%.3f", fps),
+		"-i", "-",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+	}
+	if stream.gop > 0 {
+		args = append(args, "-g", strconv.Itoa(stream.gop))
+	}
+	return append(args,
+		"-pix_fmt", "yuv420p",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4",
+		"-",
+	)
+}
+
+// encodeClip pipes raw frames into FFmpeg and returns them as a fragmented MP4
+func (sm *StreamManager) encodeClip(ctx context.Context, stream *Stream, frames []*Frame) ([]byte, error) {
+	args := clipEncodeArgs(stream, clipFPS(frames))
+
+	// A one-shot encode has nothing to flush, so cancellation may kill FFmpeg outright
+	cmd := sm.runner.CommandContext(ctx, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdin pipe: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start FFmpeg: %v", err)
+	}
+
+	frameSize := stream.frameSize()
+	go func() {
+		defer stdin.Close()
+		for _, frame := range frames {
+			// Frames of another size would misalign every frame after them
+			if len(frame.data) != frameSize {
+				continue
+			}
+			if _, err := stdin.Write(frame.data); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("FFmpeg clip encode failed: %v: %s", err, lastLine(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// lastLine returns the last non-empty line of FFmpeg's output, which usually holds the error
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// handleClip encodes the last few seconds of a stream from its frame cache into an MP4 download
+func (sm *StreamManager) handleClip(c *gin.Context) {
+	var req clipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "duration must be a positive duration such as \"10s\"", nil)
+		return
+	}
+
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	frames, buffered := stream.frameCache.since(duration)
+	if len(frames) == 0 {
+		respondError(c, http.StatusServiceUnavailable, CodeFrameUnavailable, "No frames buffered yet", nil)
+		return
+	}
+	if duration > buffered+FrameCacheTolerance {
+		respondError(c, http.StatusBadRequest, CodeClipTooLong,
+			fmt.Sprintf("Requested %s but only %s is buffered", duration, buffered.Round(time.Millisecond)),
+			map[string]interface{}{"buffered": buffered.Round(time.Millisecond).String(), "max": sm.config.FrameCacheWindow.String()})
+		return
+	}
+
+	if !sm.clipEncodes.acquire() {
+		c.Header("Retry-After", "1")
+		respondError(c, http.StatusTooManyRequests, CodeTooManyRequests, "Too many clips being encoded", nil)
+		return
+	}
+	defer sm.clipEncodes.release()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), ClipEncodeTimeout)
+	defer cancel()
+
+	data, err := sm.encodeClip(ctx, stream, frames)
+	if err != nil {
+		log.Printf("Clip for stream %s failed: %v", stream.streamID, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to encode clip", nil)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.mp4", stream.streamID, frames[len(frames)-1].timestamp.UTC().Format("20060102T150405Z"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("X-Clip-Frames", fmt.Sprint(len(frames)))
+	c.Header("X-Clip-Start", fmt.Sprint(frames[0].timestamp.UnixNano()))
+	c.Data(http.StatusOK, "video/mp4", data)
+}
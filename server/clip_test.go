@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClipEncodeArgsGOP(t *testing.T) {
+	tests := []struct {
+		gop  int
+		want string // the -g argument, or "" for none
+	}{
+		{gop: 0, want: ""},
+		{gop: 1, want: "-g 1"},
+		{gop: 50, want: "-g 50"},
+		{gop: MaxGOP, want: fmt.Sprintf("-g %d", MaxGOP)},
+	}
+	for _, tt := range tests {
+		stream := &Stream{width: 64, height: 48, pixelFormat: "bgr24", gop: tt.gop}
+		args := strings.Join(clipEncodeArgs(stream, 25), " ")
+		if tt.want == "" {
+			if strings.Contains(args, "-g ") {
+				t.Errorf("gop %d: args %q set a keyframe interval", tt.gop, args)
+			}
+			continue
+		}
+		// -g is an output option, so it must come after the input
+		if i := strings.Index(args, tt.want+" "); i < 0 || i < strings.Index(args, "-i -") {
+			t.Errorf("gop %d: args %q lack %q after the input", tt.gop, args, tt.want)
+		}
+	}
+}
+
+// TestSnapshotDecodesWithGOP checks that a stream with a long keyframe interval still serves a complete,
+// decodable image from any frame: raw frames, JPEG frames and thumbnails don't depend on keyframes
+func TestSnapshotDecodesWithGOP(t *testing.T) {
+	ts := newTestServer(t, 25, nil)
+	for _, pixelFormat := range []string{"bgr24", "rgb24", "gray", "yuv420p"} {
+		streamID := "gop-" + pixelFormat
+		ts.startStream(t, map[string]interface{}{
+			"stream_id":    streamID,
+			"rtsp_url":     "rtsp://camera.example/" + streamID,
+			"width":        64,
+			"height":       48,
+			"pixel_format": pixelFormat,
+			"gop":          MaxGOP,
+		})
+
+		var format map[string]interface{}
+		ts.do(t, http.MethodGet, "/api/streams/"+streamID+"/format", nil, &format)
+		if format["gop"] != float64(MaxGOP) || format["keyframe_interval"] != float64(1) {
+			t.Errorf("%s: format reports gop %v and keyframe_interval %v, want %d and 1", pixelFormat, format["gop"], format["keyframe_interval"], MaxGOP)
+		}
+
+		// Successive snapshots land on arbitrary frames of the GOP; each must decode on its own
+		for i := 0; i < 3; i++ {
+			resp, body := ts.get(t, "/api/streams/"+streamID+"/frame", FrameTypeRaw)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s: raw frame: status %d: %s", pixelFormat, resp.StatusCode, body)
+			}
+			checkMockFrame(t, body, 64, 48, pixelFormat)
+
+			resp, body = ts.get(t, "/api/streams/"+streamID+"/frame", FrameTypeJPEG)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s: JPEG frame: status %d: %s", pixelFormat, resp.StatusCode, body)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("%s: JPEG frame does not decode: %v", pixelFormat, err)
+			}
+			if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 48 {
+				t.Errorf("%s: JPEG frame is %dx%d, want 64x48", pixelFormat, b.Dx(), b.Dy())
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		resp, body := ts.get(t, "/api/streams/"+streamID+"/thumbnail.jpg", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: thumbnail: status %d: %s", pixelFormat, resp.StatusCode, body)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(body)); err != nil {
+			t.Errorf("%s: thumbnail does not decode: %v", pixelFormat, err)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cloneRequest is the body of POST /api/streams/:streamId/clone
+type cloneRequest struct {
+	StreamID string `json:"stream_id" binding:"required"`
+
+	// Output of the clone; a 0 side keeps the parent's aspect ratio, and both 0 keep its size
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	PixelFormat string  `json:"pixel_format"` // empty keeps the parent's
+	FPS         float64 `json:"fps"`          // frame rate cap; 0 passes every parent frame
+
+	MaxDuration string                 `json:"max_duration"`
+	IdleTimeout string                 `json:"idle_timeout"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// CloneStream starts a stream that derives its frames from an existing stream's ingest instead of
+// running FFmpeg: each parent frame is scaled and converted in process, so a second size or format of
+// a camera costs no second connection or decode. Cloning a clone derives from the same ingest.
+func (sm *StreamManager) CloneStream(parentID string, req cloneRequest) (*Stream, error) {
+	if req.FPS < 0 || req.FPS > MaxClientTargetFPS {
+		return nil, fmt.Errorf("fps must be between 0 (every frame) and %v", MaxClientTargetFPS)
+	}
+	if err := validateMetadata(req.Metadata); err != nil {
+		return nil, err
+	}
+	if _, err := parseMaxDuration(req.MaxDuration); err != nil {
+		return nil, err
+	}
+	if _, err := parseIdleTimeout(req.IdleTimeout); err != nil {
+		return nil, err
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	parent, exists := sm.streams[parentID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrStreamNotFound, parentID)
+	}
+	if parent.parent != nil {
+		parent = parent.parent
+	}
+	if _, exists := sm.streams[req.StreamID]; exists {
+		return nil, fmt.Errorf("%w: %s", ErrStreamExists, req.StreamID)
+	}
+	if sm.config.MaxStreams > 0 && len(sm.streams) >= sm.config.MaxStreams {
+		return nil, fmt.Errorf("%w: at most %d streams may run at once", ErrStreamLimit, sm.config.MaxStreams)
+	}
+
+	pixelFormat := req.PixelFormat
+	if pixelFormat == "" {
+		pixelFormat = parent.pixelFormat
+	}
+	if _, ok := pixelFormats[pixelFormat]; !ok {
+		return nil, fmt.Errorf("%w %q (supported: bgr24, rgb24, gray, yuv420p)", ErrUnsupportedPixelFormat, pixelFormat)
+	}
+	width, height, err := fitSize(parent.width, parent.height, req.Width, req.Height, pixelFormat, "clone size")
+	if err != nil {
+		return nil, err
+	}
+
+	// The clone reports the parent's input settings; what it doesn't run itself is switched off
+	opts := parent.opts
+	opts.Width, opts.Height, opts.PixelFormat = width, height, pixelFormat
+	opts.MaxDuration, opts.IdleTimeout, opts.Metadata = req.MaxDuration, req.IdleTimeout, req.Metadata
+	opts.MotionDetection, opts.Audio, opts.Record, opts.PlaceholderOnStall = false, false, false, false
+	opts.LocalSocketPath, opts.Processors = "", nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clone := sm.newStream(req.StreamID, parent.rtspURL, opts, cancel)
+	clone.inputURLs = parent.inputURLs
+	clone.runner = parent.runner
+	clone.sched = parent.sched
+	clone.parent = parent
+	clone.cloneFPS = req.FPS
+	clone.cloneFrames = make(chan *Frame, CloneFrameBuffer)
+
+	sm.registerLocked(clone)
+	parent.clonesMu.Lock()
+	if parent.clones == nil {
+		parent.clones = make(map[string]*Stream)
+	}
+	parent.clones[clone.streamID] = clone
+	parent.clonesMu.Unlock()
+
+	// Start out in the parent's state; from here on setStatus on the parent carries it over
+	parent.mu.RLock()
+	status := parent.status
+	parent.mu.RUnlock()
+	clone.setStatus(status, "")
+
+	sm.tasks.goTask("clone feed for stream "+clone.streamID, func() { sm.runClone(ctx, clone) })
+	sm.tasks.goTask("frame distribution for stream "+clone.streamID, func() { sm.distributeFrames(clone) })
+	sm.tasks.goTask("health monitor for stream "+clone.streamID, func() { sm.monitorStreamHealth(clone) })
+
+	log.Printf("Cloned stream %s from %s (%dx%d %s)", clone.streamID, parent.streamID, width, height, pixelFormat)
+	return clone, nil
+}
+
+// feedClones hands a new ingest frame to every clone without blocking; a clone still converting an
+// earlier frame drops this one
+func (s *Stream) feedClones(frame *Frame) {
+	s.clonesMu.RLock()
+	defer s.clonesMu.RUnlock()
+
+	for _, clone := range s.clones {
+		select {
+		case clone.cloneFrames <- frame:
+		default:
+			clone.droppedFrames.Add(1)
+		}
+	}
+}
+
+// runClone turns parent frames into the clone's own frames until the clone stops. This takes the
+// place of the FFmpeg read loop: frames get the clone's sequence numbers and fill its cache and buffer.
+func (sm *StreamManager) runClone(ctx context.Context, clone *Stream) {
+	parent := clone.parent
+	var minInterval time.Duration
+	if clone.cloneFPS > 0 {
+		minInterval = time.Duration(float64(time.Second) / clone.cloneFPS)
+	}
+	var lastQueued time.Time
+	firstFrame := true
+
+	for {
+		select {
+		case <-clone.healthStopChan:
+			return
+		case source := <-clone.cloneFrames:
+			if minInterval > 0 && source.timestamp.Sub(lastQueued) < minInterval {
+				clone.cappedFrames.Add(1)
+				continue
+			}
+			lastQueued = source.timestamp
+
+			frame := &Frame{
+				data:      cloneFrameData(source, parent, clone),
+				timestamp: source.timestamp,
+				seq:       clone.frameCount.Add(1),
+				fromPTS:   source.fromPTS,
+			}
+			clone.frameCache.add(frame)
+			clone.newFrames.notify()
+			if firstFrame {
+				firstFrame = false
+				clone.markFramesFlowing()
+			}
+			clone.enqueueFrame(ctx, frame)
+
+			now := time.Now()
+			clone.lastFrameTime.Store(now.UnixNano())
+			clone.ingestRate.mark()
+			clone.currentFPS.mark(now)
+		}
+	}
+}
+
+// cloneFrameData converts a parent frame to the clone's size and pixel format. Same-format scaling is
+// cached on the frame, so a clone and WebSocket clients of the parent at that size share it.
+func cloneFrameData(source *Frame, parent, clone *Stream) []byte {
+	sameSize := clone.width == parent.width && clone.height == parent.height
+	if clone.pixelFormat == parent.pixelFormat {
+		if sameSize {
+			return source.data
+		}
+		return source.scaledFrame(parent.width, parent.height, parent.pixelFormat, clone.width, clone.height)
+	}
+
+	rgb := make([]byte, clone.width*clone.height*3)
+	for ty := 0; ty < clone.height; ty++ {
+		sy := ty * parent.height / clone.height
+		for tx := 0; tx < clone.width; tx++ {
+			sx := tx * parent.width / clone.width
+			i := (ty*clone.width + tx) * 3
+			rgb[i], rgb[i+1], rgb[i+2] = pixelAt(source.data, parent.width, parent.height, parent.pixelFormat, sx, sy)
+		}
+	}
+	return encodeRGB(rgb, clone.width, clone.height, clone.pixelFormat)
+}
+
+// cloneList returns the streams cloned from this one
+func (s *Stream) cloneList() []*Stream {
+	s.clonesMu.RLock()
+	defer s.clonesMu.RUnlock()
+
+	clones := make([]*Stream, 0, len(s.clones))
+	for _, clone := range s.clones {
+		clones = append(clones, clone)
+	}
+	return clones
+}
+
+// cloneIDs returns the IDs of the streams cloned from this one, for stats
+func (s *Stream) cloneIDs() []string {
+	ids := make([]string, 0)
+	for _, clone := range s.cloneList() {
+		ids = append(ids, clone.streamID)
+	}
+	return ids
+}
+
+// detachCloneLocked removes a stopped clone from its parent. A parent that was itself stopped while
+// clones used its ingest stops ingesting with its last clone. The caller holds sm.mu.
+func (sm *StreamManager) detachCloneLocked(clone *Stream) {
+	parent := clone.parent
+	parent.clonesMu.Lock()
+	delete(parent.clones, clone.streamID)
+	remaining := len(parent.clones)
+	parent.clonesMu.Unlock()
+
+	if parent.retired && remaining == 0 {
+		sm.stopIngestLocked(parent)
+		log.Printf("Stopped the shared ingest of stream %s with its last clone %s", parent.streamID, clone.streamID)
+	}
+}
+
+// ingestState reports whether an FFmpeg run is feeding the stream and whether ingest is paused;
+// clones report their parent's
+func (s *Stream) ingestState() (ingesting, paused bool) {
+	ingest := s
+	if s.parent != nil {
+		ingest = s.parent
+	}
+	ingest.mu.RLock()
+	defer ingest.mu.RUnlock()
+	return ingest.ffmpegUp, ingest.paused
+}
+
+// errIfClone refuses an operation that only the stream's own ingest can do when the stream is a clone
+func (s *Stream) errIfClone(what string) error {
+	if s.parent == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s has no %s of its own; use its parent %s", ErrStreamIsClone, s.streamID, what, s.parent.streamID)
+}
+
+// handleCloneStream derives a new stream with its own output settings from an existing stream's ingest
+func (sm *StreamManager) handleCloneStream(c *gin.Context) {
+	var req cloneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+	streamID, err := validateStreamID(req.StreamID)
+	if err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+	req.StreamID = streamID
+
+	clone, err := sm.CloneStream(c.Param("streamId"), req)
+	if err != nil {
+		if errors.Is(err, ErrStreamNotFound) || errors.Is(err, ErrStreamExists) || errors.Is(err, ErrStreamLimit) {
+			respondManagerError(c, err)
+		} else {
+			respondInvalidRequest(c, err)
+		}
+		return
+	}
+
+	sm.auditRequest(c, "stream_cloned", clone.streamID, map[string]interface{}{
+		"clone_of":     clone.parent.streamID,
+		"width":        clone.width,
+		"height":       clone.height,
+		"pixel_format": clone.pixelFormat,
+		"fps":          clone.cloneFPS,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Stream cloned successfully",
+		"stream_id":    clone.streamID,
+		"clone_of":     clone.parent.streamID,
+		"width":        clone.width,
+		"height":       clone.height,
+		"pixel_format": clone.pixelFormat,
+		"fps":          clone.cloneFPS,
+	})
+}
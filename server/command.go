@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// credentialParams are URL query parameters that commonly carry camera credentials, e.g.
+// rtsp://cam/stream?user=admin&password=secret; their values are masked like a URL password
+var credentialParams = map[string]bool{
+	"password": true,
+	"pass":     true,
+	"pwd":      true,
+	"passwd":   true,
+	"token":    true,
+	"auth":     true,
+	"key":      true,
+	"secret":   true,
+	"sig":      true,
+}
+
+// shellSafeArg matches arguments that need no quoting in a POSIX shell
+var shellSafeArg = regexp.MustCompile(`^[A-Za-z0-9_./:=,+@%-]+$`)
+
+// redactCommandArg masks the credentials of a URL argument: the password of its user info and the
+// values of credentialParams. Other arguments are returned unchanged.
+func redactCommandArg(arg string) string {
+	u, err := url.Parse(arg)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return arg
+	}
+	query, masked := u.Query(), false
+	for name := range query {
+		if credentialParams[strings.ToLower(name)] {
+			query.Set(name, "xxxxx")
+			masked = true
+		}
+	}
+	// Re-encoding reorders the parameters, so only a query that had credentials is rewritten
+	if masked {
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+// shellQuote quotes arg for a POSIX shell, so the command can be pasted into a terminal
+func shellQuote(arg string) string {
+	if shellSafeArg.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// handleGetFFmpegCommand returns the arguments of the stream's latest ingest FFmpeg run with credentials
+// masked, as a list and as a shell command line, to reproduce the invocation by hand
+func (sm *StreamManager) handleGetFFmpegCommand(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+	if err := stream.errIfClone("FFmpeg process"); err != nil {
+		respondManagerError(c, err)
+		return
+	}
+
+	stream.mu.RLock()
+	binary, args, startedAt := stream.ffmpegBinary, stream.ffmpegArgs, stream.ffmpegStartedAt
+	stream.mu.RUnlock()
+	if args == nil {
+		respondError(c, http.StatusConflict, CodeStreamNotRunning, "FFmpeg has not been started for this stream yet", nil)
+		return
+	}
+
+	redacted := make([]string, len(args))
+	quoted := []string{shellQuote(binary)}
+	for i, arg := range args {
+		redacted[i] = redactCommandArg(arg)
+		quoted = append(quoted, shellQuote(redacted[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stream_id":  stream.streamID,
+		"binary":     binary,
+		"args":       redacted,
+		"command":    strings.Join(quoted, " "),
+		"started_at": startedAt,
+	})
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds runtime settings loaded from environment variables
+type Config struct {
+	// AuditLogSize is how many audit entries are kept in memory; AuditLogFile, when set, also receives
+	// every entry as a JSON line and is rotated once it reaches AuditLogMaxBytes
+	AuditLogSize     int
+	AuditLogFile     string
+	AuditLogMaxBytes int
+
+	// WebhooksFile is a JSON file of webhooks registered at startup; WebhookSecret signs deliveries
+	// to webhooks without their own secret, and WebhookQueueSize bounds the pending deliveries
+	WebhooksFile     string
+	WebhookSecret    string
+	WebhookQueueSize int
+
+	// DrainTimeout is how long a draining stream waits for its clients to leave before it is stopped anyway
+	DrainTimeout time.Duration
+
+	// ShutdownTimeout is the budget for draining HTTP requests and stopping streams, FFmpeg and clients
+	ShutdownTimeout time.Duration
+
+	// ListenAddr is the host:port the HTTP server binds, e.g. ":8091", "127.0.0.1:8091" or "[::]:8091"
+	ListenAddr string
+
+	// SigningSecret is the HMAC key for signed stream URLs; signing is disabled when empty
+	SigningSecret []byte
+
+	// TLSCertFile and TLSKeyFile enable HTTPS/WSS when both are set
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// LocalSocketDir is the directory streams may create local_socket_path sockets in; disabled when empty
+	LocalSocketDir string
+
+	// RecordingDir is where streams started with record write their segments, one directory per
+	// stream; recording is disabled when empty
+	RecordingDir string
+
+	// RecordingSegment is how much video each recording segment holds; RecordingMaxSegments is how many
+	// segments each stream keeps, oldest deleted first, 0 keeping them all
+	RecordingSegment     time.Duration
+	RecordingMaxSegments int
+
+	// AuthKeysFile is a JSON file of scoped API keys; the API is unauthenticated when empty
+	AuthKeysFile string
+
+	// AdminUser and AdminPass require HTTP Basic credentials on the control routes when both are set
+	AdminUser string
+	AdminPass string
+
+	// InputSchemes is the allow-list of input kinds streams may read from (rtsp, udp, http, file, device, ...)
+	InputSchemes map[string]bool
+
+	// Client holds the default WebSocket deadlines and ping interval for new clients
+	Client ClientOptions
+
+	// MaxIngestFPS caps how many frames per second each stream pushes into its buffer; 0 disables the cap
+	MaxIngestFPS float64
+
+	// BufferHighWater is the fraction of a stream's frame buffer that, once filled, flags buffer pressure
+	BufferHighWater float64
+
+	// FFmpegCheckInterval is how often FFmpeg is re-checked and how long health probes cache the result
+	FFmpegCheckInterval time.Duration
+
+	// BreakerThreshold is how many consecutive failed FFmpeg runs open a stream's circuit breaker; 0 disables it
+	BreakerThreshold int
+
+	// BreakerCooldown is how long an open circuit breaker suspends retries before a trial run
+	BreakerCooldown time.Duration
+
+	// ReconnectJitter randomizes each FFmpeg restart delay by up to this fraction either way, so streams
+	// that failed together, e.g. on one NVR, don't all retry at the same moment; 0 disables it
+	ReconnectJitter float64
+
+	// DiscoveryTimeout is how long ONVIF discovery waits for cameras to answer the multicast probe
+	DiscoveryTimeout time.Duration
+
+	// MaxStreams caps how many streams may run at once; 0 means unlimited
+	MaxStreams int
+
+	// MaxFFmpegProcesses caps how many streams run an ingest FFmpeg at once; 0 means unlimited. Starts
+	// beyond it wait in a queue of up to StartQueueSize streams.
+	MaxFFmpegProcesses int
+	StartQueueSize     int
+
+	// FrameRatePerIP is how many /frame requests per second one client IP may make; 0 disables the limit
+	FrameRatePerIP float64
+
+	// TrustedProxies are the addresses or CIDRs of reverse proxies whose X-Forwarded-For and X-Real-IP
+	// headers name the client IP; empty trusts none, so the client IP is the connection's peer address
+	TrustedProxies []string
+
+	// FrameCacheSize and FrameCacheWindow bound the per-stream cache of recent frames, which
+	// serves /frame?ts= lookups and bounds the longest clip
+	FrameCacheSize   int
+	FrameCacheWindow time.Duration
+
+	// FrameRequestLimit caps concurrent GET /frame requests per stream; further requests get 429
+	FrameRequestLimit int
+
+	// FFmpegNice is the default niceness (0-19) of FFmpeg processes, keeping the server responsive under load
+	FFmpegNice int
+
+	// FFmpegCPUs is the default CPU set FFmpeg processes are pinned to; empty allows every CPU
+	FFmpegCPUs []int
+
+	// FFmpegPath is the FFmpeg binary streams run by default, a path or a name looked up in PATH
+	FFmpegPath string
+
+	// FFmpegBinaries maps names streams may select with ffmpeg_binary to alternative FFmpeg builds
+	FFmpegBinaries map[string]string
+
+	// FFmpegLogLevel is how much of FFmpeg's stderr streams log by default: error, warning, info or debug
+	FFmpegLogLevel string
+
+	// FFmpegMock replaces FFmpeg with a built-in synthetic source emitting a test pattern at FFmpegMockFPS
+	FFmpegMock    bool
+	FFmpegMockFPS float64
+
+	// EncodeCacheSize is how many encoded snapshots (per size and quality) each stream keeps
+	EncodeCacheSize int
+}
+
+// loadConfig reads the server configuration from the environment
+func loadConfig() (Config, error) {
+	cfg := Config{}
+
+	cfg.ListenAddr = ServerPort
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return cfg, fmt.Errorf("LISTEN_ADDR: %v (bracket IPv6 addresses, e.g. [::]:8091)", err)
+		}
+		cfg.ListenAddr = addr
+	}
+
+	if secret := os.Getenv("STREAM_SIGNING_SECRET"); secret != "" {
+		cfg.SigningSecret = []byte(secret)
+	}
+
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return cfg, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if dir := os.Getenv("LOCAL_SOCKET_DIR"); dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return cfg, fmt.Errorf("LOCAL_SOCKET_DIR: %v", err)
+		}
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			return cfg, fmt.Errorf("LOCAL_SOCKET_DIR %s is not a directory", abs)
+		}
+		cfg.LocalSocketDir = abs
+	}
+
+	if dir := os.Getenv("RECORDING_DIR"); dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return cfg, fmt.Errorf("RECORDING_DIR: %v", err)
+		}
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			return cfg, fmt.Errorf("RECORDING_DIR %s is not a directory", abs)
+		}
+		cfg.RecordingDir = abs
+	}
+
+	cfg.AuthKeysFile = os.Getenv("AUTH_KEYS_FILE")
+
+	cfg.AdminUser = os.Getenv("ADMIN_USER")
+	cfg.AdminPass = os.Getenv("ADMIN_PASS")
+	if (cfg.AdminUser == "") != (cfg.AdminPass == "") {
+		return cfg, fmt.Errorf("ADMIN_USER and ADMIN_PASS must be set together")
+	}
+
+	var err error
+	inputSchemes := os.Getenv("INPUT_SCHEMES")
+	if inputSchemes == "" {
+		inputSchemes = DefaultInputSchemes
+	}
+	if cfg.InputSchemes, err = parseInputSchemes(inputSchemes); err != nil {
+		return cfg, err
+	}
+
+	if cfg.RecordingSegment, err = durationEnv("RECORDING_SEGMENT", DefaultRecordingSegment); err != nil {
+		return cfg, err
+	}
+	if cfg.RecordingSegment < time.Second {
+		return cfg, fmt.Errorf("RECORDING_SEGMENT must be at least 1s")
+	}
+	if cfg.RecordingMaxSegments, err = intEnv("RECORDING_MAX_SEGMENTS", DefaultRecordingMaxSegments); err != nil {
+		return cfg, err
+	}
+	if cfg.RecordingMaxSegments < 0 {
+		return cfg, fmt.Errorf("RECORDING_MAX_SEGMENTS must not be negative")
+	}
+
+	if cfg.Client.ReadDeadline, err = durationEnv("WS_READ_DEADLINE", WebSocketReadDeadline); err != nil {
+		return cfg, err
+	}
+	if cfg.Client.WriteDeadline, err = durationEnv("WS_WRITE_DEADLINE", WebSocketWriteDeadline); err != nil {
+		return cfg, err
+	}
+	if cfg.Client.PingInterval, err = durationEnv("WS_PING_INTERVAL", WebSocketPingInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.Client.MinBitrateMbps, err = floatEnv("WS_MIN_BITRATE_MBPS", DefaultMinBitrateMbps); err != nil {
+		return cfg, err
+	}
+	if cfg.Client.MinFrameWriteDeadline, err = durationEnv("WS_FRAME_WRITE_DEADLINE_MIN", MinFrameWriteDeadline); err != nil {
+		return cfg, err
+	}
+	if cfg.Client.MaxFrameWriteDeadline, err = durationEnv("WS_FRAME_WRITE_DEADLINE_MAX", MaxFrameWriteDeadline); err != nil {
+		return cfg, err
+	}
+	readLimit, err := intEnv("WS_READ_LIMIT", DefaultWebSocketReadLimit)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Client.ReadLimit = int64(readLimit)
+	if cfg.Client.MaxStaleness, err = durationEnv("WS_MAX_STALENESS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.Client.MaxWriteStall, err = durationEnv("WS_MAX_WRITE_STALL", DefaultMaxWriteStall); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Client.validate(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.MaxIngestFPS, err = floatEnv("MAX_INGEST_FPS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxIngestFPS < 0 {
+		return cfg, fmt.Errorf("MAX_INGEST_FPS must not be negative")
+	}
+
+	if cfg.BufferHighWater, err = floatEnv("BUFFER_HIGH_WATER", DefaultBufferHighWater); err != nil {
+		return cfg, err
+	}
+	if cfg.BufferHighWater <= 0 || cfg.BufferHighWater > 1 {
+		return cfg, fmt.Errorf("BUFFER_HIGH_WATER must be greater than 0 and at most 1")
+	}
+
+	if cfg.FFmpegCheckInterval, err = durationEnv("FFMPEG_CHECK_INTERVAL", DefaultFFmpegCheckInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.FFmpegCheckInterval < time.Second {
+		return cfg, fmt.Errorf("FFMPEG_CHECK_INTERVAL must be at least 1s")
+	}
+
+	if cfg.BreakerThreshold, err = intEnv("BREAKER_THRESHOLD", DefaultBreakerThreshold); err != nil {
+		return cfg, err
+	}
+	if cfg.BreakerThreshold < 0 {
+		return cfg, fmt.Errorf("BREAKER_THRESHOLD must not be negative")
+	}
+	if cfg.BreakerCooldown, err = durationEnv("BREAKER_COOLDOWN", DefaultBreakerCooldown); err != nil {
+		return cfg, err
+	}
+	if cfg.BreakerCooldown <= 0 {
+		return cfg, fmt.Errorf("BREAKER_COOLDOWN must be positive")
+	}
+	if cfg.ReconnectJitter, err = floatEnv("RECONNECT_JITTER", DefaultReconnectJitter); err != nil {
+		return cfg, err
+	}
+	if cfg.ReconnectJitter < 0 || cfg.ReconnectJitter > 1 {
+		return cfg, fmt.Errorf("RECONNECT_JITTER must be between 0 and 1")
+	}
+
+	if cfg.DiscoveryTimeout, err = durationEnv("ONVIF_PROBE_TIMEOUT", DefaultDiscoveryTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.DiscoveryTimeout <= 0 || cfg.DiscoveryTimeout > MaxDiscoveryTimeout {
+		return cfg, fmt.Errorf("ONVIF_PROBE_TIMEOUT must be between 0 and %s", MaxDiscoveryTimeout)
+	}
+
+	if cfg.MaxStreams, err = intEnv("MAX_STREAMS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxStreams < 0 {
+		return cfg, fmt.Errorf("MAX_STREAMS must not be negative")
+	}
+
+	if cfg.MaxFFmpegProcesses, err = intEnv("MAX_FFMPEG_PROCESSES", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxFFmpegProcesses < 0 {
+		return cfg, fmt.Errorf("MAX_FFMPEG_PROCESSES must not be negative")
+	}
+	if cfg.StartQueueSize, err = intEnv("START_QUEUE_SIZE", DefaultStartQueueSize); err != nil {
+		return cfg, err
+	}
+	if cfg.StartQueueSize < 0 {
+		return cfg, fmt.Errorf("START_QUEUE_SIZE must not be negative")
+	}
+
+	if cfg.FrameRatePerIP, err = floatEnv("FRAME_RATE_PER_IP", DefaultFrameRatePerIP); err != nil {
+		return cfg, err
+	}
+	if cfg.FrameRatePerIP < 0 {
+		return cfg, fmt.Errorf("FRAME_RATE_PER_IP must not be negative")
+	}
+	if cfg.TrustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		return cfg, fmt.Errorf("TRUSTED_PROXIES: %v", err)
+	}
+
+	if cfg.AuditLogSize, err = intEnv("AUDIT_LOG_SIZE", DefaultAuditLogSize); err != nil {
+		return cfg, err
+	}
+	if cfg.AuditLogSize < 1 {
+		return cfg, fmt.Errorf("AUDIT_LOG_SIZE must be at least 1")
+	}
+	cfg.AuditLogFile = os.Getenv("AUDIT_LOG_FILE")
+	if cfg.AuditLogMaxBytes, err = intEnv("AUDIT_LOG_MAX_BYTES", DefaultAuditLogMaxBytes); err != nil {
+		return cfg, err
+	}
+	if cfg.AuditLogMaxBytes < 1024 {
+		return cfg, fmt.Errorf("AUDIT_LOG_MAX_BYTES must be at least 1024")
+	}
+
+	cfg.WebhooksFile = os.Getenv("WEBHOOKS_FILE")
+	cfg.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	if cfg.WebhookQueueSize, err = intEnv("WEBHOOK_QUEUE_SIZE", DefaultWebhookQueueSize); err != nil {
+		return cfg, err
+	}
+	if cfg.WebhookQueueSize < 1 {
+		return cfg, fmt.Errorf("WEBHOOK_QUEUE_SIZE must be at least 1")
+	}
+
+	if cfg.DrainTimeout, err = durationEnv("DRAIN_TIMEOUT", DefaultDrainTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.DrainTimeout <= 0 || cfg.DrainTimeout > MaxStreamDuration {
+		return cfg, fmt.Errorf("DRAIN_TIMEOUT must be positive and at most %s", MaxStreamDuration)
+	}
+
+	if cfg.ShutdownTimeout, err = durationEnv("SHUTDOWN_TIMEOUT", DefaultShutdownTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return cfg, fmt.Errorf("SHUTDOWN_TIMEOUT must be positive")
+	}
+
+	if cfg.FrameCacheSize, err = intEnv("FRAME_CACHE_SIZE", DefaultFrameCacheSize); err != nil {
+		return cfg, err
+	}
+	if cfg.FrameCacheSize < 1 {
+		return cfg, fmt.Errorf("FRAME_CACHE_SIZE must be at least 1")
+	}
+	if cfg.FrameCacheWindow, err = durationEnv("FRAME_CACHE_WINDOW", DefaultFrameCacheWindow); err != nil {
+		return cfg, err
+	}
+	if cfg.FrameCacheWindow <= 0 {
+		return cfg, fmt.Errorf("FRAME_CACHE_WINDOW must be positive")
+	}
+
+	if cfg.FrameRequestLimit, err = intEnv("FRAME_REQUEST_LIMIT", DefaultFrameRequestLimit); err != nil {
+		return cfg, err
+	}
+	if cfg.FrameRequestLimit < 1 {
+		return cfg, fmt.Errorf("FRAME_REQUEST_LIMIT must be at least 1")
+	}
+
+	if cfg.FFmpegNice, err = intEnv("FFMPEG_NICE", 0); err != nil {
+		return cfg, err
+	}
+	if err := validateNice("FFMPEG_NICE", cfg.FFmpegNice); err != nil {
+		return cfg, err
+	}
+	if cfg.FFmpegCPUs, err = parseCPUList(os.Getenv("FFMPEG_CPUS")); err != nil {
+		return cfg, fmt.Errorf("FFMPEG_CPUS: %v", err)
+	}
+
+	cfg.FFmpegPath = DefaultFFmpegPath
+	if path := os.Getenv("FFMPEG_PATH"); path != "" {
+		cfg.FFmpegPath = path
+	}
+	if cfg.FFmpegBinaries, err = parseFFmpegBinaries(os.Getenv("FFMPEG_BINARIES")); err != nil {
+		return cfg, fmt.Errorf("FFMPEG_BINARIES: %v", err)
+	}
+
+	cfg.FFmpegLogLevel = DefaultFFmpegLogLevel
+	if level := os.Getenv("FFMPEG_LOG_LEVEL"); level != "" {
+		if err := validateFFmpegLogLevel(level); err != nil {
+			return cfg, fmt.Errorf("FFMPEG_LOG_LEVEL: %v", err)
+		}
+		cfg.FFmpegLogLevel = level
+	}
+
+	if raw := os.Getenv("FFMPEG_MOCK"); raw != "" {
+		if cfg.FFmpegMock, err = strconv.ParseBool(raw); err != nil {
+			return cfg, fmt.Errorf("FFMPEG_MOCK: %v", err)
+		}
+	}
+	if cfg.FFmpegMockFPS, err = floatEnv("FFMPEG_MOCK_FPS", DefaultMockFFmpegFPS); err != nil {
+		return cfg, err
+	}
+	if cfg.FFmpegMockFPS <= 0 || cfg.FFmpegMockFPS > MaxClientTargetFPS {
+		return cfg, fmt.Errorf("FFMPEG_MOCK_FPS must be greater than 0 and at most %v", MaxClientTargetFPS)
+	}
+
+	if cfg.EncodeCacheSize, err = intEnv("ENCODE_CACHE_SIZE", DefaultEncodeCacheSize); err != nil {
+		return cfg, err
+	}
+	if cfg.EncodeCacheSize < 1 {
+		return cfg, fmt.Errorf("ENCODE_CACHE_SIZE must be at least 1")
+	}
+
+	return cfg, nil
+}
+
+// parseFFmpegBinaries parses a comma-separated list of name=path pairs, e.g. "nvenc=/opt/ffmpeg-nvenc/bin/ffmpeg"
+func parseFFmpegBinaries(raw string) (map[string]string, error) {
+	binaries := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, path, ok := strings.Cut(entry, "=")
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+		if !ok || path == "" || !streamIDPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid entry %q, want name=path with a name of letters, digits, '_' or '-'", entry)
+		}
+		if _, dup := binaries[name]; dup {
+			return nil, fmt.Errorf("%s is defined twice", name)
+		}
+		binaries[name] = path
+	}
+	return binaries, nil
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDRs, e.g. "10.0.0.0/8,::1"
+func parseTrustedProxies(raw string) ([]string, error) {
+	var proxies []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", entry)
+			}
+		} else if net.ParseIP(entry) == nil {
+			return nil, fmt.Errorf("invalid IP address %q", entry)
+		}
+		proxies = append(proxies, entry)
+	}
+	return proxies, nil
+}
+
+// intEnv parses an integer from an environment variable, returning def when unset
+func intEnv(name string, def int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+	return v, nil
+}
+
+// floatEnv parses a number from an environment variable, returning def when unset
+func floatEnv(name string, def float64) (float64, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+	return v, nil
+}
+
+// durationEnv parses a duration such as "30s" from an environment variable, returning def when unset
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+	return d, nil
+}
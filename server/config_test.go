@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		env, want string
+		ok        bool
+	}{
+		{"", ServerPort, true},
+		{":8091", ":8091", true},
+		{"127.0.0.1:9000", "127.0.0.1:9000", true},
+		{"[::]:8091", "[::]:8091", true},
+		{"[::1]:8091", "[::1]:8091", true},
+		{"[fe80::1%eth0]:8091", "[fe80::1%eth0]:8091", true},
+		{"::8091", "", false},
+		{"2001:db8::1:8091", "", false},
+		{"localhost", "", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("LISTEN_ADDR", tt.env)
+		cfg, err := loadConfig()
+		if !tt.ok {
+			if err == nil || !strings.Contains(err.Error(), "LISTEN_ADDR") {
+				t.Errorf("LISTEN_ADDR=%s: error %v, want one naming LISTEN_ADDR", tt.env, err)
+			}
+			continue
+		}
+		if err != nil || cfg.ListenAddr != tt.want {
+			t.Errorf("LISTEN_ADDR=%s: ListenAddr %q, %v; want %q", tt.env, cfg.ListenAddr, err, tt.want)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	tests := []struct {
+		env  string
+		want []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"127.0.0.1", []string{"127.0.0.1"}, true},
+		{" 10.0.0.0/8, ::1 ,fd00::/8,", []string{"10.0.0.0/8", "::1", "fd00::/8"}, true},
+		{"proxy.internal", nil, false},
+		{"10.0.0.0/33", nil, false},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRUSTED_PROXIES", tt.env)
+		cfg, err := loadConfig()
+		if !tt.ok {
+			if err == nil || !strings.Contains(err.Error(), "TRUSTED_PROXIES") {
+				t.Errorf("TRUSTED_PROXIES=%s: error %v, want one naming TRUSTED_PROXIES", tt.env, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(cfg.TrustedProxies, tt.want) {
+			t.Errorf("TRUSTED_PROXIES=%s: %q, %v; want %q", tt.env, cfg.TrustedProxies, err, tt.want)
+		}
+	}
+}
@@ -4,32 +4,289 @@ import "time"
 
 // Server configuration constants
 const (
-	// ServerPort is the port on which the RTSP server listens
+	// ServerPort is the default listen address of the RTSP server; LISTEN_ADDR overrides it
 	ServerPort = ":8091"
 
 	// FrameBufferSize is the maximum number of frames to buffer per stream
 	FrameBufferSize = 100
 
+	// DefaultBufferHighWater is the fraction of FrameBufferSize that flags buffer pressure by default
+	DefaultBufferHighWater = 0.8
+
+	// BufferPressureLogInterval is the minimum time between buffer pressure warnings for one stream
+	BufferPressureLogInterval = time.Minute
+
+	// DefaultFrameCacheSize is the default maximum number of recent frames kept per stream for
+	// timestamp lookups and clips
+	DefaultFrameCacheSize = 60
+
+	// DefaultFrameCacheWindow is how far back the per-stream recent-frame cache reaches by default
+	DefaultFrameCacheWindow = 2 * time.Second
+
+	// LocalSocketBufferSize is how many frames may queue for one local socket consumer before frames are dropped
+	LocalSocketBufferSize = 4
+
+	// LocalSocketWriteTimeout disconnects a local socket consumer that stops reading
+	LocalSocketWriteTimeout = 5 * time.Second
+
+	// BroadcastShardSize is how many clients one goroutine delivers a frame to; streams with more
+	// clients fan delivery out over several goroutines
+	BroadcastShardSize = 16
+
+	// DefaultClipFPS is the clip frame rate used when too few frames are cached to measure it
+	DefaultClipFPS = 25.0
+
+	// ClipEncodeTimeout bounds how long FFmpeg may take to encode one clip
+	ClipEncodeTimeout = 60 * time.Second
+
+	// ClipEncodeLimit is how many clips may be encoded at once across all streams
+	ClipEncodeLimit = 2
+
+	// FrameCacheTolerance is how far outside the cached window a requested timestamp may fall
+	FrameCacheTolerance = 100 * time.Millisecond
+
+	// WaitReadyDefaultTimeout is how long wait-ready blocks when no timeout is given
+	WaitReadyDefaultTimeout = 10 * time.Second
+
+	// WaitReadyMaxTimeout is the longest timeout a wait-ready request may ask for
+	WaitReadyMaxTimeout = 60 * time.Second
+
+	// DefaultThumbnailWidth is the thumbnail width used when none is requested
+	DefaultThumbnailWidth = 160
+
+	// MaxThumbnailDimension caps both sides of a thumbnail
+	MaxThumbnailDimension = 640
+
+	// ThumbnailCacheTTL is how long an encoded thumbnail may be reused after newer frames arrive
+	ThumbnailCacheTTL = 1 * time.Second
+
+	// DefaultEncodeCacheSize is how many encoded snapshots each stream caches by default
+	DefaultEncodeCacheSize = 16
+
+	// ThumbnailJPEGQuality is the JPEG quality used for thumbnails
+	ThumbnailJPEGQuality = 75
+
+	// DefaultGridColumns and DefaultGridTileWidth lay out /api/snapshot-grid.jpg when cols and w are not given
+	DefaultGridColumns   = 4
+	DefaultGridTileWidth = 320
+
+	// MaxGridColumns, MinGridTileWidth, MaxGridTiles and MaxGridDimension bound the snapshot grid; tiles that
+	// would make either side longer than MaxGridDimension pixels are left out
+	MaxGridColumns   = 16
+	MinGridTileWidth = 64
+	MaxGridTiles     = 64
+	MaxGridDimension = 4096
+
+	// GridCaptionScaleWidth is the tile width per step of caption text scale; GridCaptionPadding surrounds
+	// the caption in pixels
+	GridCaptionScaleWidth = 160
+	GridCaptionPadding    = 4
+
+	// FrameJPEGQuality is the JPEG quality of full-size frames requested with Accept: image/jpeg
+	FrameJPEGQuality = 85
+
+	// MaxMetadataBytes bounds the JSON-encoded size of a stream's metadata labels
+	MaxMetadataBytes = 4096
+
+	// DefaultDiscoveryTimeout is how long ONVIF discovery listens for probe replies by default
+	DefaultDiscoveryTimeout = 3 * time.Second
+
+	// MaxDiscoveryTimeout caps the ONVIF probe timeout
+	MaxDiscoveryTimeout = 15 * time.Second
+
+	// ONVIFRequestTimeout bounds each SOAP call made while resolving a camera's stream URIs
+	ONVIFRequestTimeout = 5 * time.Second
+
+	// DefaultRecordingSegment is how much video each recording segment holds by default
+	DefaultRecordingSegment = time.Hour
+
+	// DefaultRecordingMaxSegments is how many segments a stream's recording keeps by default
+	DefaultRecordingMaxSegments = 24
+
+	// RecordingFilePattern names recording segments by their start time (FFmpeg strftime syntax)
+	RecordingFilePattern = "%Y%m%d-%H%M%S.ts"
+
+	// AudioBitrate is the AAC bitrate used for audio passthrough
+	AudioBitrate = "64k"
+
+	// AudioChunkSize is the largest chunk of encoded audio read from FFmpeg at once
+	AudioChunkSize = 4096
+
+	// AudioListenerBufferSize is how many audio chunks may queue per listener before chunks are dropped
+	AudioListenerBufferSize = 64
+
+	// TSChunkPackets is how many 188-byte packets the MPEG-TS output reads from FFmpeg at once
+	TSChunkPackets = 64
+
+	// TSListenerBufferSize is how many MPEG-TS chunks may queue per listener before chunks are dropped
+	TSListenerBufferSize = 256
+
+	// DefaultMockFFmpegFPS is the frame rate of the mock FFmpeg's test pattern
+	DefaultMockFFmpegFPS = 25.0
+
+	// DropBlockTimeout is how long the block-with-timeout drop policy waits for buffer space
+	DropBlockTimeout = 200 * time.Millisecond
+
+	// BatchStartWorkers bounds how many streams a batch start request brings up concurrently
+	BatchStartWorkers = 8
+
+	// MaxBatchStartSize caps the number of streams in one batch start request
+	MaxBatchStartSize = 100
+
+	// MaxStreamListLimit caps the page size of the stream list
+	MaxStreamListLimit = 1000
+
 	// ClientBufferSize is the maximum number of frames to buffer per client
 	ClientBufferSize = 10
 
+	// MaxClientBufferSize caps a client's send buffer when it is resized at runtime
+	MaxClientBufferSize = 1000
+
+	// AutoBufferMinSize and AutoBufferMaxSize bound the send buffer of a client with ?auto_buffer=true
+	AutoBufferMinSize = 2
+	AutoBufferMaxSize = 120
+
+	// AutoBufferGrowHits is how many frames must find an auto-buffered client's queue full within
+	// AutoBufferWindow before its buffer is doubled
+	AutoBufferGrowHits = 5
+	AutoBufferWindow   = 10 * time.Second
+
+	// AutoBufferShrinkAfter is how many consecutive frames must find an auto-buffered client's queue at
+	// most a quarter full before its buffer is halved
+	AutoBufferShrinkAfter = 300
+
+	// PacingMaxBacklog is how many frames may wait in a paced stream's buffer before pacing lets them
+	// through straight away to catch up
+	PacingMaxBacklog = 5
+
+	// JitterSmoothingDivisor weights each new gap difference in the output jitter estimate, as in RFC 3550
+	JitterSmoothingDivisor = 16
+
+	// MaxClientTargetFPS caps the per-client delivery rate set at runtime
+	MaxClientTargetFPS = 120.0
+
+	// MaxClientSubsample caps the ?subsample= factor of a WebSocket client
+	MaxClientSubsample = 1000
+
+	// CloneFrameBuffer is how many parent frames a clone may have waiting for conversion
+	CloneFrameBuffer = 2
+
+	// DefaultInputSchemes is the input allow-list used when INPUT_SCHEMES is unset
+	DefaultInputSchemes = "rtsp"
+
+	// MaxGOP caps the per-stream keyframe interval, in frames
+	MaxGOP = 600
+
+	// MaxStreamDuration caps a stream's max_duration
+	MaxStreamDuration = 30 * 24 * time.Hour
+
 	// DefaultWidth is the default frame width when not specified
 	DefaultWidth = 640
 
 	// DefaultHeight is the default frame height when not specified
 	DefaultHeight = 480
 
+	// DefaultPixelFormat is the raw pixel format used when not specified
+	DefaultPixelFormat = "bgr24"
+
+	// IngestRateWindow is the window over which the effective ingest frame rate is measured
+	IngestRateWindow = 2 * time.Second
+
+	// FPSSmoothing is the weight of the newest inter-frame gap in the current_fps moving average
+	FPSSmoothing = 0.1
+
+	// FPSIdleTimeout is how long without frames before current_fps reports 0
+	FPSIdleTimeout = 2 * time.Second
+
 	// HealthCheckInterval is how often to check stream health
 	HealthCheckInterval = 5 * time.Second
 
 	// MaxStallDuration is the maximum time allowed without frames before restart
 	MaxStallDuration = 10 * time.Second
 
+	// StallFrameIntervals is how many frame intervals at min_expected_fps may pass without a frame before
+	// a slow source counts as stalled; MaxStallThreshold caps the resulting threshold
+	StallFrameIntervals = 2
+	MaxStallThreshold   = time.Hour
+
 	// FFmpegRestartDelay is the delay before restarting FFmpeg after an error
 	FFmpegRestartDelay = 2 * time.Second
 
-	// GracefulShutdownDelay is the time to wait for FFmpeg to stop gracefully
-	GracefulShutdownDelay = 100 * time.Millisecond
+	// MaxRestartDelay caps the exponential backoff between FFmpeg restart attempts
+	MaxRestartDelay = 30 * time.Second
+
+	// DefaultReconnectJitter spreads FFmpeg restart delays by up to 25% either way by default
+	DefaultReconnectJitter = 0.25
+
+	// DefaultBreakerThreshold is how many consecutive failed FFmpeg runs open the circuit breaker by default
+	DefaultBreakerThreshold = 10
+
+	// DefaultBreakerCooldown is how long an open circuit breaker waits before a trial run by default
+	DefaultBreakerCooldown = 5 * time.Minute
+
+	// FailoverThreshold is the number of consecutive failures on one input URL before trying the next
+	FailoverThreshold = 3
+
+	// FailoverCycleBackoff is the pause after every input URL has failed, before retrying the primary
+	FailoverCycleBackoff = 15 * time.Second
+
+	// DefaultFFmpegCheckInterval is how often FFmpeg availability is re-checked by default
+	DefaultFFmpegCheckInterval = 30 * time.Second
+
+	// FFmpegProbeTimeout bounds short informational FFmpeg runs such as listing decoders
+	FFmpegProbeTimeout = 10 * time.Second
+
+	// DefaultFFmpegPath is the FFmpeg binary used when FFMPEG_PATH is unset, looked up in PATH
+	DefaultFFmpegPath = "ffmpeg"
+
+	// DefaultFFmpegLogLevel is the FFMPEG_LOG_LEVEL used when unset; warnings and errors only
+	DefaultFFmpegLogLevel = "warning"
+
+	// FFmpegStderrDrainTimeout is how long a finished FFmpeg run waits for the rest of its stderr,
+	// which usually holds the reason it exited
+	FFmpegStderrDrainTimeout = 500 * time.Millisecond
+
+	// DefaultStartQueueSize is how many starts may wait for an FFmpeg slot when MAX_FFMPEG_PROCESSES is set
+	DefaultStartQueueSize = 50
+
+	// DefaultDrainTimeout is how long a draining stream waits for its clients to leave by default
+	DefaultDrainTimeout = 15 * time.Minute
+
+	// DefaultShutdownTimeout is how long shutdown waits for HTTP requests, streams and clients to finish
+	DefaultShutdownTimeout = 5 * time.Second
+
+	// DefaultAuditLogSize is how many audit entries are kept in memory for GET /api/events
+	DefaultAuditLogSize = 1000
+
+	// DefaultAuditLogMaxBytes is the size at which the audit log file is rotated
+	DefaultAuditLogMaxBytes = 10 << 20
+
+	// AuditLogBackups is how many rotated audit log files (path.1 is the newest) are kept
+	AuditLogBackups = 3
+
+	// DefaultWebhookQueueSize is how many webhook deliveries may wait before new ones are dropped
+	DefaultWebhookQueueSize = 1000
+
+	// WebhookWorkers is how many webhook deliveries are sent concurrently
+	WebhookWorkers = 4
+
+	// WebhookTimeout bounds one webhook request, including reading the response status
+	WebhookTimeout = 5 * time.Second
+
+	// WebhookMaxAttempts is how many times a delivery is tried before it counts as failed
+	WebhookMaxAttempts = 4
+
+	// WebhookRetryBackoff is the wait before the first retry; it doubles for each further one
+	WebhookRetryBackoff = time.Second
+
+	// MinIdleTimeout is the shortest idle_timeout accepted; idleness is checked every 5 seconds
+	MinIdleTimeout = 10 * time.Second
+
+	// GracefulShutdownDelay is how long FFmpeg gets to exit after SIGTERM before it is killed
+	GracefulShutdownDelay = 2 * time.Second
+
+	// CertReloadInterval is how often the TLS certificate files are checked for changes
+	CertReloadInterval = 30 * time.Second
 
 	// WebSocketPingInterval is how often to send ping messages to clients
 	WebSocketPingInterval = 54 * time.Second
@@ -40,9 +297,112 @@ const (
 	// WebSocketWriteDeadline is the deadline for writing WebSocket messages
 	WebSocketWriteDeadline = 10 * time.Second
 
-	// WebSocketReadLimit is the maximum message size for incoming WebSocket messages
-	WebSocketReadLimit = 512
+	// DefaultMinBitrateMbps is the slowest client link, in Mbit/s, that frame write deadlines allow for
+	DefaultMinBitrateMbps = 8.0
+
+	// MinFrameWriteDeadline and MaxFrameWriteDeadline clamp the size-based deadline for writing one frame
+	MinFrameWriteDeadline = 2 * time.Second
+	MaxFrameWriteDeadline = 60 * time.Second
+
+	// PTSQueueSize is how many showinfo PTS samples may wait for their frames to be read
+	PTSQueueSize = 64
+
+	// PTSWaitTimeout is how long the frame reader waits for a frame's PTS before using its arrival time
+	PTSWaitTimeout = 100 * time.Millisecond
+
+	// PTSMaxDrift is how far a frame's arrival may trail its PTS-based time before the PTS clock is
+	// re-anchored, e.g. after the source restarted its timestamps
+	PTSMaxDrift = 2 * time.Second
+
+	// DefaultMaxWriteStall is how long a client's buffer may stay full without a frame reaching its
+	// connection before the client is disconnected
+	DefaultMaxWriteStall = 30 * time.Second
+
+	// MaxWebSocketDeadline is the upper bound accepted for configurable WebSocket deadlines and intervals
+	MaxWebSocketDeadline = 10 * time.Minute
+
+	// DefaultWebSocketReadLimit is the maximum size of an incoming WebSocket message; clients only send
+	// small JSON commands, so anything larger is abuse and closes the connection
+	DefaultWebSocketReadLimit = 4096
+
+	// MinWebSocketReadLimit and MaxWebSocketReadLimit bound WS_READ_LIMIT
+	MinWebSocketReadLimit = 256
+	MaxWebSocketReadLimit = 64 << 10
+
+	// MaxRejectedClientMessages is how many malformed or unknown commands or non-empty binary messages
+	// a client may send before it is disconnected
+	MaxRejectedClientMessages = 10
 
 	// FrameRequestTimeout is the timeout for HTTP frame requests
 	FrameRequestTimeout = 5 * time.Second
+
+	// DefaultFrameRatePerIP is how many /frame requests per second each client IP may make by default
+	DefaultFrameRatePerIP = 30.0
+
+	// IPLimiterTTL is how long an idle client IP's rate-limit state is kept
+	IPLimiterTTL = time.Minute
+
+	// MaxTrackedIPs bounds the number of client IPs the rate limiter tracks at once
+	MaxTrackedIPs = 10000
+
+	// DefaultFrameRequestLimit is how many HTTP frame requests may be in flight per stream by default
+	DefaultFrameRequestLimit = 64
+
+	// SignedURLDefaultTTL is the validity of a signed stream URL when no ttl is requested
+	SignedURLDefaultTTL = 10 * time.Minute
+
+	// DefaultMotionThreshold is the mean absolute luma difference (0-255) that counts as motion
+	DefaultMotionThreshold = 8.0
+
+	// DefaultMotionSampleStep is the pixel stride used to downsample frames for motion detection
+	DefaultMotionSampleStep = 8
+
+	// MotionPreRoll is how much video before detected motion on_motion clients receive
+	MotionPreRoll = time.Second
+
+	// MotionPostRoll is how long on_motion clients keep receiving frames after the last detected motion
+	MotionPostRoll = 3 * time.Second
+
+	// MotionEventInterval is the minimum time between two motion events for a stream
+	MotionEventInterval = 500 * time.Millisecond
+
+	// PlaceholderInterval is how often the "NO SIGNAL" placeholder is sent to clients of a stalled stream
+	PlaceholderInterval = time.Second
+
+	// EventSubscriberBufferSize is the number of events buffered per event-stream subscriber
+	EventSubscriberBufferSize = 32
+
+	// SSEKeepAliveInterval is how often an idle server-sent-events connection receives a comment line
+	SSEKeepAliveInterval = 15 * time.Second
+
+	// StatusRateInterval is how often status event streams receive a frame-rate update
+	StatusRateInterval = 2 * time.Second
+
+	// SignedURLMaxTTL is the longest validity a signed stream URL may be issued with
+	SignedURLMaxTTL = 24 * time.Hour
+
+	// SelfTestStreamPrefix reserves the IDs of self-test streams; caller-chosen stream IDs may not start with it
+	SelfTestStreamPrefix = "_selftest-"
+
+	// SelfTestTimeout bounds a self-test run from starting FFmpeg to the last frame received
+	SelfTestTimeout = 10 * time.Second
+
+	// SelfTestTeardownTimeout bounds how long a self-test waits for its FFmpeg to exit
+	SelfTestTeardownTimeout = 5 * time.Second
+
+	// SelfTestFrames is how many frames the self-test client must receive for the test to pass
+	SelfTestFrames = 5
+
+	// SelfTestWidth, SelfTestHeight and SelfTestFPS describe the synthetic source a self-test ingests
+	SelfTestWidth  = 160
+	SelfTestHeight = 120
+	SelfTestFPS    = 10
 )
+
+// pixelFormats maps each supported raw output pixel format to its bytes per pixel
+var pixelFormats = map[string]float64{
+	"bgr24":   3,
+	"rgb24":   3,
+	"gray":    1,
+	"yuv420p": 1.5,
+}
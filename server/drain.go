@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DrainStream retires a stream without cutting off its viewers: it takes no new WebSocket clients from
+// now on and stops once the connected ones have left, or after timeout, whichever comes first. A stream
+// without clients stops straight away. Draining a stream that is already draining keeps its deadline.
+// It returns the clients still connected and the drain deadline.
+func (sm *StreamManager) DrainStream(streamID string, timeout time.Duration) (int, time.Time, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	stream, exists := sm.streams[streamID]
+	if !exists {
+		return 0, time.Time{}, fmt.Errorf("%w: %s", ErrStreamNotFound, streamID)
+	}
+
+	stream.clientsMu.RLock()
+	clients := len(stream.clients)
+	stream.clientsMu.RUnlock()
+
+	if stream.draining {
+		return clients, stream.drainDeadline, nil
+	}
+
+	now := time.Now()
+	stream.draining = true
+	stream.drainDeadline = now.Add(timeout)
+	if clients == 0 {
+		sm.finishDrainLocked(stream, "no_clients")
+		return 0, now, nil
+	}
+
+	stream.drainTimer = time.AfterFunc(timeout, func() { sm.drainTimedOut(stream) })
+	stream.events.publish(StreamEvent{
+		"type":           "drain",
+		"stream_id":      streamID,
+		"state":          "draining",
+		"client_count":   clients,
+		"drain_deadline": stream.drainDeadline,
+	})
+	log.Printf("Draining stream %s: waiting up to %s for %d client(s) to leave", streamID, timeout, clients)
+	return clients, stream.drainDeadline, nil
+}
+
+// drainTimedOut stops a draining stream whose clients didn't all leave in time, unless it was already
+// stopped or replaced
+func (sm *StreamManager) drainTimedOut(stream *Stream) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.streams[stream.streamID] != stream {
+		return
+	}
+	sm.finishDrainLocked(stream, "timeout")
+}
+
+// finishDrainLocked announces the end of a drain, then stops the stream, closing any clients left.
+// The caller holds sm.mu.
+func (sm *StreamManager) finishDrainLocked(stream *Stream, reason string) {
+	stream.clientsMu.RLock()
+	clients := len(stream.clients)
+	stream.clientsMu.RUnlock()
+
+	stream.events.publish(StreamEvent{
+		"type":            "drain",
+		"stream_id":       stream.streamID,
+		"state":           "drained",
+		"reason":          reason,
+		"clients_dropped": clients,
+	})
+	log.Printf("Stream %s drained (%s), stopping it with %d client(s) left", stream.streamID, reason, clients)
+	sm.stopLocked(stream)
+}
+
+// drainDeadlineOrNil returns when a draining stream will be stopped at the latest, or nil when it isn't draining
+func (s *Stream) drainDeadlineOrNil() interface{} {
+	if !s.draining {
+		return nil
+	}
+	return s.drainDeadline
+}
+
+// handleDrainStream puts a stream in drain mode, stopping it once its current clients have left
+func (sm *StreamManager) handleDrainStream(c *gin.Context) {
+	streamID := c.Param("streamId")
+
+	timeout := sm.config.DrainTimeout
+	if raw := c.Query("timeout"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 || parsed > MaxStreamDuration {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("timeout must be a positive duration up to %s", MaxStreamDuration), nil)
+			return
+		}
+		timeout = parsed
+	}
+
+	clients, deadline, err := sm.DrainStream(streamID, timeout)
+	if err != nil {
+		respondManagerError(c, err)
+		return
+	}
+	sm.auditRequest(c, "stream_draining", streamID, map[string]interface{}{
+		"client_count": clients,
+		"timeout":      timeout.String(),
+	})
+
+	if clients == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Stream had no clients and was stopped",
+			"stream_id": streamID,
+			"stopped":   true,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Stream draining",
+		"stream_id":      streamID,
+		"stopped":        false,
+		"client_count":   clients,
+		"drain_deadline": deadline,
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Frame buffer drop policies, chosen per stream with the drop_policy option
+const (
+	// DropOldest evicts the oldest buffered frame so clients always get the freshest picture
+	DropOldest = "oldest"
+	// DropNewest discards the incoming frame, keeping what's already queued
+	DropNewest = "newest"
+	// DropBlock waits up to DropBlockTimeout for room before discarding the incoming frame,
+	// trading latency for completeness
+	DropBlock = "block-with-timeout"
+)
+
+// validateDropPolicy checks that a drop policy is one of the supported values
+func validateDropPolicy(policy string) error {
+	switch policy {
+	case DropOldest, DropNewest, DropBlock:
+		return nil
+	}
+	return fmt.Errorf("unsupported drop_policy %q (supported: %s, %s, %s)", policy, DropOldest, DropNewest, DropBlock)
+}
+
+// enqueueFrame inserts a frame into the stream's buffer, applying the stream's drop policy when it's full
+func (s *Stream) enqueueFrame(ctx context.Context, frame *Frame) {
+	select {
+	case s.frameBuffer <- frame:
+		return
+	default:
+	}
+
+	switch s.opts.DropPolicy {
+	case DropNewest:
+		s.droppedFrames.Add(1)
+		log.Printf("Frame buffer full for stream %s, dropped newest frame", s.streamID)
+
+	case DropBlock:
+		timer := time.NewTimer(DropBlockTimeout)
+		defer timer.Stop()
+
+		select {
+		case s.frameBuffer <- frame:
+		case <-timer.C:
+			s.droppedFrames.Add(1)
+			log.Printf("Frame buffer full for stream %s after %s, dropped newest frame", s.streamID, DropBlockTimeout)
+		case <-ctx.Done():
+		}
+
+	default:
+		// Buffer full, drop oldest frame and insert new
+		select {
+		case <-s.frameBuffer:
+			s.droppedFrames.Add(1)
+		default:
+		}
+		s.frameBuffer <- frame
+		log.Printf("Frame buffer full for stream %s, dropped oldest frame", s.streamID)
+	}
+}
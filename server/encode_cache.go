@@ -0,0 +1,114 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// encodeKey identifies one encoding of a frame
+type encodeKey struct {
+	width   int
+	height  int
+	format  string
+	quality int
+}
+
+// encodedFrame is a cached encoding of one frame
+type encodedFrame struct {
+	key        encodeKey
+	data       []byte
+	generation int64 // stream frame count when the source frame was current
+	frameTime  time.Time
+	createdAt  time.Time
+}
+
+// encodeCache is a small per-stream LRU of encoded snapshots (thumbnails and the like), so many
+// dashboard tiles polling the same camera share one encode per frame
+type encodeCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used at the front
+	entries map[encodeKey]*list.Element
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// newEncodeCache creates a cache holding up to size encodings
+func newEncodeCache(size int) *encodeCache {
+	return &encodeCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[encodeKey]*list.Element),
+	}
+}
+
+// get returns a cached encoding that is still fresh: made from the current frame generation,
+// or younger than ThumbnailCacheTTL so fast cameras don't force an encode on every poll
+func (ec *encodeCache) get(key encodeKey, generation int64, now time.Time) (*encodedFrame, bool) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	elem, ok := ec.entries[key]
+	if ok {
+		entry := elem.Value.(*encodedFrame)
+		if entry.generation == generation || now.Sub(entry.createdAt) < ThumbnailCacheTTL {
+			ec.order.MoveToFront(elem)
+			ec.hits.Add(1)
+			return entry, true
+		}
+		ec.order.Remove(elem)
+		delete(ec.entries, key)
+	}
+	ec.misses.Add(1)
+	return nil, false
+}
+
+// getExact returns a cached encoding only if it was made from the given frame generation
+func (ec *encodeCache) getExact(key encodeKey, generation int64) (*encodedFrame, bool) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if elem, ok := ec.entries[key]; ok && elem.Value.(*encodedFrame).generation == generation {
+		ec.order.MoveToFront(elem)
+		ec.hits.Add(1)
+		return elem.Value.(*encodedFrame), true
+	}
+	ec.misses.Add(1)
+	return nil, false
+}
+
+// put stores an encoding, evicting the least recently used entries beyond the cache size
+func (ec *encodeCache) put(entry *encodedFrame) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if elem, ok := ec.entries[entry.key]; ok {
+		elem.Value = entry
+		ec.order.MoveToFront(elem)
+		return
+	}
+	ec.entries[entry.key] = ec.order.PushFront(entry)
+
+	for ec.order.Len() > ec.size {
+		oldest := ec.order.Back()
+		ec.order.Remove(oldest)
+		delete(ec.entries, oldest.Value.(*encodedFrame).key)
+	}
+}
+
+// stats returns the cache's hit and miss counters and current size
+func (ec *encodeCache) stats() map[string]interface{} {
+	ec.mu.Lock()
+	entries := ec.order.Len()
+	ec.mu.Unlock()
+
+	return map[string]interface{}{
+		"hits":     ec.hits.Load(),
+		"misses":   ec.misses.Load(),
+		"entries":  entries,
+		"capacity": ec.size,
+	}
+}
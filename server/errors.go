@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Machine-readable error codes returned in the "code" field of every error response
+const (
+	CodeInvalidRequest       = "INVALID_REQUEST"
+	CodeInvalidResolution    = "INVALID_RESOLUTION"
+	CodeInvalidPixelFormat   = "INVALID_PIXEL_FORMAT"
+	CodeInputNotAllowed      = "INPUT_NOT_ALLOWED"
+	CodeStreamNotFound       = "STREAM_NOT_FOUND"
+	CodeStreamExists         = "STREAM_EXISTS"
+	CodeStreamLimit          = "STREAM_LIMIT_REACHED"
+	CodeStartQueueFull       = "START_QUEUE_FULL"
+	CodeStreamNotRunning     = "STREAM_NOT_RUNNING"
+	CodeStreamNotReady       = "STREAM_NOT_READY"
+	CodeStreamPaused         = "STREAM_PAUSED"
+	CodeStreamNotPaused      = "STREAM_NOT_PAUSED"
+	CodeStreamIsClone        = "STREAM_IS_CLONE"
+	CodeStreamDraining       = "STREAM_DRAINING"
+	CodeClientsConnected     = "CLIENTS_CONNECTED"
+	CodeClientNotFound       = "CLIENT_NOT_FOUND"
+	CodeFFmpegFailed         = "FFMPEG_FAILED"
+	CodeSigningDisabled      = "SIGNING_DISABLED"
+	CodeInvalidSignature     = "INVALID_SIGNATURE"
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeForbidden            = "FORBIDDEN"
+	CodeFrameNotFound        = "FRAME_NOT_FOUND"
+	CodeFrameUnavailable     = "FRAME_UNAVAILABLE"
+	CodeTooManyRequests      = "TOO_MANY_REQUESTS"
+	CodeClipTooLong          = "CLIP_TOO_LONG"
+	CodeLocalSocket          = "LOCAL_SOCKET_UNAVAILABLE"
+	CodeHWAccelUnavailable   = "HWACCEL_UNAVAILABLE"
+	CodeAudioUnavailable     = "AUDIO_UNAVAILABLE"
+	CodeRecordingUnavailable = "RECORDING_UNAVAILABLE"
+	CodeWebhookNotFound      = "WEBHOOK_NOT_FOUND"
+	CodeNotAcceptable        = "NOT_ACCEPTABLE"
+	CodeShuttingDown         = "SERVER_SHUTTING_DOWN"
+	CodeSelfTestRunning      = "SELFTEST_RUNNING"
+	CodeInternal             = "INTERNAL_ERROR"
+)
+
+// Sentinel errors returned by the StreamManager, wrapped with the stream ID or offending value
+var (
+	ErrStreamExists           = errors.New("stream already exists")
+	ErrStreamNotFound         = errors.New("stream not found")
+	ErrStreamLimit            = errors.New("stream limit reached")
+	ErrStartQueueFull         = errors.New("start queue full")
+	ErrStreamAlreadyPaused    = errors.New("stream is already paused")
+	ErrStreamNotPaused        = errors.New("stream is not paused")
+	ErrStreamIsClone          = errors.New("stream is a clone")
+	ErrStreamGone             = errors.New("stream stopped while the client was connecting")
+	ErrStreamDraining         = errors.New("stream is draining")
+	ErrInvalidResolution      = errors.New("invalid resolution")
+	ErrUnsupportedPixelFormat = errors.New("unsupported pixel format")
+	ErrInputNotAllowed        = errors.New("input not allowed")
+	ErrLocalSocket            = errors.New("local socket unavailable")
+	ErrHWAccelUnavailable     = errors.New("hardware acceleration unavailable")
+	ErrRecordingUnavailable   = errors.New("recording unavailable")
+)
+
+// APIError is the body of the "error" field in every error response
+type APIError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// respondError writes an error envelope: {"error": {"code": ..., "message": ..., "details": ...}}
+func respondError(c *gin.Context, status int, code, message string, details gin.H) {
+	c.JSON(status, gin.H{"error": APIError{Code: code, Message: message, Details: details}})
+}
+
+// respondManagerError maps an error from the StreamManager to its HTTP status and error code
+func respondManagerError(c *gin.Context, err error) {
+	status, code := http.StatusInternalServerError, CodeInternal
+	switch {
+	case errors.Is(err, ErrStreamNotFound):
+		status, code = http.StatusNotFound, CodeStreamNotFound
+	case errors.Is(err, ErrStreamExists):
+		status, code = http.StatusConflict, CodeStreamExists
+	case errors.Is(err, ErrStreamLimit):
+		status, code = http.StatusServiceUnavailable, CodeStreamLimit
+	case errors.Is(err, ErrStartQueueFull):
+		status, code = http.StatusTooManyRequests, CodeStartQueueFull
+	case errors.Is(err, ErrStreamAlreadyPaused):
+		status, code = http.StatusConflict, CodeStreamPaused
+	case errors.Is(err, ErrStreamNotPaused):
+		status, code = http.StatusConflict, CodeStreamNotPaused
+	case errors.Is(err, ErrStreamIsClone):
+		status, code = http.StatusConflict, CodeStreamIsClone
+	case errors.Is(err, ErrStreamDraining):
+		status, code = http.StatusServiceUnavailable, CodeStreamDraining
+	case errors.Is(err, ErrInvalidResolution):
+		status, code = http.StatusBadRequest, CodeInvalidResolution
+	case errors.Is(err, ErrUnsupportedPixelFormat):
+		status, code = http.StatusBadRequest, CodeInvalidPixelFormat
+	case errors.Is(err, ErrInputNotAllowed):
+		status, code = http.StatusBadRequest, CodeInputNotAllowed
+	case errors.Is(err, ErrLocalSocket):
+		status, code = http.StatusBadRequest, CodeLocalSocket
+	case errors.Is(err, ErrHWAccelUnavailable):
+		status, code = http.StatusBadRequest, CodeHWAccelUnavailable
+	case errors.Is(err, ErrRecordingUnavailable):
+		status, code = http.StatusBadRequest, CodeRecordingUnavailable
+	}
+	respondError(c, status, code, err.Error(), nil)
+}
+
+// respondInvalidRequest reports a malformed request body or stream option
+func respondInvalidRequest(c *gin.Context, err error) {
+	if errors.Is(err, ErrInvalidResolution) || errors.Is(err, ErrUnsupportedPixelFormat) || errors.Is(err, ErrInputNotAllowed) ||
+		errors.Is(err, ErrLocalSocket) || errors.Is(err, ErrHWAccelUnavailable) || errors.Is(err, ErrRecordingUnavailable) {
+		respondManagerError(c, err)
+		return
+	}
+	respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error(), nil)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// StreamEvent is a JSON-serializable notification about a stream (motion, status changes, ...)
+type StreamEvent map[string]interface{}
+
+// eventHub fans out stream events to subscribers without ever blocking the publisher
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan StreamEvent]struct{}
+	closed bool
+
+	// tap, when set, sees every event before subscribers do; it feeds the audit log and webhooks
+	tap func(StreamEvent)
+}
+
+// newEventHub creates an empty event hub
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan StreamEvent]struct{})}
+}
+
+// subscribe registers a new subscriber; the returned channel is closed when the hub closes
+func (h *eventHub) subscribe() chan StreamEvent {
+	ch := make(chan StreamEvent, EventSubscriberBufferSize)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes a subscriber and closes its channel
+func (h *eventHub) unsubscribe(ch chan StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// publish stamps the event with a timestamp and delivers it to every subscriber,
+// dropping it for subscribers whose buffer is full
+func (h *eventHub) publish(event StreamEvent) {
+	if _, ok := event["timestamp"]; !ok {
+		event["timestamp"] = time.Now().UnixMilli()
+	}
+	h.mu.Lock()
+	closed := h.closed
+	h.mu.Unlock()
+	// A closed hub belongs to a stream that is gone; only a retired clone parent still publishes then
+	if h.tap != nil && !closed {
+		h.tap(event)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// close disconnects all subscribers; later subscriptions receive an already-closed channel
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for ch := range h.subs {
+		close(ch)
+	}
+	h.subs = nil
+}
+
+// Stream states reported as status in stats, status snapshots and status events
+const (
+	StatusQueued       = "queued"       // waiting for an FFmpeg slot under MAX_FFMPEG_PROCESSES
+	StatusConnecting   = "connecting"   // FFmpeg launched, waiting for the first frame
+	StatusRunning      = "running"      // frames are flowing
+	StatusStalled      = "stalled"      // no frames within the stall window; FFmpeg is being restarted
+	StatusReconnecting = "reconnecting" // FFmpeg exited or failed to connect; backing off before a retry
+	StatusFailed       = "failed"       // circuit breaker open; retries suspended for the cooldown
+	StatusPaused       = "paused"       // ingest stopped on request
+	StatusStopped      = "stopped"      // stream removed; terminal
+)
+
+// statusTransitions lists the states each state may move to. Anything else is a stale update racing a
+// newer one (e.g. a late FFmpeg error after a pause) and is ignored.
+var statusTransitions = map[string][]string{
+	StatusQueued:       {StatusConnecting, StatusPaused, StatusStopped},
+	StatusConnecting:   {StatusRunning, StatusStalled, StatusReconnecting, StatusFailed, StatusPaused, StatusStopped},
+	StatusRunning:      {StatusStalled, StatusReconnecting, StatusPaused, StatusStopped},
+	StatusStalled:      {StatusRunning, StatusReconnecting, StatusFailed, StatusPaused, StatusStopped},
+	StatusReconnecting: {StatusConnecting, StatusFailed, StatusPaused, StatusStopped},
+	StatusFailed:       {StatusConnecting, StatusPaused, StatusStopped},
+	StatusPaused:       {StatusQueued, StatusConnecting, StatusStopped},
+	StatusStopped:      {},
+}
+
+// setStatus moves the stream to a new state and publishes a status event, ignoring transitions the
+// state machine doesn't allow. It reports whether the state changed.
+func (s *Stream) setStatus(status, detail string) bool {
+	s.mu.Lock()
+	previous := s.status
+	if previous == status || !containsString(statusTransitions[previous], status) {
+		s.mu.Unlock()
+		return false
+	}
+	s.status = status
+	s.statusChangedAt = time.Now()
+	// Running again after a stall or failure is announced as a recovery
+	recovered := status == StatusRunning && s.interrupted
+	switch status {
+	case StatusStalled, StatusReconnecting, StatusFailed:
+		s.interrupted = true
+	case StatusRunning, StatusPaused:
+		s.interrupted = false
+	}
+	s.syncReadyLocked()
+	s.mu.Unlock()
+
+	event := StreamEvent{
+		"type":      "status",
+		"stream_id": s.streamID,
+		"status":    status,
+		"previous":  previous,
+	}
+	if detail != "" {
+		event["detail"] = detail
+	}
+	if recovered {
+		event["recovered"] = true
+	}
+	s.events.publish(event)
+
+	// Clones share this stream's ingest, so they follow its state; stopping is their own
+	if status != StatusStopped {
+		for _, clone := range s.cloneList() {
+			clone.setStatus(status, detail)
+		}
+	}
+	return true
+}
+
+// beginConnect marks a new FFmpeg run as connecting. A stalled stream keeps reporting stalled until
+// the restarted run delivers frames or fails, so a stall stays visible through its restart.
+func (s *Stream) beginConnect() {
+	s.mu.RLock()
+	stalled := s.status == StatusStalled
+	s.mu.RUnlock()
+	if !stalled {
+		s.setStatus(StatusConnecting, "")
+	}
+}
+
+// markFramesFlowing moves the stream to running after the first frame of an FFmpeg run
+func (s *Stream) markFramesFlowing() {
+	s.mu.Lock()
+	s.isRunning = true
+	s.mu.Unlock()
+	s.setStatus(StatusRunning, "")
+}
+
+// syncReadyLocked closes the ready channel when the stream reaches running and swaps in a
+// fresh one when it leaves it; callers must hold s.mu
+func (s *Stream) syncReadyLocked() {
+	select {
+	case <-s.ready:
+		if s.status != StatusRunning {
+			s.ready = make(chan struct{})
+		}
+	default:
+		if s.status == StatusRunning {
+			close(s.ready)
+		}
+	}
+}
+
+// readyChan returns a channel that is closed once the stream is running with frames flowing
+func (s *Stream) readyChan() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ready
+}
+
+// clientCount returns the number of clients currently attached to the stream
+func (s *Stream) clientCount() int {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	return len(s.clients)
+}
+
+// publishClientCount notifies subscribers that the number of connected clients changed
+func (s *Stream) publishClientCount() {
+	s.events.publish(StreamEvent{
+		"type":         "clients",
+		"stream_id":    s.streamID,
+		"client_count": s.clientCount(),
+	})
+}
+
+// statusSnapshot returns the full current status of a stream as an event
+func (s *Stream) statusSnapshot() StreamEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return StreamEvent{
+		"type":             "snapshot",
+		"stream_id":        s.streamID,
+		"status":           s.status,
+		"state_changed_at": s.statusChangedAt,
+		"is_running":       s.isRunning,
+		"paused":           s.paused,
+		"frame_count":      s.frameCount.Load(),
+		"current_fps":      s.currentFPS.rate(time.Now()),
+		"last_frame_time":  s.lastFrameAt(),
+		"client_count":     s.clientCount(),
+		"active_url":       s.inputURLs[s.activeURL],
+		"metadata":         s.metadataOrEmpty(),
+		"breaker":          s.breaker.info(),
+		"queue_position":   s.queuePosition(),
+	}
+}
+
+// queuePosition is the stream's 1-based place in the start queue, or 0 when it isn't waiting for a slot
+func (s *Stream) queuePosition() int {
+	if s.startQueue == nil {
+		return 0
+	}
+	return s.startQueue.position(s)
+}
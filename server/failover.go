@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// resolveInputURLs returns the primary URL and the ordered failover list for a start request.
+// rtsp_urls lists the inputs in priority order; rtsp_url, when given, is always tried first.
+func resolveInputURLs(rtspURL string, rtspURLs []string) (string, []string, error) {
+	urls := make([]string, 0, len(rtspURLs)+1)
+	if rtspURL != "" {
+		urls = append(urls, rtspURL)
+	}
+	for _, u := range rtspURLs {
+		if u != "" && u != rtspURL {
+			urls = append(urls, u)
+		}
+	}
+
+	if len(urls) == 0 {
+		return "", nil, errors.New("rtsp_url or rtsp_urls is required")
+	}
+	return urls[0], urls, nil
+}
+
+// currentURL returns the input URL FFmpeg should currently read from
+func (s *Stream) currentURL() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inputURLs[s.activeURL]
+}
+
+// failover advances to the next input URL, reporting whether the list wrapped back to the primary
+func (s *Stream) failover(reason error) (wrapped bool) {
+	s.mu.Lock()
+	from := s.inputURLs[s.activeURL]
+	s.activeURL = (s.activeURL + 1) % len(s.inputURLs)
+	to := s.inputURLs[s.activeURL]
+	wrapped = s.activeURL == 0
+	s.mu.Unlock()
+
+	log.Printf("Stream %s failing over from %s to %s after repeated errors: %v", s.streamID, from, to, reason)
+	s.events.publish(StreamEvent{
+		"type":      "failover",
+		"stream_id": s.streamID,
+		"from":      from,
+		"to":        to,
+		"reason":    reason.Error(),
+	})
+	return wrapped
+}
+
+// jitterDelay spreads delay by up to fraction of it either way; r is a random number in [0, 1)
+func jitterDelay(delay time.Duration, fraction, r float64) time.Duration {
+	return delay + time.Duration(float64(delay)*fraction*(2*r-1))
+}
+
+// withJitter applies RECONNECT_JITTER to a restart delay
+func (sm *StreamManager) withJitter(delay time.Duration) time.Duration {
+	return jitterDelay(delay, sm.config.ReconnectJitter, rand.Float64())
+}
+
+// restartDelay returns the capped exponential backoff before the given consecutive failed attempt
+func restartDelay(failures int) time.Duration {
+	delay := FFmpegRestartDelay
+	for i := 1; i < failures && delay < MaxRestartDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxRestartDelay {
+		delay = MaxRestartDelay
+	}
+	return delay
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitterDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		fraction float64
+		r        float64
+		want     time.Duration
+	}{
+		{name: "r=0 is the shortest", delay: 4 * time.Second, fraction: 0.25, r: 0, want: 3 * time.Second},
+		{name: "r=0.5 is the delay itself", delay: 4 * time.Second, fraction: 0.25, r: 0.5, want: 4 * time.Second},
+		{name: "r=1 bounds the longest", delay: 4 * time.Second, fraction: 0.25, r: 1, want: 5 * time.Second},
+		{name: "fraction=0 with r=0", delay: 4 * time.Second, fraction: 0, r: 0, want: 4 * time.Second},
+		{name: "fraction=0 with r=1", delay: 4 * time.Second, fraction: 0, r: 1, want: 4 * time.Second},
+		{name: "fraction=1 with r=0 retries at once", delay: 4 * time.Second, fraction: 1, r: 0, want: 0},
+		{name: "fraction=1 with r=1 doubles", delay: 4 * time.Second, fraction: 1, r: 1, want: 8 * time.Second},
+		{name: "zero delay", delay: 0, fraction: 0.25, r: 1, want: 0},
+	}
+	for _, tt := range tests {
+		if got := jitterDelay(tt.delay, tt.fraction, tt.r); got != tt.want {
+			t.Errorf("%s: jitterDelay(%s, %v, %v) = %s, want %s", tt.name, tt.delay, tt.fraction, tt.r, got, tt.want)
+		}
+	}
+
+	// Every restart delay withJitter hands out stays within RECONNECT_JITTER of the backoff
+	sm := &StreamManager{config: Config{ReconnectJitter: DefaultReconnectJitter}}
+	for failures := 1; failures <= 6; failures++ {
+		delay := restartDelay(failures)
+		low := time.Duration(float64(delay) * (1 - DefaultReconnectJitter))
+		high := time.Duration(float64(delay) * (1 + DefaultReconnectJitter))
+		for i := 0; i < 1000; i++ {
+			if got := sm.withJitter(delay); got < low || got >= high {
+				t.Fatalf("withJitter(%s) = %s, want within [%s, %s)", delay, got, low, high)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrFrameDesync means FFmpeg's raw output no longer lines up with the expected frame size
+var ErrFrameDesync = errors.New("frame desync")
+
+// ErrSourceChanged means the camera changed resolution mid-stream and ingest is restarted to match
+var ErrSourceChanged = errors.New("source resolution changed")
+
+// videoGeometryPattern matches the "WIDTHxHEIGHT" part of an FFmpeg "Video:" stream line
+var videoGeometryPattern = regexp.MustCompile(`, (\d{2,5})x(\d{2,5})[ ,\[]`)
+
+// videoFPSPattern matches the frame rate of an FFmpeg "Video:" stream line, e.g. ", 25 fps,"
+var videoFPSPattern = regexp.MustCompile(`, (\d+(?:\.\d+)?) fps`)
+
+// sourceChangePatterns match the notices FFmpeg logs when decoded frames change size mid-stream: the
+// CLI's filter reinit, the buffer source's property warning and the decoder's reinit (debug level)
+var sourceChangePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`frame changed from size:\d+x\d+ fmt:\S+ to size:(\d+)x(\d+)`),
+	regexp.MustCompile(`incoming frame - w: (\d+) h: (\d+)`),
+	regexp.MustCompile(`Reinit context to (\d+)x(\d+)`),
+}
+
+// ffmpegLogParser follows FFmpeg's stderr and picks out the geometry of the input and output video streams
+type ffmpegLogParser struct {
+	inInput  bool
+	inOutput bool
+}
+
+// outputGeometry consumes one stderr line and returns the output video resolution once it's announced
+func (p *ffmpegLogParser) outputGeometry(line string) (int, int, bool) {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(trimmed, "Output #"):
+		p.inInput, p.inOutput = false, true
+		return 0, 0, false
+	case strings.HasPrefix(trimmed, "Input #"):
+		p.inInput, p.inOutput = true, false
+		return 0, 0, false
+	}
+
+	if !p.inOutput || !strings.HasPrefix(trimmed, "Stream #") || !strings.Contains(trimmed, "Video:") {
+		return 0, 0, false
+	}
+
+	m := videoGeometryPattern.FindStringSubmatch(trimmed + " ")
+	if m == nil {
+		return 0, 0, false
+	}
+	width, _ := strconv.Atoi(m[1])
+	height, _ := strconv.Atoi(m[2])
+	return width, height, true
+}
+
+// inputGeometry returns the source resolution and frame rate announced in the "Input #" header; fps
+// is 0 when FFmpeg doesn't report one. It relies on outputGeometry tracking the Input/Output sections.
+func (p *ffmpegLogParser) inputGeometry(line string) (int, int, float64, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !p.inInput || !strings.HasPrefix(trimmed, "Stream #") || !strings.Contains(trimmed, "Video:") {
+		return 0, 0, 0, false
+	}
+
+	m := videoGeometryPattern.FindStringSubmatch(trimmed + " ")
+	if m == nil {
+		return 0, 0, 0, false
+	}
+	width, _ := strconv.Atoi(m[1])
+	height, _ := strconv.Atoi(m[2])
+	var fps float64
+	if f := videoFPSPattern.FindStringSubmatch(trimmed); f != nil {
+		fps, _ = strconv.ParseFloat(f[1], 64)
+	}
+	return width, height, fps, true
+}
+
+// sourceChange returns the new source resolution when a line reports a mid-stream size change
+func sourceChange(line string) (int, int, bool) {
+	for _, pattern := range sourceChangePatterns {
+		if m := pattern.FindStringSubmatch(line); m != nil {
+			width, _ := strconv.Atoi(m[1])
+			height, _ := strconv.Atoi(m[2])
+			return width, height, true
+		}
+	}
+	return 0, 0, false
+}
+
+// sourceInfo is the resolution and frame rate FFmpeg reports for a stream's source, before scaling
+type sourceInfo struct {
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	FPS    float64 `json:"fps,omitempty"`
+}
+
+// sourceLocked returns the detected source geometry, or nil before FFmpeg has reported it; callers
+// must hold s.mu
+func (s *Stream) sourceLocked() *sourceInfo {
+	if s.source.Width == 0 {
+		return nil
+	}
+	source := s.source
+	return &source
+}
+
+// updateSource records the source geometry reported by FFmpeg. When it differs from the previously
+// known geometry it logs, publishes a source_changed event and returns true. Mid-stream notices carry
+// no frame rate (fps 0), so the last known one is kept.
+func (s *Stream) updateSource(width, height int, fps float64) bool {
+	s.mu.Lock()
+	previous := s.source
+	if fps == 0 {
+		fps = previous.FPS
+	}
+	s.source = sourceInfo{Width: width, Height: height, FPS: fps}
+	s.mu.Unlock()
+
+	if previous.Width == 0 || (previous.Width == width && previous.Height == height) {
+		return false
+	}
+	log.Printf("Source of stream %s changed resolution from %dx%d to %dx%d", s.streamID, previous.Width, previous.Height, width, height)
+	s.events.publish(StreamEvent{
+		"type":            "source_changed",
+		"stream_id":       s.streamID,
+		"width":           width,
+		"height":          height,
+		"previous_width":  previous.Width,
+		"previous_height": previous.Height,
+	})
+	return true
+}
+
+// checkOutputGeometry returns a desync error when FFmpeg announces an output size other than the stream's
+func (s *Stream) checkOutputGeometry(width, height int) error {
+	if width == s.width && height == s.height {
+		return nil
+	}
+	return fmt.Errorf("%w: FFmpeg output is %dx%d, expected %dx%d", ErrFrameDesync, width, height, s.width, s.height)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The lines below are FFmpeg stderr output as the server reads it, with the level tags added by
+// -loglevel level+info, in the formats FFmpeg 4 to 7 print for RTSP cameras, MPEG-TS and MJPEG sources
+
+func TestSourceChange(t *testing.T) {
+	tests := []struct {
+		name          string
+		line          string
+		width, height int
+		ok            bool
+	}{
+		{
+			name:  "CLI filter reinit",
+			line:  "[info] Input stream #0:0 frame changed from size:1280x720 fmt:yuvj420p to size:1920x1080 fmt:yuvj420p",
+			width: 1920, height: 1080, ok: true,
+		},
+		{
+			name:  "buffer source property warning",
+			line:  "[graph 0 input from stream 0:0 @ 0x7f8b3c004c40] [warning] filter context - w: 1280 h: 720 fmt: 12, incoming frame - w: 640 h: 360 fmt: 12 pts_time: 12.48",
+			width: 640, height: 360, ok: true,
+		},
+		{
+			name:  "buffer source warning with colorspace",
+			line:  "[graph 0 input from stream 0:0 @ 0x55d0a1c2e880] [warning] filter context - w: 1920 h: 1080 fmt: 0 csp: bt709 range: tv, incoming frame - w: 2560 h: 1440 fmt: 0 csp: bt709 range: tv pts_time: 301.2",
+			width: 2560, height: 1440, ok: true,
+		},
+		{
+			name:  "decoder reinit, coded height",
+			line:  "[h264 @ 0x55a8e0c3a9c0] [debug] Reinit context to 1920x1088, pix_fmt: yuvj420p",
+			width: 1920, height: 1088, ok: true,
+		},
+		{
+			name: "follow-up warning without sizes",
+			line: "[graph 0 input from stream 0:0 @ 0x7f8b3c004c40] [warning] Changing video frame properties on the fly is not supported by all filters.",
+		},
+		{
+			name: "input stream header",
+			line: "[info]   Stream #0:0: Video: h264 (Main), yuvj420p(pc, bt709, progressive), 2560x1440, 20 fps, 20 tbr, 90k tbn",
+		},
+		{
+			name: "progress line",
+			line: "[info] frame=  250 fps= 25 q=-0.0 size=  230400kB time=00:00:10.00 bitrate=188743.7kbits/s speed=   1x",
+		},
+		{
+			name: "decoder error",
+			line: "[h264 @ 0x55a8e0c3a9c0] [error] error while decoding MB 28 41, bytestream -5",
+		},
+	}
+	for _, tt := range tests {
+		_, line := parseFFmpegLogLine(tt.line)
+		width, height, ok := sourceChange(line)
+		if ok != tt.ok || width != tt.width || height != tt.height {
+			t.Errorf("%s: sourceChange = %dx%d, %v; want %dx%d, %v", tt.name, width, height, ok, tt.width, tt.height, tt.ok)
+		}
+	}
+}
+
+// geometry is a width, height and frame rate picked out of an FFmpeg header
+type geometry struct {
+	width, height int
+	fps           float64
+}
+
+func TestInputOutputGeometry(t *testing.T) {
+	tests := []struct {
+		name   string
+		log    string
+		input  []geometry
+		output []geometry
+	}{
+		{
+			name: "RTSP camera with audio",
+			log: `[info] Input #0, rtsp, from 'rtsp://192.168.1.10:554/stream1':
+[info]   Metadata:
+[info]     title           : Media Presentation
+[info]   Duration: N/A, start: 0.080000, bitrate: N/A
+[info]   Stream #0:0: Video: h264 (Main), yuvj420p(pc, bt709, progressive), 2560x1440, 20 fps, 20 tbr, 90k tbn
+[info]   Stream #0:1: Audio: pcm_alaw, 8000 Hz, mono, s16, 64 kb/s
+[info] Stream mapping:
+[info]   Stream #0:0 -> #0:0 (h264 (native) -> rawvideo (native))
+[info] Output #0, rawvideo, to 'pipe:':
+[info]   Metadata:
+[info]     encoder         : Lavf60.16.100
+[info]   Stream #0:0: Video: rawvideo (BGR[24] / 0x18524742), bgr24(pc, gbr/bt709/bt709, progressive), 640x480, q=2-31, 147456 kb/s, 20 fps, 20 tbn
+[info] frame=   41 fps= 20 q=-0.0 size=   36864kB time=00:00:02.05 bitrate=147456.0kbits/s speed=   1x`,
+			input:  []geometry{{2560, 1440, 20}},
+			output: []geometry{{640, 480, 0}},
+		},
+		{
+			name: "MPEG-TS with SAR and fractional rate",
+			log: `[info] Input #0, mpegts, from 'udp://239.0.0.1:1234':
+[info]   Duration: N/A, start: 1.400000, bitrate: N/A
+[info]   Program 1
+[info]   Stream #0:0[0x100]: Video: h264 (High) ([27][0][0][0] / 0x001B), yuv420p(tv, bt709, progressive), 1920x1080 [SAR 1:1 DAR 16:9], 29.97 fps, 29.97 tbr, 90k tbn
+[info] Output #0, rawvideo, to 'pipe:':
+[info]   Stream #0:0: Video: rawvideo (I420 / 0x30323449), yuv420p(tv, bt709, progressive), 1280x720 [SAR 1:1 DAR 16:9], q=2-31, 331776 kb/s, 29.97 fps, 29.97 tbn`,
+			input:  []geometry{{1920, 1080, 29.97}},
+			output: []geometry{{1280, 720, 0}},
+		},
+		{
+			name: "MJPEG without a frame rate",
+			log: `[info] Input #0, mpjpeg, from 'http://10.0.0.5/video.cgi':
+[info]   Duration: N/A, bitrate: N/A
+[info]   Stream #0:0: Video: mjpeg (Baseline), yuvj422p(pc, bt470bg/unknown/unknown), 1280x720, 90k tbr, 90k tbn
+[info] Output #0, rawvideo, to 'pipe:':
+[info]   Stream #0:0: Video: rawvideo (Y800 / 0x30303859), gray, 320x180, q=2-31, 13824 kb/s, 25 fps, 25 tbn`,
+			input:  []geometry{{1280, 720, 0}},
+			output: []geometry{{320, 180, 0}},
+		},
+		{
+			name: "untagged output of an FFmpeg ignoring the level flag",
+			log: `Input #0, rtsp, from 'rtsp://cam/live':
+  Stream #0:0: Video: hevc (Main), yuv420p(tv), 3840x2160, 15 fps, 15 tbr, 90k tbn
+Output #0, rawvideo, to 'pipe:':
+  Stream #0:0: Video: rawvideo (RGB[24] / 0x18424752), rgb24(pc, gbr/unknown/unknown, progressive), 960x540, q=2-31, 186624 kb/s, 15 fps, 15 tbn`,
+			input:  []geometry{{3840, 2160, 15}},
+			output: []geometry{{960, 540, 0}},
+		},
+		{
+			name: "audio only before the header",
+			log: `[info] Input #0, rtsp, from 'rtsp://cam/audio':
+[info]   Stream #0:0: Audio: aac (LC), 16000 Hz, mono, fltp
+[error] Stream map '0:v:0' matches no streams.`,
+		},
+	}
+	for _, tt := range tests {
+		var parser ffmpegLogParser
+		var input, output []geometry
+		// In the order startFFmpeg applies them, each line after the previous one updated the sections
+		for _, raw := range strings.Split(tt.log, "\n") {
+			_, line := parseFFmpegLogLine(raw)
+			if width, height, fps, ok := parser.inputGeometry(line); ok {
+				input = append(input, geometry{width, height, fps})
+			}
+			if width, height, ok := parser.outputGeometry(line); ok {
+				output = append(output, geometry{width, height, 0})
+			}
+		}
+		if !slices.Equal(input, tt.input) {
+			t.Errorf("%s: input geometry = %v, want %v", tt.name, input, tt.input)
+		}
+		if !slices.Equal(output, tt.output) {
+			t.Errorf("%s: output geometry = %v, want %v", tt.name, output, tt.output)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ffmpegLogLevels ranks the levels FFmpeg stderr can be logged at by FFmpeg's own numbering; a line is
+// logged when its level is at or below the stream's
+var ffmpegLogLevels = map[string]int32{
+	"panic":   0,
+	"fatal":   8,
+	"error":   16,
+	"warning": 24,
+	"info":    32,
+	"verbose": 40,
+	"debug":   48,
+	"trace":   56,
+}
+
+// ffmpegLevelTag matches the "[level] " tag -loglevel level+... adds after any "[ctx @ 0x...] " prefixes
+var ffmpegLevelTag = regexp.MustCompile(`^((?:\[[^\]]+\] )*?)\[(panic|fatal|error|warning|info|verbose|debug|trace)\] `)
+
+// validateFFmpegLogLevel checks a level a stream's FFmpeg output may be logged at
+func validateFFmpegLogLevel(level string) error {
+	switch level {
+	case "error", "warning", "info", "debug":
+		return nil
+	}
+	return fmt.Errorf("ffmpeg_log_level %q is not supported (supported: error, warning, info, debug)", level)
+}
+
+// ffmpegRunLevel is the -loglevel FFmpeg runs with for a stream logging at level. It never goes below
+// info: the stderr parser needs the Input/Output headers FFmpeg prints there, and lines below the
+// stream's level are dropped here rather than by FFmpeg.
+func ffmpegRunLevel(level string) string {
+	if ffmpegLogLevels[level] > ffmpegLogLevels["info"] {
+		return level
+	}
+	return "info"
+}
+
+// ffmpegLogArgs returns the flags that make FFmpeg tag every stderr line with its level
+func ffmpegLogArgs(level string) []string {
+	return []string{"-loglevel", "level+" + ffmpegRunLevel(level)}
+}
+
+// parseFFmpegLogLine strips the level tag from an FFmpeg stderr line, returning the level and the
+// line as FFmpeg would print it untagged. Untagged lines, e.g. from an FFmpeg that ignores the flag,
+// count as info.
+func parseFFmpegLogLine(line string) (string, string) {
+	m := ffmpegLevelTag.FindStringSubmatchIndex(line)
+	if m == nil {
+		return "info", line
+	}
+	return line[m[4]:m[5]], line[:m[3]] + line[m[1]:]
+}
+
+// ffmpegLogLevel returns the level this stream's FFmpeg output is logged at
+func (s *Stream) ffmpegLogLevel() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.logLevel
+}
+
+// logFFmpegLine logs an FFmpeg stderr line if its level is at or below the stream's
+func (s *Stream) logFFmpegLine(level, line string) {
+	if ffmpegLogLevels[level] > ffmpegLogLevels[s.ffmpegLogLevel()] {
+		return
+	}
+	log.Printf("FFmpeg [%s] %s: %s", s.streamID, level, line)
+}
+
+// ffmpegLogLevelRequest is the body of PUT /api/streams/:streamId/ffmpeg-log-level
+type ffmpegLogLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// handleSetFFmpegLogLevel changes how much of a running stream's FFmpeg output is logged, e.g. debug
+// while diagnosing a camera. Ingest is restarted only when FFmpeg itself must print more.
+func (sm *StreamManager) handleSetFFmpegLogLevel(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+	if err := stream.errIfClone("FFmpeg process"); err != nil {
+		respondManagerError(c, err)
+		return
+	}
+
+	var req ffmpegLogLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+	if err := validateFFmpegLogLevel(req.Level); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	stream.mu.Lock()
+	previous := stream.logLevel
+	stream.logLevel = req.Level
+	stream.mu.Unlock()
+
+	restarted := false
+	if ffmpegRunLevel(previous) != ffmpegRunLevel(req.Level) {
+		restarted = sm.restartIngest(stream)
+	}
+	log.Printf("FFmpeg log level of stream %s changed from %s to %s", stream.streamID, previous, req.Level)
+
+	sm.auditRequest(c, "ffmpeg_log_level_changed", stream.streamID, map[string]interface{}{
+		"from":      previous,
+		"to":        req.Level,
+		"restarted": restarted,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"stream_id":        stream.streamID,
+		"ffmpeg_log_level": req.Level,
+		"previous":         previous,
+		"restarted":        restarted,
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MockFFmpegFPSEnv passes the mock FFmpeg's frame rate to the child process
+const MockFFmpegFPSEnv = "RTSP_STREAM_MOCK_FFMPEG_FPS"
+
+// mockScaleFilter matches the scale filter built by Stream.scaleFilter
+var mockScaleFilter = regexp.MustCompile(`scale=(\d+):(\d+)`)
+
+// runMockFFmpeg imitates the FFmpeg invocations the server makes and returns the exit code. Raw video
+// outputs get a deterministic pattern at a fixed rate: frame n has pixel (x, y) = B (x+n)%256,
+// G (y+n)%256, R n%256 for bgr24 (rgb24 reversed), luma (x+y+n)%256 for gray and yuv420p (chroma 128).
+// There is no audio track, MPEG-TS outputs and recording segments carry null packets, and inputs
+// containing "mock-fail" fail to connect, inputs containing "mock-resize" report a mid-stream source
+// resolution change after two seconds, inputs containing "mock-stall" stop sending frames after one
+// second while staying connected and inputs containing "mock-desync" exit halfway through a frame after
+// one second. A showinfo filter logs every frame with a PTS at the
+// nominal frame rate. Encodes from stdin (clips) consume their input and write a bare MP4 ftyp box.
+func runMockFFmpeg(args []string) int {
+	opts := make(map[string]string)
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") && i+1 < len(args) {
+			opts[arg] = args[i+1]
+		}
+	}
+
+	switch {
+	case containsString(args, "-version"):
+		fmt.Printf("ffmpeg version mock-%s Copyright (c) synthetic test source\n", version)
+		return 0
+	case containsString(args, "-decoders"):
+		fmt.Println("Decoders:\n V..... = Video\n ------\n V....D rawvideo             raw video")
+		return 0
+	case containsString(args, "-hwaccels"):
+		// Claims every accelerator so hwaccel can be exercised; -hwaccel is then ignored
+		fmt.Println("Hardware acceleration methods:\ncuda\nvaapi\nqsv\nvideotoolbox")
+		return 0
+	case strings.Contains(opts["-i"], "mock-fail"):
+		fmt.Fprintf(os.Stderr, "%s: Connection refused\n", opts["-i"])
+		return 1
+	case containsString(args, "-vn"):
+		fmt.Fprintln(os.Stderr, "Stream map '0:a:0' matches no streams.")
+		return 1
+	case opts["-f"] == "segment":
+		return mockSegments(args[len(args)-1], opts["-segment_time"])
+	case opts["-i"] == "-":
+		n, _ := io.Copy(io.Discard, os.Stdin)
+		fmt.Fprintf(os.Stderr, "mock encode: read %d bytes from stdin\n", n)
+		os.Stdout.Write([]byte("\x00\x00\x00\x10ftypisom\x00\x00\x02\x00"))
+		return 0
+	}
+
+	fps, err := strconv.ParseFloat(os.Getenv(MockFFmpegFPSEnv), 64)
+	if err != nil || fps <= 0 {
+		fps = DefaultMockFFmpegFPS
+	}
+
+	width, height := DefaultWidth, DefaultHeight
+	if m := mockScaleFilter.FindStringSubmatch(opts["-vf"]); m != nil {
+		width, _ = strconv.Atoi(m[1])
+		height, _ = strconv.Atoi(m[2])
+	}
+	pixelFormat := opts["-pix_fmt"]
+	if pixelFormat == "" {
+		pixelFormat = DefaultPixelFormat
+	}
+
+	fmt.Fprintf(os.Stderr, "Input #0, rtsp, from '%s':\n  Stream #0:0: Video: h264, yuv420p, %dx%d, %g fps\n", opts["-i"], width, height, fps)
+
+	out := bufio.NewWriter(os.Stdout)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / fps))
+	defer ticker.Stop()
+
+	resizeAt := time.Now().Add(2 * time.Second)
+	resize := strings.Contains(opts["-i"], "mock-resize")
+	failAt := time.Now().Add(time.Second) // when mock-stall and mock-desync sources break
+	stall := strings.Contains(opts["-i"], "mock-stall")
+	desync := strings.Contains(opts["-i"], "mock-desync")
+	showinfo := strings.Contains(opts["-vf"], "showinfo")
+
+	for n := 0; ; n++ {
+		if resize && time.Now().After(resizeAt) {
+			resize = false
+			fmt.Fprintf(os.Stderr, "[graph 0 input from stream 0:0 @ 0x0] filter context - w: %d h: %d fmt: 0, incoming frame - w: %d h: %d fmt: 0 pts_time: 2\n", width, height, width*2, height*2)
+		}
+		if stall && time.Now().After(failAt) {
+			// Hang like a camera that stopped sending, until the server kills the process
+			for {
+				time.Sleep(time.Hour)
+			}
+		}
+		if showinfo {
+			pts := float64(n) / fps
+			fmt.Fprintf(os.Stderr, "[Parsed_showinfo_1 @ 0x0] n:%4d pts:%7d pts_time:%-7g duration:1\n", n, int64(pts*90000), pts)
+		}
+		var data []byte
+		if opts["-f"] == "mpegts" {
+			data = mockTSPackets()
+		} else {
+			data = mockFrame(n, width, height, pixelFormat)
+		}
+		if desync && time.Now().After(failAt) {
+			// Die mid-frame, as a crashing FFmpeg does
+			out.Write(data[:len(data)/2])
+			out.Flush()
+			fmt.Fprintln(os.Stderr, "mock: exiting mid-frame")
+			return 1
+		}
+		if _, err := out.Write(data); err != nil {
+			return 0
+		}
+		if err := out.Flush(); err != nil {
+			// The server closed the pipe
+			return 0
+		}
+		<-ticker.C
+	}
+}
+
+// mockFrame renders frame n of the test pattern in the given pixel format
+func mockFrame(n, width, height int, pixelFormat string) []byte {
+	frame := make([]byte, int(float64(width*height)*pixelFormats[pixelFormat]))
+	switch pixelFormat {
+	case "bgr24", "rgb24":
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				i := (y*width + x) * 3
+				b, g, r := byte(x+n), byte(y+n), byte(n)
+				if pixelFormat == "rgb24" {
+					b, r = r, b
+				}
+				frame[i], frame[i+1], frame[i+2] = b, g, r
+			}
+		}
+	default:
+		// gray and yuv420p start with a full-resolution luma plane; yuv420p's chroma planes stay neutral
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				frame[y*width+x] = byte(x + y + n)
+			}
+		}
+		for i := width * height; i < len(frame); i++ {
+			frame[i] = 128
+		}
+	}
+	return frame
+}
+
+// mockTSPackets returns a batch of MPEG-TS null packets (PID 0x1FFF)
+func mockTSPackets() []byte {
+	packets := make([]byte, 0, 10*TSPacketSize)
+	for i := 0; i < 10; i++ {
+		packet := make([]byte, TSPacketSize)
+		packet[0], packet[1], packet[2], packet[3] = 0x47, 0x1F, 0xFF, 0x10
+		for j := 4; j < TSPacketSize; j++ {
+			packet[j] = 0xFF
+		}
+		packets = append(packets, packet...)
+	}
+	return packets
+}
+
+// mockSegments imitates the segment muxer of a recording: it writes MPEG-TS null packets to files named
+// by pattern, where the recording's strftime fields become the start time, and starts a new file every
+// segmentTime seconds, logging each one as FFmpeg does
+func mockSegments(pattern, segmentTime string) int {
+	seconds, err := strconv.ParseFloat(segmentTime, 64)
+	if err != nil || seconds <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid segment time %q\n", segmentTime)
+		return 1
+	}
+	segment := time.Duration(seconds * float64(time.Second))
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		path := strings.Replace(pattern, "%Y%m%d-%H%M%S", start.Format("20060102-150405"), 1)
+		f, err := os.Create(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "[segment @ 0x0] Opening '%s' for writing\n", path)
+		for time.Since(start) < segment {
+			if _, err := f.Write(mockTSPackets()); err != nil {
+				f.Close()
+				return 1
+			}
+			<-ticker.C
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// allowedInputOpts whitelists the FFmpeg input options that may be set per stream through
+// ffmpeg_input_opts. Only these keys are accepted, and only with non-negative integer values,
+// so a start request can never inject arbitrary FFmpeg flags.
+var allowedInputOpts = map[string]string{
+	// RTSP socket I/O timeout in microseconds; detects dead cameras instead of hanging forever (FFmpeg < 5)
+	"stimeout": "socket timeout in microseconds (FFmpeg < 5)",
+	// Same as stimeout for FFmpeg 5 and later
+	"timeout": "socket timeout in microseconds (FFmpeg >= 5)",
+	// Maximum demuxing delay in microseconds; caps how long packets may be reordered
+	"max_delay": "maximum demux delay in microseconds",
+	// UDP socket receive buffer in bytes; larger values reduce artifacts on lossy links
+	"buffer_size": "socket buffer size in bytes",
+	// Number of packets to buffer for handling reordered RTP packets
+	"reorder_queue_size": "RTP reorder queue size in packets",
+	// How long to analyze the input to detect streams, in microseconds
+	"analyzeduration": "stream analysis duration in microseconds",
+	// How many bytes to probe to detect the input format
+	"probesize": "input probe size in bytes",
+}
+
+// scaleFlags whitelists the scale filter algorithms a stream may select with scale_flags
+var scaleFlags = map[string]bool{
+	"bilinear": true, // cheap, slightly soft
+	"bicubic":  true, // FFmpeg's default
+	"lanczos":  true, // sharpest, most CPU
+	"neighbor": true, // fastest; keeps hard pixel edges
+}
+
+// validateScaleFlags checks a requested scale algorithm; empty keeps FFmpeg's default
+func validateScaleFlags(flags string) error {
+	if flags != "" && !scaleFlags[flags] {
+		return fmt.Errorf("scale_flags %q is not supported (supported: bilinear, bicubic, lanczos, neighbor)", flags)
+	}
+	return nil
+}
+
+// scaleFilter returns the FFmpeg scale filter for the stream's output size and algorithm
+func (s *Stream) scaleFilter() string {
+	filter := fmt.Sprintf("scale=%d:%d", s.width, s.height)
+	if s.scaleFlags != "" {
+		filter += ":flags=" + s.scaleFlags
+	}
+	return filter
+}
+
+// validateInputOpts checks the requested FFmpeg input options against the whitelist and
+// returns them as canonical decimal strings
+func validateInputOpts(opts map[string]interface{}) (map[string]string, error) {
+	effective := make(map[string]string, len(opts))
+	for key, raw := range opts {
+		if _, ok := allowedInputOpts[key]; !ok {
+			return nil, fmt.Errorf("ffmpeg_input_opts: option %q is not allowed", key)
+		}
+
+		var value int64
+		switch v := raw.(type) {
+		case float64:
+			if v < 0 || v != math.Trunc(v) || v > math.MaxInt64 {
+				return nil, fmt.Errorf("ffmpeg_input_opts: %s must be a non-negative integer", key)
+			}
+			value = int64(v)
+		case string:
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || parsed < 0 {
+				return nil, fmt.Errorf("ffmpeg_input_opts: %s must be a non-negative integer", key)
+			}
+			value = parsed
+		default:
+			return nil, fmt.Errorf("ffmpeg_input_opts: %s must be a non-negative integer", key)
+		}
+		effective[key] = strconv.FormatInt(value, 10)
+	}
+	return effective, nil
+}
+
+// inputOptArgs renders validated input options as FFmpeg arguments in a stable order
+func inputOptArgs(opts map[string]string) []string {
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		args = append(args, "-"+key, opts[key])
+	}
+	return args
+}
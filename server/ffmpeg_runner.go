@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CommandRunner builds every FFmpeg command the server runs. The default runs the ffmpeg binary; the
+// mock runner substitutes a synthetic source so the server can run and be tested without FFmpeg or a camera.
+type CommandRunner interface {
+	CommandContext(ctx context.Context, args ...string) *exec.Cmd
+
+	// Path is the binary the commands run, reported by /api/version
+	Path() string
+}
+
+// execRunner runs the real FFmpeg binary
+type execRunner struct {
+	path string
+}
+
+// CommandContext returns an FFmpeg command
+func (r execRunner) CommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, r.path, args...)
+}
+
+// Path returns the FFmpeg binary's resolved path
+func (r execRunner) Path() string {
+	return r.path
+}
+
+// MockFFmpegEnv marks a re-executed server process as the mock FFmpeg
+const MockFFmpegEnv = "RTSP_STREAM_MOCK_FFMPEG"
+
+// mockRunner re-executes the server binary as a fake FFmpeg that emits a known test pattern (see
+// runMockFFmpeg). The child is a real process, so pipes, signals and scheduling behave as with FFmpeg.
+type mockRunner struct {
+	self string
+	fps  float64
+}
+
+// CommandContext returns a command running the mock FFmpeg with the given FFmpeg arguments
+func (r mockRunner) CommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, r.self, args...)
+	cmd.Env = append(os.Environ(), MockFFmpegEnv+"=1", fmt.Sprintf("%s=%g", MockFFmpegFPSEnv, r.fps))
+	return cmd
+}
+
+// Path returns the server executable standing in for FFmpeg
+func (r mockRunner) Path() string {
+	return r.self
+}
+
+// newCommandRunner returns the mock runner when FFMPEG_MOCK is set and the FFmpeg at FFMPEG_PATH otherwise
+func newCommandRunner(cfg Config) (CommandRunner, error) {
+	if !cfg.FFmpegMock {
+		return newExecRunner("FFMPEG_PATH", cfg.FFmpegPath)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("mock FFmpeg needs the server executable: %v", err)
+	}
+	return mockRunner{self: self, fps: cfg.FFmpegMockFPS}, nil
+}
+
+// newBinaryRunners returns a runner for each FFMPEG_BINARIES entry streams may select with
+// ffmpeg_binary. Under FFMPEG_MOCK every name maps to the mock runner.
+func newBinaryRunners(cfg Config, mock CommandRunner) (map[string]CommandRunner, error) {
+	runners := make(map[string]CommandRunner, len(cfg.FFmpegBinaries))
+	for name, path := range cfg.FFmpegBinaries {
+		if cfg.FFmpegMock {
+			runners[name] = mock
+			continue
+		}
+		runner, err := newExecRunner("FFMPEG_BINARIES "+name, path)
+		if err != nil {
+			return nil, err
+		}
+		runners[name] = runner
+	}
+	return runners, nil
+}
+
+// newExecRunner resolves an FFmpeg binary, searching PATH for bare names, and checks that it is
+// an executable file so a bad path fails at startup instead of on every stream start
+func newExecRunner(setting, path string) (execRunner, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return execRunner{}, fmt.Errorf("%s: %v", setting, err)
+	}
+	if abs, err := filepath.Abs(resolved); err == nil {
+		resolved = abs
+	}
+	return execRunner{path: resolved}, nil
+}
+
+// runnerFor returns the runner for a stream's ffmpeg_binary, or the default runner when it names none
+func (sm *StreamManager) runnerFor(binary string) (CommandRunner, error) {
+	if binary == "" {
+		return sm.runner, nil
+	}
+	runner, ok := sm.binaries[binary]
+	if !ok {
+		names := make([]string, 0, len(sm.binaries))
+		for name := range sm.binaries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown ffmpeg_binary %q: FFMPEG_BINARIES defines none", binary)
+		}
+		return nil, fmt.Errorf("unknown ffmpeg_binary %q (configured: %s)", binary, strings.Join(names, ", "))
+	}
+	return runner, nil
+}
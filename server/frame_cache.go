@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// frameCache keeps a short, time-ordered history of recent frames so clients can fetch
+// the frame closest to an external timestamp. It is bounded both by frame count and by age.
+type frameCache struct {
+	mu     sync.RWMutex
+	frames []*Frame // ring buffer, oldest at start
+	start  int
+	count  int
+	maxAge time.Duration
+}
+
+// newFrameCache creates a cache holding at most size frames no older than maxAge
+func newFrameCache(size int, maxAge time.Duration) *frameCache {
+	return &frameCache{
+		frames: make([]*Frame, size),
+		maxAge: maxAge,
+	}
+}
+
+// add appends a frame, evicting the oldest frames beyond the count or age bound
+func (fc *frameCache) add(frame *Frame) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.count == len(fc.frames) {
+		fc.frames[fc.start] = nil
+		fc.start = (fc.start + 1) % len(fc.frames)
+		fc.count--
+	}
+	fc.frames[(fc.start+fc.count)%len(fc.frames)] = frame
+	fc.count++
+
+	cutoff := frame.timestamp.Add(-fc.maxAge)
+	for fc.count > 1 && fc.frames[fc.start].timestamp.Before(cutoff) {
+		fc.frames[fc.start] = nil
+		fc.start = (fc.start + 1) % len(fc.frames)
+		fc.count--
+	}
+}
+
+// at returns the i-th cached frame, oldest first; callers must hold fc.mu
+func (fc *frameCache) at(i int) *Frame {
+	return fc.frames[(fc.start+i)%len(fc.frames)]
+}
+
+// latest returns the most recent frame, or nil when the cache is empty
+func (fc *frameCache) latest() *Frame {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	if fc.count == 0 {
+		return nil
+	}
+	return fc.at(fc.count - 1)
+}
+
+// nearest returns the cached frame whose timestamp is closest to ts. It reports false when
+// ts lies outside the cached window (allowing FrameCacheTolerance on either side).
+func (fc *frameCache) nearest(ts time.Time) (*Frame, bool) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	if fc.count == 0 {
+		return nil, false
+	}
+	oldest, newest := fc.at(0), fc.at(fc.count-1)
+	if ts.Before(oldest.timestamp.Add(-FrameCacheTolerance)) || ts.After(newest.timestamp.Add(FrameCacheTolerance)) {
+		return nil, false
+	}
+
+	best := oldest
+	for i := 1; i < fc.count; i++ {
+		frame := fc.at(i)
+		if absDuration(frame.timestamp.Sub(ts)) < absDuration(best.timestamp.Sub(ts)) {
+			best = frame
+		}
+	}
+	return best, true
+}
+
+// window returns the timestamps of the oldest and newest cached frames
+func (fc *frameCache) window() (time.Time, time.Time, bool) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	if fc.count == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	return fc.at(0).timestamp, fc.at(fc.count - 1).timestamp, true
+}
+
+// absDuration returns the absolute value of d
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket frame encodings a client may pick with ?encoding=
+const (
+	EncodingBinary = "binary" // raw frame bytes in a binary message
+	EncodingBase64 = "base64" // a FrameMessage JSON text message, frame_data base64-encoded
+)
+
+// encodingQuery parses the ?encoding= query parameter of a WebSocket connection request; without it
+// frames are sent as binary messages
+func encodingQuery(query url.Values) (string, error) {
+	switch raw := query.Get("encoding"); raw {
+	case "", EncodingBinary:
+		return EncodingBinary, nil
+	case EncodingBase64:
+		return EncodingBase64, nil
+	default:
+		return "", fmt.Errorf("encoding must be %s or %s, got %q", EncodingBinary, EncodingBase64, raw)
+	}
+}
+
+// writeFrame sends one frame in the client's encoding. Base64 frames are a third larger and encoded
+// for each client, so they are only a fallback for clients that can't take binary messages.
+func (c *Client) writeFrame(frame *Frame, data []byte) error {
+	messageType := websocket.BinaryMessage
+	if c.opts.Encoding == EncodingBase64 {
+		width, height := c.srcWidth, c.srcHeight
+		if c.opts.Width > 0 {
+			width, height = c.opts.Width, c.opts.Height
+		}
+		msg, err := json.Marshal(FrameMessage{
+			StreamID:  c.streamID,
+			Timestamp: frame.timestamp.UnixMilli(),
+			FrameData: data,
+			Width:     width,
+			Height:    height,
+		})
+		if err != nil {
+			return err
+		}
+		messageType, data = websocket.TextMessage, msg
+	}
+
+	// Allow large frames longer on the wire
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.frameWriteDeadline(len(data))))
+	return c.conn.WriteMessage(messageType, data)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// frameNotifier wakes HTTP frame requests waiting for a newer frame. Waiters read the retained latest
+// frame from the frame cache, so polling never competes with distributeFrames for frameBuffer.
+type frameNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// newFrameNotifier creates a notifier with no waiters
+func newFrameNotifier() *frameNotifier {
+	return &frameNotifier{ch: make(chan struct{})}
+}
+
+// wait returns a channel that is closed when the next frame arrives
+func (n *frameNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+// notify wakes every current waiter
+func (n *frameNotifier) notify() {
+	n.mu.Lock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+	n.mu.Unlock()
+}
+
+// frameLimiter bounds the number of concurrent HTTP frame requests per stream
+type frameLimiter struct {
+	slots    chan struct{}
+	rejected atomic.Int64
+}
+
+// newFrameLimiter creates a limiter admitting up to limit requests at once
+func newFrameLimiter(limit int) *frameLimiter {
+	return &frameLimiter{slots: make(chan struct{}, limit)}
+}
+
+// acquire takes a slot without waiting, reporting false when every slot is in use
+func (l *frameLimiter) acquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		l.rejected.Add(1)
+		return false
+	}
+}
+
+// release frees a slot taken by acquire
+func (l *frameLimiter) release() {
+	<-l.slots
+}
+
+// stats reports the limiter's occupancy for stream stats
+func (l *frameLimiter) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"in_flight": len(l.slots),
+		"limit":     cap(l.slots),
+		"rejected":  l.rejected.Load(),
+	}
+}
+
+// serveLatestFrame writes the stream's most recent frame newer than the after_seq query parameter
+// (default 0, any frame), waiting up to FrameRequestTimeout for one to arrive
+func (sm *StreamManager) serveLatestFrame(c *gin.Context, stream *Stream, frameType string) {
+	var afterSeq int64
+	if raw := c.Query("after_seq"); raw != "" {
+		var err error
+		if afterSeq, err = strconv.ParseInt(raw, 10, 64); err != nil || afterSeq < 0 {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "after_seq must be a non-negative frame sequence number", nil)
+			return
+		}
+	}
+
+	timeout := time.NewTimer(FrameRequestTimeout)
+	defer timeout.Stop()
+
+	for {
+		// Take the wait channel before checking, so a frame arriving in between still wakes us
+		next := stream.newFrames.wait()
+		if frame := stream.frameCache.latest(); frame != nil && frame.seq > afterSeq {
+			stream.writeFrame(c, frame, frameType)
+			return
+		}
+
+		select {
+		case <-sm.shutdownCtx.Done():
+			respondError(c, http.StatusServiceUnavailable, CodeShuttingDown, "Server shutting down", nil)
+			return
+		case <-stream.healthStopChan:
+			respondError(c, http.StatusServiceUnavailable, CodeStreamNotRunning, "Stream stopped", nil)
+			return
+		case <-c.Request.Context().Done():
+			return
+		case <-next:
+		case <-timeout.C:
+			// Instead of 408, return 204 No Content for smoother client experience
+			c.Status(http.StatusNoContent)
+			return
+		}
+	}
+}
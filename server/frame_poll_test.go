@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFrameRequestLimitUnderLoad has 100 pollers long-poll /frame at once: every request must get a
+// frame or a 429, the limiter must never admit more than FrameRequestLimit requests at a time, and every
+// slot must be returned afterwards
+func TestFrameRequestLimitUnderLoad(t *testing.T) {
+	const (
+		pollers  = 100
+		requests = 5
+	)
+	// At 5fps pollers waiting for the next frame pile up for 200ms. Every poller shares the loopback
+	// address, so the per-IP rate limit would answer before the per-stream limiter; turn it off to load
+	// the limiter alone.
+	ts := newTestServer(t, 5, func(sm *StreamManager) {
+		sm.frameRateLimit = nil
+		sm.config.FrameRequestLimit = 16
+	})
+	ts.startStream(t, map[string]interface{}{
+		"stream_id": "polled",
+		"rtsp_url":  "rtsp://camera.example/polled",
+		"width":     16,
+		"height":    16,
+	})
+	stream := ts.stream(t, "polled")
+	limit := ts.sm.config.FrameRequestLimit
+
+	// Sample the limiter while the pollers run
+	var peak atomic.Int64
+	sampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			select {
+			case <-sampling:
+				return
+			default:
+			}
+			if n := int64(stream.frameRequests.stats()["in_flight"].(int)); n > peak.Load() {
+				peak.Store(n)
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	var ok, limited atomic.Int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < pollers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			var afterSeq int64
+			for j := 0; j < requests; j++ {
+				resp, err := http.Get(fmt.Sprintf("%s/api/streams/polled/frame?after_seq=%d", ts.srv.URL, afterSeq))
+				if err != nil {
+					t.Errorf("frame request: %v", err)
+					return
+				}
+				var body struct {
+					Error APIError `json:"error"`
+				}
+				switch resp.StatusCode {
+				case http.StatusOK:
+					ok.Add(1)
+					// Like a real poller, wait for a frame newer than this one next time
+					afterSeq, _ = strconv.ParseInt(resp.Header.Get("X-Frame-Seq"), 10, 64)
+				case http.StatusTooManyRequests:
+					limited.Add(1)
+					json.NewDecoder(resp.Body).Decode(&body)
+					if body.Error.Code != CodeTooManyRequests || resp.Header.Get("Retry-After") == "" {
+						t.Errorf("429 with code %q and Retry-After %q", body.Error.Code, resp.Header.Get("Retry-After"))
+					}
+				default:
+					t.Errorf("frame request: status %d, want 200 or 429", resp.StatusCode)
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(sampling)
+	<-sampled
+
+	if ok.Load()+limited.Load() != pollers*requests {
+		t.Errorf("%d frames and %d 429s for %d requests", ok.Load(), limited.Load(), pollers*requests)
+	}
+	if ok.Load() == 0 || limited.Load() == 0 {
+		t.Errorf("%d frames and %d 429s; the load should both be served and hit the limit", ok.Load(), limited.Load())
+	}
+	if p := peak.Load(); p > int64(limit) {
+		t.Errorf("%d frame requests in flight at once, limit %d", p, limit)
+	} else if p < int64(limit) {
+		t.Logf("peak of %d frame requests in flight, limit %d", p, limit)
+	}
+	stats := stream.frameRequests.stats()
+	if stats["in_flight"] != 0 {
+		t.Errorf("%v frame request slots still taken after every request finished", stats["in_flight"])
+	}
+	if stats["rejected"] != limited.Load() {
+		t.Errorf("limiter counted %v rejections, clients got %d 429s", stats["rejected"], limited.Load())
+	}
+}
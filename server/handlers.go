@@ -2,10 +2,16 @@ package main
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -25,106 +31,336 @@ func getUpgrader() websocket.Upgrader {
 func (sm *StreamManager) handleWebSocket(c *gin.Context) {
 	streamID := c.Param("streamId")
 
+	// When URL signing is enabled every connection must carry a valid, unexpired signature
+	if len(sm.config.SigningSecret) > 0 {
+		if err := sm.VerifyStreamSignature(streamID, c.Query("exp"), c.Query("sig")); err != nil {
+			log.Printf("WebSocket connection rejected for stream %s: %v", streamID, err)
+			respondError(c, http.StatusForbidden, CodeInvalidSignature, err.Error(), nil)
+			return
+		}
+	}
+
 	// Check if stream exists and is running
 	sm.mu.RLock()
 	stream, exists := sm.streams[streamID]
+	draining := exists && stream.draining
 	sm.mu.RUnlock()
 
 	if !exists {
 		log.Printf("WebSocket connection failed: stream %s not found", streamID)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+		respondError(c, http.StatusNotFound, CodeStreamNotFound, "Stream not found", nil)
 		return
 	}
 
-	// Check if stream is actually running
-	stream.mu.RLock()
-	isRunning := stream.isRunning
-	stream.mu.RUnlock()
+	// A draining stream keeps its clients but takes no new ones
+	if draining {
+		log.Printf("WebSocket connection refused: stream %s is draining", streamID)
+		respondError(c, http.StatusServiceUnavailable, CodeStreamDraining, "Stream is draining", nil)
+		return
+	}
 
-	if !isRunning {
+	// Clients may attach while FFmpeg is still connecting; paused streams still accept clients
+	ingesting, paused := stream.ingestState()
+
+	if !ingesting && !paused {
 		log.Printf("WebSocket connection failed: stream %s not running", streamID)
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Stream not running"})
+		respondError(c, http.StatusServiceUnavailable, CodeStreamNotRunning, "Stream not running", nil)
+		return
+	}
+
+	// Per-connection deadline overrides, e.g. longer deadlines for mobile clients on flaky links
+	opts, err := sm.config.Client.withQuery(c.Request.URL.Query())
+	if err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	// ?w=&h= downscales this client's frames without touching the ingest or other clients
+	if opts.Width, opts.Height, err = stream.clientSize(c.Request.URL.Query()); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	// ?on_motion=true delivers frames only around detected motion
+	if opts.OnMotion, err = stream.onMotionQuery(c.Request.URL.Query()); err != nil {
+		respondInvalidRequest(c, err)
 		return
 	}
 
+	// ?prime=true shows the latest frame immediately, which matters on low-fps streams
+	if opts.Prime, err = primeQuery(c.Request.URL.Query()); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	// ?ack=true sends each frame only once the client acknowledged the previous one
+	if opts.Ack, err = ackQuery(c.Request.URL.Query()); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	// ?subsample=N delivers every Nth frame, e.g. for analytics that want a fixed share of frames
+	if opts.Subsample, err = subsampleQuery(c.Request.URL.Query()); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	// ?encoding=base64 sends frames as JSON text messages for clients that can't take binary ones
+	if opts.Encoding, err = encodingQuery(c.Request.URL.Query()); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	// ?auto_buffer=true sizes the send buffer to the client instead of the fixed ClientBufferSize
+	if opts.AutoBuffer, err = autoBufferQuery(c.Request.URL.Query()); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+	if opts.AutoBuffer && opts.Ack {
+		respondInvalidRequest(c, fmt.Errorf("auto_buffer has no effect with ack, which has one frame in flight"))
+		return
+	}
+
+	opts.RequestID = requestID(c)
+
+	// Advertise the frame format as a subprotocol; clients that offer it get it echoed back, older
+	// clients offering nothing connect without one. The header carries it for clients that can read it.
+	format := stream.formatSubprotocol(opts.Width, opts.Height)
 	upgrader := getUpgrader()
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	upgrader.Subprotocols = []string{format}
+	responseHeader := http.Header{}
+	responseHeader.Set("X-Frame-Format", format)
+	// The upgrade writes its own response, without the headers set by middleware
+	responseHeader.Set(RequestIDHeader, opts.RequestID)
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, responseHeader)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 
-	client, err := sm.AddClient(streamID, conn)
+	client, err := sm.AddClient(stream, conn, opts)
 	if err != nil {
-		log.Printf("Error adding client: %v", err)
+		// The stream stopped between the checks above and the upgrade. Close with a code that tells the
+		// client to reconnect, which then sees the stream's current state, instead of just dropping it.
+		log.Printf("Error adding client to stream %s (request %s): %v", streamID, opts.RequestID, err)
+		code, reason := websocket.CloseTryAgainLater, "stream stopped while connecting, reconnect"
+		switch {
+		case sm.shuttingDown.Load():
+			code, reason = websocket.CloseGoingAway, "server shutting down"
+		case errors.Is(err, ErrStreamDraining):
+			reason = "stream draining"
+		}
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(opts.WriteDeadline))
 		conn.Close()
 		return
 	}
 
-	log.Printf("WebSocket client %s connected to stream %s", client.id, streamID)
+	sm.auditRequest(c, "client_connected", streamID, map[string]interface{}{
+		"client_id":   client.id,
+		"ack":         opts.Ack,
+		"on_motion":   opts.OnMotion,
+		"subsample":   opts.Subsample,
+		"auto_buffer": opts.AutoBuffer,
+		"encoding":    opts.Encoding,
+	})
+	log.Printf("WebSocket client %s connected to stream %s (request %s)", client.id, streamID, opts.RequestID)
 }
 
-// handleStartStream starts a new RTSP stream with specified ID
-func (sm *StreamManager) handleStartStream(c *gin.Context) {
-	var req struct {
-		StreamID string `json:"stream_id" binding:"required"`
-		RTSPURL  string `json:"rtsp_url" binding:"required"`
-		Width    int    `json:"width"`
-		Height   int    `json:"height"`
+// formatSubprotocol encodes the format of the frames a client receives as a WebSocket subprotocol,
+// e.g. rtsp-bgr24-640x480. A width and height of 0 mean the stream's own size.
+func (s *Stream) formatSubprotocol(width, height int) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if width == 0 || height == 0 {
+		width, height = s.width, s.height
 	}
+	return fmt.Sprintf("rtsp-%s-%dx%d", s.pixelFormat, width, height)
+}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// streamIDPattern is what caller-chosen stream IDs must match, so they are safe as a single URL path segment
+var streamIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// validateStreamID normalizes a caller-chosen stream ID by trimming surrounding whitespace and checks
+// it against streamIDPattern
+func validateStreamID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if !streamIDPattern.MatchString(id) {
+		return "", fmt.Errorf("stream_id %q must be 1-64 characters of letters, digits, '_' or '-'", id)
+	}
+	if strings.HasPrefix(id, SelfTestStreamPrefix) {
+		return "", fmt.Errorf("stream_id %q uses the reserved prefix %q", id, SelfTestStreamPrefix)
+	}
+	return id, nil
+}
+
+// startStreamRequest is the body of a start request for a stream with a caller-chosen ID
+type startStreamRequest struct {
+	StreamID string `json:"stream_id" binding:"required"`
+	RTSPURL  string `json:"rtsp_url"`
+	StreamOptions
+}
+
+// streamConflictError reports a start request for an existing stream with different parameters
+type streamConflictError struct {
+	streamID   string
+	mismatches []string
+}
+
+func (e *streamConflictError) Error() string {
+	return fmt.Sprintf("stream %s already exists with different parameters: %s", e.streamID, strings.Join(e.mismatches, ", "))
+}
+
+func (e *streamConflictError) Unwrap() error {
+	return ErrStreamExists
+}
+
+// startRequested validates and applies defaults to a start request, then starts the stream. Repeating an
+// identical start is a no-op reported as alreadyRunning, so callers can safely retry.
+func (sm *StreamManager) startRequested(req *startStreamRequest) (alreadyRunning bool, err error) {
+	if req.StreamID, err = validateStreamID(req.StreamID); err != nil {
+		return false, err
+	}
+
+	// rtsp_url may be omitted when rtsp_urls supplies the failover list
+	primary, _, err := resolveInputURLs(req.RTSPURL, req.RTSPURLs)
+	if err != nil {
+		return false, err
+	}
+	req.RTSPURL = primary
+
+	// Apply default resolution and pixel format if not specified
+	if err := req.normalize(); err != nil {
+		return false, err
 	}
 
-	// Default resolution if not specified
-	if req.Width == 0 {
-		req.Width = 640
+	err = sm.StartStream(req.StreamID, req.RTSPURL, req.StreamOptions)
+	if errors.Is(err, ErrStreamExists) {
+		mismatches, exists := sm.existingStreamDiff(req.StreamID, req.RTSPURL, req.StreamOptions)
+		switch {
+		case !exists:
+			// Stopped between StartStream and the comparison; report the original conflict
+		case len(mismatches) == 0:
+			return true, nil
+		default:
+			return false, &streamConflictError{streamID: req.StreamID, mismatches: mismatches}
+		}
 	}
-	if req.Height == 0 {
-		req.Height = 480
+	return false, err
+}
+
+// respondStartError maps a startRequested failure to an error response
+func respondStartError(c *gin.Context, err error) {
+	var conflict *streamConflictError
+	switch {
+	case errors.As(err, &conflict):
+		respondError(c, http.StatusConflict, CodeStreamExists, err.Error(), gin.H{"mismatched_fields": conflict.mismatches})
+	case errors.Is(err, ErrStreamExists), errors.Is(err, ErrStreamLimit), errors.Is(err, ErrStartQueueFull):
+		respondManagerError(c, err)
+	default:
+		respondInvalidRequest(c, err)
 	}
+}
 
-	err := sm.StartStream(req.StreamID, req.RTSPURL, req.Width, req.Height)
+// handleStartStream starts a new RTSP stream with specified ID
+func (sm *StreamManager) handleStartStream(c *gin.Context) {
+	var req startStreamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	alreadyRunning, err := sm.startRequested(&req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondStartError(c, err)
 		return
 	}
+	if !alreadyRunning {
+		sm.auditStreamStarted(c, req.StreamID, req.RTSPURL, req.StreamOptions)
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":   "Stream started successfully",
-		"stream_id": req.StreamID,
-		"rtsp_url":  req.RTSPURL,
-		"width":     req.Width,
-		"height":    req.Height,
-	})
+	message := "Stream started successfully"
+	position := sm.queuePosition(req.StreamID)
+	switch {
+	case alreadyRunning:
+		message = "Stream already running"
+	case position > 0:
+		message = "Stream queued until an FFmpeg slot frees up"
+	}
+
+	response := gin.H{
+		"message":      message,
+		"stream_id":    req.StreamID,
+		"rtsp_url":     req.RTSPURL,
+		"width":        req.Width,
+		"height":       req.Height,
+		"pixel_format": req.PixelFormat,
+	}
+	if position > 0 {
+		response["status"] = StatusQueued
+		response["queue_position"] = position
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // handleStartStreamWithURL starts a new RTSP stream with auto-generated ID
 func (sm *StreamManager) handleStartStreamWithURL(c *gin.Context) {
 	var req struct {
-		RTSPURL string `json:"rtsp_url" binding:"required"`
-		Width   int    `json:"width"`
-		Height  int    `json:"height"`
+		RTSPURL   string `json:"rtsp_url"`
+		Dedicated bool   `json:"dedicated"`
+		StreamOptions
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondInvalidRequest(c, err)
 		return
 	}
+	if raw := c.Query("dedicated"); raw != "" {
+		dedicated, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondInvalidRequest(c, fmt.Errorf("invalid dedicated: %v", err))
+			return
+		}
+		req.Dedicated = req.Dedicated || dedicated
+	}
+
+	// rtsp_url may be omitted when rtsp_urls supplies the failover list
+	primary, _, err := resolveInputURLs(req.RTSPURL, req.RTSPURLs)
+	if err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+	req.RTSPURL = primary
 
 	// Generate stream ID from URL hash for consistency
 	hasher := md5.New()
 	hasher.Write([]byte(req.RTSPURL))
 	streamID := fmt.Sprintf("stream_%x", hasher.Sum(nil))[:16]
 
-	// Default resolution if not specified
-	if req.Width == 0 {
-		req.Width = 640
+	// Apply default resolution and pixel format if not specified
+	if err := req.normalize(); err != nil {
+		respondInvalidRequest(c, err)
+		return
 	}
-	if req.Height == 0 {
-		req.Height = 480
+
+	// A dedicated stream gets an ingest of its own even when the URL is already streaming
+	if req.Dedicated {
+		streamID, err = sm.startDedicated(streamID, req.RTSPURL, req.StreamOptions)
+		if err != nil {
+			respondManagerError(c, err)
+			return
+		}
+		sm.auditStreamStarted(c, streamID, req.RTSPURL, req.StreamOptions)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Dedicated stream started successfully",
+			"stream_id":    streamID,
+			"dedicated":    true,
+			"rtsp_url":     req.RTSPURL,
+			"width":        req.Width,
+			"height":       req.Height,
+			"pixel_format": req.PixelFormat,
+		})
+		return
 	}
 
 	// Check if stream already exists
@@ -132,31 +368,45 @@ func (sm *StreamManager) handleStartStreamWithURL(c *gin.Context) {
 	if _, exists := sm.streams[streamID]; exists {
 		sm.mu.RUnlock()
 		c.JSON(http.StatusOK, gin.H{
-			"message":   "Stream already running",
-			"stream_id": streamID,
-			"rtsp_url":  req.RTSPURL,
-			"width":     req.Width,
-			"height":    req.Height,
+			"message":      "Stream already running",
+			"stream_id":    streamID,
+			"rtsp_url":     req.RTSPURL,
+			"width":        req.Width,
+			"height":       req.Height,
+			"pixel_format": req.PixelFormat,
 		})
 		return
 	}
 	sm.mu.RUnlock()
 
-	err := sm.StartStream(streamID, req.RTSPURL, req.Width, req.Height)
+	err = sm.StartStream(streamID, req.RTSPURL, req.StreamOptions)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondManagerError(c, err)
 		return
 	}
+	sm.auditStreamStarted(c, streamID, req.RTSPURL, req.StreamOptions)
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":   "Stream started successfully",
-		"stream_id": streamID,
-		"rtsp_url":  req.RTSPURL,
-		"width":     req.Width,
-		"height":    req.Height,
+		"message":      "Stream started successfully",
+		"stream_id":    streamID,
+		"rtsp_url":     req.RTSPURL,
+		"width":        req.Width,
+		"height":       req.Height,
+		"pixel_format": req.PixelFormat,
 	})
 }
 
+// startDedicated starts a stream under the first free ID of the form <sharedID>-d<N>, so any number
+// of dedicated streams can share a URL alongside the shared one
+func (sm *StreamManager) startDedicated(sharedID, rtspURL string, opts StreamOptions) (string, error) {
+	for n := 1; ; n++ {
+		streamID := fmt.Sprintf("%s-d%d", sharedID, n)
+		if err := sm.StartStream(streamID, rtspURL, opts); !errors.Is(err, ErrStreamExists) {
+			return streamID, err
+		}
+	}
+}
+
 // handleStopStream stops a stream if no clients are connected
 func (sm *StreamManager) handleStopStream(c *gin.Context) {
 	streamID := c.Param("streamId")
@@ -166,7 +416,7 @@ func (sm *StreamManager) handleStopStream(c *gin.Context) {
 	stream, exists := sm.streams[streamID]
 	if !exists {
 		sm.mu.RUnlock()
-		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+		respondError(c, http.StatusNotFound, CodeStreamNotFound, "Stream not found", nil)
 		return
 	}
 
@@ -176,18 +426,29 @@ func (sm *StreamManager) handleStopStream(c *gin.Context) {
 	sm.mu.RUnlock()
 
 	if clientCount > 0 {
-		c.JSON(http.StatusConflict, gin.H{
-			"error":        fmt.Sprintf("Cannot stop stream %s: %d client(s) still connected", streamID, clientCount),
-			"client_count": clientCount,
-		})
+		respondError(c, http.StatusConflict, CodeClientsConnected,
+			fmt.Sprintf("Cannot stop stream %s: %d client(s) still connected", streamID, clientCount),
+			gin.H{"client_count": clientCount})
 		return
 	}
 
+	// Stopping a stream that is still waiting for an FFmpeg slot cancels its queued start
+	position := stream.queuePosition()
 	err := sm.StopStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondManagerError(c, err)
 		return
 	}
+	if position > 0 {
+		sm.auditRequest(c, "stream_stopped", streamID, map[string]interface{}{"queue_position": position})
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Queued start cancelled",
+			"stream_id":      streamID,
+			"queue_position": position,
+		})
+		return
+	}
+	sm.auditRequest(c, "stream_stopped", streamID, nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Stream stopped successfully",
@@ -201,9 +462,10 @@ func (sm *StreamManager) handleForceStopStream(c *gin.Context) {
 
 	err := sm.StopStream(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondManagerError(c, err)
 		return
 	}
+	sm.auditRequest(c, "stream_stopped", streamID, map[string]interface{}{"force": true})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Stream force-stopped successfully",
@@ -211,42 +473,435 @@ func (sm *StreamManager) handleForceStopStream(c *gin.Context) {
 	})
 }
 
+// handleSignStreamURL issues a time-limited signed WebSocket URL for a stream
+func (sm *StreamManager) handleSignStreamURL(c *gin.Context) {
+	streamID := c.Param("streamId")
+
+	if len(sm.config.SigningSecret) == 0 {
+		respondError(c, http.StatusBadRequest, CodeSigningDisabled, "URL signing is not configured (set STREAM_SIGNING_SECRET)", nil)
+		return
+	}
+
+	ttl := SignedURLDefaultTTL
+	if raw := c.Query("ttl"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 || parsed > SignedURLMaxTTL {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("ttl must be a positive duration up to %s", SignedURLMaxTTL), nil)
+			return
+		}
+		ttl = parsed
+	}
+
+	sm.mu.RLock()
+	_, exists := sm.streams[streamID]
+	sm.mu.RUnlock()
+
+	if !exists {
+		respondError(c, http.StatusNotFound, CodeStreamNotFound, "Stream not found", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stream_id":  streamID,
+		"url":        sm.SignStreamURL(streamID, ttl),
+		"expires_at": time.Now().Add(ttl).Unix(),
+	})
+}
+
+// handlePauseStream pauses ingest for a stream without disconnecting its clients
+func (sm *StreamManager) handlePauseStream(c *gin.Context) {
+	streamID := c.Param("streamId")
+
+	if err := sm.PauseStream(streamID); err != nil {
+		respondManagerError(c, err)
+		return
+	}
+	sm.auditRequest(c, "stream_paused", streamID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Stream paused successfully",
+		"stream_id": streamID,
+	})
+}
+
+// handleResumeStream resumes ingest for a paused stream
+func (sm *StreamManager) handleResumeStream(c *gin.Context) {
+	streamID := c.Param("streamId")
+
+	if err := sm.ResumeStream(streamID); err != nil {
+		respondManagerError(c, err)
+		return
+	}
+	sm.auditRequest(c, "stream_resumed", streamID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Stream resumed successfully",
+		"stream_id": streamID,
+	})
+}
+
 // handleGetStreamStats returns statistics about a specific stream
 func (sm *StreamManager) handleGetStreamStats(c *gin.Context) {
 	streamID := c.Param("streamId")
 
 	stats, err := sm.GetStreamStats(streamID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondManagerError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, stats)
 }
 
-// handleListStreams returns a list of all active streams
+// handleGetStreamFormat returns the raw frame geometry so clients can set up decoding before connecting
+func (sm *StreamManager) handleGetStreamFormat(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	stream.mu.RLock()
+	format := gin.H{
+		"stream_id":        stream.streamID,
+		"width":            stream.width,
+		"height":           stream.height,
+		"pixel_format":     stream.pixelFormat,
+		"bytes_per_pixel":  stream.bytesPerPixel,
+		"frame_size_bytes": stream.frameSize(),
+		"gop":              stream.gop,
+		// Every raw frame is a complete image, so decoding may start at any frame
+		"keyframe_interval": 1,
+	}
+	stream.mu.RUnlock()
+	format["subprotocol"] = stream.formatSubprotocol(0, 0)
+	format["fps"] = stream.ingestRate.rate()
+
+	c.JSON(http.StatusOK, format)
+}
+
+// handleGetBatchStreamStats returns statistics for several streams in one call. Stream IDs come from
+// the comma-separated ids query parameter (GET) or a {"stream_ids": [...]} body (POST); unknown IDs
+// are reported in not_found rather than failing the request.
+func (sm *StreamManager) handleGetBatchStreamStats(c *gin.Context) {
+	var streamIDs []string
+
+	if c.Request.Method == http.MethodPost {
+		var req struct {
+			StreamIDs []string `json:"stream_ids" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondInvalidRequest(c, err)
+			return
+		}
+		streamIDs = req.StreamIDs
+	} else {
+		for _, id := range strings.Split(c.Query("ids"), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				streamIDs = append(streamIDs, id)
+			}
+		}
+	}
+
+	if len(streamIDs) == 0 {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "at least one stream ID is required", nil)
+		return
+	}
+
+	stats := make(map[string]interface{}, len(streamIDs))
+	notFound := make([]string, 0)
+	for _, streamID := range streamIDs {
+		// Streams the caller may not view are reported as not found so their existence isn't revealed
+		if !canView(c, streamID) {
+			notFound = append(notFound, streamID)
+			continue
+		}
+		streamStats, err := sm.GetStreamStats(streamID)
+		if err != nil {
+			notFound = append(notFound, streamID)
+			continue
+		}
+		stats[streamID] = streamStats
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stats":     stats,
+		"not_found": notFound,
+	})
+}
+
+// handleListClients returns the clients connected to a stream with their delivery counters
+func (sm *StreamManager) handleListClients(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	stream.clientsMu.RLock()
+	clients := make([]map[string]interface{}, 0, len(stream.clients))
+	for _, client := range stream.clients {
+		clients = append(clients, client.info())
+	}
+	stream.clientsMu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"stream_id": stream.streamID,
+		"clients":   clients,
+	})
+}
+
+// handleUpdateClient tunes a connected client's buffer size, target frame rate or pause state at runtime
+func (sm *StreamManager) handleUpdateClient(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	var req clientTuning
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	clientID := c.Param("clientId")
+	stream.clientsMu.RLock()
+	client, exists := stream.clients[clientID]
+	stream.clientsMu.RUnlock()
+
+	if !exists {
+		respondError(c, http.StatusNotFound, CodeClientNotFound, "Client not found", nil)
+		return
+	}
+	if req.AutoBuffer != nil && *req.AutoBuffer && client.opts.Ack {
+		respondInvalidRequest(c, fmt.Errorf("auto_buffer has no effect on an ack client, which has one frame in flight"))
+		return
+	}
+
+	if err := client.tune(req); err != nil {
+		respondError(c, http.StatusNotFound, CodeClientNotFound, err.Error(), nil)
+		return
+	}
+
+	log.Printf("Client %s on stream %s tuned via API", clientID, stream.streamID)
+	c.JSON(http.StatusOK, client.info())
+}
+
+// handleListStreams returns the active streams ordered by ID, optionally filtered by tag, status and
+// running, paginated with limit and offset, and projected to the requested fields
 func (sm *StreamManager) handleListStreams(c *gin.Context) {
+	filter, err := parseStreamListFilter(c.Request.URL.Query())
+	if err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
 	streams := make([]map[string]interface{}, 0, len(sm.streams))
 	for streamID, stream := range sm.streams {
+		// Keys scoped to some streams only see those
+		if !canView(c, streamID) {
+			continue
+		}
 		stream.mu.RLock()
 		streamInfo := map[string]interface{}{
 			"stream_id":    streamID,
 			"rtsp_url":     stream.rtspURL,
+			"pixel_format": stream.pixelFormat,
+			"status":       stream.status,
 			"is_running":   stream.isRunning,
+			"paused":       stream.paused,
+			"draining":     stream.draining,
 			"client_count": len(stream.clients),
-			"frame_count":  stream.frameCount,
+			"frame_count":  stream.frameCount.Load(),
+			"metadata":     stream.metadataOrEmpty(),
 		}
 		stream.mu.RUnlock()
 		streams = append(streams, streamInfo)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"streams": streams})
+	// Filters apply to the folded entries, so they see exactly what the caller would
+	filtered := streams[:0]
+	for _, streamInfo := range streams {
+		if filter.match(streamInfo) {
+			filtered = append(filtered, streamInfo)
+		}
+	}
+
+	// Order by ID so pages stay stable between calls
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i]["stream_id"].(string) < filtered[j]["stream_id"].(string)
+	})
+	page := filter.page(filtered)
+	for i := range page {
+		page[i] = filter.project(page[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"streams": page,
+		"total":   len(filtered),
+		"offset":  filter.offset,
+		"limit":   filter.limit,
+	})
+}
+
+// handleStreamEvents streams all of a stream's events (motion, status, ...) as Server-Sent Events
+func (sm *StreamManager) handleStreamEvents(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	serveEventStream(c, stream, nil, nil, false)
 }
 
-// handleGetFrame returns a single frame from the stream buffer (for Python clients)
+// handleGetStreamStatus returns a snapshot of a stream's current status
+func (sm *StreamManager) handleGetStreamStatus(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, stream.statusSnapshot())
+}
+
+// handleWaitReady blocks until the stream is running and has produced a frame, or the timeout expires
+func (sm *StreamManager) handleWaitReady(c *gin.Context) {
+	timeout := WaitReadyDefaultTimeout
+	if raw := c.Query("timeout"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 || parsed > WaitReadyMaxTimeout {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("timeout must be a positive duration up to %s", WaitReadyMaxTimeout), nil)
+			return
+		}
+		timeout = parsed
+	}
+
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-stream.readyChan():
+		c.JSON(http.StatusOK, stream.statusSnapshot())
+	case <-stream.healthStopChan:
+		respondError(c, http.StatusNotFound, CodeStreamNotFound, "Stream stopped", nil)
+	case <-sm.shutdownCtx.Done():
+		respondError(c, http.StatusServiceUnavailable, CodeShuttingDown, "Server shutting down", nil)
+	case <-c.Request.Context().Done():
+	case <-timer.C:
+		status := stream.statusSnapshot()["status"]
+		code := CodeStreamNotReady
+		if status == StatusReconnecting || status == StatusFailed {
+			code = CodeFFmpegFailed
+		}
+		respondError(c, http.StatusGatewayTimeout, code, "Timed out waiting for stream to become ready", gin.H{"status": status})
+	}
+}
+
+// handleStreamStatusEvents pushes status changes, client-count changes and periodic
+// frame-rate updates as Server-Sent Events, starting with a full status snapshot
+func (sm *StreamManager) handleStreamStatusEvents(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	isStatusEvent := func(event StreamEvent) bool {
+		return event["type"] == "status" || event["type"] == "clients" || event["type"] == "breaker"
+	}
+	serveEventStream(c, stream, stream.statusSnapshot(), isStatusEvent, true)
+}
+
+// lookupStream resolves the :streamId parameter, writing a 404 response when the stream doesn't exist
+func (sm *StreamManager) lookupStream(c *gin.Context) (*Stream, bool) {
+	streamID := c.Param("streamId")
+
+	sm.mu.RLock()
+	stream, exists := sm.streams[streamID]
+	sm.mu.RUnlock()
+
+	if !exists {
+		respondError(c, http.StatusNotFound, CodeStreamNotFound, "Stream not found", nil)
+		return nil, false
+	}
+	return stream, true
+}
+
+// serveEventStream writes stream events to the client as Server-Sent Events until the client
+// disconnects or the stream stops. The optional initial event is sent first, accept filters
+// which events are forwarded (nil forwards all), and withRate adds periodic "fps" events.
+func serveEventStream(c *gin.Context, stream *Stream, initial StreamEvent, accept func(StreamEvent) bool, withRate bool) {
+	events := stream.events.subscribe()
+	defer stream.events.unsubscribe(events)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	if initial != nil {
+		c.SSEvent(fmt.Sprint(initial["type"]), initial)
+		c.Writer.Flush()
+	}
+
+	keepAlive := time.NewTicker(SSEKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	// A nil channel never fires, which disables rate updates when not requested
+	var rateTick <-chan time.Time
+	if withRate {
+		rateTicker := time.NewTicker(StatusRateInterval)
+		defer rateTicker.Stop()
+		rateTick = rateTicker.C
+	}
+	lastCount := stream.frameCount.Load()
+	lastSample := time.Now()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				// Stream stopped
+				return false
+			}
+			if accept == nil || accept(event) {
+				c.SSEvent(fmt.Sprint(event["type"]), event)
+			}
+			return true
+		case now := <-rateTick:
+			count := stream.frameCount.Load()
+
+			fps := float64(count-lastCount) / now.Sub(lastSample).Seconds()
+			lastCount, lastSample = count, now
+			c.SSEvent("fps", StreamEvent{
+				"type":        "fps",
+				"stream_id":   stream.streamID,
+				"fps":         fps,
+				"frame_count": count,
+				"timestamp":   now.UnixMilli(),
+			})
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keepalive\n\n")
+			return err == nil
+		}
+	})
+}
+
+// handleGetFrame returns a single frame from the stream buffer (for Python clients), raw or as JPEG or
+// PNG depending on the Accept header. With ?ts=<unix_nano> it instead returns the cached frame nearest
+// that timestamp.
 func (sm *StreamManager) handleGetFrame(c *gin.Context) {
 	streamID := c.Param("streamId")
 
@@ -255,35 +910,93 @@ func (sm *StreamManager) handleGetFrame(c *gin.Context) {
 	sm.mu.RUnlock()
 
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+		respondError(c, http.StatusNotFound, CodeStreamNotFound, "Stream not found", nil)
 		return
 	}
 
-	// Check if stream is actually running
-	stream.mu.RLock()
-	isRunning := stream.isRunning
-	stream.mu.RUnlock()
+	if sm.frameRateLimit != nil {
+		if ok, wait := sm.frameRateLimit.allow(c.ClientIP(), time.Now()); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondError(c, http.StatusTooManyRequests, CodeTooManyRequests, "Frame request rate exceeded for this client", nil)
+			return
+		}
+	}
 
-	if !isRunning {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Stream not running"})
+	frameType, ok := frameTypeFor(c)
+	if !ok {
 		return
 	}
 
-	// Wait for a frame with timeout
-	timeout := time.After(5 * time.Second)
-	select {
-	case frame, ok := <-stream.frameBuffer:
-		if !ok {
-			// Channel closed
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Stream buffer closed"})
+	if raw := c.Query("ts"); raw != "" {
+		sm.serveCachedFrame(c, stream, raw, frameType)
+		return
+	}
+
+	// A request during FFmpeg's first connect waits for the first frame like any other
+	ingesting, paused := stream.ingestState()
+
+	if paused {
+		respondError(c, http.StatusServiceUnavailable, CodeStreamPaused, "Stream paused", nil)
+		return
+	}
+
+	if !ingesting {
+		respondError(c, http.StatusServiceUnavailable, CodeStreamNotRunning, "Stream not running", nil)
+		return
+	}
+
+	// Waiting requests are bounded per stream so a burst of pollers can't pile up goroutines
+	if !stream.frameRequests.acquire() {
+		c.Header("Retry-After", "1")
+		respondError(c, http.StatusTooManyRequests, CodeTooManyRequests, "Too many concurrent frame requests for this stream", nil)
+		return
+	}
+	defer stream.frameRequests.release()
+
+	sm.serveLatestFrame(c, stream, frameType)
+}
+
+// serveCachedFrame returns the frame from the rolling cache nearest the requested unix-nano timestamp
+func (sm *StreamManager) serveCachedFrame(c *gin.Context, stream *Stream, raw, frameType string) {
+	nanos, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "ts must be a unix timestamp in nanoseconds", nil)
+		return
+	}
+
+	frame, ok := stream.frameCache.nearest(time.Unix(0, nanos))
+	if !ok {
+		var details gin.H
+		if oldest, newest, ok := stream.frameCache.window(); ok {
+			details = gin.H{"oldest_ts": oldest.UnixNano(), "newest_ts": newest.UnixNano()}
+		}
+		respondError(c, http.StatusNotFound, CodeFrameNotFound, "Requested timestamp is outside the cached window", details)
+		return
+	}
+
+	stream.writeFrame(c, frame, frameType)
+}
+
+// writeFrame returns a frame raw or encoded as frameType, with its capture timestamp, sequence number,
+// size and the stream's pixel format in headers
+func (s *Stream) writeFrame(c *gin.Context, frame *Frame, frameType string) {
+	data := frame.data
+	if frameType != FrameTypeRaw {
+		var err error
+		if data, err = s.encodeFrameImage(frame, frameType); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, err.Error(), nil)
 			return
 		}
-		// Return frame as binary data with headers
-		c.Header("Content-Type", "application/octet-stream")
-		c.Header("X-Frame-Timestamp", strconv.FormatInt(time.Now().UnixNano(), 10))
-		c.Data(http.StatusOK, "application/octet-stream", frame)
-	case <-timeout:
-		// Instead of 408, return 204 No Content for smoother client experience
-		c.Status(http.StatusNoContent)
 	}
+
+	c.Header("Vary", "Accept")
+	c.Header("X-Frame-Timestamp", strconv.FormatInt(frame.timestamp.UnixNano(), 10))
+	c.Header("X-Frame-Timestamp-Source", frame.timestampSource())
+	if frame.seq > 0 {
+		c.Header("X-Frame-Seq", strconv.FormatInt(frame.seq, 10))
+	}
+	c.Header("X-Frame-Width", strconv.Itoa(s.width))
+	c.Header("X-Frame-Height", strconv.Itoa(s.height))
+	c.Header("X-Pixel-Format", s.pixelFormat)
+	c.Data(http.StatusOK, frameType, data)
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// waitForGoroutine waits until some goroutine's stack contains fn, e.g. one blocked on a lock there
+func waitForGoroutine(t testing.TB, fn string) {
+	t.Helper()
+	buf := make([]byte, 1<<20)
+	waitFor(t, 5*time.Second, "a goroutine in "+fn, func() bool {
+		return strings.Contains(string(buf[:runtime.Stack(buf, true)]), fn)
+	})
+}
+
+// TestWebSocketConnectDuringStop upgrades a connection to a stream that is stopped between the handler's
+// checks and AddClient: the client must get a close code telling it to reconnect, not a dropped socket
+func TestWebSocketConnectDuringStop(t *testing.T) {
+	tests := []struct {
+		name     string
+		shutdown bool
+		code     int
+	}{
+		{name: "force stop", code: websocket.CloseTryAgainLater},
+		{name: "shutdown", shutdown: true, code: websocket.CloseGoingAway},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, 25, nil)
+			ts.startStream(t, map[string]interface{}{
+				"stream_id": "racing",
+				"rtsp_url":  "rtsp://camera.example/racing",
+				"width":     16,
+				"height":    16,
+			})
+			stream := ts.stream(t, "racing")
+
+			// Holding stream.mu parks the handler in ingestState, past its stream lookup, and then the
+			// stop in stopIngestLocked, before it unregisters the stream. Releasing it lets the stop
+			// finish first, since the handler's next read lock queues behind the waiting stop.
+			stream.mu.Lock()
+			type dialResult struct {
+				conn *websocket.Conn
+				resp *http.Response
+				err  error
+			}
+			dialed := make(chan dialResult, 1)
+			go func() {
+				conn, resp, err := ts.dial(t, "racing", "")
+				dialed <- dialResult{conn, resp, err}
+			}()
+			waitForGoroutine(t, "(*Stream).ingestState")
+
+			if tt.shutdown {
+				ts.sm.beginShutdown()
+			}
+			stopped := make(chan error, 1)
+			go func() { stopped <- ts.sm.StopStream("racing") }()
+			waitForGoroutine(t, "(*StreamManager).stopIngestLocked")
+			stream.mu.Unlock()
+
+			if err := <-stopped; err != nil {
+				t.Fatalf("StopStream: %v", err)
+			}
+			result := <-dialed
+			if result.err != nil {
+				t.Fatalf("dial: %v (want an upgrade followed by a close)", result.err)
+			}
+
+			result.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err := result.conn.ReadMessage()
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("read after stop: %v, want a close frame", err)
+			}
+			if closeErr.Code != tt.code {
+				t.Errorf("close code = %d (%q), want %d", closeErr.Code, closeErr.Text, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// hwaccels whitelists the hardware decoders a stream may request with hwaccel; "none" decodes in software
+var hwaccels = map[string]bool{
+	"none":         true,
+	"cuda":         true, // NVIDIA (NVDEC)
+	"vaapi":        true, // Intel/AMD on Linux
+	"qsv":          true, // Intel Quick Sync
+	"videotoolbox": true, // macOS
+}
+
+// hwaccelFailurePatterns match the errors FFmpeg logs when a build supports an accelerator but the
+// machine can't provide it, e.g. no GPU, a missing driver or no access to /dev/dri
+var hwaccelFailurePatterns = []string{
+	"Device creation failed",
+	"Failed to initialise VAAPI connection",
+	"No device available for decoder",
+	"Cannot load libcuda",
+	"Error creating a MFX session",
+	"Hardware device setup failed",
+}
+
+// ffmpegHWAccels caches the accelerators each FFmpeg binary was built with, by path
+var ffmpegHWAccels = struct {
+	mu     sync.Mutex
+	byPath map[string][]string
+}{byPath: make(map[string][]string)}
+
+// validateHWAccel checks a requested accelerator against the whitelist; empty means none
+func validateHWAccel(name string) error {
+	if name != "" && !hwaccels[name] {
+		return fmt.Errorf("hwaccel %q is not supported (supported: none, cuda, vaapi, qsv, videotoolbox)", name)
+	}
+	return nil
+}
+
+// hwaccelArgs returns the input flags that decode on the GPU, or nil for software decode. Decoded
+// frames are copied back to system memory for the scale filter and raw output.
+func hwaccelArgs(name string) []string {
+	if name == "" || name == "none" {
+		return nil
+	}
+	return []string{"-hwaccel", name}
+}
+
+// listHWAccels runs ffmpeg -hwaccels, which prints a header line followed by one method per line
+func listHWAccels(ctx context.Context, runner CommandRunner) ([]string, error) {
+	out, err := runner.CommandContext(ctx, "-hide_banner", "-hwaccels").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var methods []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		methods = append(methods, line)
+	}
+	return methods, nil
+}
+
+// checkHWAccel verifies that the FFmpeg build a stream runs supports its accelerator, so a missing
+// one fails the start request instead of every FFmpeg run
+func checkHWAccel(runner CommandRunner, name string) error {
+	if name == "" || name == "none" {
+		return nil
+	}
+
+	ffmpegHWAccels.mu.Lock()
+	defer ffmpegHWAccels.mu.Unlock()
+
+	methods, ok := ffmpegHWAccels.byPath[runner.Path()]
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), FFmpegProbeTimeout)
+		defer cancel()
+		var err error
+		if methods, err = listHWAccels(ctx, runner); err != nil {
+			return fmt.Errorf("%w: failed to list the accelerators of FFmpeg at %s: %v", ErrHWAccelUnavailable, runner.Path(), err)
+		}
+		ffmpegHWAccels.byPath[runner.Path()] = methods
+	}
+
+	if !containsString(methods, name) {
+		available := "none"
+		if len(methods) > 0 {
+			available = strings.Join(methods, ", ")
+		}
+		return fmt.Errorf("%w: FFmpeg at %s was built without %s (available: %s)", ErrHWAccelUnavailable, runner.Path(), name, available)
+	}
+	return nil
+}
+
+// hwaccelFailure reports whether an FFmpeg stderr line says the requested accelerator couldn't be used
+func hwaccelFailure(line string) bool {
+	for _, pattern := range hwaccelFailurePatterns {
+		if strings.Contains(line, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// hwaccelOrNone reports a stream's accelerator for stats
+func hwaccelOrNone(name string) string {
+	if name == "" {
+		return "none"
+	}
+	return name
+}
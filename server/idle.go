@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseIdleTimeout validates an idle_timeout such as "5m"; empty means the stream is never stopped for idleness
+func parseIdleTimeout(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid idle_timeout %q: %v", raw, err)
+	}
+	if d < MinIdleTimeout || d > MaxStreamDuration {
+		return 0, fmt.Errorf("idle_timeout must be between %s and %s", MinIdleTimeout, MaxStreamDuration)
+	}
+	return d, nil
+}
+
+// consumerCount returns how many WebSocket clients, MPEG-TS and audio listeners and local socket
+// consumers the stream has. HTTP frame polling doesn't count; pollers send keepalives instead.
+func (s *Stream) consumerCount() int {
+	s.clientsMu.RLock()
+	n := len(s.clients)
+	s.clientsMu.RUnlock()
+
+	n += s.ts.listenerCount()
+	if s.audio != nil {
+		n += s.audio.listenerCount()
+	}
+	if s.local != nil {
+		n += s.local.consumerCount()
+	}
+	return n
+}
+
+// touch records consumer activity or a keepalive, restarting the idle clock
+func (s *Stream) touch(now time.Time) {
+	s.lastActivity.Store(now.UnixNano())
+}
+
+// idleExpiresAtOrNil returns when the stream will be stopped if nothing connects or sends a keepalive,
+// or nil for streams without an idle_timeout
+func (s *Stream) idleExpiresAtOrNil() interface{} {
+	if s.idleTimeout == 0 {
+		return nil
+	}
+	return time.Unix(0, s.lastActivity.Load()).Add(s.idleTimeout)
+}
+
+// lastKeepaliveOrNil returns the time of the last keepalive, or nil if none was sent
+func (s *Stream) lastKeepaliveOrNil() interface{} {
+	nanos := s.lastKeepalive.Load()
+	if nanos == 0 {
+		return nil
+	}
+	return time.Unix(0, nanos)
+}
+
+// checkIdle runs on every health check: streams with consumers stay active, and streams that have had
+// none and no keepalive for their idle_timeout are stopped
+func (sm *StreamManager) checkIdle(stream *Stream, now time.Time) {
+	if stream.idleTimeout == 0 {
+		return
+	}
+	if stream.consumerCount() > 0 {
+		stream.touch(now)
+		return
+	}
+	if now.Sub(time.Unix(0, stream.lastActivity.Load())) < stream.idleTimeout {
+		return
+	}
+	sm.reapIdleStream(stream)
+}
+
+// reapIdleStream stops a stream that has been idle for its idle_timeout, unless it was already stopped or replaced
+func (sm *StreamManager) reapIdleStream(stream *Stream) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.streams[stream.streamID] != stream {
+		return
+	}
+
+	stream.events.publish(StreamEvent{
+		"type":           "idle",
+		"stream_id":      stream.streamID,
+		"idle_since":     time.Unix(0, stream.lastActivity.Load()),
+		"idle_timeout":   stream.opts.IdleTimeout,
+		"last_keepalive": stream.lastKeepaliveOrNil(),
+	})
+	log.Printf("Stream %s had no consumers or keepalives for %s, stopping it", stream.streamID, stream.opts.IdleTimeout)
+	sm.stopLocked(stream)
+}
+
+// handleKeepalive resets a stream's idle clock for controllers whose consumers don't hold a connection,
+// such as recorders polling the frame endpoint
+func (sm *StreamManager) handleKeepalive(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	stream.lastKeepalive.Store(now.UnixNano())
+	stream.touch(now)
+
+	c.JSON(http.StatusOK, gin.H{
+		"stream_id":       stream.streamID,
+		"last_keepalive":  now,
+		"idle_timeout":    stream.opts.IdleTimeout,
+		"idle_expires_at": stream.idleExpiresAtOrNil(),
+		"expires_at":      stream.expiresAtOrNil(),
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import "image"
+
+// encodeRGB converts a packed RGB24 buffer into a raw frame in the given pixel format
+func encodeRGB(rgb []byte, width, height int, pixelFormat string) []byte {
+	switch pixelFormat {
+	case "rgb24":
+		out := make([]byte, len(rgb))
+		copy(out, rgb)
+		return out
+	case "bgr24":
+		out := make([]byte, len(rgb))
+		for i := 0; i+2 < len(rgb); i += 3 {
+			out[i], out[i+1], out[i+2] = rgb[i+2], rgb[i+1], rgb[i]
+		}
+		return out
+	case "gray":
+		out := make([]byte, width*height)
+		for i := range out {
+			out[i] = luma(rgb[i*3], rgb[i*3+1], rgb[i*3+2])
+		}
+		return out
+	case "yuv420p":
+		// Full-resolution Y plane followed by U and V planes subsampled 2x2 (BT.601)
+		out := make([]byte, width*height*3/2)
+		uPlane := out[width*height : width*height+width*height/4]
+		vPlane := out[width*height+width*height/4:]
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				i := (y*width + x) * 3
+				out[y*width+x] = luma(rgb[i], rgb[i+1], rgb[i+2])
+			}
+		}
+		for y := 0; y < height; y += 2 {
+			for x := 0; x < width; x += 2 {
+				var r, g, b int
+				for _, off := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+					i := ((y+off[1])*width + x + off[0]) * 3
+					r += int(rgb[i])
+					g += int(rgb[i+1])
+					b += int(rgb[i+2])
+				}
+				r, g, b = r/4, g/4, b/4
+				c := (y/2)*(width/2) + x/2
+				uPlane[c] = clampByte((-169*r - 331*g + 500*b + 128000) / 1000)
+				vPlane[c] = clampByte((500*r - 419*g - 81*b + 128000) / 1000)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// luma returns the BT.601 luma of an RGB pixel
+func luma(r, g, b byte) byte {
+	return byte((299*int(r) + 587*int(g) + 114*int(b)) / 1000)
+}
+
+// clampByte clamps an integer to the 0-255 range
+func clampByte(v int) byte {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return byte(v)
+}
+
+// pixelAt returns the RGB value of one pixel of a raw frame in the given pixel format
+func pixelAt(frame []byte, width, height int, pixelFormat string, x, y int) (byte, byte, byte) {
+	switch pixelFormat {
+	case "rgb24":
+		i := (y*width + x) * 3
+		return frame[i], frame[i+1], frame[i+2]
+	case "bgr24":
+		i := (y*width + x) * 3
+		return frame[i+2], frame[i+1], frame[i]
+	case "gray":
+		v := frame[y*width+x]
+		return v, v, v
+	case "yuv420p":
+		c := (y/2)*(width/2) + x/2
+		yy := int(frame[y*width+x])
+		u := int(frame[width*height+c]) - 128
+		v := int(frame[width*height+width*height/4+c]) - 128
+		return clampByte(yy + (1402*v)/1000),
+			clampByte(yy - (344*u+714*v)/1000),
+			clampByte(yy + (1772*u)/1000)
+	}
+	return 0, 0, 0
+}
+
+// downscale resizes a raw frame to the target size with nearest-neighbor sampling
+func downscale(frame []byte, width, height int, pixelFormat string, targetWidth, targetHeight int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for ty := 0; ty < targetHeight; ty++ {
+		sy := ty * height / targetHeight
+		for tx := 0; tx < targetWidth; tx++ {
+			sx := tx * width / targetWidth
+			r, g, b := pixelAt(frame, width, height, pixelFormat, sx, sy)
+			i := img.PixOffset(tx, ty)
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = r, g, b, 255
+		}
+	}
+	return img
+}
+
+// setGray sets one pixel of a raw frame to a neutral gray level in place. For yuv420p the pixel's
+// shared chroma sample is neutralized too, so the surrounding 2x2 block loses its color.
+func setGray(frame []byte, width, height int, pixelFormat string, x, y int, v byte) {
+	if x < 0 || y < 0 || x >= width || y >= height {
+		return
+	}
+	switch pixelFormat {
+	case "rgb24", "bgr24":
+		i := (y*width + x) * 3
+		frame[i], frame[i+1], frame[i+2] = v, v, v
+	case "gray":
+		frame[y*width+x] = v
+	case "yuv420p":
+		frame[y*width+x] = v
+		c := (y/2)*(width/2) + x/2
+		frame[width*height+c] = 128
+		frame[width*height+width*height/4+c] = 128
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Input kinds accepted in INPUT_SCHEMES. Network inputs use their URL scheme; "file" covers local paths
+// and file:// URLs, and "device" covers /dev/video* capture devices.
+var knownInputSchemes = map[string]bool{
+	"rtsp":   true,
+	"rtsps":  true,
+	"udp":    true,
+	"http":   true,
+	"https":  true,
+	"file":   true,
+	"device": true,
+}
+
+// inputScheme classifies an input URL or path by the INPUT_SCHEMES entry that governs it
+func inputScheme(input string) (string, error) {
+	if strings.HasPrefix(input, "/dev/video") {
+		return "device", nil
+	}
+	if !strings.Contains(input, "://") {
+		return "file", nil
+	}
+
+	u, err := url.Parse(input)
+	if err != nil {
+		if strings.Contains(input, "[") && strings.Contains(input, "%") {
+			return "", fmt.Errorf("%w: %v (escape an IPv6 zone as %%25, e.g. [fe80::1%%25eth0])", ErrInputNotAllowed, err)
+		}
+		return "", fmt.Errorf("%w: %v", ErrInputNotAllowed, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if !knownInputSchemes[scheme] || scheme == "device" {
+		return "", fmt.Errorf("%w: unsupported input scheme %q", ErrInputNotAllowed, u.Scheme)
+	}
+	if err := validateURLHost(u); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInputNotAllowed, err)
+	}
+	return scheme, nil
+}
+
+// validateURLHost rejects IPv6 literals that aren't bracketed. url.Parse accepts rtsp://2001:db8::1/
+// but splits it into host "2001:db8:" and port "1", so FFmpeg would dial the wrong address.
+func validateURLHost(u *url.URL) error {
+	if u.Host == "" || strings.HasPrefix(u.Host, "[") {
+		return nil
+	}
+	if strings.Count(u.Host, ":") > 1 {
+		return fmt.Errorf("IPv6 address %q must be enclosed in brackets, e.g. rtsp://[2001:db8::1]:554/", u.Host)
+	}
+	return nil
+}
+
+// parseInputSchemes parses the comma-separated INPUT_SCHEMES allow-list
+func parseInputSchemes(raw string) (map[string]bool, error) {
+	allowed := make(map[string]bool)
+	for _, scheme := range strings.Split(raw, ",") {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme == "" {
+			continue
+		}
+		if !knownInputSchemes[scheme] {
+			return nil, fmt.Errorf("INPUT_SCHEMES: unknown input scheme %q", scheme)
+		}
+		allowed[scheme] = true
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("INPUT_SCHEMES must allow at least one input scheme")
+	}
+	return allowed, nil
+}
+
+// checkLoopInputs rejects loop mode unless every input is a local file
+func checkLoopInputs(inputs []string) error {
+	for _, input := range inputs {
+		if scheme, _ := inputScheme(input); scheme != "file" {
+			return fmt.Errorf("%w: loop is only supported for file inputs, got %s input", ErrInputNotAllowed, scheme)
+		}
+	}
+	return nil
+}
+
+// checkInputs rejects any input whose scheme isn't in the configured allow-list
+func (sm *StreamManager) checkInputs(inputs []string) error {
+	for _, input := range inputs {
+		scheme, err := inputScheme(input)
+		if err != nil {
+			return err
+		}
+		if !sm.config.InputSchemes[scheme] {
+			allowed := make([]string, 0, len(sm.config.InputSchemes))
+			for s := range sm.config.InputSchemes {
+				allowed = append(allowed, s)
+			}
+			sort.Strings(allowed)
+			return fmt.Errorf("%w: %s inputs are disabled (allowed: %s)", ErrInputNotAllowed, scheme, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// ffmpegInputArgs returns the FFmpeg arguments that open input, including any per-stream input options.
+// loop replays file inputs forever.
+func ffmpegInputArgs(input string, inputOpts map[string]string, loop bool) []string {
+	// inputScheme was checked when the stream started, so the error can't happen here
+	scheme, _ := inputScheme(input)
+
+	var args []string
+	switch scheme {
+	case "rtsp", "rtsps":
+		args = append(args, "-rtsp_transport", "tcp")
+	case "file":
+		// Read files at their native frame rate rather than as fast as they decode
+		args = append(args, "-re")
+		if loop {
+			args = append(args, "-stream_loop", "-1")
+		}
+	case "device":
+		args = append(args, "-f", "v4l2")
+	}
+	args = append(args, inputOptArgs(inputOpts)...)
+	return append(args, "-i", input)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ipRateLimiter is a token bucket per client IP. Each IP may burst up to one second's worth of
+// requests; idle entries are evicted after IPLimiterTTL and the map never exceeds MaxTrackedIPs.
+type ipRateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	buckets   map[string]*ipBucket
+	lastSweep time.Time
+}
+
+// ipBucket is the token state of one IP
+type ipBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// newIPRateLimiter creates a limiter admitting rate requests per second per IP
+func newIPRateLimiter(rate float64) *ipRateLimiter {
+	return &ipRateLimiter{rate: rate, buckets: make(map[string]*ipBucket)}
+}
+
+// burst is the bucket capacity
+func (l *ipRateLimiter) burst() float64 {
+	return math.Max(1, l.rate)
+}
+
+// allow takes a token for ip, returning false and how long until one is available when it has none
+func (l *ipRateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= IPLimiterTTL {
+		l.sweepLocked(now)
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= MaxTrackedIPs {
+			l.evictOldestLocked()
+		}
+		b = &ipBucket{tokens: l.burst(), lastSeen: now}
+		l.buckets[ip] = b
+	}
+
+	b.tokens = math.Min(l.burst(), b.tokens+now.Sub(b.lastSeen).Seconds()*l.rate)
+	b.lastSeen = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweepLocked drops IPs idle for longer than IPLimiterTTL; callers must hold l.mu
+func (l *ipRateLimiter) sweepLocked(now time.Time) {
+	for ip, b := range l.buckets {
+		if now.Sub(b.lastSeen) > IPLimiterTTL {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// evictOldestLocked drops the least recently seen IP to make room; callers must hold l.mu
+func (l *ipRateLimiter) evictOldestLocked() {
+	var oldestIP string
+	var oldest time.Time
+	for ip, b := range l.buckets {
+		if oldestIP == "" || b.lastSeen.Before(oldest) {
+			oldestIP, oldest = ip, b.lastSeen
+		}
+	}
+	delete(l.buckets, oldestIP)
+}
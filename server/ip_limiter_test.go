@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestFrameRateLimitForwardedFor checks that the per-IP frame limit keys on the connection's address:
+// X-Forwarded-For only counts when it comes from a proxy in TRUSTED_PROXIES, and never from a client
+func TestFrameRateLimitForwardedFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		limited bool // whether requests with a different X-Forwarded-For each still run out of tokens
+	}{
+		{name: "no trusted proxies", limited: true},
+		{name: "untrusted proxy", proxies: []string{"10.0.0.0/8"}, limited: true},
+		{name: "trusted proxy", proxies: []string{"127.0.0.1"}, limited: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, 25, func(sm *StreamManager) {
+				sm.frameRateLimit = newIPRateLimiter(2)
+				sm.config.TrustedProxies = tt.proxies
+			})
+			ts.startStream(t, map[string]interface{}{
+				"stream_id": "limited",
+				"rtsp_url":  "rtsp://camera.example/limited",
+				"width":     16,
+				"height":    16,
+			})
+			waitFor(t, 5*time.Second, "frames", func() bool { return ts.stream(t, "limited").frameCount.Load() > 0 })
+
+			limited := 0
+			for i := 0; i < 10; i++ {
+				req, err := http.NewRequest(http.MethodGet, ts.srv.URL+"/api/streams/limited/frame", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// A fresh address on every request, as a client dodging the limit would send
+				req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i+1))
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusTooManyRequests {
+					limited++
+				}
+			}
+			if (limited > 0) != tt.limited {
+				t.Errorf("%d of 10 requests with spoofed X-Forwarded-For got 429, want limited %v", limited, tt.limited)
+			}
+
+			// The audit log records the same address the limit used
+			want := "127.0.0.1"
+			if !tt.limited {
+				want = "203.0.113.1"
+			}
+			req, _ := http.NewRequest(http.MethodDelete, ts.srv.URL+"/api/streams/limited", nil)
+			req.Header.Set("X-Forwarded-For", "203.0.113.1")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			for _, entry := range ts.sm.audit.query(time.Time{}, "limited") {
+				if entry.Type == "stream_stopped" && entry.RemoteAddr != want {
+					t.Errorf("audit remote_addr = %q, want %q", entry.RemoteAddr, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// parseMaxDuration validates a max_duration such as "2h"; empty means the stream runs until stopped
+func parseMaxDuration(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max_duration %q: %v", raw, err)
+	}
+	if d <= 0 || d > MaxStreamDuration {
+		return 0, fmt.Errorf("max_duration must be positive and at most %s", MaxStreamDuration)
+	}
+	return d, nil
+}
+
+// scheduleStop arms the stream's lifetime timer; StopStream cancels it when the stream is stopped first
+func (sm *StreamManager) scheduleStop(stream *Stream, d time.Duration) {
+	stream.expiresAt = time.Now().Add(d)
+	stream.stopTimer = time.AfterFunc(d, func() { sm.expireStream(stream) })
+}
+
+// expireStream stops a stream whose max_duration has elapsed, unless it was already stopped or replaced
+func (sm *StreamManager) expireStream(stream *Stream) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.streams[stream.streamID] != stream {
+		return
+	}
+
+	stream.events.publish(StreamEvent{
+		"type":       "expired",
+		"stream_id":  stream.streamID,
+		"expires_at": stream.expiresAt,
+	})
+	log.Printf("Stream %s reached its max_duration of %s", stream.streamID, stream.opts.MaxDuration)
+	sm.stopLocked(stream)
+}
+
+// expiresAtOrNil returns the scheduled stop time, or nil for streams without a max_duration
+func (s *Stream) expiresAtOrNil() interface{} {
+	if s.stopTimer == nil {
+		return nil
+	}
+	return s.expiresAt
+}
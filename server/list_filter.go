@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// streamListFields are the fields of a stream list entry, in the order fields= may name them
+var streamListFields = []string{"stream_id", "rtsp_url", "pixel_format", "status", "is_running", "paused", "client_count", "frame_count", "metadata"}
+
+// streamListFilter narrows and projects GET /api/streams results
+type streamListFilter struct {
+	tags    []string // every tag must match; see hasTag
+	status  string
+	running *bool
+	fields  []string // nil returns every field
+
+	// Pagination over the filtered, stream_id-ordered list; limit 0 returns everything from offset
+	limit  int
+	offset int
+}
+
+// parseStreamListFilter reads the tag, status, running and fields query parameters
+func parseStreamListFilter(query url.Values) (streamListFilter, error) {
+	f := streamListFilter{
+		tags:   query["tag"],
+		status: query.Get("status"),
+	}
+
+	if raw := query.Get("running"); raw != "" {
+		running, err := strconv.ParseBool(raw)
+		if err != nil {
+			return f, fmt.Errorf("running must be true or false")
+		}
+		f.running = &running
+	}
+
+	var err error
+	if f.limit, err = queryCount(query, "limit", MaxStreamListLimit); err != nil {
+		return f, err
+	}
+	if f.offset, err = queryCount(query, "offset", math.MaxInt32); err != nil {
+		return f, err
+	}
+
+	if raw := query.Get("fields"); raw != "" {
+		for _, field := range strings.Split(raw, ",") {
+			field = strings.TrimSpace(field)
+			if !containsString(streamListFields, field) {
+				return f, fmt.Errorf("unknown field %q (available: %s)", field, strings.Join(streamListFields, ", "))
+			}
+			f.fields = append(f.fields, field)
+		}
+	}
+	return f, nil
+}
+
+// queryCount parses an optional non-negative integer query parameter no larger than max
+func queryCount(query url.Values, name string, max int) (int, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 || v > max {
+		return 0, fmt.Errorf("%s must be an integer between 0 and %d", name, max)
+	}
+	return v, nil
+}
+
+// page returns the slice of a filtered list selected by offset and limit
+func (f streamListFilter) page(streams []map[string]interface{}) []map[string]interface{} {
+	if f.offset >= len(streams) {
+		return streams[:0]
+	}
+	streams = streams[f.offset:]
+	if f.limit > 0 && f.limit < len(streams) {
+		streams = streams[:f.limit]
+	}
+	return streams
+}
+
+// match reports whether a stream list entry passes every filter
+func (f streamListFilter) match(info map[string]interface{}) bool {
+	if f.status != "" && info["status"] != f.status {
+		return false
+	}
+	if f.running != nil && info["is_running"] != *f.running {
+		return false
+	}
+	metadata, _ := info["metadata"].(map[string]interface{})
+	for _, tag := range f.tags {
+		if !hasTag(metadata, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// project keeps only the requested fields of a stream list entry
+func (f streamListFilter) project(info map[string]interface{}) map[string]interface{} {
+	if f.fields == nil {
+		return info
+	}
+	projected := make(map[string]interface{}, len(f.fields))
+	for _, field := range f.fields {
+		projected[field] = info[field]
+	}
+	return projected
+}
+
+// hasTag reports whether a stream's metadata carries a tag. "key=value" matches a metadata label,
+// e.g. group=lobby; a bare tag matches an entry of the metadata "tags" list.
+func hasTag(metadata map[string]interface{}, tag string) bool {
+	if key, value, ok := strings.Cut(tag, "="); ok {
+		label, exists := metadata[key]
+		return exists && fmt.Sprint(label) == value
+	}
+
+	tags, _ := metadata["tags"].([]interface{})
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
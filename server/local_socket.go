@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LocalFrameMagic starts every frame header on a stream's local socket
+const LocalFrameMagic = "RSF1"
+
+// LocalFrameHeaderSize is the size of the header before each raw frame on a local socket:
+// magic [4]byte, payload length uint32, seq uint64, timestamp (unix nanos) int64, width uint32,
+// height uint32, all little-endian
+const LocalFrameHeaderSize = 32
+
+// maxUnixSocketPath is the longest socket path every supported platform accepts (sun_path is 104
+// bytes on macOS/BSD and 108 on Linux, including the terminating NUL)
+const maxUnixSocketPath = 103
+
+// localSocket publishes a stream's frames on a Unix domain socket for co-located consumers, which
+// avoids the WebSocket framing and HTTP overhead. Each consumer gets every frame unless it falls
+// LocalSocketBufferSize frames behind, in which case frames are dropped for it.
+type localSocket struct {
+	path     string
+	listener net.Listener
+	stream   *Stream
+
+	mu        sync.Mutex
+	consumers map[*localConsumer]struct{}
+	closed    bool
+
+	dropped atomic.Uint64
+}
+
+// localConsumer is one connection to a local socket
+type localConsumer struct {
+	conn   net.Conn
+	frames chan *Frame
+}
+
+// localHello is the JSON line sent to each consumer on connect, before any frame
+type localHello struct {
+	StreamID    string `json:"stream_id"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	PixelFormat string `json:"pixel_format"`
+	FrameSize   int    `json:"frame_size"`
+	HeaderSize  int    `json:"header_size"`
+}
+
+// resolveLocalSocketPath places a requested socket path inside dir, rejecting paths that escape it
+func resolveLocalSocketPath(dir, requested string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("%w: LOCAL_SOCKET_DIR is not configured", ErrLocalSocket)
+	}
+	path := requested
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: local_socket_path must be inside %s", ErrLocalSocket, dir)
+	}
+	if len(path) > maxUnixSocketPath {
+		return "", fmt.Errorf("%w: socket path %s is longer than %d bytes", ErrLocalSocket, path, maxUnixSocketPath)
+	}
+	return path, nil
+}
+
+// openLocalSocket listens on path, replacing a stale socket left by a previous run but never
+// another kind of file; callers must hold sm.mu so two streams can't claim the same path
+func (sm *StreamManager) openLocalSocket(stream *Stream, path string) (*localSocket, error) {
+	for id, other := range sm.streams {
+		if other.local != nil && other.local.path == path {
+			return nil, fmt.Errorf("%w: %s is already used by stream %s", ErrLocalSocket, path, id)
+		}
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%w: %s exists and is not a socket", ErrLocalSocket, path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLocalSocket, err)
+	}
+	// Owner and group only; consumers join the server's group to connect
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("%w: %v", ErrLocalSocket, err)
+	}
+
+	ls := &localSocket{
+		path:      path,
+		listener:  listener,
+		stream:    stream,
+		consumers: make(map[*localConsumer]struct{}),
+	}
+	go ls.acceptLoop()
+	log.Printf("Publishing frames for stream %s on %s", stream.streamID, path)
+	return ls, nil
+}
+
+// acceptLoop admits consumers until the socket is closed
+func (ls *localSocket) acceptLoop() {
+	for {
+		conn, err := ls.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Local socket %s accept error: %v", ls.path, err)
+			}
+			return
+		}
+
+		consumer := &localConsumer{conn: conn, frames: make(chan *Frame, LocalSocketBufferSize)}
+		ls.mu.Lock()
+		if ls.closed {
+			ls.mu.Unlock()
+			conn.Close()
+			return
+		}
+		ls.consumers[consumer] = struct{}{}
+		ls.mu.Unlock()
+
+		go ls.serve(consumer)
+	}
+}
+
+// serve writes the hello line and then every frame queued for a consumer until it disconnects
+func (ls *localSocket) serve(consumer *localConsumer) {
+	defer ls.remove(consumer)
+
+	hello, _ := json.Marshal(localHello{
+		StreamID:    ls.stream.streamID,
+		Width:       ls.stream.width,
+		Height:      ls.stream.height,
+		PixelFormat: ls.stream.pixelFormat,
+		FrameSize:   ls.stream.frameSize(),
+		HeaderSize:  LocalFrameHeaderSize,
+	})
+	consumer.conn.SetWriteDeadline(time.Now().Add(LocalSocketWriteTimeout))
+	if _, err := consumer.conn.Write(append(hello, '\n')); err != nil {
+		return
+	}
+
+	header := make([]byte, LocalFrameHeaderSize)
+	copy(header, LocalFrameMagic)
+	for frame := range consumer.frames {
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(frame.data)))
+		binary.LittleEndian.PutUint64(header[8:], uint64(frame.seq))
+		binary.LittleEndian.PutUint64(header[16:], uint64(frame.timestamp.UnixNano()))
+		binary.LittleEndian.PutUint32(header[24:], uint32(ls.stream.width))
+		binary.LittleEndian.PutUint32(header[28:], uint32(ls.stream.height))
+
+		// writev sends the header and the shared frame bytes without copying them into one buffer
+		buffers := net.Buffers{header, frame.data}
+		consumer.conn.SetWriteDeadline(time.Now().Add(LocalSocketWriteTimeout))
+		if _, err := buffers.WriteTo(consumer.conn); err != nil {
+			return
+		}
+	}
+}
+
+// remove disconnects a consumer; its frames channel is closed here and nowhere else
+func (ls *localSocket) remove(consumer *localConsumer) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if _, ok := ls.consumers[consumer]; !ok {
+		return
+	}
+	delete(ls.consumers, consumer)
+	close(consumer.frames)
+	consumer.conn.Close()
+}
+
+// publish queues a frame for every consumer, dropping it for consumers that are behind
+func (ls *localSocket) publish(frame *Frame) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for consumer := range ls.consumers {
+		select {
+		case consumer.frames <- frame:
+		default:
+			ls.dropped.Add(1)
+		}
+	}
+}
+
+// close stops accepting, disconnects every consumer and removes the socket file
+func (ls *localSocket) close() {
+	ls.mu.Lock()
+	if ls.closed {
+		ls.mu.Unlock()
+		return
+	}
+	ls.closed = true
+	for consumer := range ls.consumers {
+		delete(ls.consumers, consumer)
+		close(consumer.frames)
+		consumer.conn.Close()
+	}
+	ls.mu.Unlock()
+
+	ls.listener.Close()
+	os.Remove(ls.path)
+}
+
+// consumerCount returns the number of connected local socket consumers
+func (ls *localSocket) consumerCount() int {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return len(ls.consumers)
+}
+
+// statsOrNil reports the socket path, connected consumers and frames dropped for slow consumers,
+// or nil when the stream has no local socket
+func (ls *localSocket) statsOrNil() map[string]interface{} {
+	if ls == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"path":      ls.path,
+		"consumers": ls.consumerCount(),
+		"dropped":   ls.dropped.Load(),
+	}
+}
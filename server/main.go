@@ -2,90 +2,139 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"syscall"
-	"time"
-
-	"github.com/gin-gonic/gin"
 )
 
 // main initializes and starts the RTSP streaming server
 func main() {
-	// Check if FFmpeg is available
-	if err := exec.Command("ffmpeg", "-version").Run(); err != nil {
-		log.Fatal("FFmpeg is not installed or not in PATH. Please install FFmpeg to run this server.")
+	// The mock runner re-executes this binary in place of FFmpeg
+	if os.Getenv(MockFFmpegEnv) == "1" {
+		os.Exit(runMockFFmpeg(os.Args[1:]))
 	}
 
-	sm := NewStreamManager()
-
-	// Set up Gin router
-	r := gin.Default()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	runner, err := newCommandRunner(cfg)
+	if err != nil {
+		log.Fatalf("FFmpeg not found: %v. Install FFmpeg or set FFMPEG_PATH to its binary.", err)
+	}
+	binaries, err := newBinaryRunners(cfg, runner)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// CORS middleware
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type")
+	// Check if FFmpeg is available, keeping its version for /api/version
+	out, err := runner.CommandContext(context.Background(), "-version").Output()
+	if err != nil {
+		log.Fatalf("FFmpeg at %s failed to run: %v", runner.Path(), err)
+	}
+	ffmpegBanner, ffmpegVersion = parseFFmpegVersion(out)
+	log.Printf("RTSP Stream Server %s using %s", version, ffmpegBanner)
+	if cfg.FFmpegMock {
+		log.Printf("FFMPEG_MOCK is set: streams show a synthetic test pattern at %g fps instead of camera video", cfg.FFmpegMockFPS)
+	}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
+	sm := NewStreamManager(cfg, runner)
+	sm.binaries = binaries
+	go sm.watchFFmpeg()
+	if cfg.AuthKeysFile != "" {
+		if sm.auth, err = loadAuthStore(cfg.AuthKeysFile); err != nil {
+			log.Fatalf("Invalid auth configuration: %v", err)
 		}
-
-		c.Next()
-	})
-
-	// API routes
-	api := r.Group("/api")
-	{
-		api.POST("/streams", sm.handleStartStream)
-		api.POST("/streams/start-with-url", sm.handleStartStreamWithURL)
-		api.DELETE("/streams/:streamId", sm.handleStopStream)
-		api.DELETE("/streams/:streamId/force", sm.handleForceStopStream)
-		api.GET("/streams", sm.handleListStreams)
-		api.GET("/streams/:streamId/stats", sm.handleGetStreamStats)
-		api.GET("/streams/:streamId/frame", sm.handleGetFrame)
+	}
+	if cfg.WebhooksFile != "" {
+		if err := sm.webhooks.loadWebhooks(cfg.WebhooksFile); err != nil {
+			log.Fatalf("Invalid webhook configuration: %v", err)
+		}
+	}
+	if cfg.AdminUser != "" {
+		log.Printf("Control API requires Basic credentials for user %q", cfg.AdminUser)
+	}
+	if cfg.AuditLogFile != "" {
+		if err := sm.audit.openFile(cfg.AuditLogFile, int64(cfg.AuditLogMaxBytes)); err != nil {
+			log.Fatalf("Invalid audit log configuration: %v", err)
+		}
+		defer sm.audit.close()
 	}
 
-	// WebSocket route
-	r.GET("/ws/:streamId", sm.handleWebSocket)
-
-	// Static files for iframe viewer
-	r.Static("/static", "./")
-	r.GET("/viewer", func(c *gin.Context) {
-		c.File("./stream_viewer.html")
-	})
-
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":    "healthy",
-			"timestamp": time.Now().Unix(),
-		})
-	})
+	r := newRouter(sm)
 
 	// Graceful shutdown
 	srv := &http.Server{
-		Addr:    ":8091",
+		Addr:    cfg.ListenAddr,
 		Handler: r,
 	}
 
+	// Serve HTTPS/WSS when a certificate is configured; load it now so a bad cert fails fast
+	useTLS := cfg.TLSCertFile != ""
+	if useTLS {
+		certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	}
+
 	go func() {
-		log.Println("RTSP Stream Server starting on :8091")
+		if useTLS {
+			log.Printf("RTSP Stream Server starting on %s (TLS)", cfg.ListenAddr)
+		} else {
+			log.Printf("RTSP Stream Server starting on %s", cfg.ListenAddr)
+		}
 		log.Println("API endpoints:")
 		log.Println("  POST /api/streams - Start a new stream")
+		log.Println("  POST /api/streams/batch - Start several streams at once")
 		log.Println("  DELETE /api/streams/:streamId - Stop a stream (only if no clients)")
 		log.Println("  DELETE /api/streams/:streamId/force - Force stop a stream")
+		log.Println("  POST /api/streams/:streamId/signed-url - Issue an expiring signed WebSocket URL")
+		log.Println("  POST /api/streams/:streamId/pause - Pause ingest, keeping clients connected")
+		log.Println("  POST /api/streams/:streamId/resume - Resume a paused stream")
+		log.Println("  POST /api/streams/:streamId/keepalive - Reset a stream's idle timer")
+		log.Println("  POST /api/streams/:streamId/drain - Stop a stream once its current clients have left")
+		log.Println("  POST /api/streams/:streamId/clone - Derive a stream with other output settings from a stream's ingest")
+		log.Println("  PUT /api/streams/:streamId/ffmpeg-log-level - Change how much of a stream's FFmpeg output is logged")
+		log.Println("  GET /api/streams/:streamId/command - Show the FFmpeg command line of a stream, credentials masked")
 		log.Println("  GET /api/streams - List all streams")
 		log.Println("  GET /api/streams/:streamId/stats - Get stream statistics")
+		log.Println("  GET|POST /api/streams/stats - Get statistics for several streams at once")
 		log.Println("  GET /api/streams/:streamId/frame - Get latest frame (HTTP)")
-		log.Println("  WS /ws/:streamId - WebSocket connection for real-time frames")
-
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Println("  GET /api/streams/:streamId/format - Get frame geometry and pixel format")
+		log.Println("  GET /api/streams/:streamId/audio - Stream the audio track (chunked AAC)")
+		log.Println("  GET /api/streams/:streamId/ts - Stream the source as MPEG-TS (ffplay/VLC)")
+		log.Println("  GET /api/streams/:streamId/thumbnail.jpg - Get a small JPEG of the latest frame")
+		log.Println("  GET /api/snapshot-grid.jpg - Get a JPEG grid of the latest frame of every stream")
+		log.Println("  POST /api/streams/:streamId/clip - Download the last few seconds as an MP4")
+		log.Println("  GET /api/streams/:streamId/clients - List connected clients")
+		log.Println("  PATCH /api/streams/:streamId/clients/:clientId - Tune a client's buffer, frame rate or pause state")
+		log.Println("  GET /api/streams/:streamId/events - Stream events such as motion (SSE)")
+		log.Println("  GET /api/streams/:streamId/status - Get current stream status")
+		log.Println("  GET /api/streams/:streamId/status/stream - Live status updates (SSE)")
+		log.Println("  GET /api/streams/:streamId/wait-ready - Wait until a stream is delivering frames")
+		log.Println("  GET /api/events - Audit log of stream, client and FFmpeg lifecycle events")
+		log.Println("  GET|POST /api/webhooks - List or register webhooks for stream events")
+		log.Println("  DELETE /api/webhooks/:webhookId - Remove a webhook")
+		log.Println("  GET /api/version - Server, Go and FFmpeg versions")
+		log.Println("  POST /api/diagnostics/selftest - Run a synthetic stream end to end and report pass/fail")
+		log.Println("  GET /api/admin/resources - Goroutines, frame memory and FFmpeg RSS per stream")
+		log.Println("  GET|POST /api/discover - Discover ONVIF cameras on the local network")
+		log.Println("  WS /ws/:streamId - WebSocket connection for real-time frames (?on_motion=true for motion-gated delivery)")
+		log.Println("  GET /livez, /readyz - Liveness and readiness probes")
+
+		var err error
+		if useTLS {
+			// The certificate comes from TLSConfig.GetCertificate
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed to start: %v", err)
 		}
 	}()
@@ -95,19 +144,23 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
+	// Fail readiness first so load balancers stop routing new traffic, and release
+	// in-flight frame requests so the HTTP server can drain
+	sm.beginShutdown()
 
-	// Stop all streams
-	sm.mu.Lock()
-	for streamID := range sm.streams {
-		sm.StopStream(streamID)
-	}
-	sm.mu.Unlock()
-
-	// Shutdown server
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Drain HTTP while the streams stop: event streams and MPEG-TS responses only end with their stream
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+	httpDone := make(chan error, 1)
+	go func() {
+		httpDone <- srv.Shutdown(ctx)
+	}()
+
+	if err := sm.Shutdown(ctx); err != nil {
+		log.Printf("Streams not fully stopped within %s: %v", cfg.ShutdownTimeout, err)
+	}
+	if err := <-httpDone; err != nil {
+		log.Printf("Server forced to shutdown: %v", err)
 	}
 
 	log.Println("Server exited")
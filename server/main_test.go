@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// TestMain lets the test binary stand in for FFmpeg: the mock runner re-executes it with MockFFmpegEnv
+// set, exactly as it re-executes the server binary
+func TestMain(m *testing.M) {
+	if os.Getenv(MockFFmpegEnv) == "1" {
+		os.Exit(runMockFFmpeg(os.Args[1:]))
+	}
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	os.Exit(m.Run())
+}
+
+// testServer is a StreamManager running the mock FFmpeg behind the real routes
+type testServer struct {
+	sm  *StreamManager
+	srv *httptest.Server
+}
+
+// newTestServer starts a server with the default configuration and the mock FFmpeg at fps frames per
+// second. configure, if not nil, adjusts the manager before the server takes requests. The server is
+// shut down when the test ends, failing it if any FFmpeg process or goroutine outlives the shutdown.
+func newTestServer(t testing.TB, fps float64, configure func(sm *StreamManager)) *testServer {
+	t.Helper()
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable: %v", err)
+	}
+	cfg.FFmpegMock, cfg.FFmpegMockFPS = true, fps
+
+	sm := NewStreamManager(cfg, mockRunner{self: self, fps: fps})
+	if configure != nil {
+		configure(sm)
+	}
+	ts := &testServer{sm: sm, srv: httptest.NewServer(newRouter(sm))}
+	t.Cleanup(func() {
+		ts.shutdown(t)
+		ts.srv.Close()
+	})
+	return ts
+}
+
+// shutdown stops every stream as the server does on SIGTERM, failing the test if anything is left running
+func (ts *testServer) shutdown(t testing.TB) {
+	t.Helper()
+	ts.sm.beginShutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ts.sm.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v (still running: %s)", err, strings.Join(ts.sm.tasks.pending(), "; "))
+	}
+}
+
+// do sends a JSON request and decodes the JSON response into out, if not nil, returning the status code
+func (ts *testServer) do(t testing.TB, method, path string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal %s %s: %v", method, path, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.srv.URL+path, reader)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// get sends a GET request with the given Accept header, if not empty, and returns the response with its body
+func (ts *testServer) get(t testing.TB, path, accept string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, ts.srv.URL+path, nil)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: read body: %v", path, err)
+	}
+	return resp, body
+}
+
+// startStream starts a stream through POST /api/streams, failing the test unless it is accepted
+func (ts *testServer) startStream(t testing.TB, req map[string]interface{}) {
+	t.Helper()
+	var resp map[string]interface{}
+	if status := ts.do(t, http.MethodPost, "/api/streams", req, &resp); status != http.StatusOK {
+		t.Fatalf("start stream %v: status %d: %v", req["stream_id"], status, resp)
+	}
+}
+
+// status returns the stream's status snapshot from GET /api/streams/:streamId/status
+func (ts *testServer) status(t testing.TB, streamID string) map[string]interface{} {
+	t.Helper()
+	var resp map[string]interface{}
+	if status := ts.do(t, http.MethodGet, "/api/streams/"+streamID+"/status", nil, &resp); status != http.StatusOK {
+		t.Fatalf("status of stream %s: status %d: %v", streamID, status, resp)
+	}
+	return resp
+}
+
+// stream returns the registered stream with the given ID, failing the test if there is none
+func (ts *testServer) stream(t testing.TB, streamID string) *Stream {
+	t.Helper()
+	ts.sm.mu.RLock()
+	defer ts.sm.mu.RUnlock()
+	stream, exists := ts.sm.streams[streamID]
+	if !exists {
+		t.Fatalf("stream %s is not registered", streamID)
+	}
+	return stream
+}
+
+// dial opens a WebSocket to the stream, with query appended to the URL when not empty
+func (ts *testServer) dial(t testing.TB, streamID, query string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.srv.URL, "http") + "/ws/" + streamID
+	if query != "" {
+		url += "?" + query
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+// readFrame returns the next binary message of a WebSocket, skipping control text messages
+func readFrame(t testing.TB, conn *websocket.Conn, timeout time.Duration) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read frame: %v", err)
+		}
+		if messageType == websocket.BinaryMessage {
+			return data
+		}
+	}
+}
+
+// mockFrameNumber recovers n, mod 256, of a mock frame from its first pixel
+func mockFrameNumber(frame []byte, pixelFormat string) int {
+	if pixelFormat == "bgr24" {
+		return int(frame[2])
+	}
+	return int(frame[0])
+}
+
+// checkMockFrame fails the test unless frame is exactly one frame of the mock test pattern
+func checkMockFrame(t testing.TB, frame []byte, width, height int, pixelFormat string) {
+	t.Helper()
+	want := mockFrame(mockFrameNumber(frame, pixelFormat), width, height, pixelFormat)
+	if !bytes.Equal(frame, want) {
+		t.Fatalf("%s frame of %d bytes does not match the mock pattern (want %d bytes)", pixelFormat, len(frame), len(want))
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after timeout
+func waitFor(t testing.TB, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %s waiting for %s", timeout, what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// shortenHealthChecks makes the health monitor restart a stream after stall without frames
+func shortenHealthChecks(stall time.Duration) func(sm *StreamManager) {
+	return func(sm *StreamManager) {
+		sm.healthInterval, sm.stallTimeout = stall/4, stall
+	}
+}
+
+func TestMockStreamEndToEnd(t *testing.T) {
+	for _, pixelFormat := range []string{"bgr24", "rgb24", "gray", "yuv420p"} {
+		pixelFormat := pixelFormat
+		t.Run(pixelFormat, func(t *testing.T) {
+			t.Parallel()
+			ts := newTestServer(t, 25, nil)
+			ts.startStream(t, map[string]interface{}{
+				"stream_id":    "e2e",
+				"rtsp_url":     "rtsp://camera.example/e2e",
+				"width":        64,
+				"height":       48,
+				"pixel_format": pixelFormat,
+			})
+
+			conn, resp, err := ts.dial(t, "e2e", "")
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			if got, want := resp.Header.Get("Sec-WebSocket-Protocol"), ""; got != want {
+				t.Errorf("subprotocol = %q without an offer, want none", got)
+			}
+			if got, want := resp.Header.Get("X-Frame-Format"), fmt.Sprintf("rtsp-%s-64x48", pixelFormat); got != want {
+				t.Errorf("X-Frame-Format = %q, want %q", got, want)
+			}
+
+			seen := make(map[int]bool)
+			for i := 0; i < 5; i++ {
+				frame := readFrame(t, conn, 5*time.Second)
+				checkMockFrame(t, frame, 64, 48, pixelFormat)
+				seen[mockFrameNumber(frame, pixelFormat)] = true
+			}
+			if len(seen) < 2 {
+				t.Errorf("received 5 frames but only %d distinct frame numbers", len(seen))
+			}
+			if status := ts.status(t, "e2e"); status["status"] != StatusRunning || status["is_running"] != true {
+				t.Errorf("status = %v, is_running = %v; want running", status["status"], status["is_running"])
+			}
+		})
+	}
+}
+
+func TestMockStreamHealthRestart(t *testing.T) {
+	ts := newTestServer(t, 25, shortenHealthChecks(300*time.Millisecond))
+	ts.startStream(t, map[string]interface{}{
+		"stream_id": "stall",
+		"rtsp_url":  "rtsp://camera.example/mock-stall",
+		"width":     32,
+		"height":    24,
+	})
+	conn, _, err := ts.dial(t, "stall", "")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	stream := ts.stream(t, "stall")
+	checkMockFrame(t, readFrame(t, conn, 5*time.Second), 32, 24, "bgr24")
+	firstPID := stream.ffmpegPID.Load()
+
+	// The mock stops sending after a second; the health monitor must replace it with a fresh FFmpeg,
+	// whose frames reach the still-connected client
+	waitFor(t, 10*time.Second, "a restarted FFmpeg", func() bool {
+		pid := stream.ffmpegPID.Load()
+		return pid != 0 && pid != firstPID
+	})
+	for {
+		frame := readFrame(t, conn, 5*time.Second)
+		checkMockFrame(t, frame, 32, 24, "bgr24")
+		if mockFrameNumber(frame, "bgr24") == 0 {
+			break // the first frame of the restarted mock
+		}
+	}
+
+	var events []AuditEntry
+	for _, entry := range ts.sm.audit.query(time.Time{}, "stall") {
+		if entry.Type == "ffmpeg_restart" {
+			events = append(events, entry)
+		}
+	}
+	if len(events) == 0 || events[0].Details["reason"] != "stalled" {
+		t.Errorf("audit log has no stall restart: %+v", events)
+	}
+
+	// Shutting down closes the client and stops the restarted FFmpeg; the cleanup checks nothing is left
+	ts.shutdown(t)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				t.Errorf("connection closed with %v, want going away", err)
+			}
+			break
+		}
+	}
+	ts.sm.mu.RLock()
+	defer ts.sm.mu.RUnlock()
+	if len(ts.sm.streams) != 0 {
+		t.Errorf("%d stream(s) still registered after shutdown", len(ts.sm.streams))
+	}
+}
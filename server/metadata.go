@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// validateMetadata bounds the free-form labels attached to a stream by their encoded JSON size
+func validateMetadata(metadata map[string]interface{}) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("metadata: %v", err)
+	}
+	if len(encoded) > MaxMetadataBytes {
+		return fmt.Errorf("metadata must encode to at most %d bytes of JSON, got %d", MaxMetadataBytes, len(encoded))
+	}
+	return nil
+}
+
+// metadataOrEmpty returns the stream's metadata, substituting an empty object so responses never contain null
+func (s *Stream) metadataOrEmpty() map[string]interface{} {
+	if s.metadata == nil {
+		return map[string]interface{}{}
+	}
+	return s.metadata
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// motionDetector compares consecutive downsampled frames and reports motion events.
+// It runs in its own goroutine and is fed through a tiny channel so the ingest loop never waits on it.
+type motionDetector struct {
+	frames     chan *Frame
+	threshold  float64
+	sampleStep int
+	lastEvent  time.Time
+	prev       []byte
+
+	// lastMotion is the unix-nanosecond timestamp of the latest frame that scored above the threshold
+	lastMotion atomic.Int64
+}
+
+// newMotionDetector creates a detector using the stream's motion options
+func newMotionDetector(opts StreamOptions) *motionDetector {
+	return &motionDetector{
+		frames:     make(chan *Frame, 1),
+		threshold:  opts.MotionThreshold,
+		sampleStep: opts.MotionSampleStep,
+	}
+}
+
+// offer hands a frame to the detector, dropping it if the detector is still busy
+func (md *motionDetector) offer(frame *Frame) {
+	select {
+	case md.frames <- frame:
+	default:
+	}
+}
+
+// run analyzes frames until the stream stops, publishing motion events on the stream's event hub
+func (md *motionDetector) run(stream *Stream) {
+	for {
+		select {
+		case <-stream.healthStopChan:
+			return
+		case frame := <-md.frames:
+			score, ok := md.score(frame.data, stream.width, stream.height, stream.pixelFormat)
+			if !ok || score < md.threshold {
+				continue
+			}
+			md.lastMotion.Store(frame.timestamp.UnixNano())
+			if time.Since(md.lastEvent) < MotionEventInterval {
+				continue
+			}
+			md.lastEvent = time.Now()
+			stream.events.publish(StreamEvent{
+				"type":      "motion",
+				"stream_id": stream.streamID,
+				"score":     score,
+			})
+		}
+	}
+}
+
+// score returns the mean absolute luma difference between this frame and the previous one.
+// Only every sampleStep-th pixel in each direction is compared. The second result is false
+// for the first frame, when there is nothing to compare against yet.
+func (md *motionDetector) score(frame []byte, width, height int, pixelFormat string) (float64, bool) {
+	sampled := sampleLuma(frame, width, height, pixelFormat, md.sampleStep)
+	prev := md.prev
+	md.prev = sampled
+
+	if len(prev) != len(sampled) || len(sampled) == 0 {
+		return 0, false
+	}
+
+	var total int
+	for i := range sampled {
+		diff := int(sampled[i]) - int(prev[i])
+		if diff < 0 {
+			diff = -diff
+		}
+		total += diff
+	}
+	return float64(total) / float64(len(sampled)), true
+}
+
+// sampleLuma extracts an approximate luma value for a grid of pixels spaced step apart
+func sampleLuma(frame []byte, width, height int, pixelFormat string, step int) []byte {
+	samples := make([]byte, 0, (width/step+1)*(height/step+1))
+	for y := 0; y < height; y += step {
+		for x := 0; x < width; x += step {
+			switch pixelFormat {
+			case "bgr24", "rgb24":
+				i := (y*width + x) * 3
+				// Channel order doesn't matter much for change detection; weight the middle (green) channel
+				samples = append(samples, byte((int(frame[i])+2*int(frame[i+1])+int(frame[i+2]))/4))
+			default:
+				// gray and yuv420p both start with a full-resolution luma plane
+				samples = append(samples, frame[y*width+x])
+			}
+		}
+	}
+	return samples
+}
+
+// validateMotionOptions applies defaults to the motion detection options and checks their ranges
+func validateMotionOptions(o *StreamOptions) error {
+	if !o.MotionDetection {
+		return nil
+	}
+	if o.MotionThreshold == 0 {
+		o.MotionThreshold = DefaultMotionThreshold
+	}
+	if o.MotionSampleStep == 0 {
+		o.MotionSampleStep = DefaultMotionSampleStep
+	}
+	if o.MotionThreshold < 0 || o.MotionThreshold > 255 {
+		return fmt.Errorf("motion_threshold must be between 0 and 255, got %v", o.MotionThreshold)
+	}
+	if o.MotionSampleStep < 1 {
+		return fmt.Errorf("motion_sample_step must be at least 1, got %d", o.MotionSampleStep)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// motionActiveAt reports whether motion was detected within MotionPostRoll before t
+func (md *motionDetector) motionActiveAt(t time.Time) bool {
+	last := md.lastMotion.Load()
+	return last != 0 && t.Sub(time.Unix(0, last)) <= MotionPostRoll
+}
+
+// onMotionQuery parses the on_motion query parameter; gating needs the stream's motion detector
+func (s *Stream) onMotionQuery(query url.Values) (bool, error) {
+	raw := query.Get("on_motion")
+	if raw == "" {
+		return false, nil
+	}
+	onMotion, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid on_motion: %v", err)
+	}
+	if onMotion && s.motion == nil {
+		return false, fmt.Errorf("on_motion requires a stream started with motion_detection")
+	}
+	return onMotion, nil
+}
+
+// motionGateLocked decides whether an on_motion client gets a frame. On the transition into motion it
+// queues a motion start message and the pre-roll, and on the way out a motion stop message. Callers
+// must hold c.mu.
+func (c *Client) motionGateLocked(s *Stream, frame *Frame) bool {
+	active := s.motion.motionActiveAt(frame.timestamp)
+	switch {
+	case active && !c.motionActive:
+		c.motionActive = true
+		c.queueLocked(motionControl("start", frame.timestamp))
+		// Leave room for the triggering frame; the pre-roll never evicts queued frames
+		room := cap(c.send) - len(c.send) - 1
+		for _, preRoll := range s.frameCache.preRoll(frame, MotionPreRoll, room) {
+			c.queueLocked(preRoll)
+		}
+	case !active && c.motionActive:
+		c.motionActive = false
+		c.queueLocked(motionControl("stop", frame.timestamp))
+	}
+	return active
+}
+
+// queueLocked hands a frame or control message to writePump without blocking; callers must hold c.mu
+func (c *Client) queueLocked(frame *Frame) {
+	select {
+	case c.send <- frame:
+	default:
+		c.framesSkipped.Add(1)
+	}
+}
+
+// motionControl builds the text message announcing a motion start or stop to an on_motion client
+func motionControl(state string, at time.Time) *Frame {
+	msg, _ := json.Marshal(map[string]interface{}{
+		"type":      "motion",
+		"state":     state,
+		"timestamp": at.UnixMilli(),
+	})
+	return &Frame{control: msg, timestamp: at}
+}
+
+// preRoll returns up to max cached frames from the span before frame, oldest first
+func (fc *frameCache) preRoll(frame *Frame, span time.Duration, max int) []*Frame {
+	if max <= 0 {
+		return nil
+	}
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	from := frame.timestamp.Add(-span)
+	frames := make([]*Frame, 0, max)
+	for i := fc.count - 1; i >= 0 && len(frames) < max; i-- {
+		cached := fc.at(i)
+		if cached.seq >= frame.seq {
+			continue
+		}
+		if cached.timestamp.Before(from) {
+			break
+		}
+		frames = append(frames, cached)
+	}
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wsDiscoveryAddr is the WS-Discovery multicast group and port ONVIF devices listen on
+const wsDiscoveryAddr = "239.255.255.250:3702"
+
+// probeTemplate is a WS-Discovery Probe for ONVIF network video transmitters; %s is the message UUID
+const probeTemplate = `<?xml version="1.0" encoding="UTF-8"?>` +
+	`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing" ` +
+	`xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+	`<e:Header><w:MessageID>uuid:%s</w:MessageID>` +
+	`<w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To>` +
+	`<w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action></e:Header>` +
+	`<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body></e:Envelope>`
+
+// DiscoveredCamera is an ONVIF device that answered the discovery probe
+type DiscoveredCamera struct {
+	Endpoint         string           `json:"endpoint"`
+	DeviceServiceURL string           `json:"device_service_url"`
+	XAddrs           []string         `json:"xaddrs"`
+	Name             string           `json:"name,omitempty"`
+	Hardware         string           `json:"hardware,omitempty"`
+	Location         string           `json:"location,omitempty"`
+	StreamURIs       []ONVIFStreamURI `json:"stream_uris,omitempty"`
+	Error            string           `json:"error,omitempty"`
+}
+
+// ONVIFStreamURI is the RTSP URI of one media profile of a camera
+type ONVIFStreamURI struct {
+	ProfileToken string `json:"profile_token"`
+	ProfileName  string `json:"profile_name,omitempty"`
+	URI          string `json:"uri"`
+}
+
+// probeMatches is the subset of a WS-Discovery ProbeMatches reply we use
+type probeMatches struct {
+	Matches []struct {
+		Address string `xml:"EndpointReference>Address"`
+		Scopes  string `xml:"Scopes"`
+		XAddrs  string `xml:"XAddrs"`
+	} `xml:"Body>ProbeMatches>ProbeMatch"`
+}
+
+// discoverONVIF multicasts a WS-Discovery probe and collects replies until the timeout. It returns an
+// error when the probe can't be sent at all, e.g. because multicast is blocked on this host.
+func discoverONVIF(timeout time.Duration) ([]*DiscoveredCamera, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %v", err)
+	}
+	defer conn.Close()
+
+	group, err := net.ResolveUDPAddr("udp4", wsDiscoveryAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", wsDiscoveryAddr, err)
+	}
+
+	messageID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP([]byte(fmt.Sprintf(probeTemplate, messageID)), group); err != nil {
+		return nil, fmt.Errorf("failed to send discovery probe: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	cameras := make([]*DiscoveredCamera, 0)
+	seen := make(map[string]bool)
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The read deadline ends collection
+			break
+		}
+
+		var reply probeMatches
+		if err := xml.Unmarshal(buf[:n], &reply); err != nil {
+			continue
+		}
+		for _, match := range reply.Matches {
+			xaddrs := strings.Fields(match.XAddrs)
+			key := strings.TrimSpace(match.Address)
+			if key == "" && len(xaddrs) > 0 {
+				key = xaddrs[0]
+			}
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			camera := &DiscoveredCamera{Endpoint: key, XAddrs: xaddrs}
+			if len(xaddrs) > 0 {
+				camera.DeviceServiceURL = xaddrs[0]
+			}
+			camera.Name, camera.Hardware, camera.Location = parseScopes(match.Scopes)
+			cameras = append(cameras, camera)
+		}
+	}
+	return cameras, nil
+}
+
+// parseScopes extracts the name, hardware and location from ONVIF scope URIs
+func parseScopes(scopes string) (name, hardware, location string) {
+	for _, scope := range strings.Fields(scopes) {
+		const prefix = "onvif://www.onvif.org/"
+		if !strings.HasPrefix(scope, prefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(scope, prefix), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := url.PathUnescape(parts[1])
+		if err != nil {
+			value = parts[1]
+		}
+		switch parts[0] {
+		case "name":
+			name = value
+		case "hardware":
+			hardware = value
+		case "location":
+			location = value
+		}
+	}
+	return name, hardware, location
+}
+
+// resolveStreamURIs asks a camera's media service for the RTSP URI of each of its profiles
+func resolveStreamURIs(ctx context.Context, deviceURL, username, password string) ([]ONVIFStreamURI, error) {
+	var caps struct {
+		MediaXAddr string `xml:"Body>GetCapabilitiesResponse>Capabilities>Media>XAddr"`
+	}
+	err := onvifCall(ctx, deviceURL, username, password,
+		`<GetCapabilities xmlns="http://www.onvif.org/ver10/device/wsdl"><Category>Media</Category></GetCapabilities>`, &caps)
+	if err != nil {
+		return nil, fmt.Errorf("GetCapabilities: %v", err)
+	}
+	if caps.MediaXAddr == "" {
+		return nil, fmt.Errorf("device does not advertise a media service")
+	}
+
+	var profiles struct {
+		Profiles []struct {
+			Token string `xml:"token,attr"`
+			Name  string `xml:"Name"`
+		} `xml:"Body>GetProfilesResponse>Profiles"`
+	}
+	err = onvifCall(ctx, caps.MediaXAddr, username, password,
+		`<GetProfiles xmlns="http://www.onvif.org/ver10/media/wsdl"/>`, &profiles)
+	if err != nil {
+		return nil, fmt.Errorf("GetProfiles: %v", err)
+	}
+
+	uris := make([]ONVIFStreamURI, 0, len(profiles.Profiles))
+	for _, profile := range profiles.Profiles {
+		var streamURI struct {
+			URI string `xml:"Body>GetStreamUriResponse>MediaUri>Uri"`
+		}
+		body := `<GetStreamUri xmlns="http://www.onvif.org/ver10/media/wsdl"><StreamSetup>` +
+			`<Stream xmlns="http://www.onvif.org/ver10/schema">RTP-Unicast</Stream>` +
+			`<Transport xmlns="http://www.onvif.org/ver10/schema"><Protocol>RTSP</Protocol></Transport>` +
+			`</StreamSetup><ProfileToken>` + xmlEscape(profile.Token) + `</ProfileToken></GetStreamUri>`
+		if err := onvifCall(ctx, caps.MediaXAddr, username, password, body, &streamURI); err != nil {
+			return uris, fmt.Errorf("GetStreamUri(%s): %v", profile.Token, err)
+		}
+		uris = append(uris, ONVIFStreamURI{ProfileToken: profile.Token, ProfileName: profile.Name, URI: streamURI.URI})
+	}
+	return uris, nil
+}
+
+// onvifCall posts a SOAP request, authenticated with a WS-Security UsernameToken when a username
+// is given, and decodes the response envelope into out
+func onvifCall(ctx context.Context, endpoint, username, password, body string, out interface{}) error {
+	header := ""
+	if username != "" {
+		var err error
+		if header, err = usernameToken(username, password); err != nil {
+			return err
+		}
+	}
+	envelope := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Header>` + header + `</s:Header>` +
+		`<s:Body>` + body + `</s:Body></s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// usernameToken builds a WS-Security header with a PasswordDigest, as required by most ONVIF cameras
+func usernameToken(username, password string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(password))
+	digest := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	return `<Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+		`<UsernameToken><Username>` + xmlEscape(username) + `</Username>` +
+		`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` + digest + `</Password>` +
+		`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` +
+		base64.StdEncoding.EncodeToString(nonce) + `</Nonce>` +
+		`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` + created + `</Created>` +
+		`</UsernameToken></Security>`, nil
+}
+
+// xmlEscape escapes text for inclusion in an XML element
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// handleDiscover finds ONVIF cameras on the local network. GET probes only; POST additionally
+// accepts {"username", "password"} and resolves each camera's RTSP stream URIs.
+func (sm *StreamManager) handleDiscover(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondInvalidRequest(c, err)
+			return
+		}
+	}
+
+	timeout := sm.config.DiscoveryTimeout
+	if raw := c.Query("timeout"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 || parsed > MaxDiscoveryTimeout {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("timeout must be a positive duration up to %s", MaxDiscoveryTimeout), nil)
+			return
+		}
+		timeout = parsed
+	}
+
+	cameras, err := discoverONVIF(timeout)
+	if err != nil {
+		// Multicast is commonly blocked in containers and cloud networks; that's not a server error
+		log.Printf("ONVIF discovery failed: %v", err)
+		c.JSON(http.StatusOK, gin.H{
+			"cameras": []*DiscoveredCamera{},
+			"note":    fmt.Sprintf("discovery probe could not be sent (%v); multicast may be blocked on this network", err),
+		})
+		return
+	}
+
+	if c.Request.Method == http.MethodPost && req.Username != "" {
+		var wg sync.WaitGroup
+		for _, camera := range cameras {
+			if camera.DeviceServiceURL == "" {
+				continue
+			}
+			wg.Add(1)
+			go func(camera *DiscoveredCamera) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(c.Request.Context(), ONVIFRequestTimeout)
+				defer cancel()
+
+				uris, err := resolveStreamURIs(ctx, camera.DeviceServiceURL, req.Username, req.Password)
+				camera.StreamURIs = uris
+				if err != nil {
+					camera.Error = err.Error()
+				}
+			}(camera)
+		}
+		wg.Wait()
+	}
+
+	response := gin.H{"cameras": cameras}
+	if len(cameras) == 0 {
+		response["note"] = "no cameras answered the probe; multicast may be blocked on this network"
+	}
+	c.JSON(http.StatusOK, response)
+}
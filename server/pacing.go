@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// jitterMeter tracks the gaps between frames leaving a stream's buffer: a smoothed gap and, as in
+// RFC 3550, a smoothed difference between consecutive gaps. A camera that delivers in bursts shows a
+// jitter close to its frame interval; paced output shows almost none.
+type jitterMeter struct {
+	mu       sync.Mutex
+	last     time.Time
+	lastGap  time.Duration
+	interval time.Duration // smoothed gap between frames
+	jitter   time.Duration // smoothed |gap - previous gap|
+}
+
+// mark records a frame released at now
+func (m *jitterMeter) mark(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.last.IsZero() {
+		gap := now.Sub(m.last)
+		if m.interval == 0 {
+			m.interval = gap
+		} else {
+			m.interval += time.Duration(FPSSmoothing * float64(gap-m.interval))
+			diff := gap - m.lastGap
+			if diff < 0 {
+				diff = -diff
+			}
+			m.jitter += (diff - m.jitter) / JitterSmoothingDivisor
+		}
+		m.lastGap = gap
+	}
+	m.last = now
+}
+
+// info reports the smoothed output interval and jitter in milliseconds
+func (m *jitterMeter) info() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return map[string]interface{}{
+		"output_interval_ms": float64(m.interval) / float64(time.Millisecond),
+		"jitter_ms":          float64(m.jitter) / float64(time.Millisecond),
+	}
+}
+
+// validatePacing checks the pacing options of a stream
+func validatePacing(o *StreamOptions) error {
+	if o.PacingFPS < 0 || o.PacingFPS > MaxClientTargetFPS {
+		return fmt.Errorf("pacing_fps must be between 0 (the source frame rate) and %v", MaxClientTargetFPS)
+	}
+	if o.PacingFPS > 0 && !o.Pacing {
+		return fmt.Errorf("pacing_fps requires pacing")
+	}
+	return nil
+}
+
+// pacingInterval is the steady gap paced output releases frames at: pacing_fps when set, else the
+// frame rate FFmpeg reports for the source, else the measured ingest rate, never faster than
+// maxIngestFPS allows. It is 0 while no rate is known, releasing frames as they come.
+func (s *Stream) pacingInterval(maxIngestFPS float64) time.Duration {
+	fps := s.opts.PacingFPS
+	if fps == 0 {
+		s.mu.RLock()
+		fps = s.source.FPS
+		s.mu.RUnlock()
+	}
+	if fps == 0 {
+		fps = s.currentFPS.rate(time.Now())
+	}
+	if maxIngestFPS > 0 && (fps == 0 || fps > maxIngestFPS) {
+		fps = maxIngestFPS
+	}
+	if fps <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / fps)
+}
+
+// distributePaced is distributeFrames for streams started with pacing: frames leave the buffer at a
+// steady interval instead of as they arrive, so a burst after a network hiccup plays out smoothly. This
+// holds each frame back by up to one interval. When more than PacingMaxBacklog frames are waiting, the
+// source is outpacing the interval and frames are released straight away until the backlog is gone.
+func (sm *StreamManager) distributePaced(stream *Stream) {
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+
+	var next time.Time
+	for {
+		select {
+		case <-stream.healthStopChan:
+			return
+		case frame := <-stream.frameBuffer:
+			interval := stream.pacingInterval(sm.config.MaxIngestFPS)
+			if wait := time.Until(next); interval > 0 && wait > 0 && len(stream.frameBuffer) < PacingMaxBacklog {
+				timer.Reset(wait)
+				select {
+				case <-stream.healthStopChan:
+					return
+				case <-timer.C:
+				}
+			}
+
+			now := time.Now()
+			stream.broadcast(frame)
+			stream.outputJitter.mark(now)
+
+			// A frame that arrived late starts a fresh schedule: squeezing the next one in early to keep the
+			// old cadence would make two uneven gaps instead of one
+			if next.Before(now) {
+				next = now
+			}
+			next = next.Add(interval)
+		}
+	}
+}
+
+// pacingInfo reports a stream's pacing settings and the measured output interval and jitter, which
+// are tracked whether or not pacing is on so the two can be compared
+func (s *Stream) pacingInfo(maxIngestFPS float64) map[string]interface{} {
+	info := s.outputJitter.info()
+	info["enabled"] = s.opts.Pacing
+	if s.opts.Pacing {
+		info["interval_ms"] = float64(s.pacingInterval(maxIngestFPS)) / float64(time.Millisecond)
+	}
+	return info
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// placeholderCache holds generated "NO SIGNAL" frames keyed by resolution and pixel format
+var placeholderCache = struct {
+	mu     sync.Mutex
+	frames map[string][]byte
+}{frames: make(map[string][]byte)}
+
+// placeholderFrame returns a cached "NO SIGNAL" frame for the given geometry, generating it on first use
+func placeholderFrame(width, height int, pixelFormat string) []byte {
+	key := fmt.Sprintf("%dx%d/%s", width, height, pixelFormat)
+
+	placeholderCache.mu.Lock()
+	defer placeholderCache.mu.Unlock()
+
+	if frame, ok := placeholderCache.frames[key]; ok {
+		return frame
+	}
+	frame := renderPlaceholder(width, height, pixelFormat)
+	placeholderCache.frames[key] = frame
+	return frame
+}
+
+// renderPlaceholder draws white "NO SIGNAL" text centered on a dark gray background
+func renderPlaceholder(width, height int, pixelFormat string) []byte {
+	const text = "NO SIGNAL"
+
+	rgb := make([]byte, width*height*3)
+	for i := range rgb {
+		rgb[i] = 32
+	}
+
+	// Scale the text to roughly a third of the frame width
+	scale := width / (textWidth(text, 1) * 3)
+	if scale < 1 {
+		scale = 1
+	}
+	x0 := (width - textWidth(text, scale)) / 2
+	y0 := (height - glyphHeight*scale) / 2
+	drawText(text, x0, y0, scale, func(x, y int) {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return
+		}
+		i := (y*width + x) * 3
+		rgb[i], rgb[i+1], rgb[i+2] = 255, 255, 255
+	})
+
+	return encodeRGB(rgb, width, height, pixelFormat)
+}
+
+// deliverPlaceholder sends the placeholder frame to the stream's clients at a low rate
+// until real frames arrive again or the stream is stopped
+func (sm *StreamManager) deliverPlaceholder(stream *Stream) {
+	stream.mu.Lock()
+	if stream.placeholderActive {
+		stream.mu.Unlock()
+		return
+	}
+	stream.placeholderActive = true
+	stalledAt := stream.frameCount.Load()
+	stream.mu.Unlock()
+
+	defer func() {
+		stream.mu.Lock()
+		stream.placeholderActive = false
+		stream.mu.Unlock()
+	}()
+
+	log.Printf("Stream %s stalled, delivering placeholder frames", stream.streamID)
+	frame := placeholderFrame(stream.width, stream.height, stream.pixelFormat)
+
+	ticker := time.NewTicker(PlaceholderInterval)
+	defer ticker.Stop()
+
+	for {
+		if stream.frameCount.Load() > stalledAt {
+			log.Printf("Stream %s recovered, stopping placeholder frames", stream.streamID)
+			return
+		}
+
+		stream.broadcast(&Frame{data: frame, timestamp: time.Now()})
+
+		select {
+		case <-stream.healthStopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
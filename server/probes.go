@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ffmpegHealth caches whether FFmpeg can still be spawned, so probes don't start a process on every call
+type ffmpegHealth struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	runner    CommandRunner
+	checkedAt time.Time
+	available bool
+	version   string
+	err       error
+}
+
+// ffmpegStatus is the result of the latest FFmpeg check
+type ffmpegStatus struct {
+	Available bool
+	Version   string
+	Error     string
+	CheckedAt time.Time
+}
+
+// status returns the cached result, re-running ffmpeg -version once it is older than the TTL
+func (h *ffmpegHealth) status() ffmpegStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if time.Since(h.checkedAt) >= h.ttl {
+		h.refreshLocked()
+	}
+
+	s := ffmpegStatus{Available: h.available, Version: h.version, CheckedAt: h.checkedAt}
+	if h.err != nil {
+		s.Error = h.err.Error()
+	}
+	return s
+}
+
+// refreshLocked spawns ffmpeg -version and records the outcome, logging availability changes;
+// callers must hold h.mu
+func (h *ffmpegHealth) refreshLocked() {
+	ctx, cancel := context.WithTimeout(context.Background(), FFmpegProbeTimeout)
+	defer cancel()
+
+	out, err := h.runner.CommandContext(ctx, "-version").Output()
+	wasAvailable, first := h.available, h.checkedAt.IsZero()
+	h.checkedAt = time.Now()
+	h.err = err
+	h.available = err == nil
+	h.version = ""
+	if h.available {
+		_, h.version = parseFFmpegVersion(out)
+	}
+
+	switch {
+	case !h.available && (wasAvailable || first):
+		log.Printf("FFmpeg health check failed, new streams cannot start: %v", err)
+	case h.available && !wasAvailable && !first:
+		log.Printf("FFmpeg health check recovered (version %s)", h.version)
+	}
+}
+
+// watchFFmpeg re-checks FFmpeg in the background every TTL so a broken environment is logged even
+// when nothing probes the server
+func (sm *StreamManager) watchFFmpeg() {
+	ticker := time.NewTicker(sm.ffmpeg.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.shutdownCtx.Done():
+			return
+		case <-ticker.C:
+			sm.ffmpeg.status()
+		}
+	}
+}
+
+// handleHealth reports basic health along with the cached FFmpeg availability. The server stays
+// "healthy" to liveness checks but reports "degraded" when FFmpeg can't be spawned.
+func (sm *StreamManager) handleHealth(c *gin.Context) {
+	ffmpeg := sm.ffmpeg.status()
+	status := "healthy"
+	if !ffmpeg.Available {
+		status = "degraded"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":            status,
+		"timestamp":         time.Now().Unix(),
+		"ffmpeg_available":  ffmpeg.Available,
+		"ffmpeg_version":    ffmpeg.Version,
+		"ffmpeg_checked_at": ffmpeg.CheckedAt,
+	})
+}
+
+// handleLivez reports that the process is up and serving HTTP
+func (sm *StreamManager) handleLivez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "alive",
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// handleReadyz reports whether the server should receive traffic: FFmpeg must be available
+// and the server must not be shutting down
+func (sm *StreamManager) handleReadyz(c *gin.Context) {
+	ffmpeg := sm.ffmpeg.status()
+	checks := gin.H{
+		"ffmpeg":            "ok",
+		"ffmpeg_available":  ffmpeg.Available,
+		"ffmpeg_version":    ffmpeg.Version,
+		"ffmpeg_checked_at": ffmpeg.CheckedAt,
+		"shutting_down":     sm.shuttingDown.Load(),
+	}
+	ready := true
+
+	if !ffmpeg.Available {
+		checks["ffmpeg"] = ffmpeg.Error
+		ready = false
+	}
+	if sm.shuttingDown.Load() {
+		ready = false
+	}
+
+	status, statusCode := "ready", http.StatusOK
+	if !ready {
+		status, statusCode = "not_ready", http.StatusServiceUnavailable
+	}
+	c.JSON(statusCode, gin.H{
+		"status":    status,
+		"checks":    checks,
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// beginShutdown marks the server as draining so readiness probes fail immediately, and releases
+// requests blocked waiting on streams
+func (sm *StreamManager) beginShutdown() {
+	sm.shuttingDown.Store(true)
+	sm.shutdownCancel()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestShutdownReleasesFrameRequests blocks a /frame request waiting for a frame that won't come, then
+// begins shutdown: the request must be answered at once with 503 SERVER_SHUTTING_DOWN so the HTTP server
+// can drain, and readiness must fail
+func TestShutdownReleasesFrameRequests(t *testing.T) {
+	ts := newTestServer(t, 25, nil)
+	ts.startStream(t, map[string]interface{}{
+		"stream_id": "polled",
+		"rtsp_url":  "rtsp://camera.example/polled",
+		"width":     16,
+		"height":    16,
+	})
+
+	type frameResult struct {
+		resp *http.Response
+		body []byte
+	}
+	done := make(chan frameResult, 1)
+	go func() {
+		// A sequence number far ahead keeps the request waiting for newer frames
+		resp, body := ts.get(t, "/api/streams/polled/frame?after_seq=1000000000", FrameTypeRaw)
+		done <- frameResult{resp, body}
+	}()
+	waitForGoroutine(t, "(*StreamManager).serveLatestFrame")
+
+	start := time.Now()
+	ts.sm.beginShutdown()
+	var result frameResult
+	select {
+	case result = <-done:
+	case <-time.After(FrameRequestTimeout / 2):
+		t.Fatal("frame request still blocked after shutdown began")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("frame request released %s after shutdown began", elapsed)
+	}
+
+	if result.resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", result.resp.StatusCode)
+	}
+	var envelope struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.Unmarshal(result.body, &envelope); err != nil {
+		t.Fatalf("decode %q: %v", result.body, err)
+	}
+	if envelope.Error.Code != CodeShuttingDown {
+		t.Errorf("error code = %q, want %s", envelope.Error.Code, CodeShuttingDown)
+	}
+
+	if resp, body := ts.get(t, "/readyz", ""); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readyz during shutdown: status %d: %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FrameProcessor transforms a raw frame between the FFmpeg read and the frame buffer, e.g. to burn in
+// overlays, watermarks or redactions. It may modify frame in place and return it, but the result
+// must keep the stream's frame size.
+type FrameProcessor interface {
+	Process(frame []byte, width, height int) ([]byte, error)
+}
+
+// frameProcessorFactory builds a processor for a stream's pixel format
+type frameProcessorFactory func(pixelFormat string) (FrameProcessor, error)
+
+// frameProcessors is the registry of processors that can be enabled per stream by name
+var frameProcessors = map[string]frameProcessorFactory{
+	"timestamp": newTimestampOverlay,
+}
+
+// RegisterFrameProcessor makes a processor available to the processors stream option
+func RegisterFrameProcessor(name string, factory frameProcessorFactory) {
+	frameProcessors[name] = factory
+}
+
+// validateProcessors checks that every requested processor is registered
+func validateProcessors(names []string) error {
+	for _, name := range names {
+		if _, ok := frameProcessors[name]; !ok {
+			available := make([]string, 0, len(frameProcessors))
+			for n := range frameProcessors {
+				available = append(available, n)
+			}
+			sort.Strings(available)
+			return fmt.Errorf("unknown processor %q (available: %s)", name, strings.Join(available, ", "))
+		}
+	}
+	return nil
+}
+
+// buildProcessors instantiates the named processors in order
+func buildProcessors(names []string, pixelFormat string) ([]FrameProcessor, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	chain := make([]FrameProcessor, 0, len(names))
+	for _, name := range names {
+		processor, err := frameProcessors[name](pixelFormat)
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %v", name, err)
+		}
+		chain = append(chain, processor)
+	}
+	return chain, nil
+}
+
+// processFrame runs a frame through the stream's processor chain
+func (s *Stream) processFrame(frame []byte) ([]byte, error) {
+	for i, processor := range s.processors {
+		out, err := processor.Process(frame, s.width, s.height)
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %v", s.opts.Processors[i], err)
+		}
+		if len(out) != len(frame) {
+			return nil, fmt.Errorf("processor %s returned %d bytes, expected %d", s.opts.Processors[i], len(out), len(frame))
+		}
+		frame = out
+	}
+	return frame, nil
+}
+
+// timestampOverlay burns the current wall-clock time into the top-left corner of each frame
+type timestampOverlay struct {
+	pixelFormat string
+}
+
+// newTimestampOverlay creates the built-in timestamp overlay processor
+func newTimestampOverlay(pixelFormat string) (FrameProcessor, error) {
+	return &timestampOverlay{pixelFormat: pixelFormat}, nil
+}
+
+// Process draws white text on a black box so the timestamp stays legible on any scene
+func (t *timestampOverlay) Process(frame []byte, width, height int) ([]byte, error) {
+	text := time.Now().Format("2006-01-02 15:04:05")
+
+	scale := height / 240
+	if scale < 1 {
+		scale = 1
+	}
+	margin := 2 * scale
+	boxWidth := textWidth(text, scale) + 2*margin
+	boxHeight := glyphHeight*scale + 2*margin
+
+	for y := 0; y < boxHeight; y++ {
+		for x := 0; x < boxWidth; x++ {
+			setGray(frame, width, height, t.pixelFormat, x, y, 0)
+		}
+	}
+	drawText(text, margin, margin, scale, func(x, y int) {
+		setGray(frame, width, height, t.pixelFormat, x, y, 255)
+	})
+	return frame, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Frame timestamp sources
+const (
+	TimestampArrival = "arrival" // when the frame was read from FFmpeg
+	TimestampPTS     = "pts"     // the frame's presentation timestamp, reported by FFmpeg's showinfo filter
+)
+
+// showinfoFrame matches the line FFmpeg's showinfo filter logs for every frame, capturing the frame
+// number within the run and its presentation time in seconds, e.g.
+// "[Parsed_showinfo_1 @ 0x55d0] n:  12 pts: 1080000 pts_time:12 duration:..."
+var showinfoFrame = regexp.MustCompile(`^\[Parsed_showinfo_\d+ @ [^\]]+\] n:\s*(\d+) pts:\s*-?\d+ pts_time:(-?[0-9.]+)`)
+
+// validateTimestampSource checks a stream's timestamp_source, defaulting it to arrival
+func validateTimestampSource(o *StreamOptions) error {
+	switch o.TimestampSource {
+	case "":
+		o.TimestampSource = TimestampArrival
+	case TimestampArrival, TimestampPTS:
+	default:
+		return fmt.Errorf("timestamp_source %q is not supported (supported: arrival, pts)", o.TimestampSource)
+	}
+	return nil
+}
+
+// ptsSample is the presentation time showinfo reported for frame n of an FFmpeg run
+type ptsSample struct {
+	n   int64
+	pts time.Duration
+}
+
+// parseShowinfo extracts the frame number and presentation time from a showinfo line
+func parseShowinfo(line string) (ptsSample, bool) {
+	m := showinfoFrame.FindStringSubmatch(line)
+	if m == nil {
+		return ptsSample{}, false
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return ptsSample{}, false
+	}
+	seconds, err := strconv.ParseFloat(m[2], 64)
+	if err != nil {
+		return ptsSample{}, false
+	}
+	return ptsSample{n: n, pts: time.Duration(seconds * float64(time.Second))}, true
+}
+
+// ptsStats counts how a stream's frames were timestamped under timestamp_source pts
+type ptsStats struct {
+	ptsFrames atomic.Int64 // frames timed by their PTS
+	fallbacks atomic.Int64 // frames that got their arrival time because no PTS came in time
+	reanchors atomic.Int64 // times the PTS clock was realigned with wall-clock time
+}
+
+// info reports the counters for stats
+func (p *ptsStats) info() map[string]interface{} {
+	return map[string]interface{}{
+		"pts_frames":        p.ptsFrames.Load(),
+		"arrival_fallbacks": p.fallbacks.Load(),
+		"reanchors":         p.reanchors.Load(),
+	}
+}
+
+// ptsClock turns the PTS of one FFmpeg run's frames into wall-clock times. The stderr reader feeds it
+// showinfo samples and the frame reader asks it for each frame's time, matching the two by frame
+// number. PTS only gives times relative to each other, so they are anchored to the earliest
+// arrival they imply: a frame delayed on the way doesn't shift the timestamps, while one that arrives
+// sooner than any before moves the anchor to it. A jump of more than PTSMaxDrift, e.g. the source
+// restarting its clock, re-anchors at the frame's arrival.
+type ptsClock struct {
+	samples  chan ptsSample
+	held     *ptsSample // a sample for a frame after the one asked for
+	starved  bool       // the last frame had no sample; don't wait for the next one
+	anchored bool
+	anchor   time.Time // wall-clock time of PTS 0
+	stats    *ptsStats
+}
+
+// newPTSClock creates the clock for one FFmpeg run
+func newPTSClock(stats *ptsStats) *ptsClock {
+	return &ptsClock{samples: make(chan ptsSample, PTSQueueSize), stats: stats}
+}
+
+// offer hands a sample from the stderr reader to the frame reader, dropping it if the frame reader is
+// far behind; that frame then falls back to its arrival time
+func (c *ptsClock) offer(sample ptsSample) {
+	select {
+	case c.samples <- sample:
+	default:
+	}
+}
+
+// timestamp returns the time of frame n of the run, counting from 0, and whether it came from the
+// frame's PTS. showinfo logs a frame before FFmpeg writes it out, so its sample is normally waiting;
+// the reader waits up to PTSWaitTimeout for one that isn't, unless the previous frame had none either.
+func (c *ptsClock) timestamp(n int64, arrival time.Time) (time.Time, bool) {
+	for {
+		sample := c.held
+		c.held = nil
+		if sample == nil {
+			wait := PTSWaitTimeout
+			if c.starved {
+				wait = 0
+			}
+			select {
+			case s := <-c.samples:
+				sample = &s
+			case <-time.After(wait):
+				return c.fallback(arrival)
+			}
+		}
+		switch {
+		case sample.n < n:
+			// For a frame that already fell back
+			continue
+		case sample.n > n:
+			c.held = sample
+			return c.fallback(arrival)
+		}
+		c.starved = false
+		c.stats.ptsFrames.Add(1)
+		return c.at(sample.pts, arrival), true
+	}
+}
+
+// fallback times a frame without a PTS sample by its arrival
+func (c *ptsClock) fallback(arrival time.Time) (time.Time, bool) {
+	c.starved = true
+	c.stats.fallbacks.Add(1)
+	return arrival, false
+}
+
+// at converts a PTS to wall-clock time, moving the anchor as described on ptsClock
+func (c *ptsClock) at(pts time.Duration, arrival time.Time) time.Time {
+	earliest := arrival.Add(-pts)
+	switch {
+	case !c.anchored:
+		c.anchor, c.anchored = earliest, true
+	case earliest.Before(c.anchor):
+		c.anchor = earliest
+	case earliest.Sub(c.anchor) > PTSMaxDrift:
+		c.anchor = earliest
+		c.stats.reanchors.Add(1)
+	}
+	return c.anchor.Add(pts)
+}
+
+// ingestFilter is the -vf filter chain of the raw-frame ingest; pts streams add showinfo last, so it
+// reports exactly the frames written out
+func (s *Stream) ingestFilter() string {
+	if s.opts.TimestampSource == TimestampPTS {
+		return s.scaleFilter() + ",showinfo"
+	}
+	return s.scaleFilter()
+}
+
+// timestampSource reports how the frame's timestamp was obtained
+func (f *Frame) timestampSource() string {
+	if f.fromPTS {
+		return TimestampPTS
+	}
+	return TimestampArrival
+}
+
+// timestampInfo reports a stream's timestamp source for stats, with the PTS counters when it uses PTS
+func (s *Stream) timestampInfo() map[string]interface{} {
+	info := map[string]interface{}{"source": s.opts.TimestampSource}
+	if s.opts.TimestampSource == TimestampPTS {
+		for key, value := range s.ptsStats.info() {
+			info[key] = value
+		}
+	}
+	return info
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateMeter measures an event rate over fixed windows, reporting the rate of the last full window
+type rateMeter struct {
+	mu          sync.Mutex
+	window      time.Duration
+	windowStart time.Time
+	count       int64
+	lastRate    float64
+}
+
+// newRateMeter creates a meter that averages over the given window
+func newRateMeter(window time.Duration) *rateMeter {
+	return &rateMeter{window: window, windowStart: time.Now()}
+}
+
+// mark records one event
+func (m *rateMeter) mark() {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	m.count++
+}
+
+// rate returns events per second over the most recent complete window
+func (m *rateMeter) rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(time.Now())
+	return m.lastRate
+}
+
+// roll closes the current window once it has elapsed; callers must hold m.mu
+func (m *rateMeter) roll(now time.Time) {
+	elapsed := now.Sub(m.windowStart)
+	if elapsed < m.window {
+		return
+	}
+	if elapsed >= 2*m.window {
+		// No events for over a full window
+		m.lastRate = 0
+	} else {
+		m.lastRate = float64(m.count) / elapsed.Seconds()
+	}
+	m.windowStart = now
+	m.count = 0
+}
+
+// fpsEMA estimates a live frame rate from an exponential moving average of the gap between frames.
+// It takes no locks: mark must only be called from one goroutine, while rate may be called from any.
+type fpsEMA struct {
+	last     atomic.Int64 // unix nanoseconds of the latest mark
+	interval atomic.Int64 // smoothed gap between marks in nanoseconds
+}
+
+// mark records a frame at now
+func (e *fpsEMA) mark(now time.Time) {
+	prev := e.last.Swap(now.UnixNano())
+	if prev == 0 {
+		return
+	}
+
+	gap := now.UnixNano() - prev
+	interval := e.interval.Load()
+	if interval == 0 {
+		interval = gap
+	} else {
+		interval += int64(FPSSmoothing * float64(gap-interval))
+	}
+	e.interval.Store(interval)
+}
+
+// rate returns the smoothed frames per second at now. A gap longer than the smoothed interval pulls the
+// estimate down straight away, and the rate drops to 0 once no frame has arrived for FPSIdleTimeout.
+func (e *fpsEMA) rate(now time.Time) float64 {
+	interval := e.interval.Load()
+	if interval <= 0 {
+		return 0
+	}
+
+	gap := now.UnixNano() - e.last.Load()
+	if gap > int64(FPSIdleTimeout) {
+		return 0
+	}
+	if gap > interval {
+		interval = gap
+	}
+	return float64(time.Second) / float64(interval)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recording status values
+const (
+	RecordingStarting    = "starting"
+	RecordingActive      = "recording"
+	RecordingInterrupted = "interrupted"
+	RecordingFinished    = "finished"
+)
+
+// recordingSegmentOpened matches the line FFmpeg's segment muxer logs when it starts writing a file
+var recordingSegmentOpened = regexp.MustCompile(`Opening '([^']+)' for writing`)
+
+// recorder writes a stream's source to MPEG-TS segments with an FFmpeg process of its own. It reads the
+// input directly rather than sharing the raw-frame ingest, so stalls, restarts and pauses of the live
+// pipeline don't touch the files; a failure of the recorder only loses the segment being written.
+type recorder struct {
+	dir         string
+	segment     time.Duration
+	maxSegments int // oldest segments beyond this are deleted; 0 keeps them all
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+
+	mu        sync.Mutex
+	status    string
+	detail    string
+	current   string    // path of the segment being written
+	restarts  int       // FFmpeg runs that ended while the stream was still recording
+	downSince time.Time // start of the current interruption, after recording began; zero while recording
+	downtime  time.Duration
+	deleted   int // segments deleted to stay within maxSegments
+}
+
+// newRecorder creates the recording directory for a stream inside RECORDING_DIR
+func (sm *StreamManager) newRecorder(streamID string) (*recorder, error) {
+	if sm.config.RecordingDir == "" {
+		return nil, fmt.Errorf("%w: RECORDING_DIR is not set", ErrRecordingUnavailable)
+	}
+	dir := filepath.Join(sm.config.RecordingDir, streamID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRecordingUnavailable, err)
+	}
+	return &recorder{
+		dir:         dir,
+		segment:     sm.config.RecordingSegment,
+		maxSegments: sm.config.RecordingMaxSegments,
+		stopCh:      make(chan struct{}),
+		status:      RecordingStarting,
+	}, nil
+}
+
+// stop ends the recording; FFmpeg gets SIGTERM so it closes the last segment cleanly
+func (r *recorder) stop() {
+	r.stopOnce.Do(func() { close(r.stopCh) })
+}
+
+// opened records that FFmpeg started writing a segment and reports the status it was in before
+func (r *recorder) opened(path string, now time.Time) (previous string, gap time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous = r.status
+	if !r.downSince.IsZero() {
+		gap = now.Sub(r.downSince)
+		r.downtime += gap
+		r.downSince = time.Time{}
+	}
+	r.status, r.detail, r.current = RecordingActive, "", path
+	return previous, gap
+}
+
+// failed records an FFmpeg run that ended while the stream was still recording. Time without a
+// recorder counts as a gap once the first segment was written.
+func (r *recorder) failed(err error, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.restarts++
+	if r.current != "" && r.downSince.IsZero() {
+		r.downSince = now
+	}
+	r.status, r.detail = RecordingInterrupted, err.Error()
+}
+
+// finish marks the recording finished, closing an interruption that was still open
+func (r *recorder) finish(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.downSince.IsZero() {
+		r.downtime += now.Sub(r.downSince)
+		r.downSince = time.Time{}
+	}
+	r.status = RecordingFinished
+}
+
+// segments lists the recording's segment files, oldest first; the timestamped names sort by time
+func (r *recorder) segments() []string {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".ts") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// prune deletes the oldest segments beyond maxSegments, never the one being written
+func (r *recorder) prune(streamID string) {
+	if r.maxSegments <= 0 {
+		return
+	}
+	names := r.segments()
+	r.mu.Lock()
+	current := filepath.Base(r.current)
+	r.mu.Unlock()
+
+	for _, name := range names[:max(len(names)-r.maxSegments, 0)] {
+		if name == current {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.dir, name)); err != nil {
+			log.Printf("Failed to delete old recording segment of stream %s: %v", streamID, err)
+			continue
+		}
+		r.mu.Lock()
+		r.deleted++
+		r.mu.Unlock()
+	}
+}
+
+// info reports the recording's health for stats
+func (r *recorder) info(now time.Time) map[string]interface{} {
+	names := r.segments()
+	var bytes int64
+	for _, name := range names {
+		if fi, err := os.Stat(filepath.Join(r.dir, name)); err == nil {
+			bytes += fi.Size()
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	downtime := r.downtime
+	if !r.downSince.IsZero() {
+		downtime += now.Sub(r.downSince)
+	}
+	info := map[string]interface{}{
+		"status":           r.status,
+		"dir":              r.dir,
+		"segments":         len(names),
+		"bytes":            bytes,
+		"segment_seconds":  r.segment.Seconds(),
+		"max_segments":     r.maxSegments,
+		"segments_deleted": r.deleted,
+		"restarts":         r.restarts,
+		"gap_seconds":      downtime.Seconds(),
+	}
+	if r.current != "" {
+		info["current_segment"] = filepath.Base(r.current)
+	}
+	if r.detail != "" {
+		info["detail"] = r.detail
+	}
+	return info
+}
+
+// infoOrNil is info, or nil when the stream isn't recording
+func (r *recorder) infoOrNil(now time.Time) map[string]interface{} {
+	if r == nil {
+		return nil
+	}
+	return r.info(now)
+}
+
+// runRecorder keeps the recording FFmpeg running until the recording is stopped, restarting it with the
+// usual backoff when it fails, then announces the finished recording
+func (sm *StreamManager) runRecorder(stream *Stream) {
+	rec := stream.recorder
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-rec.stopCh
+		cancel()
+	}()
+
+	failures := 0
+	for ctx.Err() == nil {
+		err := rec.runFFmpeg(ctx, stream)
+		if ctx.Err() != nil {
+			break
+		}
+		if err == nil {
+			err = errors.New("FFmpeg exited")
+		}
+
+		// A run that got as far as writing was a working connection, so the backoff starts over
+		rec.mu.Lock()
+		if rec.status == RecordingActive {
+			failures = 0
+		}
+		rec.mu.Unlock()
+		failures++
+
+		log.Printf("Recording FFmpeg error for stream %s: %v", stream.streamID, err)
+		rec.failed(err, time.Now())
+		stream.events.publish(StreamEvent{
+			"type":      "recording",
+			"stream_id": stream.streamID,
+			"state":     RecordingInterrupted,
+			"reason":    err.Error(),
+		})
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(sm.withJitter(restartDelay(failures))):
+		}
+	}
+
+	now := time.Now()
+	rec.finish(now)
+	event := StreamEvent{"type": "recording", "stream_id": stream.streamID, "state": RecordingFinished, "timestamp": now.UnixMilli()}
+	for key, value := range rec.info(now) {
+		if key != "status" {
+			event[key] = value
+		}
+	}
+	log.Printf("Recording of stream %s finished: %d segment(s) in %s", stream.streamID, event["segments"], rec.dir)
+	// By the time FFmpeg has closed the last segment the stream is usually gone and its event hub with it,
+	// so the event goes straight to the audit log and webhooks
+	sm.streamEventTap(event)
+}
+
+// runFFmpeg runs one recording FFmpeg process, copying the video and any audio track unchanged into
+// MPEG-TS segments aligned to the wall clock. MPEG-TS stays playable up to the last complete packet, so
+// a killed process leaves a truncated segment rather than an unreadable one.
+func (r *recorder) runFFmpeg(ctx context.Context, stream *Stream) error {
+	args := ffmpegLogArgs(stream.ffmpegLogLevel())
+	args = append(args, ffmpegInputArgs(stream.currentURL(), stream.inputOpts, stream.opts.Loop)...)
+	args = append(args,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.FormatFloat(r.segment.Seconds(), 'f', -1, 64),
+		"-segment_atclocktime", "1",
+		"-segment_format", "mpegts",
+		"-reset_timestamps", "1",
+		"-strftime", "1",
+		filepath.Join(r.dir, RecordingFilePattern),
+	)
+
+	// Background context: cancellation goes through stopFFmpeg so FFmpeg can close the segment
+	cmd := stream.runner.CommandContext(context.Background(), args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stderr pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start FFmpeg: %v", err)
+	}
+	stream.sched.apply(stream.streamID, cmd)
+	untrack := stream.trackFFmpeg("recording", cmd)
+
+	exited := make(chan struct{})
+	defer func() {
+		close(exited)
+		untrack()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopFFmpeg(cmd, exited)
+		case <-exited:
+		}
+	}()
+
+	// The last error FFmpeg logged, else its last line, explains why it exited
+	var lastError, lastLine string
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		level, line := parseFFmpegLogLine(scanner.Text())
+		stream.logFFmpegLine(level, "recording: "+line)
+		lastLine = strings.TrimSpace(line)
+		if ffmpegLogLevels[level] <= ffmpegLogLevels["error"] {
+			lastError = lastLine
+		}
+
+		m := recordingSegmentOpened.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		previous, gap := r.opened(m[1], time.Now())
+		r.prune(stream.streamID)
+		switch previous {
+		case RecordingStarting:
+			log.Printf("Recording stream %s to %s", stream.streamID, r.dir)
+			stream.events.publish(StreamEvent{"type": "recording", "stream_id": stream.streamID, "state": "started"})
+		case RecordingInterrupted:
+			log.Printf("Recording of stream %s resumed after %s", stream.streamID, gap.Round(time.Millisecond))
+			stream.events.publish(StreamEvent{
+				"type":        "recording",
+				"stream_id":   stream.streamID,
+				"state":       "resumed",
+				"gap_seconds": gap.Seconds(),
+			})
+		}
+	}
+
+	err = cmd.Wait()
+	if lastError != "" {
+		lastLine = lastError
+	}
+	if err != nil && lastLine != "" {
+		return fmt.Errorf("%v: %s", err, lastLine)
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the ID that ties a request to the server's log lines and audit entries
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern is what a caller-supplied request ID must match to be kept: short, and free of
+// spaces and control characters so it can't break up a log line
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// generateRequestID returns a random 32-character hex request ID
+func generateRequestID() string {
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		// crypto/rand should never fail; the nanosecond clock still tells requests apart
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(random[:])
+}
+
+// requestIDMiddleware takes the caller's X-Request-ID, or generates one when it is missing or
+// unusable, stores it on the context and echoes it in the response
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = generateRequestID()
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// requestID returns the ID requestIDMiddleware assigned to a request
+func requestID(c *gin.Context) string {
+	return c.GetString("request_id")
+}
+
+// requestLogFormatter is gin's default access log line with the request ID appended
+func requestLogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	id, _ := param.Keys["request_id"].(string)
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v | request %s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		param.Path,
+		id,
+		param.ErrorMessage,
+	)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// goroutineEstimate counts the goroutines working for a stream, by what they do. It is derived from
+// the stream's setup rather than a goroutine dump, so it is cheap enough to poll.
+func (s *Stream) goroutineEstimate() map[string]int {
+	counts := map[string]int{
+		"distribution":   1,
+		"health_monitor": 1,
+	}
+
+	// A clone is fed by one goroutine; a stream's ingest loop also runs a stderr reader and a stop
+	// watcher while its FFmpeg is up
+	counts["ingest"] = 1
+	if s.ffmpegPID.Load() != 0 {
+		counts["ingest"] += 2
+	}
+
+	s.clientsMu.RLock()
+	counts["clients"] = 2 * len(s.clients) // a reader and a writer each
+	s.clientsMu.RUnlock()
+
+	if s.motion != nil {
+		counts["motion"] = 1
+	}
+	if s.recorder != nil {
+		counts["recorder"] = 1
+	}
+	if s.audio != nil {
+		counts["audio"] = 1
+	}
+	if s.ts.listenerCount() > 0 {
+		counts["mpegts"] = 1
+	}
+	if s.local != nil {
+		// The accept loop and one goroutine per consumer
+		counts["local_socket"] = 1 + s.local.consumerCount()
+	}
+
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	counts["total"] = total
+	return counts
+}
+
+// bytes returns the size of the frames in the cache
+func (fc *frameCache) bytes() int64 {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	var total int64
+	for i := 0; i < fc.count; i++ {
+		total += int64(len(fc.at(i).data))
+	}
+	return total
+}
+
+// bytes returns the size of the cached encodings
+func (ec *encodeCache) bytes() int64 {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	var total int64
+	for elem := ec.order.Front(); elem != nil; elem = elem.Next() {
+		total += int64(len(elem.Value.(*encodedFrame).data))
+	}
+	return total
+}
+
+// memoryEstimate reports the frame memory a stream holds. The buffer, the cache and client queues
+// share frames, so the parts overlap and the total is an upper bound.
+func (s *Stream) memoryEstimate() map[string]int64 {
+	frameSize := int64(s.frameSize())
+	queued, _ := s.clientQueueDepths()
+	memory := map[string]int64{
+		"frame_buffer_bytes": int64(len(s.frameBuffer)) * frameSize,
+		"frame_cache_bytes":  s.frameCache.bytes(),
+		"client_queue_bytes": int64(queued) * frameSize,
+		"encode_cache_bytes": s.encoded.bytes(),
+	}
+	memory["total_bytes"] = memory["frame_buffer_bytes"] + memory["frame_cache_bytes"] +
+		memory["client_queue_bytes"] + memory["encode_cache_bytes"]
+	return memory
+}
+
+// ffmpegResources reports the stream's ingest FFmpeg process and its resident memory, or nil while
+// none is running. RSS is left out where it can't be read.
+func (s *Stream) ffmpegResources() map[string]interface{} {
+	pid := int(s.ffmpegPID.Load())
+	if pid == 0 {
+		return nil
+	}
+	info := map[string]interface{}{"pid": pid}
+	if rss, ok := processRSS(pid); ok {
+		info["rss_bytes"] = rss
+	}
+	return info
+}
+
+// handleResources reports per-stream goroutines, frame memory and FFmpeg RSS, heaviest first, so
+// operators can find which stream is loading the host
+func (sm *StreamManager) handleResources(c *gin.Context) {
+	sm.mu.RLock()
+	streams := make([]map[string]interface{}, 0, len(sm.streams))
+	for streamID, stream := range sm.streams {
+		memory := stream.memoryEstimate()
+		entry := map[string]interface{}{
+			"stream_id":  streamID,
+			"goroutines": stream.goroutineEstimate(),
+			"memory":     memory,
+			"ffmpeg":     stream.ffmpegResources(),
+		}
+		if stream.parent != nil {
+			entry["clone_of"] = stream.parent.streamID
+		}
+		streams = append(streams, entry)
+	}
+	sm.mu.RUnlock()
+
+	sort.Slice(streams, func(i, j int) bool {
+		a, b := streams[i]["memory"].(map[string]int64)["total_bytes"], streams[j]["memory"].(map[string]int64)["total_bytes"]
+		if a != b {
+			return a > b
+		}
+		return streams[i]["stream_id"].(string) < streams[j]["stream_id"].(string)
+	})
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	process := map[string]interface{}{
+		"goroutines":       runtime.NumGoroutine(),
+		"heap_alloc_bytes": mem.HeapAlloc,
+		"sys_bytes":        mem.Sys,
+	}
+	if rss, ok := processRSS(os.Getpid()); ok {
+		process["rss_bytes"] = rss
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"streams":       streams,
+		"process":       process,
+		"rss_supported": rssSupported,
+		"ffmpeg_slots":  sm.startQueue.info(),
+	})
+}
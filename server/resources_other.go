@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+// rssSupported reports whether process RSS can be read on this platform
+const rssSupported = false
+
+// processRSS is unavailable where there is no /proc; resource reports leave RSS out
+func processRSS(pid int) (int64, bool) {
+	return 0, false
+}
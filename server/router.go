@@ -0,0 +1,98 @@
+package main
+
+import "github.com/gin-gonic/gin"
+
+// newRouter builds the HTTP routes of the server: the control API, the WebSocket endpoint, the viewer
+// and the health probes
+func newRouter(sm *StreamManager) *gin.Engine {
+	// Set up Gin router; every request gets an ID, logged with it and echoed as X-Request-ID
+	r := gin.New()
+	// Gin trusts forwarding headers from anyone by default, which would let a caller pick the IP the
+	// per-IP limits and the audit log see. loadConfig validated the list, so this can't fail.
+	r.SetTrustedProxies(sm.config.TrustedProxies)
+	r.Use(requestIDMiddleware(), gin.LoggerWithFormatter(requestLogFormatter), gin.Recovery())
+
+	// CORS middleware
+	r.Use(func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+
+		c.Next()
+	})
+
+	// API routes
+	api := r.Group("/api")
+	{
+		// With AUTH_KEYS_FILE set, managing streams needs an admin key and watching one a viewer key;
+		// with ADMIN_USER set, managing streams also needs the admin Basic credentials
+		admin, viewer := sm.requireAdmin(), sm.requireViewer()
+
+		api.POST("/streams", admin, sm.handleStartStream)
+		api.POST("/streams/batch", admin, sm.handleBatchStartStreams)
+		api.POST("/streams/start-with-url", admin, sm.handleStartStreamWithURL)
+		api.DELETE("/streams/:streamId", admin, sm.handleStopStream)
+		api.DELETE("/streams/:streamId/force", admin, sm.handleForceStopStream)
+		api.POST("/streams/:streamId/signed-url", viewer, sm.handleSignStreamURL)
+		api.POST("/streams/:streamId/pause", admin, sm.handlePauseStream)
+		api.POST("/streams/:streamId/resume", admin, sm.handleResumeStream)
+		api.POST("/streams/:streamId/keepalive", admin, sm.handleKeepalive)
+		api.POST("/streams/:streamId/drain", admin, sm.handleDrainStream)
+		api.POST("/streams/:streamId/clone", admin, sm.handleCloneStream)
+		api.PUT("/streams/:streamId/ffmpeg-log-level", admin, sm.handleSetFFmpegLogLevel)
+		api.GET("/streams/:streamId/command", admin, sm.handleGetFFmpegCommand)
+		api.GET("/streams", sm.requireKey(), sm.handleListStreams)
+		api.GET("/streams/stats", sm.requireKey(), sm.handleGetBatchStreamStats)
+		api.GET("/snapshot-grid.jpg", sm.requireKey(), sm.handleSnapshotGrid)
+		api.POST("/streams/stats", sm.requireKey(), sm.handleGetBatchStreamStats)
+		api.GET("/streams/:streamId/stats", viewer, sm.handleGetStreamStats)
+		api.GET("/streams/:streamId/frame", viewer, sm.handleGetFrame)
+		api.GET("/streams/:streamId/format", viewer, sm.handleGetStreamFormat)
+		api.GET("/streams/:streamId/audio", viewer, sm.handleStreamAudio)
+		api.GET("/streams/:streamId/ts", viewer, sm.handleStreamTS)
+		api.GET("/streams/:streamId/thumbnail.jpg", viewer, sm.handleGetThumbnail)
+		api.POST("/streams/:streamId/clip", viewer, sm.handleClip)
+		api.GET("/streams/:streamId/clients", viewer, sm.handleListClients)
+		api.PATCH("/streams/:streamId/clients/:clientId", admin, sm.handleUpdateClient)
+		api.GET("/streams/:streamId/events", viewer, sm.handleStreamEvents)
+		api.GET("/streams/:streamId/status", viewer, sm.handleGetStreamStatus)
+		api.GET("/streams/:streamId/status/stream", viewer, sm.handleStreamStatusEvents)
+		api.GET("/streams/:streamId/wait-ready", viewer, sm.handleWaitReady)
+
+		api.GET("/events", admin, sm.handleAuditEvents)
+		api.GET("/webhooks", admin, sm.handleListWebhooks)
+		api.POST("/webhooks", admin, sm.handleAddWebhook)
+		api.DELETE("/webhooks/:webhookId", admin, sm.handleDeleteWebhook)
+		api.GET("/version", sm.requireKey(), sm.handleVersion)
+		api.POST("/diagnostics/selftest", admin, sm.handleSelfTest)
+		api.GET("/admin/resources", admin, sm.handleResources)
+
+		// ONVIF camera discovery
+		api.GET("/discover", admin, sm.handleDiscover)
+		api.POST("/discover", admin, sm.handleDiscover)
+	}
+
+	// WebSocket route
+	r.GET("/ws/:streamId", sm.requireWebSocketViewer(), sm.handleWebSocket)
+
+	// Static files for iframe viewer
+	r.Static("/static", "./")
+	r.GET("/viewer", func(c *gin.Context) {
+		c.File("./stream_viewer.html")
+	})
+
+	// Health check
+	r.GET("/health", sm.handleHealth)
+
+	// Kubernetes-style probes
+	r.GET("/livez", sm.handleLivez)
+	r.GET("/readyz", sm.handleReadyz)
+
+	return r
+}
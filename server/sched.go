@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxFFmpegNice is the lowest scheduling priority FFmpeg can be given; nice only ever lowers priority
+const MaxFFmpegNice = 19
+
+// processScheduling is the niceness and CPU set applied to a stream's FFmpeg processes
+type processScheduling struct {
+	nice int
+	cpus []int // empty means any CPU
+}
+
+// parseCPUList parses a CPU list such as "0-3,6" into sorted, distinct CPU numbers
+func parseCPUList(raw string) ([]int, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list %q: %q is not a CPU number or range", raw, part)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU list %q: %q is not a CPU number or range", raw, part)
+			}
+		}
+		if first < 0 || last >= runtime.NumCPU() {
+			return nil, fmt.Errorf("invalid CPU list %q: CPUs must be between 0 and %d", raw, runtime.NumCPU()-1)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// validateNice checks a niceness value
+func validateNice(name string, nice int) error {
+	if nice < 0 || nice > MaxFFmpegNice {
+		return fmt.Errorf("%s must be between 0 and %d", name, MaxFFmpegNice)
+	}
+	return nil
+}
+
+// validateSchedulingOptions checks a start request's nice and cpus overrides
+func validateSchedulingOptions(o *StreamOptions) error {
+	if o.Nice != nil {
+		if err := validateNice("nice", *o.Nice); err != nil {
+			return err
+		}
+	}
+	_, err := parseCPUList(o.CPUs)
+	return err
+}
+
+// schedulingFor resolves a stream's scheduling from the global defaults and its start options
+func (sm *StreamManager) schedulingFor(opts StreamOptions) processScheduling {
+	sched := processScheduling{nice: sm.config.FFmpegNice, cpus: sm.config.FFmpegCPUs}
+	if opts.Nice != nil {
+		sched.nice = *opts.Nice
+	}
+	if cpus, _ := parseCPUList(opts.CPUs); len(cpus) > 0 {
+		sched.cpus = cpus
+	}
+	return sched
+}
+
+// apply lowers the priority and pins the CPUs of a just-started FFmpeg process. Failures are logged
+// rather than fatal: the process still works, just without the requested scheduling.
+func (p processScheduling) apply(streamID string, cmd *exec.Cmd) {
+	if p.nice == 0 && len(p.cpus) == 0 {
+		return
+	}
+	if err := setProcessScheduling(cmd.Process.Pid, p.nice, p.cpus); err != nil {
+		log.Printf("Could not apply FFmpeg scheduling for stream %s: %v", streamID, err)
+	}
+}
+
+// info reports the scheduling for stream stats
+func (p processScheduling) info() map[string]interface{} {
+	cpus := p.cpus
+	if cpus == nil {
+		cpus = []int{}
+	}
+	return map[string]interface{}{
+		"nice":      p.nice,
+		"cpus":      cpus,
+		"supported": schedulingSupported,
+	}
+}
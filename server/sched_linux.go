@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// schedulingSupported reports whether FFmpeg niceness and CPU pinning take effect on this platform
+const schedulingSupported = true
+
+// setProcessScheduling applies niceness and CPU affinity to every thread of a process. Linux tracks
+// both per thread, and FFmpeg may already have started threads, so each one in /proc/<pid>/task is set;
+// threads started later inherit the settings.
+func setProcessScheduling(pid, nice int, cpus []int) error {
+	tids := []int{pid}
+	if entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid)); err == nil {
+		tids = tids[:0]
+		for _, entry := range entries {
+			if tid, err := strconv.Atoi(entry.Name()); err == nil {
+				tids = append(tids, tid)
+			}
+		}
+	}
+
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+
+	for _, tid := range tids {
+		if nice != 0 {
+			if err := unix.Setpriority(unix.PRIO_PROCESS, tid, nice); err != nil {
+				return fmt.Errorf("setpriority: %v", err)
+			}
+		}
+		if len(cpus) > 0 {
+			if err := unix.SchedSetaffinity(tid, &set); err != nil {
+				return fmt.Errorf("sched_setaffinity: %v", err)
+			}
+		}
+	}
+	return nil
+}
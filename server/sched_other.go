@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+// schedulingSupported reports whether FFmpeg niceness and CPU pinning take effect on this platform
+const schedulingSupported = false
+
+// setProcessScheduling is a no-op where per-process niceness and CPU affinity aren't supported
+func setProcessScheduling(pid, nice int, cpus []int) error {
+	return nil
+}
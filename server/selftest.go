@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// selfTestResult is the outcome of a self-test run. Each phase is timed on its own; a failed run
+// reports the phase that failed and stops timing there, except for teardown, which always runs.
+type selfTestResult struct {
+	Passed      bool               `json:"passed"`
+	StreamID    string             `json:"stream_id"`
+	FailedPhase string             `json:"failed_phase,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	Frames      int                `json:"frames"`
+	DurationMS  float64            `json:"duration_ms"`
+	PhasesMS    map[string]float64 `json:"phases_ms"`
+}
+
+// endPhase records how long phase took since start and returns the end time, which starts the next phase
+func (r *selfTestResult) endPhase(phase string, start time.Time) time.Time {
+	now := time.Now()
+	r.PhasesMS[phase] = float64(now.Sub(start)) / float64(time.Millisecond)
+	return now
+}
+
+// fail records the phase a self-test failed in; only the first failure is kept
+func (r *selfTestResult) fail(phase string, err error) {
+	if r.FailedPhase == "" {
+		r.FailedPhase, r.Error = phase, err.Error()
+	}
+}
+
+// ingestInputArgs returns the FFmpeg arguments that open the stream's input. Self-test streams read
+// FFmpeg's built-in test source, which no start request can ask for.
+func (s *Stream) ingestInputArgs() []string {
+	if s.selfTest {
+		source := fmt.Sprintf("testsrc2=size=%dx%d:rate=%d", SelfTestWidth, SelfTestHeight, SelfTestFPS)
+		return []string{"-re", "-f", "lavfi", "-i", source}
+	}
+	return ffmpegInputArgs(s.currentURL(), s.inputOpts, s.opts.Loop)
+}
+
+// selfTestWaitError explains why a self-test stopped waiting for something
+func selfTestWaitError(ctx context.Context, what string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("no %s within %s", what, SelfTestTimeout)
+	}
+	return errors.New("server shutting down")
+}
+
+// runSelfTest ingests a synthetic source through the same FFmpeg runner, frame pipeline and WebSocket
+// writer as real streams, receives SelfTestFrames frames on a loopback WebSocket client, then tears it
+// all down. The stream uses a reserved ID and is never registered, so it doesn't show up in listings,
+// count against MAX_STREAMS or reach the audit log and webhooks.
+func (sm *StreamManager) runSelfTest() selfTestResult {
+	start := time.Now()
+	streamID := SelfTestStreamPrefix + strconv.FormatInt(start.UnixNano(), 36)
+	result := selfTestResult{StreamID: streamID, PhasesMS: make(map[string]float64)}
+
+	opts := StreamOptions{Width: SelfTestWidth, Height: SelfTestHeight}
+	if err := opts.normalize(); err != nil {
+		result.fail("setup", err)
+		return result
+	}
+
+	// Give up early when the server starts shutting down, so the self-test doesn't hold up Shutdown
+	ctx, cancelWait := context.WithDeadline(sm.shutdownCtx, start.Add(SelfTestTimeout))
+	defer cancelWait()
+
+	ingestCtx, cancel := context.WithCancel(context.Background())
+	stream := sm.newStream(streamID, "", opts, cancel)
+	stream.selfTest = true
+	stream.events.tap = nil
+	stream.runner = sm.runner
+	stream.sched = sm.schedulingFor(opts)
+
+	// A single FFmpeg run: unlike runFFmpegStream, a failure is reported rather than retried
+	ingestErr := make(chan error, 1)
+	ingestDone := make(chan struct{})
+	sm.tasks.goTask("self-test ingest "+streamID, func() {
+		defer close(ingestDone)
+		err := sm.startFFmpeg(ingestCtx, stream)
+		if err == nil {
+			err = errors.New("FFmpeg exited")
+		}
+		ingestErr <- err
+	})
+	sm.tasks.goTask("self-test frame distribution "+streamID, func() { sm.distributeFrames(stream) })
+
+	frames := 0
+	func() {
+		phaseStart := start
+		select {
+		case <-stream.readyChan():
+			phaseStart = result.endPhase("ingest", phaseStart)
+		case err := <-ingestErr:
+			result.fail("ingest", fmt.Errorf("FFmpeg exited before the first frame: %v", err))
+			return
+		case <-ctx.Done():
+			result.fail("ingest", selfTestWaitError(ctx, "frames from FFmpeg"))
+			return
+		}
+
+		conn, client, stopServer, err := sm.selfTestConnect(ctx, stream)
+		if err != nil {
+			result.fail("connect", err)
+			return
+		}
+		defer stopServer()
+		defer conn.Close()
+		// Marked closed before the connection goes, so its reader doesn't report an unexpected disconnect
+		defer client.closeWith(websocket.CloseNormalClosure, "self-test finished")
+		phaseStart = result.endPhase("connect", phaseStart)
+
+		deadline, _ := ctx.Deadline()
+		conn.SetReadDeadline(deadline)
+		for frames < SelfTestFrames {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				if ctx.Err() != nil {
+					err = selfTestWaitError(ctx, fmt.Sprintf("%d frames on the WebSocket", SelfTestFrames))
+				}
+				result.fail("frames", err)
+				return
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			if len(data) != stream.frameSize() {
+				result.fail("frames", fmt.Errorf("received a %d-byte frame, expected %d", len(data), stream.frameSize()))
+				return
+			}
+			frames++
+			if frames == 1 {
+				phaseStart = result.endPhase("first_frame", phaseStart)
+			}
+		}
+		result.endPhase("frames", phaseStart)
+	}()
+	result.Frames = frames
+
+	// Teardown mirrors stopping a stream: stop distribution, then FFmpeg
+	teardownStart := time.Now()
+	close(stream.healthStopChan)
+	cancel()
+	stream.events.close()
+	select {
+	case <-ingestDone:
+	case <-time.After(SelfTestTeardownTimeout):
+		result.fail("teardown", fmt.Errorf("FFmpeg still running %s after the self-test ended", SelfTestTeardownTimeout))
+	}
+	result.endPhase("teardown", teardownStart)
+
+	result.Passed = result.FailedPhase == ""
+	result.DurationMS = float64(time.Since(start)) / float64(time.Millisecond)
+	return result
+}
+
+// selfTestConnect serves a WebSocket for stream on a loopback listener and connects to it, returning
+// the dialled connection, the server-side client and a function that stops the listener. The client
+// is added to the stream directly, as AddClient would for a registered stream.
+func (sm *StreamManager) selfTestConnect(ctx context.Context, stream *Stream) (*websocket.Conn, *Client, func(), error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to listen on loopback: %v", err)
+	}
+
+	accepted := make(chan *Client, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := getUpgrader()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := sm.newClient(stream, conn, sm.config.Client)
+		stream.clientsMu.Lock()
+		stream.clients[client.id] = client
+		stream.clientsMu.Unlock()
+		sm.tasks.goTask("writer for client "+client.id, client.writePump)
+		sm.tasks.goTask("reader for client "+client.id, client.readPump)
+		accepted <- client
+	})}
+	go server.Serve(listener)
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, "ws://"+listener.Addr().String()+"/ws/"+stream.streamID, nil)
+	if err != nil {
+		server.Close()
+		return nil, nil, nil, fmt.Errorf("WebSocket connection failed: %v", err)
+	}
+	return conn, <-accepted, func() { server.Close() }, nil
+}
+
+// handleSelfTest runs an end-to-end self-test on a synthetic stream and reports pass or fail with
+// timings. Only one self-test runs at a time; it takes at most SelfTestTimeout plus teardown.
+func (sm *StreamManager) handleSelfTest(c *gin.Context) {
+	if sm.shuttingDown.Load() {
+		respondError(c, http.StatusServiceUnavailable, CodeShuttingDown, "Server shutting down", nil)
+		return
+	}
+	if !sm.selfTesting.CompareAndSwap(false, true) {
+		respondError(c, http.StatusConflict, CodeSelfTestRunning, "A self-test is already running", nil)
+		return
+	}
+	defer sm.selfTesting.Store(false)
+
+	result := sm.runSelfTest()
+	if result.Passed {
+		log.Printf("Self-test %s passed in %.0fms", result.StreamID, result.DurationMS)
+	} else {
+		log.Printf("Self-test %s failed in phase %s: %s", result.StreamID, result.FailedPhase, result.Error)
+	}
+
+	sm.auditRequest(c, "selftest", result.StreamID, map[string]interface{}{
+		"passed":       result.Passed,
+		"failed_phase": result.FailedPhase,
+		"duration_ms":  result.DurationMS,
+	})
+
+	status := http.StatusOK
+	if !result.Passed {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, result)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"sync"
+)
+
+// taskTracker keeps track of the goroutines and FFmpeg processes that shutdown waits for, remembering
+// what each one is so anything still running when the timeout expires can be named and killed
+type taskTracker struct {
+	mu    sync.Mutex
+	next  uint64
+	tasks map[uint64]trackedTask
+	idle  chan struct{} // closed while no tasks are running
+}
+
+// trackedTask describes one running task; kill is nil for goroutines, which can't be forced to stop
+type trackedTask struct {
+	name string
+	kill func()
+}
+
+// newTaskTracker creates an idle tracker
+func newTaskTracker() *taskTracker {
+	idle := make(chan struct{})
+	close(idle)
+	return &taskTracker{tasks: make(map[uint64]trackedTask), idle: idle}
+}
+
+// start registers a running task and returns the function that marks it finished
+func (t *taskTracker) start(name string, kill func()) (done func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.tasks) == 0 {
+		t.idle = make(chan struct{})
+	}
+	id := t.next
+	t.next++
+	t.tasks[id] = trackedTask{name: name, kill: kill}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			delete(t.tasks, id)
+			if len(t.tasks) == 0 {
+				close(t.idle)
+			}
+		})
+	}
+}
+
+// goTask runs fn in a tracked goroutine
+func (t *taskTracker) goTask(name string, fn func()) {
+	done := t.start(name, nil)
+	go func() {
+		defer done()
+		fn()
+	}()
+}
+
+// wait blocks until every task has finished or ctx is done
+func (t *taskTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pending returns the names of the tasks still running, sorted
+func (t *taskTracker) pending() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	names := make([]string, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		names = append(names, task.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// killAll force-kills every running task that can be killed, i.e. FFmpeg processes
+func (t *taskTracker) killAll() {
+	t.mu.Lock()
+	kills := make([]func(), 0, len(t.tasks))
+	for _, task := range t.tasks {
+		if task.kill != nil {
+			kills = append(kills, task.kill)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, kill := range kills {
+		kill()
+	}
+}
+
+// trackFFmpeg registers a started FFmpeg process so shutdown waits for it and can kill it when out of time
+func (s *Stream) trackFFmpeg(kind string, cmd *exec.Cmd) (done func()) {
+	name := fmt.Sprintf("%s FFmpeg for stream %s (pid %d)", kind, s.streamID, cmd.Process.Pid)
+	return s.tasks.start(name, func() { cmd.Process.Kill() })
+}
+
+// Shutdown stops all streams and waits, until ctx is done, for their FFmpeg processes and worker
+// goroutines and for every client's goroutines to finish. FFmpeg gets SIGTERM and then SIGKILL as
+// usual; whatever is still running when ctx ends is logged by name and FFmpeg is killed outright.
+func (sm *StreamManager) Shutdown(ctx context.Context) error {
+	sm.mu.RLock()
+	streamIDs := make([]string, 0, len(sm.streams))
+	for streamID := range sm.streams {
+		streamIDs = append(streamIDs, streamID)
+	}
+	sm.mu.RUnlock()
+
+	for _, streamID := range streamIDs {
+		if err := sm.StopStream(streamID); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+	}
+
+	// Webhook workers are tasks too; pending deliveries are abandoned rather than holding up shutdown
+	sm.webhooks.close()
+
+	if err := sm.tasks.wait(ctx); err != nil {
+		for _, name := range sm.tasks.pending() {
+			log.Printf("Shutdown: %s did not finish in time", name)
+		}
+		sm.tasks.killAll()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SignStreamURL returns a WebSocket path for the stream that is valid for ttl.
+// The signature is an HMAC-SHA256 over the stream ID and expiry using the server secret.
+func (sm *StreamManager) SignStreamURL(streamID string, ttl time.Duration) string {
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("exp", exp)
+	query.Set("sig", signStream(sm.config.SigningSecret, streamID, exp))
+
+	return fmt.Sprintf("/ws/%s?%s", url.PathEscape(streamID), query.Encode())
+}
+
+// VerifyStreamSignature checks that sig is a valid, unexpired signature for the stream
+func (sm *StreamManager) VerifyStreamSignature(streamID, exp, sig string) error {
+	if exp == "" || sig == "" {
+		return errors.New("missing exp or sig parameter")
+	}
+
+	expiry, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid exp parameter: %v", err)
+	}
+
+	expected := signStream(sm.config.SigningSecret, streamID, exp)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return errors.New("invalid signature")
+	}
+
+	if time.Now().Unix() > expiry {
+		return errors.New("signature expired")
+	}
+
+	return nil
+}
+
+// signStream computes the hex-encoded HMAC of a stream ID and expiry timestamp
+func signStream(secret []byte, streamID, exp string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(streamID))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
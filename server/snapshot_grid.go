@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gridTile is one stream's latest frame placed in a snapshot grid
+type gridTile struct {
+	stream *Stream
+	frame  *Frame
+}
+
+// gridQueryInt parses an integer query parameter of the snapshot grid within [lo, hi]
+func gridQueryInt(c *gin.Context, name string, fallback, lo, hi int) (int, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, lo, hi)
+	}
+	return n, nil
+}
+
+// gridCaption shortens a stream ID to fit maxWidth pixels at the given scale, ending it with ".." when cut
+func gridCaption(streamID string, scale, maxWidth int) string {
+	if textWidth(streamID, scale) <= maxWidth {
+		return streamID
+	}
+	runes := []rune(streamID)
+	for n := len(runes) - 1; n > 0; n-- {
+		if caption := string(runes[:n]) + ".."; textWidth(caption, scale) <= maxWidth {
+			return caption
+		}
+	}
+	return ""
+}
+
+// renderSnapshotGrid composites the tiles into a grid of cols columns. Each cell is tileWidth wide and
+// 3:4 as high, with the frame fitted inside it and the stream ID burned into a caption bar below.
+func renderSnapshotGrid(tiles []gridTile, cols, tileWidth int) *image.RGBA {
+	scale := max(tileWidth/GridCaptionScaleWidth, 1)
+	captionHeight := glyphHeight*scale + 2*GridCaptionPadding
+	cellHeight := tileWidth * 3 / 4
+	rows := (len(tiles) + cols - 1) / cols
+	cols = min(cols, len(tiles))
+
+	grid := image.NewRGBA(image.Rect(0, 0, cols*tileWidth, rows*(cellHeight+captionHeight)))
+	draw.Draw(grid, grid.Bounds(), &image.Uniform{color.RGBA{R: 16, G: 16, B: 16, A: 255}}, image.Point{}, draw.Src)
+
+	for i, tile := range tiles {
+		x0 := (i % cols) * tileWidth
+		y0 := (i / cols) * (cellHeight + captionHeight)
+
+		// Fit the frame into the cell keeping its aspect ratio, centred on the dark background
+		s := tile.stream
+		tw, th := tileWidth, s.height*tileWidth/s.width
+		if th > cellHeight {
+			tw, th = s.width*cellHeight/s.height, cellHeight
+		}
+		tw, th = max(tw, 1), max(th, 1)
+		img := downscale(tile.frame.data, s.width, s.height, s.pixelFormat, tw, th)
+		at := image.Pt(x0+(tileWidth-tw)/2, y0+(cellHeight-th)/2)
+		draw.Draw(grid, img.Bounds().Add(at), img, image.Point{}, draw.Src)
+
+		caption := gridCaption(s.streamID, scale, tileWidth-2*GridCaptionPadding)
+		captionY := y0 + cellHeight + GridCaptionPadding
+		drawText(caption, x0+GridCaptionPadding, captionY, scale, func(x, y int) {
+			if x < x0+tileWidth && y < captionY+glyphHeight*scale {
+				grid.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			}
+		})
+	}
+	return grid
+}
+
+// handleSnapshotGrid returns a JPEG montage of the latest frame of every stream the caller may view,
+// ordered by stream ID, as a lightweight video wall. Streams without a frame yet are skipped; tiles
+// beyond MaxGridTiles or MaxGridDimension are left out and counted in X-Grid-Truncated.
+func (sm *StreamManager) handleSnapshotGrid(c *gin.Context) {
+	cols, err := gridQueryInt(c, "cols", DefaultGridColumns, 1, MaxGridColumns)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	tileWidth, err := gridQueryInt(c, "w", DefaultGridTileWidth, MinGridTileWidth, MaxThumbnailDimension)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	quality, err := gridQueryInt(c, "q", ThumbnailJPEGQuality, 1, 100)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	if cols*tileWidth > MaxGridDimension {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("cols x w must not exceed %d pixels", MaxGridDimension), nil)
+		return
+	}
+
+	sm.mu.RLock()
+	streams := make([]*Stream, 0, len(sm.streams))
+	for streamID, stream := range sm.streams {
+		if canView(c, streamID) {
+			streams = append(streams, stream)
+		}
+	}
+	sm.mu.RUnlock()
+	sort.Slice(streams, func(i, j int) bool { return streams[i].streamID < streams[j].streamID })
+
+	tiles := make([]gridTile, 0, len(streams))
+	skipped := 0
+	for _, stream := range streams {
+		frame := stream.frameCache.latest()
+		if frame == nil {
+			skipped++
+			continue
+		}
+		tiles = append(tiles, gridTile{stream: stream, frame: frame})
+	}
+	if len(tiles) == 0 {
+		respondError(c, http.StatusServiceUnavailable, CodeFrameUnavailable, "No stream has a frame yet", gin.H{"skipped": skipped})
+		return
+	}
+
+	// Bound the tiles, and the rows so the image stays within MaxGridDimension pixels high
+	scale := max(tileWidth/GridCaptionScaleWidth, 1)
+	rowHeight := tileWidth*3/4 + glyphHeight*scale + 2*GridCaptionPadding
+	limit := min(MaxGridTiles, cols*(MaxGridDimension/rowHeight))
+	truncated := max(len(tiles)-limit, 0)
+	tiles = tiles[:len(tiles)-truncated]
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, renderSnapshotGrid(tiles, cols, tileWidth), &jpeg.Options{Quality: quality}); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("failed to encode snapshot grid: %v", err), nil)
+		return
+	}
+
+	c.Header("X-Grid-Tiles", strconv.Itoa(len(tiles)))
+	c.Header("X-Grid-Skipped", strconv.Itoa(skipped))
+	c.Header("X-Grid-Truncated", strconv.Itoa(truncated))
+	c.Header("Cache-Control", fmt.Sprintf("max-age=%d", int(ThumbnailCacheTTL/time.Second)))
+	c.Data(http.StatusOK, "image/jpeg", buf.Bytes())
+}
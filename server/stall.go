@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// validateMinExpectedFPS checks min_expected_fps; 0 keeps the default stall detection
+func validateMinExpectedFPS(o *StreamOptions) error {
+	if o.MinExpectedFPS < 0 {
+		return fmt.Errorf("min_expected_fps must not be negative")
+	}
+	if o.MinExpectedFPS > 0 && o.stallThreshold(MaxStallDuration) > MaxStallThreshold {
+		return fmt.Errorf("min_expected_fps must be at least %.4g (one frame per %s)", StallFrameIntervals/MaxStallThreshold.Seconds(), MaxStallThreshold/StallFrameIntervals)
+	}
+	return nil
+}
+
+// stallThreshold is how long the stream may go without a frame before the health monitor restarts its
+// ingest: minimum (MaxStallDuration), lengthened for a source slower than one frame per half of that to
+// StallFrameIntervals of its min_expected_fps, so slideshow-like cameras aren't restarted between frames
+func (o StreamOptions) stallThreshold(minimum time.Duration) time.Duration {
+	if o.MinExpectedFPS <= 0 {
+		return minimum
+	}
+	threshold := time.Duration(StallFrameIntervals / o.MinExpectedFPS * float64(time.Second))
+	return max(threshold, minimum)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStallThreshold(t *testing.T) {
+	tests := []struct {
+		minFPS  float64
+		minimum time.Duration
+		want    time.Duration
+	}{
+		// Without min_expected_fps the stall window is the minimum
+		{minFPS: 0, minimum: MaxStallDuration, want: MaxStallDuration},
+		// Sources fast enough for the minimum keep it
+		{minFPS: 25, minimum: MaxStallDuration, want: MaxStallDuration},
+		{minFPS: 1, minimum: MaxStallDuration, want: MaxStallDuration},
+		{minFPS: StallFrameIntervals / MaxStallDuration.Seconds(), minimum: MaxStallDuration, want: MaxStallDuration},
+		// Slower ones get StallFrameIntervals of their frame interval
+		{minFPS: 0.1, minimum: MaxStallDuration, want: 20 * time.Second},
+		{minFPS: 0.01, minimum: MaxStallDuration, want: 200 * time.Second},
+		{minFPS: StallFrameIntervals / MaxStallThreshold.Seconds(), minimum: MaxStallDuration, want: MaxStallThreshold},
+		// The minimum is the health monitor's stall timeout, shortened in tests
+		{minFPS: 2, minimum: 100 * time.Millisecond, want: time.Second},
+		{minFPS: 50, minimum: 100 * time.Millisecond, want: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		o := StreamOptions{MinExpectedFPS: tt.minFPS}
+		if got := o.stallThreshold(tt.minimum); got != tt.want {
+			t.Errorf("min_expected_fps %v: stallThreshold(%s) = %s, want %s", tt.minFPS, tt.minimum, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		minFPS float64
+		ok     bool
+	}{
+		{0, true},
+		{0.1, true},
+		{StallFrameIntervals / MaxStallThreshold.Seconds(), true},
+		{-1, false},
+		// A frame every 40 minutes would need a stall window over MaxStallThreshold
+		{1.0 / 2400, false},
+	} {
+		if err := validateMinExpectedFPS(&StreamOptions{MinExpectedFPS: tt.minFPS}); (err == nil) != tt.ok {
+			t.Errorf("validateMinExpectedFPS(%v) = %v, want ok %v", tt.minFPS, err, tt.ok)
+		}
+	}
+}
+
+// TestSlowSourceNotRestarted runs a one-frame-a-second source with a 100ms stall timeout: with a
+// min_expected_fps it may go a second between frames and is left alone, while the same source
+// without one is restarted for stalling
+func TestSlowSourceNotRestarted(t *testing.T) {
+	ts := newTestServer(t, 1, shortenHealthChecks(100*time.Millisecond))
+	ts.startStream(t, map[string]interface{}{
+		"stream_id":        "slow",
+		"rtsp_url":         "rtsp://camera.example/slow",
+		"width":            16,
+		"height":           16,
+		"min_expected_fps": 1, // a 2s stall threshold, twice the gap between frames
+	})
+	ts.startStream(t, map[string]interface{}{
+		"stream_id": "impatient",
+		"rtsp_url":  "rtsp://camera.example/impatient",
+		"width":     16,
+		"height":    16,
+	})
+	slow := ts.stream(t, "slow")
+	waitFor(t, 5*time.Second, "frames from the slow source", func() bool { return slow.frameCount.Load() > 0 })
+	pid := slow.ffmpegPID.Load()
+
+	restarts := func(streamID string) (n int) {
+		for _, entry := range ts.sm.audit.query(time.Time{}, streamID) {
+			if entry.Type == "ffmpeg_restart" {
+				n++
+			}
+		}
+		return n
+	}
+	waitFor(t, 10*time.Second, "the stream without min_expected_fps to be restarted", func() bool { return restarts("impatient") > 0 })
+
+	// Three more frame intervals of the slow source, longer than its stall threshold
+	frames := slow.frameCount.Load()
+	time.Sleep(3 * time.Second)
+	if n := slow.frameCount.Load() - frames; n < 2 {
+		t.Errorf("the slow source sent %d frames in 3s, want a frame a second", n)
+	}
+	if n := restarts("slow"); n != 0 {
+		t.Errorf("the slow source was restarted %d times within its min_expected_fps", n)
+	}
+	if got := slow.ffmpegPID.Load(); got != pid {
+		t.Errorf("the slow source's FFmpeg changed from pid %d to %d", pid, got)
+	}
+	if status := ts.status(t, "slow")["status"]; status != StatusRunning {
+		t.Errorf("slow source status = %v, want %s", status, StatusRunning)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// startQueue caps how many streams run an ingest FFmpeg at once (MAX_FFMPEG_PROCESSES). A stream takes a
+// slot when it starts or resumes and keeps it through restarts until it is paused or its ingest stops;
+// streams started while every slot is taken wait in the queued status, first come first served, in a
+// queue of at most START_QUEUE_SIZE. Recorder, audio and TS output processes and clones don't take slots.
+type startQueue struct {
+	mu      sync.Mutex
+	limit   int // 0 means unlimited
+	size    int
+	holders map[*Stream]struct{}
+	waiting []*Stream
+	granted map[*Stream]chan struct{} // closed when a waiting stream gets its slot
+}
+
+func newStartQueue(limit, size int) *startQueue {
+	return &startQueue{
+		limit:   limit,
+		size:    size,
+		holders: make(map[*Stream]struct{}),
+		granted: make(map[*Stream]chan struct{}),
+	}
+}
+
+// enqueue gives stream a slot, or queues it when all are taken, and reports whether it was queued. It
+// fails with ErrStartQueueFull when the queue is full as well.
+func (q *startQueue) enqueue(stream *Stream) (bool, error) {
+	if q.limit == 0 {
+		return false, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, held := q.holders[stream]; held {
+		return false, nil
+	}
+	if len(q.holders) < q.limit {
+		q.holders[stream] = struct{}{}
+		return false, nil
+	}
+	if len(q.waiting) >= q.size {
+		return false, fmt.Errorf("%w: all %d FFmpeg slots are taken and %d start(s) are already waiting", ErrStartQueueFull, q.limit, len(q.waiting))
+	}
+	q.waiting = append(q.waiting, stream)
+	q.granted[stream] = make(chan struct{})
+	return true, nil
+}
+
+// wait blocks until stream holds its slot, returning false if ctx is cancelled first
+func (q *startQueue) wait(ctx context.Context, stream *Stream) bool {
+	q.mu.Lock()
+	granted, queued := q.granted[stream]
+	q.mu.Unlock()
+	if !queued {
+		return true
+	}
+
+	select {
+	case <-granted:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release gives up stream's slot, or its place in the queue, and hands freed slots to the streams at the
+// front of the queue
+func (q *startQueue) release(stream *Stream) {
+	if q.limit == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.holders, stream)
+	if _, queued := q.granted[stream]; queued {
+		delete(q.granted, stream)
+		for i, waiting := range q.waiting {
+			if waiting == stream {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for len(q.holders) < q.limit && len(q.waiting) > 0 {
+		next := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.holders[next] = struct{}{}
+		close(q.granted[next])
+		delete(q.granted, next)
+		log.Printf("Stream %s got an FFmpeg slot after waiting in the start queue", next.streamID)
+	}
+}
+
+// position is stream's 1-based place in the queue, or 0 when it isn't waiting
+func (q *startQueue) position(stream *Stream) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, waiting := range q.waiting {
+		if waiting == stream {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// info reports how many slots are in use and how many starts are waiting
+func (q *startQueue) info() map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return map[string]interface{}{
+		"limit":      q.limit,
+		"active":     len(q.holders),
+		"queued":     len(q.waiting),
+		"queue_size": q.size,
+	}
+}
+
+// queuePosition is the start queue position of the stream with the given ID, or 0 when it isn't queued
+func (sm *StreamManager) queuePosition(streamID string) int {
+	sm.mu.RLock()
+	stream, exists := sm.streams[streamID]
+	sm.mu.RUnlock()
+	if !exists {
+		return 0
+	}
+	return stream.queuePosition()
+}
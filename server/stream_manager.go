@@ -3,91 +3,471 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"os/exec"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 // NewStreamManager creates a new instance of StreamManager
-func NewStreamManager() *StreamManager {
-	return &StreamManager{
-		streams: make(map[string]*Stream),
-		clients: make(map[string]map[string]*Client),
+func NewStreamManager(cfg Config, runner CommandRunner) *StreamManager {
+	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
+	sm := &StreamManager{
+		streams:        make(map[string]*Stream),
+		clients:        make(map[string]map[string]*Client),
+		config:         cfg,
+		runner:         runner,
+		ffmpeg:         &ffmpegHealth{ttl: cfg.FFmpegCheckInterval, runner: runner},
+		shutdownCtx:    shutdownCtx,
+		shutdownCancel: shutdownCancel,
+		clipEncodes:    newFrameLimiter(ClipEncodeLimit),
+		startQueue:     newStartQueue(cfg.MaxFFmpegProcesses, cfg.StartQueueSize),
+		tasks:          newTaskTracker(),
+		audit:          newAuditLog(cfg.AuditLogSize),
+		webhooks:       newWebhookDispatcher(cfg),
+		healthInterval: HealthCheckInterval,
+		stallTimeout:   MaxStallDuration,
 	}
+	sm.webhooks.start(sm.tasks)
+	if cfg.FrameRatePerIP > 0 {
+		sm.frameRateLimit = newIPRateLimiter(cfg.FrameRatePerIP)
+	}
+	return sm
+}
+
+// generateClientID generates a client ID that stays unique across server restarts.
+// The ID embeds the creation time (base36 unix millis) followed by 64 random bits.
+func generateClientID(now time.Time) string {
+	var random [8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		// crypto/rand should never fail; fall back to the nanosecond clock for the random part
+		binary.BigEndian.PutUint64(random[:], uint64(now.UnixNano()))
+	}
+	return fmt.Sprintf("client_%s_%s", strconv.FormatInt(now.UnixMilli(), 36), hex.EncodeToString(random[:]))
+}
+
+// normalize applies default output parameters and validates the requested pixel format
+func (o *StreamOptions) normalize() error {
+	if o.Width == 0 {
+		o.Width = DefaultWidth
+	}
+	if o.Height == 0 {
+		o.Height = DefaultHeight
+	}
+	if o.Width < 0 || o.Height < 0 {
+		return fmt.Errorf("%w %dx%d", ErrInvalidResolution, o.Width, o.Height)
+	}
+	if o.PixelFormat == "" {
+		o.PixelFormat = DefaultPixelFormat
+	}
+	if _, ok := pixelFormats[o.PixelFormat]; !ok {
+		return fmt.Errorf("%w %q (supported: bgr24, rgb24, gray, yuv420p)", ErrUnsupportedPixelFormat, o.PixelFormat)
+	}
+	// yuv420p subsamples chroma 2x2, so both dimensions must be even
+	if o.PixelFormat == "yuv420p" && (o.Width%2 != 0 || o.Height%2 != 0) {
+		return fmt.Errorf("%w: pixel format yuv420p requires even width and height, got %dx%d", ErrInvalidResolution, o.Width, o.Height)
+	}
+	if err := validateScaleFlags(o.ScaleFlags); err != nil {
+		return err
+	}
+	if err := validateHWAccel(o.HWAccel); err != nil {
+		return err
+	}
+	if o.FFmpegLogLevel != "" {
+		if err := validateFFmpegLogLevel(o.FFmpegLogLevel); err != nil {
+			return err
+		}
+	}
+	if o.GOP < 0 || o.GOP > MaxGOP {
+		return fmt.Errorf("gop must be between 0 (FFmpeg default) and %d frames", MaxGOP)
+	}
+	inputOpts, err := validateInputOpts(o.FFmpegInputOpts)
+	if err != nil {
+		return err
+	}
+	// Store canonical values so equivalent requests compare equal (5000000 vs "5000000")
+	for key, value := range inputOpts {
+		o.FFmpegInputOpts[key] = value
+	}
+	if o.DropPolicy == "" {
+		o.DropPolicy = DropOldest
+	}
+	if err := validateDropPolicy(o.DropPolicy); err != nil {
+		return err
+	}
+	if err := validateProcessors(o.Processors); err != nil {
+		return err
+	}
+	if err := validateMetadata(o.Metadata); err != nil {
+		return err
+	}
+	if _, err := parseMaxDuration(o.MaxDuration); err != nil {
+		return err
+	}
+	if _, err := parseIdleTimeout(o.IdleTimeout); err != nil {
+		return err
+	}
+	if err := validateSchedulingOptions(o); err != nil {
+		return err
+	}
+	if err := validatePacing(o); err != nil {
+		return err
+	}
+	if err := validateTimestampSource(o); err != nil {
+		return err
+	}
+	if err := validateMinExpectedFPS(o); err != nil {
+		return err
+	}
+	return validateMotionOptions(o)
 }
 
-// generateClientID generates a unique client ID
-func (sm *StreamManager) generateClientID() string {
-	sm.clientIDGen++
-	return fmt.Sprintf("client_%d", sm.clientIDGen)
+// frameSize returns the size in bytes of a single raw frame for this stream
+func (s *Stream) frameSize() int {
+	return int(float64(s.width*s.height) * s.bytesPerPixel)
+}
+
+// lastFrameAt returns when the last frame was read from FFmpeg, or the zero time if none has been
+func (s *Stream) lastFrameAt() time.Time {
+	nanos := s.lastFrameTime.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
 }
 
 // StartStream starts a new RTSP stream ingestion
-func (sm *StreamManager) StartStream(streamID, rtspURL string, width, height int) error {
+func (sm *StreamManager) StartStream(streamID, rtspURL string, opts StreamOptions) error {
+	if err := opts.normalize(); err != nil {
+		return err
+	}
+
+	// Resolved before taking the lock: checking the hwaccel may run FFmpeg
+	runner, err := sm.runnerFor(opts.FFmpegBinary)
+	if err != nil {
+		return err
+	}
+	if err := checkHWAccel(runner, opts.HWAccel); err != nil {
+		return err
+	}
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
 	if _, exists := sm.streams[streamID]; exists {
-		return fmt.Errorf("stream %s already exists", streamID)
+		return fmt.Errorf("%w: %s", ErrStreamExists, streamID)
+	}
+	if sm.config.MaxStreams > 0 && len(sm.streams) >= sm.config.MaxStreams {
+		return fmt.Errorf("%w: at most %d streams may run at once", ErrStreamLimit, sm.config.MaxStreams)
+	}
+
+	_, inputURLs, err := resolveInputURLs(rtspURL, opts.RTSPURLs)
+	if err != nil {
+		return err
+	}
+	if err := sm.checkInputs(inputURLs); err != nil {
+		return err
+	}
+	if opts.Loop {
+		if err := checkLoopInputs(inputURLs); err != nil {
+			return err
+		}
+	}
+
+	inputOpts, err := validateInputOpts(opts.FFmpegInputOpts)
+	if err != nil {
+		return err
+	}
+
+	processors, err := buildProcessors(opts.Processors, opts.PixelFormat)
+	if err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	stream := &Stream{
-		rtspURL:        rtspURL,
-		streamID:       streamID,
-		frameBuffer:    make(chan []byte, 100), // Buffer up to 100 frames
-		clients:        make(map[string]*Client),
-		cancelFunc:     cancel,
-		isRunning:      false,
-		healthStopChan: make(chan struct{}),
+	stream := sm.newStream(streamID, rtspURL, opts, cancel)
+	stream.inputURLs = inputURLs
+	stream.gop = opts.GOP
+	stream.scaleFlags = opts.ScaleFlags
+	stream.inputOpts = inputOpts
+	stream.processors = processors
+	stream.sched = sm.schedulingFor(opts)
+	stream.runner = runner
+	stream.placeholderOnStall = opts.PlaceholderOnStall
+	if opts.MotionDetection {
+		stream.motion = newMotionDetector(opts)
+	}
+	if opts.Audio {
+		stream.audio = newAudioIngest()
+	}
+	if opts.Record {
+		if stream.recorder, err = sm.newRecorder(streamID); err != nil {
+			cancel()
+			return err
+		}
+	}
+	if opts.LocalSocketPath != "" {
+		path, err := resolveLocalSocketPath(sm.config.LocalSocketDir, opts.LocalSocketPath)
+		if err == nil {
+			stream.local, err = sm.openLocalSocket(stream, path)
+		}
+		if err != nil {
+			cancel()
+			return err
+		}
+	}
+
+	queued, err := sm.startQueue.enqueue(stream)
+	if err != nil {
+		if stream.local != nil {
+			stream.local.close()
+		}
+		cancel()
+		return err
+	}
+	if queued {
+		stream.status = StatusQueued
 	}
 
-	sm.streams[streamID] = stream
-	sm.clients[streamID] = make(map[string]*Client)
+	sm.registerLocked(stream)
 
-	go sm.runFFmpegStream(ctx, stream, width, height)
-	go sm.distributeFrames(stream)
-	go sm.monitorStreamHealth(stream, width, height)
+	sm.tasks.goTask("ingest loop for stream "+streamID, func() { sm.runFFmpegStream(ctx, stream) })
+	sm.tasks.goTask("frame distribution for stream "+streamID, func() { sm.distributeFrames(stream) })
+	sm.tasks.goTask("health monitor for stream "+streamID, func() { sm.monitorStreamHealth(stream) })
+	if stream.motion != nil {
+		go stream.motion.run(stream)
+	}
+	if stream.audio != nil {
+		go stream.audio.run(stream)
+	}
+	if stream.recorder != nil {
+		sm.tasks.goTask("recorder for stream "+streamID, func() { sm.runRecorder(stream) })
+	}
 
-	log.Printf("Started stream %s from %s", streamID, rtspURL)
+	if queued {
+		log.Printf("Queued stream %s from %s at position %d; all %d FFmpeg slots are taken", streamID, rtspURL, stream.queuePosition(), sm.config.MaxFFmpegProcesses)
+		return nil
+	}
+	log.Printf("Started stream %s from %s (%dx%d %s)", streamID, rtspURL, opts.Width, opts.Height, opts.PixelFormat)
 	return nil
 }
 
-// runFFmpegStream runs FFmpeg to capture RTSP stream and output raw frames
-func (sm *StreamManager) runFFmpegStream(ctx context.Context, stream *Stream, width, height int) {
+// newStream creates a stream in the connecting state with the output geometry of opts, its frame buffer,
+// caches and event hub; the caller sets up what feeds it frames
+func (sm *StreamManager) newStream(streamID, rtspURL string, opts StreamOptions, cancel context.CancelFunc) *Stream {
+	stream := &Stream{
+		rtspURL:         rtspURL,
+		opts:            opts,
+		streamID:        streamID,
+		width:           opts.Width,
+		height:          opts.Height,
+		pixelFormat:     opts.PixelFormat,
+		bytesPerPixel:   pixelFormats[opts.PixelFormat],
+		metadata:        opts.Metadata,
+		frameBuffer:     make(chan *Frame, 100), // Buffer up to 100 frames
+		frameCache:      newFrameCache(sm.config.FrameCacheSize, sm.config.FrameCacheWindow),
+		newFrames:       newFrameNotifier(),
+		ts:              newTSOutput(),
+		tasks:           sm.tasks,
+		frameRequests:   newFrameLimiter(sm.config.FrameRequestLimit),
+		encoded:         newEncodeCache(sm.config.EncodeCacheSize),
+		clients:         make(map[string]*Client),
+		cancelFunc:      cancel,
+		isRunning:       false,
+		status:          StatusConnecting,
+		statusChangedAt: time.Now(),
+		ready:           make(chan struct{}),
+		healthStopChan:  make(chan struct{}),
+		events:          newEventHub(),
+		ingestRate:      newRateMeter(IngestRateWindow),
+		startQueue:      sm.startQueue,
+		breaker:         newCircuitBreaker(sm.config.BreakerThreshold, sm.config.BreakerCooldown),
+	}
+	stream.logLevel = opts.FFmpegLogLevel
+	if stream.logLevel == "" {
+		stream.logLevel = sm.config.FFmpegLogLevel
+	}
+	stream.events.tap = sm.streamEventTap
+	return stream
+}
+
+// registerLocked adds a new stream to the manager and arms its max_duration and idle_timeout; the
+// caller holds sm.mu
+func (sm *StreamManager) registerLocked(stream *Stream) {
+	sm.streams[stream.streamID] = stream
+	sm.clients[stream.streamID] = make(map[string]*Client)
+	if maxDuration, _ := parseMaxDuration(stream.opts.MaxDuration); maxDuration > 0 {
+		sm.scheduleStop(stream, maxDuration)
+	}
+	stream.idleTimeout, _ = parseIdleTimeout(stream.opts.IdleTimeout)
+	stream.touch(time.Now())
+}
+
+// existingStreamDiff compares a start request against the stream already running under streamID.
+// It returns the JSON names of the parameters that differ and whether the stream exists at all.
+func (sm *StreamManager) existingStreamDiff(streamID, rtspURL string, opts StreamOptions) ([]string, bool) {
+	sm.mu.RLock()
+	stream, exists := sm.streams[streamID]
+	sm.mu.RUnlock()
+
+	if !exists {
+		return nil, false
+	}
+
+	mismatches := make([]string, 0)
+	if stream.rtspURL != rtspURL {
+		mismatches = append(mismatches, "rtsp_url")
+	}
+
+	existing := reflect.ValueOf(stream.opts)
+	requested := reflect.ValueOf(opts)
+	for i := 0; i < existing.NumField(); i++ {
+		a, b := existing.Field(i), requested.Field(i)
+		// A nil and an empty map or slice mean the same thing in a request
+		if (a.Kind() == reflect.Map || a.Kind() == reflect.Slice) && a.Len() == 0 && b.Len() == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			name := strings.Split(existing.Type().Field(i).Tag.Get("json"), ",")[0]
+			mismatches = append(mismatches, name)
+		}
+	}
+	return mismatches, true
+}
+
+// runFFmpegStream runs FFmpeg to capture RTSP stream and output raw frames, retrying with a
+// capped exponential backoff and failing over to the next input URL after repeated failures.
+// While the stream's circuit breaker is open no FFmpeg process is started.
+func (sm *StreamManager) runFFmpegStream(ctx context.Context, stream *Stream) {
+	if !sm.startQueue.wait(ctx, stream) {
+		return
+	}
+	failures := 0
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			err := sm.startFFmpeg(ctx, stream, width, height)
-			if err != nil {
-				log.Printf("FFmpeg error for stream %s: %v", stream.streamID, err)
-				time.Sleep(2 * time.Second) // Wait before retry
+		}
+
+		if !sm.waitForBreaker(ctx, stream) {
+			return
+		}
+		stream.beginConnect()
+
+		framesBefore := stream.frameCount.Load()
+
+		err := sm.startFFmpeg(ctx, stream)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("FFmpeg exited")
+		}
+
+		// A run that delivered frames was a working connection, so the backoff starts over
+		if stream.frameCount.Load() > framesBefore {
+			failures = 0
+		}
+		failures++
+
+		log.Printf("FFmpeg error for stream %s: %v", stream.streamID, err)
+		// Not running while backing off, so the health monitor doesn't restart us and reset the backoff
+		stream.mu.Lock()
+		stream.isRunning = false
+		stream.ffmpegUp = false
+		stream.mu.Unlock()
+
+		if stream.frameCount.Load() == framesBefore && stream.breaker.recordFailure(time.Now()) == BreakerOpen {
+			log.Printf("Circuit breaker opened for stream %s after repeated failures; retrying in %s", stream.streamID, sm.config.BreakerCooldown)
+			stream.setStatus(StatusFailed, err.Error())
+			stream.publishBreakerState(BreakerOpen)
+			// The wait happens in waitForBreaker at the top of the loop
+			continue
+		}
+		stream.setStatus(StatusReconnecting, err.Error())
+
+		delay := restartDelay(failures)
+		if failures >= FailoverThreshold && len(stream.inputURLs) > 1 {
+			// Each source gets a fresh retry budget; after cycling through all of them, back off before the primary
+			failures = 0
+			if stream.failover(err) {
+				delay = FailoverCycleBackoff
+			} else {
+				delay = FFmpegRestartDelay
 			}
 		}
+		delay = sm.withJitter(delay)
+
+		sm.audit.record(AuditEntry{
+			Type:     "ffmpeg_restart",
+			StreamID: stream.streamID,
+			Details:  map[string]interface{}{"reason": err.Error(), "attempt": failures, "delay": delay.String()},
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// waitForBreaker blocks while the stream's circuit breaker is open, returning false if ctx is cancelled
+func (sm *StreamManager) waitForBreaker(ctx context.Context, stream *Stream) bool {
+	for {
+		wait, halfOpened := stream.breaker.admit(time.Now())
+		if halfOpened {
+			log.Printf("Circuit breaker half-open for stream %s, trying one FFmpeg run", stream.streamID)
+			stream.publishBreakerState(BreakerHalfOpen)
+		}
+		if wait == 0 {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
 	}
 }
 
 // startFFmpeg initializes and starts the FFmpeg process for a stream
-func (sm *StreamManager) startFFmpeg(ctx context.Context, stream *Stream, width, height int) error {
-	// FFmpeg command to convert RTSP to raw BGR24 frames
-	args := []string{
-		"-rtsp_transport", "tcp",
-		"-i", stream.rtspURL,
-		"-vf", fmt.Sprintf("scale=%d:%d", width, height),
+func (sm *StreamManager) startFFmpeg(ctx context.Context, stream *Stream) error {
+	// FFmpeg command to convert RTSP to raw frames in the requested pixel format
+	args := ffmpegLogArgs(stream.ffmpegLogLevel())
+	args = append(args, hwaccelArgs(stream.opts.HWAccel)...)
+	args = append(args, stream.ingestInputArgs()...)
+	args = append(args, "-vf", stream.ingestFilter())
+	if stream.opts.TimestampSource == TimestampPTS {
+		// Keep every frame as decoded, so the frames written out are exactly those showinfo reported
+		args = append(args, "-fps_mode", "passthrough")
+	}
+	args = append(args,
 		"-f", "rawvideo",
-		"-pix_fmt", "bgr24",
+		"-pix_fmt", stream.pixelFormat,
 		"-an", // No audio
 		"-",
-	}
+	)
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	// Background context: cancellation goes through stopFFmpeg so FFmpeg can flush before exiting
+	cmd := stream.runner.CommandContext(context.Background(), args...)
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("failed to get stdout pipe: %v", err)
@@ -100,94 +480,307 @@ func (sm *StreamManager) startFFmpeg(ctx context.Context, stream *Stream, width,
 
 	stream.mu.Lock()
 	stream.cmd = cmd
-	stream.isRunning = true
+	stream.ffmpegBinary, stream.ffmpegArgs, stream.ffmpegStartedAt = cmd.Path, args, time.Now()
+	// isRunning waits for the first frame: FFmpeg often starts fine and exits moments later
+	stream.ffmpegUp = true
 	stream.mu.Unlock()
 
 	// Start FFmpeg
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start FFmpeg: %v", err)
 	}
+	stream.sched.apply(stream.streamID, cmd)
+	untrack := stream.trackFFmpeg("video", cmd)
+	pid := int64(cmd.Process.Pid)
+	stream.ffmpegPID.Store(pid)
 
-	// Read stderr in a separate goroutine for logging
+	// Reap the process once reading is finished; exited lets stopFFmpeg know it has gone
+	exited := make(chan struct{})
+	defer func() {
+		cmd.Wait()
+		close(exited)
+		// A restart may already have started the next FFmpeg, whose pid must stay
+		stream.ffmpegPID.CompareAndSwap(pid, 0)
+		untrack()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopFFmpeg(cmd, exited)
+		case <-exited:
+		}
+	}()
+
+	// Read stderr in a separate goroutine for logging, watching for an unexpected output size and for
+	// the source changing resolution
+	desync := make(chan error, 1)
+	stderrDone := make(chan struct{})
+	var clock *ptsClock
+	if stream.opts.TimestampSource == TimestampPTS {
+		clock = newPTSClock(&stream.ptsStats)
+	}
 	go func() {
+		defer close(stderrDone)
+		var parser ffmpegLogParser
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
-			log.Printf("FFmpeg [%s]: %s", stream.streamID, scanner.Text())
+			level, line := parseFFmpegLogLine(scanner.Text())
+			// showinfo logs every frame; its lines feed the PTS clock instead of the log
+			if clock != nil {
+				if sample, ok := parseShowinfo(line); ok {
+					clock.offer(sample)
+					continue
+				}
+			}
+			stream.logFFmpegLine(level, line)
+
+			// FFmpeg exits on its own; this only makes the reported reason say why
+			if stream.opts.HWAccel != "" && stream.opts.HWAccel != "none" && hwaccelFailure(line) {
+				select {
+				case desync <- fmt.Errorf("%w: %s: %s", ErrHWAccelUnavailable, stream.opts.HWAccel, strings.TrimSpace(line)):
+				default:
+				}
+			}
+
+			// A new run may connect to a source that changed while disconnected; that needs no restart
+			if width, height, fps, ok := parser.inputGeometry(line); ok {
+				stream.updateSource(width, height, fps)
+			}
+			// Mid-stream, restart so the scaler is rebuilt for the new geometry instead of reconfiguring
+			// on the fly, which not every filter supports
+			if width, height, ok := sourceChange(line); ok && stream.updateSource(width, height, 0) {
+				select {
+				case desync <- fmt.Errorf("%w: now %dx%d", ErrSourceChanged, width, height):
+				default:
+				}
+				stopFFmpeg(cmd, exited)
+			}
+
+			if width, height, ok := parser.outputGeometry(line); ok {
+				if err := stream.checkOutputGeometry(width, height); err != nil {
+					select {
+					case desync <- err:
+					default:
+					}
+					// Unblock the frame reader; every frame from here on would be misaligned
+					stopFFmpeg(cmd, exited)
+				}
+			}
 		}
 	}()
 
 	// Read frames from stdout
-	frameSize := width * height * 3 // BGR24 = 3 bytes per pixel
-	frameData := make([]byte, frameSize)
+	frameData := make([]byte, stream.frameSize())
+	firstFrame := true
+
+	// Optional global cap on how often frames enter the buffer, whatever the source frame rate
+	var minInterval time.Duration
+	if sm.config.MaxIngestFPS > 0 {
+		minInterval = time.Duration(float64(time.Second) / sm.config.MaxIngestFPS)
+	}
+	var lastInserted time.Time
+	var framesRead int64 // this run's frames so far, matching showinfo's frame numbers
 
 	for {
 		select {
 		case <-ctx.Done():
-			cmd.Process.Kill()
+			// The cancellation watcher is already stopping FFmpeg
 			return nil
 		default:
-			_, err := io.ReadFull(stdout, frameData)
+			n, err := io.ReadFull(stdout, frameData)
 			if err != nil {
+				// FFmpeg is gone; give its last stderr lines a moment to explain why
+				select {
+				case <-stderrDone:
+				case <-time.After(FFmpegStderrDrainTimeout):
+				}
+			}
+			select {
+			case desyncErr := <-desync:
+				return desyncErr
+			default:
+			}
+			if err != nil {
+				if err == io.ErrUnexpectedEOF {
+					// FFmpeg stopped mid-frame, so its output wasn't a whole number of frames
+					return fmt.Errorf("%w: FFmpeg exited after %d of %d frame bytes", ErrFrameDesync, n, len(frameData))
+				}
 				if err != io.EOF {
 					log.Printf("Error reading frame from stream %s: %v", stream.streamID, err)
 				}
 				return err
 			}
 
+			now := time.Now()
+			timestamp, fromPTS := now, false
+			if clock != nil {
+				timestamp, fromPTS = clock.timestamp(framesRead, now)
+			}
+			framesRead++
+			if minInterval > 0 && now.Sub(lastInserted) < minInterval {
+				// Over the ingest cap: the frame still proves FFmpeg is alive, but isn't buffered
+				stream.lastFrameTime.Store(now.UnixNano())
+				stream.cappedFrames.Add(1)
+				continue
+			}
+			lastInserted = now
+
 			// Create frame with metadata
-			frame := make([]byte, len(frameData))
-			copy(frame, frameData)
+			data := make([]byte, len(frameData))
+			copy(data, frameData)
+			if len(stream.processors) > 0 {
+				// A failed processor drops the frame rather than delivering it unprocessed (e.g. unredacted)
+				if data, err = stream.processFrame(data); err != nil {
+					stream.processorErrs.Add(1)
+					log.Printf("Dropping frame for stream %s: %v", stream.streamID, err)
+					continue
+				}
+			}
+			// frame_count doubles as the sequence number of the latest frame
+			frame := &Frame{data: data, timestamp: timestamp, seq: stream.frameCount.Add(1), fromPTS: fromPTS}
+			stream.frameCache.add(frame)
+			stream.newFrames.notify()
 
-			// Improved buffer: drop oldest frame if full
-			select {
-			case stream.frameBuffer <- frame:
-				stream.mu.Lock()
-				stream.lastFrameTime = time.Now()
-				stream.frameCount++
-				stream.mu.Unlock()
-			default:
-				// Buffer full, drop oldest frame and insert new
-				select {
-				case <-stream.frameBuffer:
-				default:
+			if firstFrame {
+				firstFrame = false
+				stream.markFramesFlowing()
+				if stream.breaker.recordSuccess() == BreakerClosed {
+					log.Printf("Circuit breaker closed for stream %s", stream.streamID)
+					stream.publishBreakerState(BreakerClosed)
 				}
-				stream.frameBuffer <- frame
-				stream.mu.Lock()
-				stream.lastFrameTime = time.Now()
-				stream.frameCount++
-				stream.mu.Unlock()
-				log.Printf("Frame buffer full for stream %s, dropped oldest frame", stream.streamID)
+			}
+
+			stream.enqueueFrame(ctx, frame)
+			if stream.local != nil {
+				stream.local.publish(frame)
+			}
+			stream.feedClones(frame)
+
+			// Counters are atomic so the per-frame hot path never takes stream.mu
+			stream.lastFrameTime.Store(now.UnixNano())
+			stream.ingestRate.mark()
+			stream.currentFPS.mark(now)
+
+			if stream.motion != nil {
+				stream.motion.offer(frame)
 			}
 		}
 	}
 }
 
+// ffmpegStopSignal asks FFmpeg to exit cleanly. Windows can't deliver it, so stopFFmpeg kills FFmpeg
+// outright there.
+var ffmpegStopSignal os.Signal = syscall.SIGTERM
+
+// stopFFmpeg asks FFmpeg to exit with SIGTERM so it can flush its outputs, and kills it if it's
+// still running after GracefulShutdownDelay. exited must be closed once the process has been reaped.
+func stopFFmpeg(cmd *exec.Cmd, exited <-chan struct{}) {
+	if err := cmd.Process.Signal(ffmpegStopSignal); err != nil {
+		if errors.Is(err, os.ErrProcessDone) {
+			return
+		}
+		// The platform can't signal FFmpeg, and nothing else ends it
+		log.Printf("Could not signal FFmpeg (pid %d) to exit: %v; killing it", cmd.Process.Pid, err)
+		cmd.Process.Kill()
+		return
+	}
+
+	timer := time.NewTimer(GracefulShutdownDelay)
+	defer timer.Stop()
+
+	select {
+	case <-exited:
+	case <-timer.C:
+		log.Printf("FFmpeg (pid %d) did not exit within %s of SIGTERM, killing it", cmd.Process.Pid, GracefulShutdownDelay)
+		cmd.Process.Kill()
+	}
+}
+
 // distributeFrames sends frames from buffer to all connected clients
 func (sm *StreamManager) distributeFrames(stream *Stream) {
 	defer log.Printf("Frame distribution stopped for stream %s", stream.streamID)
 
-	for frame := range stream.frameBuffer {
-		stream.clientsMu.RLock()
-		clients := make([]*Client, 0, len(stream.clients))
-		for _, client := range stream.clients {
-			clients = append(clients, client)
+	if stream.opts.Pacing {
+		sm.distributePaced(stream)
+		return
+	}
+	for {
+		select {
+		case <-stream.healthStopChan:
+			return
+		case frame := <-stream.frameBuffer:
+			stream.broadcast(frame)
+			stream.outputJitter.mark(time.Now())
 		}
-		stream.clientsMu.RUnlock()
+	}
+}
 
-		// Send frame to all clients
-		for _, client := range clients {
-			// Check if client is still active before sending
-			client.mu.Lock()
-			if !client.closed {
-				select {
-				case client.send <- frame:
-				default:
-					// Client buffer full, skip
-					log.Printf("Client %s buffer full, skipping frame", client.id)
+// broadcast sends a frame to every connected client of the stream without blocking. Large audiences are
+// split into shards delivered concurrently by at most GOMAXPROCS goroutines, so a client whose lock is
+// busy only holds up its own shard. Every shard finishes before the next frame, keeping per-client order.
+func (s *Stream) broadcast(frame *Frame) {
+	s.clientsMu.RLock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.clientsMu.RUnlock()
+
+	if len(clients) <= BroadcastShardSize {
+		s.deliver(frame, clients)
+		return
+	}
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for start := 0; start < len(clients); start += BroadcastShardSize {
+		shard := clients[start:min(start+BroadcastShardSize, len(clients))]
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(shard []*Client) {
+			defer wg.Done()
+			s.deliver(frame, shard)
+			<-workers
+		}(shard)
+	}
+	wg.Wait()
+}
+
+// deliver queues a frame for each of the given clients without blocking
+func (s *Stream) deliver(frame *Frame, clients []*Client) {
+	for _, client := range clients {
+		// Check if client is still active before sending
+		client.mu.Lock()
+		if !client.closed && (!client.opts.OnMotion || client.motionGateLocked(s, frame)) && client.wantsFrameLocked(frame) {
+			select {
+			case <-client.done:
+			case client.send <- frame:
+				client.lastQueued = frame.timestamp
+				client.noteQueuedLocked()
+			default:
+				if client.opts.Ack {
+					client.skipAckClientLocked(frame)
+					break
+				}
+				// An auto-buffered client that keeps filling up gets a bigger buffer, with room for this frame
+				if client.growOnFullLocked(time.Now()) {
+					client.queueLocked(frame)
+					client.lastQueued = frame.timestamp
+					break
+				}
+				// Client buffer full, skip. A stuck client skips every frame, so log only occasionally
+				if skipped := client.framesSkipped.Add(1); skipped == 1 || skipped%100 == 0 {
+					log.Printf("Client %s buffer full, skipped %d frames so far", client.id, skipped)
+				}
+				// One that stays stuck is dropped; RemoveClient takes sm.mu, so not on the delivery path
+				if stalled, drop := client.writeStalledLocked(time.Now()); drop {
+					// The drop runs after the loop has moved on, so it needs its own copy of the client
+					client := client
+					client.manager.tasks.goTask("drop stalled client "+client.id, func() { client.dropStalled(stalled) })
 				}
 			}
-			client.mu.Unlock()
 		}
+		client.mu.Unlock()
 	}
 }
 
@@ -198,30 +791,74 @@ func (sm *StreamManager) StopStream(streamID string) error {
 
 	stream, exists := sm.streams[streamID]
 	if !exists {
-		return fmt.Errorf("stream %s not found", streamID)
+		return fmt.Errorf("%w: %s", ErrStreamNotFound, streamID)
 	}
 
-	// Cancel the context to stop FFmpeg
-	stream.cancelFunc()
+	sm.stopLocked(stream)
+	return nil
+}
 
-	// Stop health monitor
-	close(stream.healthStopChan)
+// stopLocked tears down a registered stream; the caller holds sm.mu
+func (sm *StreamManager) stopLocked(stream *Stream) {
+	streamID := stream.streamID
+
+	// A stream stopped before its max_duration no longer needs the lifetime timer
+	if stream.stopTimer != nil {
+		stream.stopTimer.Stop()
+	}
+	if stream.drainTimer != nil {
+		stream.drainTimer.Stop()
+	}
+
+	if len(stream.cloneList()) > 0 {
+		// Clones still derive their frames from this stream's ingest, so FFmpeg and the health monitor
+		// keep running until the last clone stops; the stream itself goes away now
+		stream.retired = true
+		stream.mu.RLock()
+		previous := stream.status
+		stream.mu.RUnlock()
+		stream.events.publish(StreamEvent{
+			"type":      "status",
+			"stream_id": streamID,
+			"status":    StatusStopped,
+			"previous":  previous,
+			"detail":    "ingest kept running for clones",
+		})
+	} else {
+		sm.stopIngestLocked(stream)
+		// Announce the final state
+		stream.setStatus(StatusStopped, "")
+	}
+	if stream.parent != nil {
+		sm.detachCloneLocked(stream)
+	}
+
+	// The recording ends with the stream even when clones keep its ingest running
+	if stream.recorder != nil {
+		stream.recorder.stop()
+	}
 
-	// Wait a bit for FFmpeg to stop gracefully
-	time.Sleep(100 * time.Millisecond)
+	// Disconnect event subscribers
+	stream.events.close()
 
-	// Close frame buffer
-	close(stream.frameBuffer)
+	// Stop the MPEG-TS writer and end its responses
+	stream.ts.close()
+
+	// Disconnect local socket consumers and remove the socket file
+	if stream.local != nil {
+		stream.local.close()
+	}
 
-	// Disconnect all clients safely
+	// frameBuffer is never closed: the FFmpeg read loop may still be draining output after SIGTERM, and
+	// distributeFrames exits on healthStopChan instead
+
+	// Disconnect all clients; their send channels are never closed, so late broadcasts can't panic.
+	// writePump sends the close frame and closes the connection, so even a client added an instant
+	// before the stop learns why rather than seeing the connection drop.
 	for _, client := range sm.clients[streamID] {
-		client.mu.Lock()
-		if !client.closed {
-			client.closed = true
-			close(client.send)
+		if client.closeWith(websocket.CloseGoingAway, "stream stopped") {
+			sm.auditClientDisconnected(client, "stream stopped")
 		}
-		client.mu.Unlock()
-		client.conn.Close()
 	}
 
 	// Cleanup
@@ -229,27 +866,46 @@ func (sm *StreamManager) StopStream(streamID string) error {
 	delete(sm.clients, streamID)
 
 	log.Printf("Stopped stream %s", streamID)
-	log.Printf("Frame distribution stopped for stream %s", streamID)
-	return nil
 }
 
-// AddClient adds a new WebSocket client to a stream
-func (sm *StreamManager) AddClient(streamID string, conn *websocket.Conn) (*Client, error) {
+// stopIngestLocked stops the health monitor, then cancels the context to stop FFmpeg. Marking the
+// stream stopped in the same critical section keeps a restart the monitor already decided on from
+// relaunching ingest.
+func (sm *StreamManager) stopIngestLocked(stream *Stream) {
+	close(stream.healthStopChan)
+	stream.mu.Lock()
+	stream.stopped = true
+	stream.cancelFunc()
+	stream.mu.Unlock()
+	sm.startQueue.release(stream)
+}
+
+// AddClient adds a new WebSocket client to the stream the connection was checked and upgraded against.
+// It fails with ErrStreamGone when that stream was stopped meanwhile, even if another stream has since
+// started under the same ID: the client negotiated this stream's frame format. Holding sm.mu orders it
+// against stopLocked, so a client is either added before a stop, which then disconnects it, or not at all.
+func (sm *StreamManager) AddClient(stream *Stream, conn *websocket.Conn, opts ClientOptions) (*Client, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	stream, exists := sm.streams[streamID]
-	if !exists {
-		return nil, fmt.Errorf("stream %s not found", streamID)
+	streamID := stream.streamID
+	if current, exists := sm.streams[streamID]; !exists || current != stream {
+		return nil, fmt.Errorf("%w: %s", ErrStreamGone, streamID)
+	}
+	if stream.draining {
+		return nil, fmt.Errorf("%w: %s", ErrStreamDraining, streamID)
 	}
 
-	clientID := sm.generateClientID()
-	client := &Client{
-		id:       clientID,
-		streamID: streamID,
-		conn:     conn,
-		send:     make(chan []byte, 10), // Buffer up to 10 frames per client
-		manager:  sm,
+	client := sm.newClient(stream, conn, opts)
+	clientID := client.id
+
+	// Queue the latest frame before the client is visible to broadcast, so it is the first one sent
+	if opts.Prime && !opts.OnMotion {
+		if latest := stream.frameCache.latest(); latest != nil {
+			client.send <- latest
+			client.lastQueued = latest.timestamp
+			client.primedSeq = latest.seq
+		}
 	}
 
 	stream.clientsMu.Lock()
@@ -258,32 +914,64 @@ func (sm *StreamManager) AddClient(streamID string, conn *websocket.Conn) (*Clie
 
 	sm.clients[streamID][clientID] = client
 
-	go client.writePump()
-	go client.readPump()
+	sm.tasks.goTask("writer for client "+clientID, client.writePump)
+	sm.tasks.goTask("reader for client "+clientID, client.readPump)
+
+	stream.publishClientCount()
 
 	log.Printf("Added client %s to stream %s", clientID, streamID)
 	return client, nil
 }
 
-// RemoveClient removes a client from a stream
-func (sm *StreamManager) RemoveClient(client *Client) {
+// newClient creates a client of stream on conn; the caller makes it visible to broadcast and starts its pumps
+func (sm *StreamManager) newClient(stream *Stream, conn *websocket.Conn, opts ClientOptions) *Client {
+	connectedAt := time.Now()
+	clientID := generateClientID(connectedAt)
+	client := &Client{
+		id:          clientID,
+		streamID:    stream.streamID,
+		conn:        conn,
+		send:        make(chan *Frame, 10), // Buffer up to 10 frames per client
+		manager:     sm,
+		connectedAt: connectedAt,
+		opts:        opts,
+		auto:        autoBuffer{enabled: opts.AutoBuffer},
+		resized:     make(chan struct{}, 1),
+		acked:       make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	client.lastSuccessfulWrite.Store(connectedAt.UnixNano())
+	stream.mu.RLock()
+	client.srcWidth, client.srcHeight, client.pixelFormat = stream.width, stream.height, stream.pixelFormat
+	stream.mu.RUnlock()
+
+	return client
+}
+
+// RemoveClient removes a client from a stream, recording why it left in the audit log
+func (sm *StreamManager) RemoveClient(client *Client, reason string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	// Protect against double removal
-	client.mu.Lock()
-	if client.closed {
-		client.mu.Unlock()
+	// Protect against double removal, including by StopStream
+	if !client.markClosed() {
 		return
 	}
-	client.closed = true
-	client.mu.Unlock()
 
 	if stream, exists := sm.streams[client.streamID]; exists {
 		stream.clientsMu.Lock()
 		delete(stream.clients, client.id)
+		remaining := len(stream.clients)
 		stream.clientsMu.Unlock()
 
+		stream.touch(time.Now())
+		stream.publishClientCount()
+
+		// A draining stream stops with its last client
+		if stream.draining && remaining == 0 {
+			sm.finishDrainLocked(stream, "clients_left")
+		}
+
 		// Auto-cleanup: if no clients left, optionally stop the stream
 		// This is commented out to prevent automatic cleanup, but can be enabled if desired
 		/*
@@ -300,9 +988,7 @@ func (sm *StreamManager) RemoveClient(client *Client) {
 	}
 
 	delete(sm.clients[client.streamID], client.id)
-
-	// Safely close the send channel
-	close(client.send)
+	sm.auditClientDisconnected(client, reason)
 
 	log.Printf("Removed client %s from stream %s", client.id, client.streamID)
 }
@@ -314,51 +1000,226 @@ func (sm *StreamManager) GetStreamStats(streamID string) (map[string]interface{}
 
 	stream, exists := sm.streams[streamID]
 	if !exists {
-		return nil, fmt.Errorf("stream %s not found", streamID)
+		return nil, fmt.Errorf("%w: %s", ErrStreamNotFound, streamID)
 	}
 
+	queued, deepest := stream.clientQueueDepths()
+	clientQueue := map[string]interface{}{"total": queued, "max": deepest}
+	pacing := stream.pacingInfo(sm.config.MaxIngestFPS) // takes stream.mu itself
+	recording := stream.recorder.infoOrNil(time.Now())  // reads the recording directory
+
 	stream.mu.RLock()
 	stats := map[string]interface{}{
-		"stream_id":       streamID,
-		"rtsp_url":        stream.rtspURL,
-		"is_running":      stream.isRunning,
-		"frame_count":     stream.frameCount,
-		"last_frame_time": stream.lastFrameTime,
-		"client_count":    len(stream.clients),
-		"buffer_size":     len(stream.frameBuffer),
+		"stream_id":         streamID,
+		"rtsp_url":          stream.rtspURL,
+		"input_urls":        stream.inputURLs,
+		"active_url":        stream.inputURLs[stream.activeURL],
+		"width":             stream.width,
+		"height":            stream.height,
+		"pixel_format":      stream.pixelFormat,
+		"bytes_per_pixel":   stream.bytesPerPixel,
+		"gop":               stream.gop,
+		"scale_flags":       stream.scaleFlags,
+		"frame_size":        stream.frameSize(),
+		"ffmpeg_input_opts": stream.inputOpts,
+		"metadata":          stream.metadataOrEmpty(),
+		"status":            stream.status,
+		"queue_position":    stream.queuePosition(),
+		"state_changed_at":  stream.statusChangedAt,
+		"is_running":        stream.isRunning,
+		"paused":            stream.paused,
+		"motion_enabled":    stream.motion != nil,
+		"placeholder":       stream.placeholderActive,
+		"frame_count":       stream.frameCount.Load(),
+		"drop_policy":       stream.opts.DropPolicy,
+		"processors":        stream.opts.Processors,
+		"processor_errors":  stream.processorErrs.Load(),
+		"dropped_frames":    stream.droppedFrames.Load(),
+		"last_frame_time":   stream.lastFrameAt(),
+		"client_count":      len(stream.clients),
+		"client_queue":      clientQueue,
+		"buffer_size":       len(stream.frameBuffer),
+		"buffer_pressure":   stream.bufferPressure.Load(),
+		"buffer_high_water": stream.highWaterMark(sm.config.BufferHighWater),
+		"current_fps":       stream.currentFPS.rate(time.Now()),
+		"ingest_fps":        stream.ingestRate.rate(),
+		"max_ingest_fps":    sm.config.MaxIngestFPS,
+		"pacing":            pacing,
+		"timestamps":        stream.timestampInfo(),
+		"stall_threshold":   stream.opts.stallThreshold(sm.stallTimeout).Round(time.Millisecond).String(),
+		"capped_frames":     stream.cappedFrames.Load(),
+		"encode_cache":      stream.encoded.stats(),
+		"frame_requests":    stream.frameRequests.stats(),
+		"audio":             stream.audioStatus(),
+		"ts_clients":        stream.ts.listenerCount(),
+		"recording":         recording,
+		"idle_timeout":      stream.opts.IdleTimeout,
+		"idle_expires_at":   stream.idleExpiresAtOrNil(),
+		"last_keepalive":    stream.lastKeepaliveOrNil(),
+		"loop":              stream.opts.Loop,
+		"hwaccel":           hwaccelOrNone(stream.opts.HWAccel),
+		"ffmpeg_log_level":  stream.logLevel,
+		"ffmpeg_binary":     stream.opts.FFmpegBinary,
+		"source":            stream.sourceLocked(),
+		"local_socket":      stream.local.statsOrNil(),
+		"ffmpeg_scheduling": stream.sched.info(),
+		"breaker":           stream.breaker.info(),
+		"max_duration":      stream.opts.MaxDuration,
+		"expires_at":        stream.expiresAtOrNil(),
+		"draining":          stream.draining,
+		"drain_deadline":    stream.drainDeadlineOrNil(),
+	}
+	if stream.parent != nil {
+		stats["clone_of"] = stream.parent.streamID
+		stats["clone_fps"] = stream.cloneFPS
+	} else {
+		stats["clones"] = stream.cloneIDs()
 	}
 	stream.mu.RUnlock()
 
 	return stats, nil
 }
 
-// monitorStreamHealth checks if frames are being received and restarts FFmpeg if stalled
-func (sm *StreamManager) monitorStreamHealth(stream *Stream, width, height int) {
-	const healthCheckInterval = 5 * time.Second
-	const maxStallDuration = 10 * time.Second
-	ticker := time.NewTicker(healthCheckInterval)
+// monitorStreamHealth checks if frames are being received and restarts FFmpeg once none have arrived
+// for the stream's stall threshold
+func (sm *StreamManager) monitorStreamHealth(stream *Stream) {
+	stallThreshold := stream.opts.stallThreshold(sm.stallTimeout)
+	ticker := time.NewTicker(sm.healthInterval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-stream.healthStopChan:
 			return
-		case <-ticker.C:
+		case now := <-ticker.C:
+			sm.checkBufferPressure(stream, now)
+			sm.checkIdle(stream, now)
+
+			lastFrame := stream.lastFrameAt()
 			stream.mu.RLock()
-			lastFrame := stream.lastFrameTime
-			running := stream.isRunning
+			ingesting := stream.ffmpegUp
+			paused := stream.paused
+			// A run that hasn't produced a frame yet gets a full stall window from its start
+			if stream.ffmpegStartedAt.After(lastFrame) {
+				lastFrame = stream.ffmpegStartedAt
+			}
 			stream.mu.RUnlock()
-			if running && time.Since(lastFrame) > maxStallDuration {
+			if ingesting && !paused && time.Since(lastFrame) > stallThreshold {
 				log.Printf("Health monitor: Stream %s stalled, restarting FFmpeg", stream.streamID)
-				// Restart FFmpeg by cancelling and starting again
-				stream.cancelFunc()
-				// Create new context and cancelFunc
-				ctx, cancel := context.WithCancel(context.Background())
-				stream.mu.Lock()
-				stream.cancelFunc = cancel
-				stream.isRunning = false
-				stream.mu.Unlock()
-				go sm.runFFmpegStream(ctx, stream, width, height)
+				stream.setStatus(StatusStalled, "")
+				if stream.placeholderOnStall {
+					go sm.deliverPlaceholder(stream)
+				}
+				// A stall counts against the breaker; if it opens, the restarted ingest waits out the cooldown
+				if stream.breaker.recordFailure(now) == BreakerOpen {
+					log.Printf("Circuit breaker opened for stream %s after repeated stalls; retrying in %s", stream.streamID, sm.config.BreakerCooldown)
+					stream.setStatus(StatusFailed, "stalled repeatedly")
+					stream.publishBreakerState(BreakerOpen)
+				}
+				// A stop may have raced this check; restartIngest then leaves the stream alone
+				if sm.restartIngest(stream) {
+					sm.audit.record(AuditEntry{
+						Type:     "ffmpeg_restart",
+						StreamID: stream.streamID,
+						Details:  map[string]interface{}{"reason": "stalled", "stalled_for": time.Since(lastFrame).Round(time.Millisecond).String()},
+					})
+				}
 			}
 		}
 	}
 }
+
+// restartIngest cancels the current FFmpeg run for a stream and launches a fresh one, reporting
+// false when the stream was paused or stopped in the meantime
+func (sm *StreamManager) restartIngest(stream *Stream) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream.mu.Lock()
+	if stream.paused || stream.stopped {
+		// A pause or stop raced with this restart; leave ingest stopped. stopLocked sets stopped and
+		// cancels under stream.mu, so a restart either sees it or has its context cancelled by it.
+		stream.mu.Unlock()
+		cancel()
+		return false
+	}
+	stream.cancelFunc()
+	stream.cancelFunc = cancel
+	stream.isRunning = false
+	stream.ffmpegUp = false
+	// Give the new FFmpeg process a full stall window before the health monitor intervenes
+	stream.lastFrameTime.Store(time.Now().UnixNano())
+	stream.mu.Unlock()
+
+	sm.tasks.goTask("ingest loop for stream "+stream.streamID, func() { sm.runFFmpegStream(ctx, stream) })
+	return true
+}
+
+// PauseStream stops ingesting frames for a stream while keeping the stream and its clients alive
+func (sm *StreamManager) PauseStream(streamID string) error {
+	sm.mu.RLock()
+	stream, exists := sm.streams[streamID]
+	sm.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrStreamNotFound, streamID)
+	}
+	if err := stream.errIfClone("ingest"); err != nil {
+		return err
+	}
+
+	stream.mu.Lock()
+	if stream.paused {
+		stream.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrStreamAlreadyPaused, streamID)
+	}
+
+	// Cancelling the context kills FFmpeg; the Stream, its buffer and clients stay in place
+	stream.paused = true
+	stream.isRunning = false
+	stream.ffmpegUp = false
+	stream.cancelFunc()
+	stream.mu.Unlock()
+	sm.startQueue.release(stream)
+
+	stream.setStatus(StatusPaused, "")
+
+	log.Printf("Paused stream %s", streamID)
+	return nil
+}
+
+// ResumeStream relaunches ingest for a previously paused stream
+func (sm *StreamManager) ResumeStream(streamID string) error {
+	sm.mu.RLock()
+	stream, exists := sm.streams[streamID]
+	sm.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrStreamNotFound, streamID)
+	}
+	if err := stream.errIfClone("ingest"); err != nil {
+		return err
+	}
+
+	stream.mu.Lock()
+	if !stream.paused {
+		stream.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrStreamNotPaused, streamID)
+	}
+	// A resumed stream needs an FFmpeg slot like a new one; when the queue is full it stays paused
+	queued, err := sm.startQueue.enqueue(stream)
+	if err != nil {
+		stream.mu.Unlock()
+		return err
+	}
+	stream.paused = false
+	stream.mu.Unlock()
+
+	if queued {
+		stream.setStatus(StatusQueued, "")
+		log.Printf("Resumed stream %s; waiting for an FFmpeg slot at position %d", streamID, stream.queuePosition())
+	} else {
+		stream.setStatus(StatusConnecting, "")
+		log.Printf("Resumed stream %s", streamID)
+	}
+	sm.restartIngest(stream)
+	return nil
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// waitForStreamTasks waits until only the webhook workers, which live as long as the manager, are left
+// of the manager's tasks: every FFmpeg process and every stream and client goroutine has finished
+func waitForStreamTasks(t testing.TB, sm *StreamManager, timeout time.Duration) {
+	t.Helper()
+	var left []string
+	deadline := time.Now().Add(timeout)
+	for {
+		left = left[:0]
+		for _, name := range sm.tasks.pending() {
+			if !strings.HasPrefix(name, "webhook worker") {
+				left = append(left, name)
+			}
+		}
+		if len(left) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still running %s after the stop: %s", timeout, strings.Join(left, "; "))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestStopStreamWhileClientsDisconnect force-stops streams while frames are flowing and clients are
+// coming and going, exercising stopLocked against deliver and closeWith; run it with -race
+func TestStopStreamWhileClientsDisconnect(t *testing.T) {
+	ts := newTestServer(t, 100, nil)
+	rounds := 20
+	if testing.Short() {
+		rounds = 5
+	}
+
+	for round := 0; round < rounds; round++ {
+		streamID := fmt.Sprintf("stress-%d", round)
+		ts.startStream(t, map[string]interface{}{
+			"stream_id": streamID,
+			"rtsp_url":  "rtsp://camera.example/" + streamID,
+			"width":     16,
+			"height":    16,
+		})
+
+		waitFor(t, 5*time.Second, "frames on "+streamID, func() bool { return ts.status(t, streamID)["is_running"] == true })
+
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			conn, _, err := ts.dial(t, streamID, "")
+			if err != nil {
+				t.Fatalf("round %d: dial client %d: %v", round, i, err)
+			}
+			wg.Add(1)
+			go func(i int, conn *websocket.Conn) {
+				defer wg.Done()
+				leaveAfter := time.Duration(rand.Intn(30)) * time.Millisecond
+				conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+				start := time.Now()
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+							t.Errorf("round %d: client %d still open 10s after the stop", round, i)
+						}
+						return
+					}
+					// A third of the clients leave on their own, some politely and some by dropping the connection
+					if i%3 == 0 && time.Since(start) > leaveAfter {
+						if i%2 == 0 {
+							conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+						}
+						conn.Close()
+						return
+					}
+				}
+			}(i, conn)
+		}
+
+		time.Sleep(time.Duration(rand.Intn(40)) * time.Millisecond)
+		if status := ts.do(t, http.MethodDelete, "/api/streams/"+streamID+"/force", nil, nil); status != http.StatusOK {
+			t.Fatalf("round %d: force stop: status %d", round, status)
+		}
+		wg.Wait()
+	}
+
+	waitForStreamTasks(t, ts.sm, 10*time.Second)
+	ts.sm.mu.RLock()
+	defer ts.sm.mu.RUnlock()
+	if len(ts.sm.streams) != 0 || len(ts.sm.clients) != 0 {
+		t.Errorf("%d stream(s) and %d client set(s) left after every stream was stopped", len(ts.sm.streams), len(ts.sm.clients))
+	}
+}
+
+// TestStopStreamDuringStallRestart stops streams at random moments around the health monitor restarting
+// their stalled ingest, racing StopStream's stopLocked against restartIngest, and checks that no ingest
+// loop or FFmpeg process outlives the stop; run it with -race
+func TestStopStreamDuringStallRestart(t *testing.T) {
+	ts := newTestServer(t, 50, shortenHealthChecks(300*time.Millisecond))
+	streams := 16
+	if testing.Short() {
+		streams = 4
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		pids = make(map[int64]bool)
+	)
+	for i := 0; i < streams; i++ {
+		streamID := fmt.Sprintf("stall-%d", i)
+		ts.startStream(t, map[string]interface{}{
+			"stream_id": streamID,
+			"rtsp_url":  "rtsp://camera.example/mock-stall",
+			"width":     16,
+			"height":    16,
+		})
+		stream := ts.stream(t, streamID)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// mock-stall stops sending a second after FFmpeg starts and the stall is noticed 300-375ms later,
+			// so stops land before, during and after the restart
+			stopAt := time.Now().Add(time.Second + time.Duration(rand.Intn(1000))*time.Millisecond)
+			for time.Now().Before(stopAt) {
+				if pid := stream.ffmpegPID.Load(); pid != 0 {
+					mu.Lock()
+					pids[pid] = true
+					mu.Unlock()
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if err := ts.sm.StopStream(streamID); err != nil {
+				t.Errorf("stop %s: %v", streamID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitForStreamTasks(t, ts.sm, 10*time.Second)
+	for pid := range pids {
+		if err := syscall.Kill(int(pid), 0); err != syscall.ESRCH {
+			t.Errorf("FFmpeg process %d still exists after its stream stopped (kill: %v)", pid, err)
+		}
+	}
+
+	restarted := make(map[string]bool)
+	for _, entry := range ts.sm.audit.query(time.Time{}, "") {
+		if entry.Type == "ffmpeg_restart" && entry.Details["reason"] == "stalled" {
+			restarted[entry.StreamID] = true
+		}
+	}
+	if len(restarted) == 0 {
+		t.Error("no stream was restarted for stalling before its stop; the race was not exercised")
+	}
+	t.Logf("%d of %d streams were restarted for stalling before their stop", len(restarted), streams)
+}
+
+// TestIngestRestartsOnSourceErrors checks that a source changing resolution mid-stream (ErrSourceChanged)
+// or FFmpeg dying mid-frame (ErrFrameDesync) restarts ingest, and that clients only ever get whole,
+// aligned frames across the restart
+func TestIngestRestartsOnSourceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		reason error
+	}{
+		{name: "source changed", input: "rtsp://camera.example/mock-resize", reason: ErrSourceChanged},
+		{name: "frame desync", input: "rtsp://camera.example/mock-desync", reason: ErrFrameDesync},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ts := newTestServer(t, 25, nil)
+			ts.startStream(t, map[string]interface{}{
+				"stream_id":    "source",
+				"rtsp_url":     tt.input,
+				"width":        32,
+				"height":       24,
+				"pixel_format": "yuv420p",
+			})
+			conn, _, err := ts.dial(t, "source", "")
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+
+			restarted := func() bool {
+				for _, entry := range ts.sm.audit.query(time.Time{}, "source") {
+					if reason, _ := entry.Details["reason"].(string); entry.Type == "ffmpeg_restart" && strings.Contains(reason, tt.reason.Error()) {
+						return true
+					}
+				}
+				return false
+			}
+
+			// Read until frames of the restarted FFmpeg, whose numbering starts over, checking every frame
+			last := -1
+			for frames := 0; ; frames++ {
+				frame := readFrame(t, conn, 10*time.Second)
+				checkMockFrame(t, frame, 32, 24, "yuv420p")
+				n := mockFrameNumber(frame, "yuv420p")
+				if n < last && restarted() {
+					break
+				}
+				last = n
+				if frames > 500 {
+					t.Fatalf("no restart for %v after %d frames", tt.reason, frames)
+				}
+			}
+			waitFor(t, 5*time.Second, "status running", func() bool { return ts.status(t, "source")["status"] == StatusRunning })
+		})
+	}
+}
+
+// startShell runs script under /bin/sh, waiting for it to print "ready", and returns it with the channel
+// closed once it has been reaped
+func startShell(t *testing.T, script string) (*exec.Cmd, <-chan struct{}) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to run a stand-in FFmpeg")
+	}
+	cmd := exec.Command("sh", "-c", script)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ready, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || ready != "ready\n" {
+		cmd.Process.Kill()
+		t.Fatalf("child did not get ready: %q, %v", ready, err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		<-exited
+	})
+	return cmd, exited
+}
+
+// unsupportedSignal is a signal os.Process.Signal refuses, as Windows refuses SIGTERM
+type unsupportedSignal struct{}
+
+func (unsupportedSignal) String() string { return "unsupported" }
+func (unsupportedSignal) Signal()        {}
+
+func TestStopFFmpeg(t *testing.T) {
+	t.Run("exits on SIGTERM", func(t *testing.T) {
+		// Like FFmpeg, the child finishes its work on SIGTERM and exits cleanly
+		cmd, exited := startShell(t, `trap 'exit 0' TERM; echo ready; while :; do sleep 0.05; done`)
+		start := time.Now()
+		stopFFmpeg(cmd, exited)
+		if elapsed := time.Since(start); elapsed >= GracefulShutdownDelay {
+			t.Errorf("stopFFmpeg took %s, want well under GracefulShutdownDelay", elapsed)
+		}
+		if status := cmd.ProcessState.Sys().(syscall.WaitStatus); !status.Exited() || status.ExitStatus() != 0 {
+			t.Errorf("child ended with %v, want a clean exit without SIGKILL", cmd.ProcessState)
+		}
+	})
+
+	t.Run("ignores SIGTERM", func(t *testing.T) {
+		cmd, exited := startShell(t, `trap '' TERM; echo ready; while :; do sleep 0.05; done`)
+		start := time.Now()
+		stopFFmpeg(cmd, exited)
+		elapsed := time.Since(start)
+		<-exited
+		if elapsed < GracefulShutdownDelay {
+			t.Errorf("child killed after %s, before GracefulShutdownDelay (%s)", elapsed, GracefulShutdownDelay)
+		}
+		if status := cmd.ProcessState.Sys().(syscall.WaitStatus); !status.Signaled() || status.Signal() != syscall.SIGKILL {
+			t.Errorf("child ended with %v, want SIGKILL", cmd.ProcessState)
+		}
+	})
+
+	t.Run("signal unsupported", func(t *testing.T) {
+		// Like SIGTERM on Windows, a signal the platform can't deliver must not leave FFmpeg running
+		defer func(signal os.Signal) { ffmpegStopSignal = signal }(ffmpegStopSignal)
+		ffmpegStopSignal = unsupportedSignal{}
+		cmd, exited := startShell(t, `trap '' TERM; echo ready; while :; do sleep 0.05; done`)
+		start := time.Now()
+		stopFFmpeg(cmd, exited)
+		select {
+		case <-exited:
+		case <-time.After(time.Second):
+			t.Fatal("child still running a second after stopFFmpeg could not signal it")
+		}
+		if elapsed := time.Since(start); elapsed >= GracefulShutdownDelay {
+			t.Errorf("child killed after %s, want at once", elapsed)
+		}
+		if status := cmd.ProcessState.Sys().(syscall.WaitStatus); !status.Signaled() || status.Signal() != syscall.SIGKILL {
+			t.Errorf("child ended with %v, want SIGKILL", cmd.ProcessState)
+		}
+	})
+
+	t.Run("already exited", func(t *testing.T) {
+		cmd, exited := startShell(t, `echo ready`)
+		<-exited
+		start := time.Now()
+		stopFFmpeg(cmd, exited)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("stopFFmpeg waited %s for a process that had already exited", elapsed)
+		}
+	})
+}
+
+// TestFailingSourceNeverRunning watches a stream whose FFmpeg fails to connect through its first retries:
+// no frame ever arrives, so it must never be reported running, neither by a poll nor by any status event
+func TestFailingSourceNeverRunning(t *testing.T) {
+	ts := newTestServer(t, 25, nil)
+	ts.startStream(t, map[string]interface{}{
+		"stream_id": "refused",
+		"rtsp_url":  "rtsp://camera.example/mock-fail",
+		"width":     16,
+		"height":    16,
+	})
+
+	failures := func() (n int) {
+		for _, entry := range ts.sm.audit.query(time.Time{}, "refused") {
+			if entry.Type == "status" && entry.Details["status"] == StatusReconnecting {
+				n++
+			}
+		}
+		return n
+	}
+	seen := make(map[interface{}]bool)
+	// The first retry follows FFmpegRestartDelay after the first failure
+	deadline := time.Now().Add(FFmpegRestartDelay + 5*time.Second)
+	for failures() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d failed connection(s) in %s, want 2", failures(), FFmpegRestartDelay+5*time.Second)
+		}
+		status := ts.status(t, "refused")
+		seen[status["status"]] = true
+		if status["status"] == StatusRunning || status["is_running"] == true {
+			t.Fatalf("stream of a refused source reported status %v, is_running %v", status["status"], status["is_running"])
+		}
+		var list struct {
+			Streams []map[string]interface{} `json:"streams"`
+		}
+		ts.do(t, http.MethodGet, "/api/streams?running=true", nil, &list)
+		if len(list.Streams) != 0 {
+			t.Fatalf("running streams = %v, want none", list.Streams)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !seen[StatusReconnecting] {
+		t.Errorf("polls saw statuses %v, never %s", seen, StatusReconnecting)
+	}
+
+	// Events catch a transition too brief for a poll
+	for _, entry := range ts.sm.audit.query(time.Time{}, "refused") {
+		if entry.Type == "status" && entry.Details["status"] == StatusRunning {
+			t.Errorf("status event %v for a source that never sent a frame", entry.Details)
+		}
+	}
+	if n := ts.stream(t, "refused").frameCount.Load(); n != 0 {
+		t.Errorf("frame_count = %d", n)
+	}
+}
+
+// BenchmarkStreamStats reads the stats of 50 streams while all of them ingest, on as many goroutines
+// as GOMAXPROCS: the full GetStreamStats map and the bare frame counters it and the health monitor
+// read. Each run also reports the ingest rate per stream, which readers must not hold back.
+func BenchmarkStreamStats(b *testing.B) {
+	const (
+		streams = 50
+		fps     = 50
+	)
+	ts := newTestServer(b, fps, nil)
+	ids := make([]string, streams)
+	all := make([]*Stream, streams)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("bench-%d", i)
+		ts.startStream(b, map[string]interface{}{
+			"stream_id": ids[i],
+			"rtsp_url":  "rtsp://camera.example/" + ids[i],
+			"width":     16,
+			"height":    16,
+		})
+		all[i] = ts.stream(b, ids[i])
+	}
+	waitFor(b, 10*time.Second, "every stream running", func() bool {
+		for _, stream := range all {
+			if stream.frameCount.Load() == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	ingested := func() (n int64) {
+		for _, stream := range all {
+			n += stream.frameCount.Load()
+		}
+		return n
+	}
+	run := func(b *testing.B, read func(i int64) error) {
+		var next atomic.Int64
+		before, start := ingested(), time.Now()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := read(next.Add(1)); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+		b.StopTimer()
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			b.ReportMetric(float64(ingested()-before)/elapsed.Seconds()/streams, "frames/s/stream")
+		}
+	}
+
+	b.Run("GetStreamStats", func(b *testing.B) {
+		run(b, func(i int64) error {
+			_, err := ts.sm.GetStreamStats(ids[i%streams])
+			return err
+		})
+	})
+	b.Run("counters", func(b *testing.B) {
+		run(b, func(i int64) error {
+			stream := all[i%streams]
+			if stream.frameCount.Load() < 0 || stream.lastFrameAt().IsZero() {
+				return fmt.Errorf("stream %s has no frames", stream.streamID)
+			}
+			return nil
+		})
+	})
+}
+
+// BenchmarkBroadcastTailLatency measures how long a broadcast takes to reach its fast clients when
+// some clients' locks are busy, e.g. held by a slow writePump or a stats reader. Slow clients are spread
+// over the shards. Reported are the p50 and p99 latency over every fast client's delivery, and the p99 and
+// max latency to the last fast client of each frame, which waits behind the slow clients of its shard.
+func BenchmarkBroadcastTailLatency(b *testing.B) {
+	for _, tt := range []struct{ clients, slow int }{
+		{clients: BroadcastShardSize, slow: 0},
+		{clients: BroadcastShardSize, slow: 1},
+		{clients: 256, slow: 0},
+		{clients: 256, slow: 4},
+		{clients: 256, slow: 16},
+	} {
+		tt := tt
+		b.Run(fmt.Sprintf("clients=%d/slow=%d", tt.clients, tt.slow), func(b *testing.B) {
+			benchmarkBroadcast(b, tt.clients, tt.slow)
+		})
+	}
+}
+
+func benchmarkBroadcast(b *testing.B, clients, slow int) {
+	fast := clients - slow
+	stream := &Stream{streamID: "bench", clients: make(map[string]*Client)}
+	var (
+		started    atomic.Int64 // unix nanoseconds the current broadcast began
+		pending    atomic.Int64 // fast clients yet to get the current frame
+		reached    = make(chan time.Duration, 1)
+		deliveries = make([]time.Duration, b.N*fast)
+		delivered  atomic.Int64
+		stop       = make(chan struct{})
+		wg         sync.WaitGroup
+	)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	for i := 0; i < clients; i++ {
+		client := &Client{id: fmt.Sprintf("client-%d", i), send: make(chan *Frame, ClientBufferSize)}
+		stream.clients[client.id] = client
+		isSlow := slow > 0 && i%(clients/slow) == 0
+
+		// Every client's queue is drained as its writePump would, timing each fast client's frame; the
+		// last fast client to get a frame reports the broadcast's latency to it
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-client.send:
+					if isSlow {
+						continue
+					}
+					latency := time.Since(time.Unix(0, started.Load()))
+					deliveries[delivered.Add(1)-1] = latency
+					if pending.Add(-1) == 0 {
+						reached <- latency
+					}
+				}
+			}
+		}()
+		if !isSlow {
+			continue
+		}
+		// A slow client's lock is held for a millisecond at a time
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				client.mu.Lock()
+				time.Sleep(time.Millisecond)
+				client.mu.Unlock()
+				time.Sleep(100 * time.Microsecond)
+			}
+		}()
+	}
+
+	tails := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pending.Store(int64(fast))
+		started.Store(time.Now().UnixNano())
+		stream.broadcast(&Frame{seq: int64(i + 1), timestamp: time.Now()})
+		tails = append(tails, <-reached)
+	}
+	b.StopTimer()
+
+	percentile := func(latencies []time.Duration, p float64) float64 {
+		slices.Sort(latencies)
+		return float64(latencies[int(p*float64(len(latencies)-1))].Microseconds())
+	}
+	b.ReportMetric(percentile(deliveries, 0.5), "p50-µs")
+	b.ReportMetric(percentile(deliveries, 0.99), "p99-µs")
+	b.ReportMetric(percentile(tails, 0.99), "last-p99-µs")
+	b.ReportMetric(percentile(tails, 1), "last-max-µs")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// thumbnailSize returns the thumbnail dimensions for a requested width, keeping the
+// stream's aspect ratio and capping both sides at MaxThumbnailDimension
+func thumbnailSize(width, height, requested int) (int, int) {
+	tw := requested
+	if tw > width {
+		tw = width
+	}
+	th := height * tw / width
+	if th > MaxThumbnailDimension {
+		th = MaxThumbnailDimension
+		tw = width * th / height
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	return tw, th
+}
+
+// encodeJPEG downscales a frame to the given size and encodes it as JPEG
+func (s *Stream) encodeJPEG(frame *Frame, width, height, quality int) ([]byte, error) {
+	img := downscale(frame.data, s.width, s.height, s.pixelFormat, width, height)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// handleGetThumbnail returns a small JPEG of the stream's most recent frame
+func (sm *StreamManager) handleGetThumbnail(c *gin.Context) {
+	width := DefaultThumbnailWidth
+	if raw := c.Query("w"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > MaxThumbnailDimension {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("w must be between 1 and %d", MaxThumbnailDimension), nil)
+			return
+		}
+		width = parsed
+	}
+
+	quality := ThumbnailJPEGQuality
+	if raw := c.Query("q"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > 100 {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "q must be between 1 and 100", nil)
+			return
+		}
+		quality = parsed
+	}
+
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+
+	tw, th := thumbnailSize(stream.width, stream.height, width)
+	key := encodeKey{width: tw, height: th, format: "jpeg", quality: quality}
+	now := time.Now()
+	generation := stream.frameCount.Load()
+
+	encoded, cached := stream.encoded.get(key, generation, now)
+	if !cached {
+		frame := stream.frameCache.latest()
+		if frame == nil {
+			respondError(c, http.StatusServiceUnavailable, CodeFrameUnavailable, "No frame available yet", nil)
+			return
+		}
+
+		data, err := stream.encodeJPEG(frame, tw, th, quality)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, err.Error(), nil)
+			return
+		}
+		encoded = &encodedFrame{key: key, data: data, generation: generation, frameTime: frame.timestamp, createdAt: now}
+		stream.encoded.put(encoded)
+	}
+
+	c.Header("X-Frame-Timestamp", strconv.FormatInt(encoded.frameTime.UnixNano(), 10))
+	c.Header("Cache-Control", fmt.Sprintf("max-age=%d", int(ThumbnailCacheTTL/time.Second)))
+	c.Data(http.StatusOK, "image/jpeg", encoded.data)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a TLS certificate from disk and picks up replacements without a restart, so
+// renewed certificates take effect on long-running servers
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time // newest modification time of the cert and key files when last loaded
+	lastCheck time.Time
+}
+
+// newCertReloader loads the certificate and key, failing if they can't be used
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate, reloading the files when they have changed.
+// The files are checked at most once per CertReloadInterval; a failed reload keeps the current certificate.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastCheck) < CertReloadInterval {
+		return r.cert, nil
+	}
+	r.lastCheck = now
+
+	modTime, err := r.filesModTime()
+	if err != nil {
+		log.Printf("TLS certificate check failed, keeping current certificate: %v", err)
+		return r.cert, nil
+	}
+	if modTime.After(r.modTime) {
+		if err := r.load(modTime); err != nil {
+			log.Printf("TLS certificate reload failed, keeping current certificate: %v", err)
+		} else {
+			log.Printf("Reloaded TLS certificate from %s", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
+
+// load parses the key pair; callers other than the constructor must hold r.mu
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.lastCheck = time.Now()
+	return nil
+}
+
+// filesModTime returns the newer of the cert and key modification times
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var newest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TSPacketSize is the size of one MPEG-TS packet; chunks are whole packets so listeners can join anywhere
+const TSPacketSize = 188
+
+// tsOutput remuxes a stream's source into MPEG-TS with a dedicated FFmpeg process that runs only while
+// at least one listener is connected, fanning the same bytes out to every listener
+type tsOutput struct {
+	mu        sync.Mutex
+	listeners map[chan []byte]struct{}
+	cancel    context.CancelFunc // non-nil while the FFmpeg writer is running
+	closed    bool
+}
+
+// newTSOutput creates an idle MPEG-TS output
+func newTSOutput() *tsOutput {
+	return &tsOutput{listeners: make(map[chan []byte]struct{})}
+}
+
+// listen registers a listener, starting the writer for the first one. The returned channel is closed
+// when the stream stops.
+func (t *tsOutput) listen(stream *Stream) chan []byte {
+	ch := make(chan []byte, TSListenerBufferSize)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		close(ch)
+		return ch
+	}
+	t.listeners[ch] = struct{}{}
+	if t.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.cancel = cancel
+		go t.run(ctx, stream)
+		log.Printf("Started MPEG-TS writer for stream %s", stream.streamID)
+	}
+	return ch
+}
+
+// unlisten removes a listener and closes its channel, stopping the writer after the last one leaves
+func (t *tsOutput) unlisten(stream *Stream, ch chan []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.listeners[ch]; !ok {
+		return
+	}
+	delete(t.listeners, ch)
+	close(ch)
+	if len(t.listeners) == 0 && t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+		log.Printf("Stopped MPEG-TS writer for stream %s: no listeners", stream.streamID)
+	}
+}
+
+// broadcast delivers a chunk to every listener, dropping it for listeners that are behind
+func (t *tsOutput) broadcast(chunk []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ch := range t.listeners {
+		select {
+		case ch <- chunk:
+		default:
+		}
+	}
+}
+
+// close stops the writer and disconnects all listeners when the stream stops
+func (t *tsOutput) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	for ch := range t.listeners {
+		close(ch)
+	}
+	t.listeners = nil
+}
+
+// listenerCount returns the number of connected MPEG-TS listeners
+func (t *tsOutput) listenerCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.listeners)
+}
+
+// run keeps the MPEG-TS FFmpeg process running, with backoff, until ctx is cancelled
+func (t *tsOutput) run(ctx context.Context, stream *Stream) {
+	failures := 0
+	for {
+		err := t.runFFmpeg(ctx, stream)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("FFmpeg exited")
+		}
+
+		failures++
+		log.Printf("MPEG-TS FFmpeg error for stream %s: %v", stream.streamID, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(restartDelay(failures)):
+		}
+	}
+}
+
+// runFFmpeg runs one FFmpeg process copying the source's video and, if present, audio into MPEG-TS
+// without re-encoding, so codec timestamps and NAL units pass through untouched
+func (t *tsOutput) runFFmpeg(ctx context.Context, stream *Stream) error {
+	args := ffmpegInputArgs(stream.currentURL(), stream.inputOpts, stream.opts.Loop)
+	args = append(args,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-c", "copy",
+		"-f", "mpegts",
+		"-",
+	)
+
+	// Background context: cancellation goes through stopFFmpeg so FFmpeg can flush before exiting
+	cmd := stream.runner.CommandContext(context.Background(), args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdout pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start FFmpeg: %v", err)
+	}
+	stream.sched.apply(stream.streamID, cmd)
+	untrack := stream.trackFFmpeg("MPEG-TS", cmd)
+
+	exited := make(chan struct{})
+	defer func() {
+		cmd.Wait()
+		close(exited)
+		untrack()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopFFmpeg(cmd, exited)
+		case <-exited:
+		}
+	}()
+
+	// Only whole packets are broadcast; a partial packet waits for the rest of its bytes
+	buf := make([]byte, TSChunkPackets*TSPacketSize)
+	pending := 0
+	for {
+		n, err := stdout.Read(buf[pending:])
+		pending += n
+		if whole := pending - pending%TSPacketSize; whole > 0 {
+			chunk := make([]byte, whole)
+			copy(chunk, buf[:whole])
+			t.broadcast(chunk)
+			pending = copy(buf, buf[whole:pending])
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// handleStreamTS serves the stream as a continuous MPEG-TS feed, playable with ffplay or VLC
+func (sm *StreamManager) handleStreamTS(c *gin.Context) {
+	stream, ok := sm.lookupStream(c)
+	if !ok {
+		return
+	}
+	// The feed remuxes the source, not the frames a clone derives from it
+	if err := stream.errIfClone("MPEG-TS feed"); err != nil {
+		respondManagerError(c, err)
+		return
+	}
+
+	chunks := stream.ts.listen(stream)
+	defer stream.ts.unlisten(stream, chunks)
+
+	c.Header("Content-Type", "video/mp2t")
+	c.Header("Cache-Control", "no-cache")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case chunk, ok := <-chunks:
+			if !ok {
+				return false
+			}
+			_, err := w.Write(chunk)
+			return err == nil
+		}
+	})
+}
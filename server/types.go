@@ -4,6 +4,7 @@ import (
 	"context"
 	"os/exec"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,40 +12,362 @@ import (
 
 // StreamManager manages multiple RTSP streams with single ingest per camera
 type StreamManager struct {
-	streams     map[string]*Stream
-	clients     map[string]map[string]*Client
-	mu          sync.RWMutex
-	clientIDGen int64
+	streams map[string]*Stream
+	clients map[string]map[string]*Client
+	mu      sync.RWMutex
+	config  Config
+	auth    *authStore // nil when AUTH_KEYS_FILE is unset and the API is open
+	ffmpeg  *ffmpegHealth
+	runner  CommandRunner // builds FFmpeg commands; the mock runner replaces FFmpeg with a test pattern
+
+	clipEncodes    *frameLimiter  // bounds concurrent clip encodes
+	startQueue     *startQueue    // FFmpeg slots and the streams waiting for one
+	tasks          *taskTracker   // goroutines and FFmpeg processes that Shutdown waits for
+	frameRateLimit *ipRateLimiter // per-IP limit on /frame requests; nil when FRAME_RATE_PER_IP is 0
+	audit          *auditLog      // lifecycle record served by /api/events
+	webhooks       *webhookDispatcher
+
+	// healthInterval and stallTimeout pace the health monitor: HealthCheckInterval and MaxStallDuration,
+	// shortened by tests
+	healthInterval time.Duration
+	stallTimeout   time.Duration
+
+	// binaries are the alternative FFmpeg builds streams may pick with ffmpeg_binary, by name
+	binaries map[string]CommandRunner
+
+	shuttingDown atomic.Bool // set once graceful shutdown begins; fails readiness probes
+	selfTesting  atomic.Bool // set while POST /api/diagnostics/selftest runs
+
+	// shutdownCtx is cancelled when graceful shutdown begins so blocked requests return promptly
+	shutdownCtx    context.Context
+	shutdownCancel context.CancelFunc
 }
 
 // Stream represents a single RTSP stream with multiple consumers
 type Stream struct {
-	rtspURL        string
-	streamID       string
-	cmd            *exec.Cmd
-	frameBuffer    chan []byte
-	clients        map[string]*Client
-	clientsMu      sync.RWMutex
-	isRunning      bool
-	cancelFunc     context.CancelFunc
-	lastFrameTime  time.Time
-	frameCount     int64
-	mu             sync.RWMutex
-	healthStopChan chan struct{}
+	rtspURL         string
+	opts            StreamOptions
+	inputURLs       []string
+	activeURL       int
+	streamID        string
+	width           int
+	height          int
+	pixelFormat     string
+	gop             int    // requested keyframe interval for encoded clips; 0 leaves FFmpeg's default
+	scaleFlags      string // scale filter algorithm; empty leaves FFmpeg's default (bicubic)
+	bytesPerPixel   float64
+	inputOpts       map[string]string
+	metadata        map[string]interface{}
+	cmd             *exec.Cmd
+	ffmpegBinary    string       // binary and arguments of the latest ingest FFmpeg run, for GET .../command
+	ffmpegArgs      []string     // nil until FFmpeg was first started
+	ffmpegStartedAt time.Time    // when that run was started
+	ffmpegPID       atomic.Int64 // pid of the running ingest FFmpeg; 0 between runs and for clones
+	startQueue      *startQueue  // where the stream waits for an FFmpeg slot while queued
+	frameBuffer     chan *Frame
+	frameCache      *frameCache
+	newFrames       *frameNotifier // wakes HTTP frame requests waiting for a newer frame
+	frameRequests   *frameLimiter  // bounds concurrent HTTP frame requests
+	encoded         *encodeCache
+	clients         map[string]*Client
+	clientsMu       sync.RWMutex
+	isRunning       bool         // the current FFmpeg run has delivered at least one frame
+	ffmpegUp        bool         // an FFmpeg run is in progress, whether or not it has produced a frame yet
+	tasks           *taskTracker // the manager's shutdown tracker, for this stream's FFmpeg processes
+	local           *localSocket // Unix socket publishing frames to local consumers; nil unless requested
+	source          sourceInfo   // source geometry as last reported by FFmpeg
+	paused          bool
+	stopped         bool // set by stopLocked; ingest is never restarted afterwards
+	status          string
+	statusChangedAt time.Time
+	interrupted     bool          // stalled, reconnecting or failed since frames last flowed
+	ready           chan struct{} // closed while status is running; replaced when it leaves running
+	cancelFunc      context.CancelFunc
+	lastFrameTime   atomic.Int64 // unix nanoseconds of the last frame read from FFmpeg
+	frameCount      atomic.Int64
+	droppedFrames   atomic.Int64 // frames evicted from a full frame buffer
+	processorErrs   atomic.Int64 // frames discarded because a processor failed
+	mu              sync.RWMutex
+	healthStopChan  chan struct{}
+	events          *eventHub
+	motion          *motionDetector
+	processors      []FrameProcessor
+	audio           *audioIngest // nil unless the stream was started with audio enabled
+	recorder        *recorder    // nil unless the stream was started with record enabled
+	ts              *tsOutput    // MPEG-TS remux, running only while /ts has listeners
+	sched           processScheduling
+	runner          CommandRunner
+	ingestRate      *rateMeter
+	currentFPS      fpsEMA // live ingest rate, updated only by the FFmpeg read loop
+	breaker         *circuitBreaker
+	cappedFrames    atomic.Int64
+	ptsStats        ptsStats // how frames were timestamped with timestamp_source pts
+
+	bufferPressure  atomic.Bool // frame buffer at or above the high-water mark when last sampled
+	lastPressureLog time.Time   // owned by the health monitor
+
+	expiresAt time.Time   // when max_duration stops the stream; set only with stopTimer
+	stopTimer *time.Timer // nil unless the stream was started with a max_duration
+
+	// drain: no new WebSocket clients, and the stream stops once the last one leaves or at drainDeadline.
+	// Guarded by sm.mu, which AddClient and RemoveClient hold.
+	draining      bool
+	drainDeadline time.Time
+	drainTimer    *time.Timer
+
+	// idle_timeout: the stream stops once it has had no consumers or keepalives for idleTimeout
+	idleTimeout   time.Duration
+	lastActivity  atomic.Int64 // unix nanos of the last consumer activity or keepalive
+	lastKeepalive atomic.Int64 // unix nanos of the last POST /keepalive; 0 if none
+
+	placeholderOnStall bool
+	placeholderActive  bool
+
+	logLevel string // FFmpeg stderr lines above this level aren't logged; guarded by mu
+
+	outputJitter jitterMeter // gaps between frames leaving the buffer for clients
+
+	// Clones derive their frames from a parent's ingest instead of running FFmpeg
+	parent      *Stream     // the stream whose ingest feeds this clone; nil unless cloned
+	cloneFPS    float64     // frame rate cap of a clone; 0 passes every parent frame
+	cloneFrames chan *Frame // parent frames waiting to be converted by runClone
+	clonesMu    sync.RWMutex
+	clones      map[string]*Stream // clones fed by this stream's ingest, by stream ID
+	retired     bool               // stopped while clones still use its ingest; guarded by the manager's mu
+
+	selfTest bool // a self-test stream ingesting a synthetic source; never registered with the manager
+}
+
+// StreamOptions holds the output parameters requested when starting a stream
+type StreamOptions struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	PixelFormat string `json:"pixel_format"`
+
+	// RTSPURLs lists failover inputs in priority order; the primary rtsp_url is always tried first
+	RTSPURLs []string `json:"rtsp_urls"`
+
+	// FFmpegInputOpts tunes FFmpeg input handling; keys are restricted to allowedInputOpts
+	FFmpegInputOpts map[string]interface{} `json:"ffmpeg_input_opts"`
+
+	// Motion detection compares consecutive frames off the delivery path and emits events
+	MotionDetection  bool    `json:"motion_detection"`
+	MotionThreshold  float64 `json:"motion_threshold"`
+	MotionSampleStep int     `json:"motion_sample_step"`
+
+	// PlaceholderOnStall delivers a "NO SIGNAL" frame to clients while the stream is stalled
+	PlaceholderOnStall bool `json:"placeholder_on_stall"`
+
+	// ScaleFlags picks the scale algorithm: bilinear, bicubic, lanczos or neighbor; empty keeps bicubic
+	ScaleFlags string `json:"scale_flags"`
+
+	// HWAccel decodes the input on the GPU: none, cuda, vaapi, qsv or videotoolbox
+	HWAccel string `json:"hwaccel"`
+
+	// GOP is the keyframe interval, in frames, of the clips encoded from the stream; 0 keeps FFmpeg's
+	// default. Raw frames are always complete images, and copied outputs keep the source's keyframes.
+	GOP int `json:"gop"`
+
+	// DropPolicy decides what happens when the frame buffer is full: oldest, newest or block-with-timeout
+	DropPolicy string `json:"drop_policy"`
+
+	// Processors names registered FrameProcessors to run, in order, on every frame before it's buffered
+	Processors []string `json:"processors"`
+
+	// Loop replays file inputs indefinitely, turning a short clip into a perpetual stream
+	Loop bool `json:"loop"`
+
+	// FFmpegBinary names an FFMPEG_BINARIES entry to run this stream's FFmpeg processes with, e.g. a
+	// build with hardware decoding; empty uses FFMPEG_PATH
+	FFmpegBinary string `json:"ffmpeg_binary"`
+
+	// Audio extracts the source's audio track for GET /api/streams/:streamId/audio
+	Audio bool `json:"audio"`
+
+	// Record writes the source to segments in RECORDING_DIR with an FFmpeg process of its own
+	Record bool `json:"record"`
+
+	// Nice and CPUs override the global FFMPEG_NICE and FFMPEG_CPUS for this stream's FFmpeg processes
+	Nice *int   `json:"nice"`
+	CPUs string `json:"cpus"`
+
+	// MaxDuration, e.g. "2h", stops the stream automatically once that much wall-clock time has passed
+	MaxDuration string `json:"max_duration"`
+
+	// IdleTimeout, e.g. "10m", stops the stream once it has had no consumers or keepalives for that long
+	IdleTimeout string `json:"idle_timeout"`
+
+	// Pacing releases frames to clients at a steady interval, smoothing bursts at the cost of up to one
+	// interval of latency; PacingFPS sets the rate, 0 follows the source
+	Pacing    bool    `json:"pacing"`
+	PacingFPS float64 `json:"pacing_fps"`
+
+	// TimestampSource is where frame timestamps come from: arrival (when the frame is read from FFmpeg)
+	// or pts (the source's presentation timestamps, falling back to arrival for frames without one)
+	TimestampSource string `json:"timestamp_source"`
+
+	// MinExpectedFPS is the slowest frame rate of a healthy source, e.g. 0.1 for a camera sending a frame
+	// every 10 seconds. The stall threshold grows to fit it; 0 keeps MaxStallDuration.
+	MinExpectedFPS float64 `json:"min_expected_fps"`
+
+	// FFmpegLogLevel is how much of FFmpeg's stderr is logged: error, warning, info or debug; empty uses
+	// FFMPEG_LOG_LEVEL
+	FFmpegLogLevel string `json:"ffmpeg_log_level"`
+
+	// LocalSocketPath, a name or path inside LOCAL_SOCKET_DIR, also publishes frames on a Unix socket
+	LocalSocketPath string `json:"local_socket_path"`
+
+	// Metadata holds free-form labels (camera name, location, ...) echoed back in responses
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// ClientOptions holds per-connection WebSocket settings
+type ClientOptions struct {
+	ReadDeadline  time.Duration
+	WriteDeadline time.Duration // pings, control messages and close frames
+	PingInterval  time.Duration
+
+	// Frame writes get size/MinBitrateMbps, clamped to [MinFrameWriteDeadline, MaxFrameWriteDeadline]
+	MinBitrateMbps        float64
+	MinFrameWriteDeadline time.Duration
+	MaxFrameWriteDeadline time.Duration
+
+	// ReadLimit caps the size of a message from the client, in bytes
+	ReadLimit int64
+
+	// Width and Height downscale the client's frames; 0 sends them at the stream's size
+	Width  int
+	Height int
+
+	// OnMotion delivers frames only while the stream's motion detector reports motion
+	OnMotion bool
+
+	// Prime sends the latest frame as soon as the client connects instead of waiting for the next one
+	Prime bool
+
+	// Ack sends each frame only after the client acknowledged the previous one
+	Ack bool
+
+	// Subsample delivers every Nth frame, counted per client; 0 or 1 delivers them all
+	Subsample int
+
+	// AutoBuffer grows the send buffer of a client that keeps filling it and shrinks one that stays empty
+	AutoBuffer bool
+
+	// MaxStaleness is how old the next queued frame may be before the queue is flushed and the client
+	// jumps to the newest frame; 0 delivers every queued frame however old
+	MaxStaleness time.Duration
+
+	// Encoding is how frames are sent: EncodingBinary, or EncodingBase64 for clients that can't take
+	// binary messages
+	Encoding string
+
+	// MaxWriteStall disconnects a client whose buffer is full and that hasn't had a frame written for
+	// that long, e.g. one that never reads; 0 leaves it to the write deadline
+	MaxWriteStall time.Duration
+
+	// RequestID is the X-Request-ID of the WebSocket handshake, so a connection can be traced through its lifetime
+	RequestID string
 }
 
 // Client represents a connected client consuming a stream
 type Client struct {
-	id       string
-	streamID string
-	conn     *websocket.Conn
-	send     chan []byte
-	manager  *StreamManager
-	closed   bool
-	mu       sync.Mutex
+	id          string
+	streamID    string
+	conn        *websocket.Conn
+	send        chan *Frame
+	manager     *StreamManager
+	closed      bool
+	connectedAt time.Time
+	opts        ClientOptions
+	mu          sync.Mutex
+
+	// Source geometry, needed to downscale frames when opts sets a client size
+	srcWidth    int
+	srcHeight   int
+	pixelFormat string
+
+	// Server-side delivery counters
+	framesSent       atomic.Int64
+	framesSkipped    atomic.Int64
+	framesThrottled  atomic.Int64
+	framesSubsampled atomic.Int64
+	deliveredFPS     fpsEMA // updated only by writePump
+
+	// Runtime delivery tuning, adjustable via PATCH /api/streams/:streamId/clients/:clientId
+	targetFPS  float64
+	paused     bool
+	lastQueued time.Time
+	resized    chan struct{} // signals writePump that send was replaced
+
+	// Queue depth: the deepest the send buffer has been, and its adaptive sizing when opted in
+	queuePeak     int
+	auto          autoBuffer
+	bufferGrows   atomic.Int64
+	bufferShrinks atomic.Int64
+
+	// subsampleCount counts the frames offered to a subsampling client; the first and every
+	// opts.Subsample-th after it are delivered
+	subsampleCount int64
+
+	// primedSeq is the sequence number of the frame sent on connect with ?prime=true, so the same
+	// frame isn't delivered again when the stream's buffer catches up
+	primedSeq int64
+
+	// Ack mode: at most one frame is in flight, released by {"ack":seq} through acked
+	ackPending  bool
+	ackSeq      int64
+	acked       chan struct{}
+	framesAcked atomic.Int64
+	ackSkips    atomic.Int64
+
+	// motionActive tracks the motion state last announced to an on_motion client
+	motionActive bool
+
+	// Catch-up after lagging: flushes of a queue whose next frame was older than opts.MaxStaleness
+	staleFlushes  atomic.Int64
+	framesFlushed atomic.Int64
+	lastFlushAt   time.Time // guarded by mu
+
+	// Write stall protection: when writePump last got a frame onto the connection (unix nanos, starting
+	// at connect), and whether the client is already being dropped for stalling
+	lastSuccessfulWrite atomic.Int64
+	stallDropped        bool // guarded by mu
+
+	// send is never closed, so a racing broadcast can't panic; done is closed exactly once, by
+	// markClosed, to tell writePump to exit
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// closeCode and closeText, when set by closeWith, go in the close frame writePump sends on done
+	closeCode int
+	closeText string
+
+	// messagesRejected counts inbound messages that were malformed or unknown commands or binary data
+	messagesRejected atomic.Int64
+
+	// Latest metrics reported by the client itself via {"cmd":"report"}
+	reportedFPS   float64
+	reportedRTTMs float64
+	lastReportAt  time.Time
+}
+
+// Frame is a raw video frame together with the time it was read from FFmpeg
+type Frame struct {
+	data      []byte
+	timestamp time.Time
+	seq       int64 // per-stream sequence number starting at 1; 0 for synthetic frames such as placeholders
+	fromPTS   bool  // timestamp is the frame's presentation time rather than its arrival
+
+	// control, when set, is a JSON control message written as a text message instead of frame data
+	control []byte
+
+	// Downscaled copies for clients that connected with ?w=&h=, keyed by size
+	scaledMu sync.Mutex
+	scaled   map[[2]int][]byte
 }
 
-// FrameMessage represents the frame data sent to clients
+// FrameMessage is a frame as sent to ?encoding=base64 clients, frame_data base64-encoded by encoding/json
 type FrameMessage struct {
 	StreamID  string `json:"stream_id"`
 	Timestamp int64  `json:"timestamp"`
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// version is the server build version, set at build time with
+// go build -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+// ffmpegVersion and ffmpegBanner describe the FFmpeg found at startup
+var (
+	ffmpegVersion string // e.g. "6.0" or "n6.1-3-gabcdef"
+	ffmpegBanner  string // the first line of ffmpeg -version
+)
+
+// ffmpegDecoders caches the decoder list, which can't change while the server runs
+var ffmpegDecoders struct {
+	once sync.Once
+	list []gin.H
+	err  error
+}
+
+// parseFFmpegVersion extracts the first line and the version number from ffmpeg -version output
+func parseFFmpegVersion(out []byte) (banner, version string) {
+	banner, _, _ = strings.Cut(string(out), "\n")
+	banner = strings.TrimSpace(banner)
+
+	// "ffmpeg version 6.0-1ubuntu1 Copyright (c) ..."
+	fields := strings.Fields(banner)
+	if len(fields) >= 3 && fields[0] == "ffmpeg" && fields[1] == "version" {
+		version = fields[2]
+	}
+	return banner, version
+}
+
+// listFFmpegDecoders runs ffmpeg -decoders and parses its table. Each row looks like
+// " V....D h264                 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10"
+func listFFmpegDecoders(ctx context.Context, runner CommandRunner) ([]gin.H, error) {
+	out, err := runner.CommandContext(ctx, "-hide_banner", "-decoders").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	decoders := make([]gin.H, 0)
+	kinds := map[byte]string{'V': "video", 'A': "audio", 'S': "subtitle"}
+	inTable := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !inTable {
+			// The legend above the table ends with a " ------" separator
+			inTable = strings.HasPrefix(line, "------")
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields[0]) != 6 {
+			continue
+		}
+		decoders = append(decoders, gin.H{
+			"name":        fields[1],
+			"type":        kinds[fields[0][0]],
+			"description": strings.Join(fields[2:], " "),
+		})
+	}
+	return decoders, nil
+}
+
+// handleVersion reports the server build, Go runtime and FFmpeg versions. With ?decoders=true it also
+// lists the decoders the installed FFmpeg supports.
+func (sm *StreamManager) handleVersion(c *gin.Context) {
+	resp := gin.H{
+		"version":        version,
+		"go_version":     runtime.Version(),
+		"ffmpeg_version": ffmpegVersion,
+		"ffmpeg_banner":  ffmpegBanner,
+		"ffmpeg_path":    sm.runner.Path(),
+	}
+	if len(sm.binaries) > 0 {
+		binaries := make(map[string]string, len(sm.binaries))
+		for name, runner := range sm.binaries {
+			binaries[name] = runner.Path()
+		}
+		resp["ffmpeg_binaries"] = binaries
+	}
+
+	if c.Query("decoders") == "true" {
+		ffmpegDecoders.once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), FFmpegProbeTimeout)
+			defer cancel()
+			ffmpegDecoders.list, ffmpegDecoders.err = listFFmpegDecoders(ctx, sm.runner)
+		})
+		if ffmpegDecoders.err != nil {
+			respondError(c, http.StatusInternalServerError, CodeFFmpegFailed, "failed to list FFmpeg decoders: "+ffmpegDecoders.err.Error(), nil)
+			return
+		}
+		resp["decoders"] = ffmpegDecoders.list
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
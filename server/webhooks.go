@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookEvents are the events a webhook may subscribe to
+var webhookEvents = map[string]bool{
+	"stream_failed":      true, // circuit breaker opened; retries are suspended for the cooldown
+	"stream_recovered":   true, // frames flow again after a stall, reconnect or failure
+	"motion_detected":    true, // the stream's motion detector fired
+	"client_threshold":   true, // the client count rose to or fell below the webhook's client_threshold
+	"recording_finished": true, // a stream recording was finalized
+}
+
+// webhookRequest registers a webhook, through POST /api/webhooks or an entry of WEBHOOKS_FILE
+type webhookRequest struct {
+	URL             string   `json:"url" binding:"required"`
+	StreamID        string   `json:"stream_id"`        // empty for every stream
+	Events          []string `json:"events"`           // empty for every event
+	ClientThreshold int      `json:"client_threshold"` // client count that triggers client_threshold
+	Secret          string   `json:"secret"`           // HMAC key for the signature; defaults to WEBHOOK_SECRET
+}
+
+// validate checks the URL, events and threshold of a webhook registration
+func (r webhookRequest) validate() error {
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url must be an absolute http or https URL")
+	}
+	for _, event := range r.Events {
+		if !webhookEvents[event] {
+			return fmt.Errorf("unknown webhook event %q (supported: stream_failed, stream_recovered, motion_detected, client_threshold, recording_finished)", event)
+		}
+	}
+	if r.ClientThreshold < 0 {
+		return fmt.Errorf("client_threshold must not be negative")
+	}
+	if containsString(r.Events, "client_threshold") && r.ClientThreshold == 0 {
+		return fmt.Errorf("the client_threshold event needs a client_threshold")
+	}
+	return nil
+}
+
+// Webhook is a registered endpoint notified of stream events
+type Webhook struct {
+	ID              string
+	URL             string
+	StreamID        string
+	Events          []string
+	ClientThreshold int
+	CreatedAt       time.Time
+	secret          []byte // nil sends deliveries unsigned
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64 // gave up after WebhookMaxAttempts or a permanent error
+	dropped   atomic.Uint64 // lost to a full delivery queue
+
+	mu        sync.Mutex
+	lastError string
+	lastAt    time.Time
+}
+
+// matches reports whether the webhook subscribes to event on the stream
+func (w *Webhook) matches(event, streamID string) bool {
+	if w.StreamID != "" && w.StreamID != streamID {
+		return false
+	}
+	return len(w.Events) == 0 || containsString(w.Events, event)
+}
+
+// recordResult remembers the outcome of the latest delivery attempt for the webhook list
+func (w *Webhook) recordResult(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastAt = time.Now()
+	w.lastError = ""
+	if err != nil {
+		w.lastError = err.Error()
+	}
+}
+
+// info describes the webhook and its delivery counters; the secret is never shown
+func (w *Webhook) info() map[string]interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info := map[string]interface{}{
+		"id":         w.ID,
+		"url":        w.URL,
+		"stream_id":  w.StreamID,
+		"events":     w.Events,
+		"signed":     w.secret != nil,
+		"created_at": w.CreatedAt,
+		"delivered":  w.delivered.Load(),
+		"failed":     w.failed.Load(),
+		"dropped":    w.dropped.Load(),
+	}
+	if w.ClientThreshold > 0 {
+		info["client_threshold"] = w.ClientThreshold
+	}
+	if !w.lastAt.IsZero() {
+		info["last_attempt_at"] = w.lastAt
+		info["last_error"] = w.lastError
+	}
+	return info
+}
+
+// webhookPayload is the JSON body POSTed to a webhook
+type webhookPayload struct {
+	ID        string      `json:"id"` // unique per delivery, the same across its retries
+	Event     string      `json:"event"`
+	WebhookID string      `json:"webhook_id"`
+	StreamID  string      `json:"stream_id,omitempty"`
+	Timestamp int64       `json:"timestamp"` // unix milliseconds, as in stream events
+	Data      StreamEvent `json:"data"`
+}
+
+// webhookDelivery is one payload on its way to one webhook
+type webhookDelivery struct {
+	hook    *Webhook
+	payload webhookPayload
+	body    []byte // encoded on the first attempt
+	attempt int
+}
+
+// webhookStatusError is a delivery answered with a non-2xx status
+type webhookStatusError struct {
+	status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook responded %d %s", e.status, http.StatusText(e.status))
+}
+
+// retryableWebhookError reports whether a failed delivery may succeed later: network errors, timeouts,
+// server errors and 408/429 are retried, other client errors mean the request itself is rejected
+func retryableWebhookError(err error) bool {
+	var statusErr *webhookStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	return statusErr.status >= 500 || statusErr.status == http.StatusRequestTimeout || statusErr.status == http.StatusTooManyRequests
+}
+
+// webhookDispatcher turns stream events into webhook deliveries. Events are matched and queued from
+// the event hub's tap without blocking; WebhookWorkers goroutines POST them, so a slow or dead
+// endpoint never holds up a stream. When the queue is full deliveries are dropped and counted.
+type webhookDispatcher struct {
+	mu    sync.RWMutex
+	hooks map[string]*Webhook
+
+	// clientCounts is the last client count seen per stream, to detect threshold crossings
+	countsMu     sync.Mutex
+	clientCounts map[string]int
+
+	queue   chan *webhookDelivery
+	secret  []byte // WEBHOOK_SECRET, for webhooks without their own secret
+	client  *http.Client
+	dropped atomic.Uint64
+
+	ctx    context.Context // cancelled by close; aborts in-flight requests and pending retries
+	cancel context.CancelFunc
+}
+
+// newWebhookDispatcher creates a dispatcher with an empty registry; start launches its workers
+func newWebhookDispatcher(cfg Config) *webhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &webhookDispatcher{
+		hooks:        make(map[string]*Webhook),
+		clientCounts: make(map[string]int),
+		queue:        make(chan *webhookDelivery, cfg.WebhookQueueSize),
+		client:       &http.Client{Timeout: WebhookTimeout},
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	if cfg.WebhookSecret != "" {
+		d.secret = []byte(cfg.WebhookSecret)
+	}
+	return d
+}
+
+// start launches the delivery workers as tasks that shutdown waits for
+func (d *webhookDispatcher) start(tasks *taskTracker) {
+	for i := 0; i < WebhookWorkers; i++ {
+		tasks.goTask(fmt.Sprintf("webhook worker %d", i), d.run)
+	}
+}
+
+// close stops the workers and abandons queued deliveries and retries
+func (d *webhookDispatcher) close() {
+	d.cancel()
+	if pending := len(d.queue); pending > 0 {
+		log.Printf("Webhooks: abandoning %d queued deliveries on shutdown", pending)
+	}
+}
+
+// loadWebhooks registers the webhooks of a JSON file of the form {"webhooks": [{"url", "stream_id", "events", ...}]}
+func (d *webhookDispatcher) loadWebhooks(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read webhooks: %v", err)
+	}
+
+	var file struct {
+		Webhooks []webhookRequest `json:"webhooks"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return fmt.Errorf("failed to parse webhooks %s: %v", path, err)
+	}
+	for i, req := range file.Webhooks {
+		if _, err := d.add(req); err != nil {
+			return fmt.Errorf("webhook %d of %s: %v", i, path, err)
+		}
+	}
+	log.Printf("Loaded %d webhooks from %s", len(file.Webhooks), path)
+	return nil
+}
+
+// add validates and registers a webhook
+func (d *webhookDispatcher) add(req webhookRequest) (*Webhook, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	hook := &Webhook{
+		ID:              generateWebhookID(),
+		URL:             req.URL,
+		StreamID:        req.StreamID,
+		Events:          req.Events,
+		ClientThreshold: req.ClientThreshold,
+		CreatedAt:       time.Now(),
+		secret:          d.secret,
+	}
+	if req.Secret != "" {
+		hook.secret = []byte(req.Secret)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks[hook.ID] = hook
+	return hook, nil
+}
+
+// remove unregisters a webhook, reporting whether it existed. Deliveries already queued still go out.
+func (d *webhookDispatcher) remove(id string) (*Webhook, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hook, ok := d.hooks[id]
+	delete(d.hooks, id)
+	return hook, ok
+}
+
+// list returns the registered webhooks, oldest first
+func (d *webhookDispatcher) list() []*Webhook {
+	d.mu.RLock()
+	hooks := make([]*Webhook, 0, len(d.hooks))
+	for _, hook := range d.hooks {
+		hooks = append(hooks, hook)
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	return hooks
+}
+
+// generateWebhookID returns a random webhook ID such as wh_1f2e3d4c5b6a7980
+func generateWebhookID() string {
+	var random [8]byte
+	rand.Read(random[:])
+	return "wh_" + hex.EncodeToString(random[:])
+}
+
+// notify maps a stream event to a webhook event and queues it for every matching webhook; it is
+// called from the event hub's tap, so it must never block
+func (d *webhookDispatcher) notify(event StreamEvent) {
+	kind, _ := event["type"].(string)
+	streamID, _ := event["stream_id"].(string)
+
+	var name string
+	switch kind {
+	case "status":
+		recovered, _ := event["recovered"].(bool)
+		switch {
+		case event["status"] == StatusFailed:
+			name = "stream_failed"
+		case recovered:
+			name = "stream_recovered"
+		case event["status"] == StatusStopped:
+			d.countsMu.Lock()
+			delete(d.clientCounts, streamID)
+			d.countsMu.Unlock()
+		}
+	case "motion":
+		name = "motion_detected"
+	case "recording":
+		if event["state"] == "finished" {
+			name = "recording_finished"
+		}
+	case "clients":
+		d.notifyClientCount(streamID, event)
+	}
+	if name == "" {
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, hook := range d.hooks {
+		if hook.matches(name, streamID) {
+			d.enqueue(hook, name, streamID, event)
+		}
+	}
+}
+
+// notifyClientCount fires client_threshold for every webhook whose threshold the stream's client
+// count just crossed, in either direction
+func (d *webhookDispatcher) notifyClientCount(streamID string, event StreamEvent) {
+	count, _ := event["client_count"].(int)
+
+	d.countsMu.Lock()
+	previous := d.clientCounts[streamID]
+	d.clientCounts[streamID] = count
+	d.countsMu.Unlock()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, hook := range d.hooks {
+		threshold := hook.ClientThreshold
+		if threshold == 0 || (previous >= threshold) == (count >= threshold) || !hook.matches("client_threshold", streamID) {
+			continue
+		}
+		direction := "above"
+		if count < threshold {
+			direction = "below"
+		}
+		d.enqueue(hook, "client_threshold", streamID, StreamEvent{
+			"client_count": count,
+			"previous":     previous,
+			"threshold":    threshold,
+			"direction":    direction,
+			"timestamp":    event["timestamp"],
+		})
+	}
+}
+
+// enqueue hands a new delivery to the workers, dropping it if the queue is full
+func (d *webhookDispatcher) enqueue(hook *Webhook, name, streamID string, data StreamEvent) {
+	var id [8]byte
+	rand.Read(id[:])
+	d.push(&webhookDelivery{
+		hook: hook,
+		payload: webhookPayload{
+			ID:        hex.EncodeToString(id[:]),
+			Event:     name,
+			WebhookID: hook.ID,
+			StreamID:  streamID,
+			Timestamp: time.Now().UnixMilli(),
+			Data:      data,
+		},
+	})
+}
+
+// push queues a delivery without blocking. A full queue means the endpoints can't keep up, so the
+// delivery is dropped; that is logged only occasionally.
+func (d *webhookDispatcher) push(delivery *webhookDelivery) {
+	select {
+	case d.queue <- delivery:
+	default:
+		delivery.hook.dropped.Add(1)
+		if dropped := d.dropped.Add(1); dropped == 1 || dropped%100 == 0 {
+			log.Printf("Webhooks: delivery queue full, dropped %d deliveries so far", dropped)
+		}
+	}
+}
+
+// run delivers queued payloads until the dispatcher is closed
+func (d *webhookDispatcher) run() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case delivery := <-d.queue:
+			d.deliver(delivery)
+		}
+	}
+}
+
+// deliver makes one attempt and, if it failed with a retryable error, schedules the next one after an
+// exponential backoff. Waiting retries don't occupy a worker.
+func (d *webhookDispatcher) deliver(delivery *webhookDelivery) {
+	hook := delivery.hook
+	delivery.attempt++
+	err := d.post(delivery)
+	hook.recordResult(err)
+	if err == nil {
+		hook.delivered.Add(1)
+		return
+	}
+	if d.ctx.Err() != nil {
+		return
+	}
+
+	if retryableWebhookError(err) && delivery.attempt < WebhookMaxAttempts {
+		backoff := WebhookRetryBackoff << (delivery.attempt - 1)
+		time.AfterFunc(backoff, func() {
+			if d.ctx.Err() == nil {
+				d.push(delivery)
+			}
+		})
+		return
+	}
+	hook.failed.Add(1)
+	log.Printf("Webhooks: giving up on %s delivery %s to %s after %d attempts: %v",
+		delivery.payload.Event, delivery.payload.ID, redactURL(hook.URL), delivery.attempt, err)
+}
+
+// post sends a delivery. With a secret, X-Webhook-Signature carries sha256=<hex HMAC-SHA256 of the
+// body>, which receivers recompute to verify the sender; the body's timestamp lets them reject replays.
+func (d *webhookDispatcher) post(delivery *webhookDelivery) error {
+	hook := delivery.hook
+	if delivery.body == nil {
+		body, err := json.Marshal(delivery.payload)
+		if err != nil {
+			return fmt.Errorf("failed to encode payload: %v", err)
+		}
+		delivery.body = body
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rtsp-stream-server/"+version)
+	req.Header.Set("X-Webhook-Event", delivery.payload.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.payload.ID)
+	if hook.secret != nil {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(hook.secret, delivery.body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookStatusError{status: resp.StatusCode}
+	}
+	return nil
+}
+
+// signWebhook computes the hex-encoded HMAC-SHA256 of a webhook body
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// streamEventTap sees every event of every stream: it feeds the audit log and the webhooks
+func (sm *StreamManager) streamEventTap(event StreamEvent) {
+	sm.audit.recordStreamEvent(event)
+	sm.webhooks.notify(event)
+}
+
+// handleAddWebhook registers a webhook
+func (sm *StreamManager) handleAddWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	hook, err := sm.webhooks.add(req)
+	if err != nil {
+		respondInvalidRequest(c, err)
+		return
+	}
+
+	sm.auditRequest(c, "webhook_added", req.StreamID, map[string]interface{}{
+		"webhook_id": hook.ID,
+		"url":        redactURL(hook.URL),
+		"events":     hook.Events,
+	})
+	c.JSON(http.StatusCreated, hook.info())
+}
+
+// handleListWebhooks lists the registered webhooks with their delivery counters
+func (sm *StreamManager) handleListWebhooks(c *gin.Context) {
+	hooks := sm.webhooks.list()
+	infos := make([]map[string]interface{}, 0, len(hooks))
+	for _, hook := range hooks {
+		infos = append(infos, hook.info())
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks":     infos,
+		"queue_length": len(sm.webhooks.queue),
+		"queue_size":   cap(sm.webhooks.queue),
+		"dropped":      sm.webhooks.dropped.Load(),
+	})
+}
+
+// handleDeleteWebhook unregisters a webhook
+func (sm *StreamManager) handleDeleteWebhook(c *gin.Context) {
+	hook, ok := sm.webhooks.remove(c.Param("webhookId"))
+	if !ok {
+		respondError(c, http.StatusNotFound, CodeWebhookNotFound, "Webhook not found", nil)
+		return
+	}
+
+	sm.auditRequest(c, "webhook_removed", hook.StreamID, map[string]interface{}{
+		"webhook_id": hook.ID,
+		"url":        redactURL(hook.URL),
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook removed", "id": hook.ID})
+}